@@ -12,6 +12,7 @@ import (
 	"paypal-integration-demo/internal/repository"
 	"paypal-integration-demo/internal/server"
 	"paypal-integration-demo/internal/service"
+	"paypal-integration-demo/internal/view"
 	"syscall"
 	"time"
 
@@ -30,26 +31,82 @@ func main() {
 		fmt.Printf("Failed to parse config: %v\n", err)
 		os.Exit(1)
 	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("Invalid config: %v\n", err)
+		os.Exit(1)
+	}
+
+	clientProfiles, err := config.LoadClientProfiles(cfg.ClientProfilesFile, cfg.BaseURL)
+	if err != nil {
+		log.Fatalf("load client profiles: %v", err)
+	}
+	taxRates, err := config.LoadTaxRates(cfg.TaxRatesFile)
+	if err != nil {
+		log.Fatalf("load tax rates: %v", err)
+	}
 
 	db := client.InitMysqlClient(cfg.DatabaseURL)
 	paypalClient := client.NewPaypalClient(&cfg.Paypal)
+	if err := paypalClient.VerifyCredentials(context.Background()); err != nil {
+		log.Fatalf("paypal %s mode: %v", cfg.Paypal.Mode, err)
+	}
 
 	productRepo := repository.NewProductRepository(db)
-	err := productRepo.Seed(context.Background())
+	err = productRepo.Seed(context.Background())
 	if err != nil {
 		log.Fatal("seed some products data into db")
 	}
 
+	entitlementRepo := repository.NewEntitlementRepository(db)
+	if err := entitlementRepo.Seed(context.Background()); err != nil {
+		log.Fatal("seed entitlements into db")
+	}
+
 	merchantRepo := repository.NewMerchantRepository(db)
 	orderRepo := repository.NewOrderRepository(db)
 	webhookEventRepo := repository.NewWebhookEventRepository(db)
 	inventoryRepo := repository.NewInventoryRepository(db)
 	vaultRepo := repository.NewVaultRepository(db)
 	subscriptionRepo := repository.NewSubscriptionRepository(db)
+	walletRepo := repository.NewWalletRepository(db)
+	promotionRepo := repository.NewPromotionRepository(db)
+	purchaseLimitRepo := repository.NewPurchaseLimitRepository(db)
+	riskRepo := repository.NewRiskRepository(db)
+	disputeRepo := repository.NewDisputeRepository(db)
+	payoutRepo := repository.NewPayoutRepository(db)
+	invoiceRepo := repository.NewInvoiceRepository(db)
 
+	// checkout and invoices both change orders watched over SSE
+	orderEvents := service.NewOrderEventBus()
+	disputeService := service.NewDisputeService(
+		db,
+		paypalClient,
+		merchantRepo,
+		orderRepo,
+		entitlementRepo,
+		walletRepo,
+		inventoryRepo,
+		disputeRepo,
+		cfg.Disputes,
+	)
+	payoutService := service.NewPayoutService(db, paypalClient, payoutRepo, cfg.Payouts)
+	invoiceService := service.NewInvoiceService(
+		db,
+		paypalClient,
+		orderEvents,
+		merchantRepo,
+		productRepo,
+		orderRepo,
+		entitlementRepo,
+		walletRepo,
+		inventoryRepo,
+		invoiceRepo,
+	)
 	paypalService := service.NewPaypalService(
 		db,
 		paypalClient, cfg.BaseURL,
+		clientProfiles,
+		orderEvents,
 		merchantRepo,
 		productRepo,
 		orderRepo,
@@ -57,14 +114,42 @@ func main() {
 		inventoryRepo,
 		vaultRepo,
 		subscriptionRepo,
+		walletRepo,
+		entitlementRepo,
+		promotionRepo,
+		service.NewTableTaxCalculator(taxRates),
+		purchaseLimitRepo,
+		cfg.PurchaseLimits,
+		service.NewRuleRiskEngine(riskRepo, cfg.RiskRules),
+		disputeService,
+		payoutService,
+		invoiceService,
 	)
-	userService := service.NewUserService(inventoryRepo)
-	merchantService := service.NewMerchantService(merchantRepo)
+	userService := service.NewUserService(db, inventoryRepo)
+	merchantService := service.NewMerchantService(merchantRepo, orderRepo, productRepo, entitlementRepo)
+	orderService := service.NewOrderService(db, orderRepo, productRepo)
+	adminService := service.NewAdminService(orderRepo, subscriptionRepo, webhookEventRepo, riskRepo)
+	walletService := service.NewWalletService(db, walletRepo)
+	entitlementService := service.NewEntitlementService(entitlementRepo)
+	promotionService := service.NewPromotionService(paypalClient, merchantRepo, orderRepo, promotionRepo)
+	purchaseLimitService := service.NewPurchaseLimitService(purchaseLimitRepo, cfg.PurchaseLimits)
 
 	serverAddr := cfg.HTTP.Host + ":" + cfg.HTTP.Port
 
 	// Init HTTP server
-	srv := server.NewServer(paypalService, userService, merchantService)
+	views, err := view.NewRenderer(cfg.TemplatesOverrideDir)
+	if err != nil {
+		log.Fatalf("load templates: %v", err)
+	}
+
+	srv := server.NewServer(&cfg.Paypal, cfg.AdminToken, cfg.GameServerAPIKey, views, paypalService, userService, merchantService, orderService, adminService, walletService, entitlementService, promotionService, purchaseLimitService, disputeService, payoutService, invoiceService)
+
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	go entitlementService.RunExpirySweeper(sweeperCtx, time.Minute)
+	go promotionService.RunRedemptionSweeper(sweeperCtx, 5*time.Minute)
+	go disputeService.RunDisputeAlerts(sweeperCtx, 10*time.Minute)
+	go payoutService.RunPayoutSync(sweeperCtx, 15*time.Minute)
 
 	log.Println("Starting HTTP server on", serverAddr)
 	go func() {
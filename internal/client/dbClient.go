@@ -26,19 +26,52 @@ func InitMysqlClient(databaseURL string) *gorm.DB {
 	sqlDB.SetMaxOpenConns(50)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
-	if err := db.AutoMigrate(
+	if err := AutoMigrate(db); err != nil {
+		log.Fatal(err)
+	}
+
+	return db
+}
+
+// AutoMigrate creates or updates the tables of every model
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(
 		&model.Product{},
+		&model.Entitlement{},
+		&model.ProductEntitlement{},
 		&model.Merchant{},
+		&model.MerchantFeeRule{},
+		&model.Promotion{},
+		&model.PromotionRedemption{},
 		&model.Order{},
+		&model.OrderUnit{},
 		&model.OrderItem{},
+		&model.OrderStatusHistory{},
+		&model.OrderRefund{},
+		&model.UserPurchaseLimit{},
+		&model.PurchaseLimitAudit{},
+		&model.SpendHold{},
+		&model.PayAttempt{},
+		&model.RiskDecision{},
+		&model.RiskRuleHit{},
+		&model.Dispute{},
+		&model.ItemFreeze{},
+		&model.DisputeAction{},
+		&model.Earnings{},
+		&model.EarningsLedgerEntry{},
+		&model.Payout{},
+		&model.Invoice{},
 		&model.UserVault{},
+		&model.VaultSetup{},
 		&model.WebhookEvent{},
+		&model.Wallet{},
+		&model.WalletOperation{},
+		&model.WalletLedgerEntry{},
 		&model.UserInventory{},
+		&model.InventoryLedgerEntry{},
+		&model.UserPass{},
+		&model.PassGrant{},
 		&model.SubscriptionPlan{},
 		&model.UserSubscription{},
-	); err != nil {
-		log.Fatal(err)
-	}
-
-	return db
+	)
 }
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"paypal-integration-demo/internal/model"
+	"strconv"
+	"time"
+)
+
+// DisputeListQuery filters /v1/customer/disputes, zero values are left out
+type DisputeListQuery struct {
+	// DisputeState is e.g. REQUIRED_ACTION, REQUIRED_OTHER_PARTY_ACTION, UNDER_PAYPAL_REVIEW, RESOLVED
+	DisputeState          string
+	DisputedTransactionID string
+	StartTime             time.Time
+	PageSize              int
+	NextPageToken         string
+}
+
+type DisputePage struct {
+	Disputes []*model.PaypalDispute
+	// NextPageToken is empty on the last page
+	NextPageToken string
+}
+
+// DisputeEvidence is one piece of evidence sent with provide-evidence.
+// Type is a PayPal evidence type, e.g. PROOF_OF_FULFILLMENT.
+type DisputeEvidence struct {
+	Type  string
+	Notes string
+}
+
+func (c *paypalClientImpl) ListDisputes(ctx context.Context, query *DisputeListQuery, auth *MerchantAuth) (*DisputePage, error) {
+	values := url.Values{}
+	if query.DisputeState != "" {
+		values.Set("dispute_state", query.DisputeState)
+	}
+	if query.DisputedTransactionID != "" {
+		values.Set("disputed_transaction_id", query.DisputedTransactionID)
+	}
+	if !query.StartTime.IsZero() {
+		values.Set("start_time", query.StartTime.UTC().Format("2006-01-02T15:04:05.000Z"))
+	}
+	if query.PageSize > 0 {
+		values.Set("page_size", strconv.Itoa(query.PageSize))
+	}
+	if query.NextPageToken != "" {
+		values.Set("next_page_token", query.NextPageToken)
+	}
+
+	var list model.PaypalDisputeList
+	if err := c.disputeCall(ctx, http.MethodGet, "?"+values.Encode(), "", nil, auth, &list); err != nil {
+		return nil, fmt.Errorf("paypal list disputes: %w", err)
+	}
+
+	page := &DisputePage{Disputes: list.Items}
+	for _, link := range list.Links {
+		if link.Rel != "next" {
+			continue
+		}
+		if next, err := url.Parse(link.Href); err == nil {
+			page.NextPageToken = next.Query().Get("next_page_token")
+		}
+	}
+
+	return page, nil
+}
+
+func (c *paypalClientImpl) GetDispute(ctx context.Context, disputeID string, auth *MerchantAuth) (*model.PaypalDispute, error) {
+	var dispute model.PaypalDispute
+	if err := c.disputeCall(ctx, http.MethodGet, "/"+url.PathEscape(disputeID), "", nil, auth, &dispute); err != nil {
+		return nil, fmt.Errorf("paypal get dispute: %w", err)
+	}
+	return &dispute, nil
+}
+
+// ProvideEvidence answers a dispute with evidence. PayPal only takes this
+// endpoint as multipart/form-data, the evidence goes in the "input" part.
+func (c *paypalClientImpl) ProvideEvidence(ctx context.Context, disputeID string, evidence []*DisputeEvidence, auth *MerchantAuth) error {
+	evidences := make([]map[string]interface{}, len(evidence))
+	for i, e := range evidence {
+		evidences[i] = map[string]interface{}{
+			"evidence_type": e.Type,
+			"notes":         e.Notes,
+		}
+	}
+	input, err := json.Marshal(map[string]interface{}{"evidences": evidences})
+	if err != nil {
+		return fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="input"; filename="input.json"`)
+	header.Set("Content-Type", "application/json")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("create evidence form: %w", err)
+	}
+	if _, err := part.Write(input); err != nil {
+		return fmt.Errorf("write evidence form: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return fmt.Errorf("close evidence form: %w", err)
+	}
+
+	path := "/" + url.PathEscape(disputeID) + "/provide-evidence"
+	if err := c.disputeCall(ctx, http.MethodPost, path, form.FormDataContentType(), &body, auth, nil); err != nil {
+		return fmt.Errorf("paypal provide dispute evidence: %w", err)
+	}
+	return nil
+}
+
+// AcceptClaim gives the buyer the disputed amount and closes the dispute
+func (c *paypalClientImpl) AcceptClaim(ctx context.Context, disputeID string, note string, auth *MerchantAuth) error {
+	body, err := json.Marshal(map[string]string{"note": note})
+	if err != nil {
+		return fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	path := "/" + url.PathEscape(disputeID) + "/accept-claim"
+	if err := c.disputeCall(ctx, http.MethodPost, path, "application/json", bytes.NewReader(body), auth, nil); err != nil {
+		return fmt.Errorf("paypal accept dispute claim: %w", err)
+	}
+	return nil
+}
+
+// MakeOffer proposes a partial refund, the buyer can accept or deny it
+func (c *paypalClientImpl) MakeOffer(ctx context.Context, disputeID string, note string, currency string, amountMinor int64, auth *MerchantAuth) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"note":         note,
+		"offer_amount": _money(currency, amountMinor),
+		"offer_type":   "REFUND",
+	})
+	if err != nil {
+		return fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	path := "/" + url.PathEscape(disputeID) + "/make-offer"
+	if err := c.disputeCall(ctx, http.MethodPost, path, "application/json", bytes.NewReader(body), auth, nil); err != nil {
+		return fmt.Errorf("paypal make dispute offer: %w", err)
+	}
+	return nil
+}
+
+// disputeCall sends a request to /v1/customer/disputes<path>
+func (c *paypalClientImpl) disputeCall(ctx context.Context, method string, path string, contentType string, body io.Reader, auth *MerchantAuth, out interface{}) error {
+	return c.apiCall(ctx, method, "/v1/customer/disputes"+path, contentType, body, auth, out)
+}
+
+// apiCall sends a request to the API path and decodes the response into out
+// when it is not nil
+func (c *paypalClientImpl) apiCall(ctx context.Context, method string, path string, contentType string, body io.Reader, auth *MerchantAuth, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseApiURL+path, body)
+	if err != nil {
+		return fmt.Errorf("http new request: %w", err)
+	}
+
+	if err := c.authorize(req, auth); err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http client do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return _newAPIError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode paypal response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"paypal-integration-demo/internal/model"
+	"strconv"
+	"strings"
+)
+
+const invoicesPath = "/v2/invoicing/invoices"
+
+// InvoiceRequest is a draft invoice of catalog products for one recipient
+type InvoiceRequest struct {
+	Reference      string // shown on the invoice, our own reference
+	RecipientEmail string
+	Currency       string
+	Note           string
+	DueDate        string // YYYY-MM-DD, empty when due on receipt
+	Items          []*InvoiceItem
+}
+
+type InvoiceItem struct {
+	Name        string
+	Description string
+	Quantity    int32
+	UnitAmount  int64 // minor units (cents)
+}
+
+// InvoicePayment is a payment received outside PayPal, e.g. a bank transfer
+type InvoicePayment struct {
+	Method      string // BANK_TRANSFER, CASH, CHECK, CREDIT_CARD, DEBIT_CARD, PAYPAL, WIRE_TRANSFER, OTHER
+	PaymentDate string // YYYY-MM-DD
+	Currency    string
+	AmountMinor int64
+	Note        string
+}
+
+// CreateDraftInvoice returns the id of the new draft, it is only sent to the
+// recipient by SendInvoice
+func (c *paypalClientImpl) CreateDraftInvoice(ctx context.Context, invoice *InvoiceRequest, auth *MerchantAuth) (string, error) {
+	items := make([]map[string]interface{}, len(invoice.Items))
+	for i, item := range invoice.Items {
+		items[i] = map[string]interface{}{
+			"name":            item.Name,
+			"description":     item.Description,
+			"quantity":        strconv.Itoa(int(item.Quantity)),
+			"unit_amount":     _money(invoice.Currency, item.UnitAmount),
+			"unit_of_measure": "QUANTITY",
+		}
+	}
+
+	detail := map[string]interface{}{
+		"reference":     invoice.Reference,
+		"currency_code": invoice.Currency,
+		"note":          invoice.Note,
+	}
+	if invoice.DueDate != "" {
+		detail["payment_term"] = map[string]string{"due_date": invoice.DueDate}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"detail": detail,
+		"primary_recipients": []map[string]interface{}{
+			{"billing_info": map[string]string{"email_address": invoice.RecipientEmail}},
+		},
+		"items": items,
+		"configuration": map[string]interface{}{
+			"allow_tip":       false,
+			"partial_payment": map[string]bool{"allow_partial_payment": false},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	// without a Prefer header PayPal answers with a link to the new invoice
+	var link model.PaypalLink
+	if err := c.apiCall(ctx, http.MethodPost, invoicesPath, "application/json", bytes.NewReader(body), auth, &link); err != nil {
+		return "", fmt.Errorf("paypal create draft invoice: %w", err)
+	}
+
+	invoiceID := path.Base(link.Href)
+	if link.Href == "" || invoiceID == "invoices" {
+		return "", fmt.Errorf("paypal create draft invoice: no invoice link in response")
+	}
+	return invoiceID, nil
+}
+
+func (c *paypalClientImpl) GetInvoice(ctx context.Context, invoiceID string, auth *MerchantAuth) (*model.PaypalInvoice, error) {
+	var invoice model.PaypalInvoice
+	if err := c.apiCall(ctx, http.MethodGet, _invoicePath(invoiceID, ""), "", nil, auth, &invoice); err != nil {
+		return nil, fmt.Errorf("paypal get invoice: %w", err)
+	}
+	return &invoice, nil
+}
+
+// SendInvoice emails the draft to its recipient
+func (c *paypalClientImpl) SendInvoice(ctx context.Context, invoiceID string, auth *MerchantAuth) error {
+	return c.invoiceAction(ctx, invoiceID, "/send", map[string]interface{}{
+		"send_to_recipient": true,
+		"send_to_invoicer":  false,
+	}, auth, "send invoice")
+}
+
+func (c *paypalClientImpl) RemindInvoice(ctx context.Context, invoiceID string, note string, auth *MerchantAuth) error {
+	return c.invoiceAction(ctx, invoiceID, "/remind", map[string]interface{}{
+		"note":              note,
+		"send_to_recipient": true,
+	}, auth, "remind invoice")
+}
+
+func (c *paypalClientImpl) CancelInvoice(ctx context.Context, invoiceID string, note string, auth *MerchantAuth) error {
+	return c.invoiceAction(ctx, invoiceID, "/cancel", map[string]interface{}{
+		"note":              note,
+		"send_to_recipient": true,
+	}, auth, "cancel invoice")
+}
+
+// RecordInvoicePayment marks the invoice as paid by a payment PayPal did not process
+func (c *paypalClientImpl) RecordInvoicePayment(ctx context.Context, invoiceID string, payment *InvoicePayment, auth *MerchantAuth) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"method":       payment.Method,
+		"payment_date": payment.PaymentDate,
+		"amount":       _money(payment.Currency, payment.AmountMinor),
+		"note":         payment.Note,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	var result struct {
+		PaymentID string `json:"payment_id"`
+	}
+	if err := c.apiCall(ctx, http.MethodPost, _invoicePath(invoiceID, "/payments"), "application/json", bytes.NewReader(body), auth, &result); err != nil {
+		return "", fmt.Errorf("paypal record invoice payment: %w", err)
+	}
+	return result.PaymentID, nil
+}
+
+// GenerateInvoiceQRCode returns a base64 encoded PNG that opens the invoice's
+// payment page. PayPal answers with the image data as plain text.
+func (c *paypalClientImpl) GenerateInvoiceQRCode(ctx context.Context, invoiceID string, auth *MerchantAuth) (string, error) {
+	body, err := json.Marshal(map[string]int{"width": 400, "height": 400})
+	if err != nil {
+		return "", fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseApiURL+_invoicePath(invoiceID, "/generate-qr-code"), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("http new request: %w", err)
+	}
+	if err := c.authorize(req, auth); err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http client do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("paypal generate invoice qr code: %w", _newAPIError(resp))
+	}
+
+	image, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read paypal response: %w", err)
+	}
+	return strings.TrimSpace(string(image)), nil
+}
+
+func (c *paypalClientImpl) invoiceAction(ctx context.Context, invoiceID string, action string, payload map[string]interface{}, auth *MerchantAuth, name string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	if err := c.apiCall(ctx, http.MethodPost, _invoicePath(invoiceID, action), "application/json", bytes.NewReader(body), auth, nil); err != nil {
+		return fmt.Errorf("paypal %s: %w", name, err)
+	}
+	return nil
+}
+
+func _invoicePath(invoiceID string, action string) string {
+	return invoicesPath + "/" + url.PathEscape(invoiceID) + action
+}
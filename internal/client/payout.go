@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"paypal-integration-demo/internal/model"
+	"strings"
+)
+
+// PayoutRequest is a payout batch of one item paid from the platform's own
+// PayPal balance. SenderBatchID is also the sender_item_id; PayPal rejects a
+// sender_batch_id used in the last 30 days, which makes retries safe.
+type PayoutRequest struct {
+	SenderBatchID string
+	Receiver      string // PayPal account email
+	Currency      string
+	AmountMinor   int64
+	Note          string
+	EmailSubject  string
+}
+
+func (c *paypalClientImpl) CreatePayout(ctx context.Context, payout *PayoutRequest) (*model.PayoutBatchHeader, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"sender_batch_header": map[string]string{
+			"sender_batch_id": payout.SenderBatchID,
+			"email_subject":   payout.EmailSubject,
+		},
+		"items": []map[string]interface{}{
+			{
+				"recipient_type": "EMAIL",
+				"receiver":       payout.Receiver,
+				"amount":         _money(payout.Currency, payout.AmountMinor),
+				"note":           payout.Note,
+				"sender_item_id": payout.SenderBatchID,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal req payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseApiURL+"/v1/payments/payouts", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("http new request: %w", err)
+	}
+	if err := c.authorize(req, &MerchantAuth{}); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http client do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := _newAPIError(resp)
+		// a batch sent before whose answer got lost, PayPal links to it
+		if batchID := _duplicatePayoutBatch(apiErr); batchID != "" {
+			return &model.PayoutBatchHeader{PayoutBatchID: batchID}, nil
+		}
+		return nil, fmt.Errorf("paypal create payout: %w", apiErr)
+	}
+
+	var result struct {
+		BatchHeader model.PayoutBatchHeader `json:"batch_header"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode paypal response: %w", err)
+	}
+
+	return &result.BatchHeader, nil
+}
+
+// GetPayoutBatch returns the batch with the status of each of its items
+func (c *paypalClientImpl) GetPayoutBatch(ctx context.Context, payoutBatchID string) (*model.PaypalPayoutBatch, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseApiURL+"/v1/payments/payouts/"+url.PathEscape(payoutBatchID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("http new request: %w", err)
+	}
+	if err := c.authorize(req, &MerchantAuth{}); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http client do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("paypal get payout batch: %w", _newAPIError(resp))
+	}
+
+	var batch model.PaypalPayoutBatch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("decode paypal response: %w", err)
+	}
+
+	return &batch, nil
+}
+
+// _duplicatePayoutBatch returns the id of the batch a rejected duplicate
+// sender_batch_id was first used for, empty for any other error
+func _duplicatePayoutBatch(apiErr *APIError) string {
+	if !IsDuplicatePayout(apiErr) {
+		return ""
+	}
+	for _, link := range apiErr.Links {
+		_, batchID, ok := strings.Cut(link.Href, "/v1/payments/payouts/")
+		if ok && batchID != "" && !strings.Contains(batchID, "/") {
+			return batchID
+		}
+	}
+	return ""
+}
+
+// IsDuplicatePayout reports whether PayPal rejected the payout because its
+// sender_batch_id was already used, i.e. the payout was sent before
+func IsDuplicatePayout(apiErr *APIError) bool {
+	return apiErr.StatusCode == http.StatusBadRequest && strings.EqualFold(apiErr.Field, "sender_batch_id")
+}
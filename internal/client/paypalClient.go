@@ -9,13 +9,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"paypal-integration-demo/internal/config"
 	"paypal-integration-demo/internal/model"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -24,26 +23,56 @@ type PaypalClient interface {
 	ExchangeAuthCode(ctx context.Context, code string) (*model.PayPalToken, error)
 	RefreshMerchantToken(ctx context.Context, refreshToken string) (*model.PayPalToken, error)
 
+	VerifyCredentials(ctx context.Context) error
 	GetMerchantUserInfo(ctx context.Context, merchantToken string) (string, error)
-
-	CreateOrderForApproval(ctx context.Context, serviceBaseUrl string, userID string, currency string, cost int32, merchantToken string) (*HandleOrderResponse, error)
-	CreateOrderWithVault(ctx context.Context, userID string, vaultID string, currency string, cost int32, merchantToken string) (string, error)
-	CaptureOrder(ctx context.Context, orderID string, merchantToken string) (*HandleOrderResponse, error)
+	CreatePartnerReferral(ctx context.Context, trackingID string, returnURL string) (actionURL string, err error)
+	GetMerchantIntegration(ctx context.Context, paypalMerchantID string) (*model.MerchantIntegration, error)
+
+	CreateOrderForApproval(ctx context.Context, experience *Experience, fundingSource model.FundingSource, units []*OrderUnit, auth *MerchantAuth) (*HandleOrderResponse, error)
+	CreateOrderWithVault(ctx context.Context, provider model.VaultProvider, vaultID string, clientMetadataID string, units []*OrderUnit, auth *MerchantAuth) (*HandleOrderResponse, error)
+	CreateCardOrder(ctx context.Context, serviceBaseUrl string, singleUseToken string, saveCard bool, units []*OrderUnit, auth *MerchantAuth) (*HandleOrderResponse, error)
+	GetOrder(ctx context.Context, orderID string, auth *MerchantAuth) (*HandleOrderResponse, error)
+	UpdateOrderAmounts(ctx context.Context, orderID string, units []*OrderUnit, auth *MerchantAuth) error
+	CaptureOrder(ctx context.Context, orderID string, auth *MerchantAuth) (*HandleOrderResponse, error)
+	RefundCapture(ctx context.Context, captureID string, auth *MerchantAuth) error
+	DeletePaymentToken(ctx context.Context, vaultID string, auth *MerchantAuth) error
+	CreateSetupToken(ctx context.Context, returnURL string, cancelURL string, auth *MerchantAuth) (setupTokenID string, approveURL string, err error)
+	CreatePaymentToken(ctx context.Context, setupTokenID string, auth *MerchantAuth) (*model.VaultPaymentToken, error)
 	VerifyWebhookSignature(ctx context.Context, headers http.Header, body []byte) error
-	CreateUserSubscription(ctx context.Context, serviceBaseUrl string, planID string, userID string, merchantAccessToken string) (subscriptionID string, approveURL string, err error)
+	CreateUserSubscription(ctx context.Context, experience *Experience, planID string, userID string, merchantAccessToken string) (subscriptionID string, approveURL string, err error)
 
 	CreateSubscriptionProduct(ctx context.Context, merchantToken string, product *model.Product) (string, error)
 	CreateSubscriptionPlan(ctx context.Context, merchantToken string, paypalProductID string, product *model.Product) (string, error)
 	CancelSubscription(ctx context.Context, merchantAccessToken string, subscriptionID string) error
+
+	ListDisputes(ctx context.Context, query *DisputeListQuery, auth *MerchantAuth) (*DisputePage, error)
+	GetDispute(ctx context.Context, disputeID string, auth *MerchantAuth) (*model.PaypalDispute, error)
+	ProvideEvidence(ctx context.Context, disputeID string, evidence []*DisputeEvidence, auth *MerchantAuth) error
+	AcceptClaim(ctx context.Context, disputeID string, note string, auth *MerchantAuth) error
+	MakeOffer(ctx context.Context, disputeID string, note string, currency string, amountMinor int64, auth *MerchantAuth) error
+
+	CreatePayout(ctx context.Context, payout *PayoutRequest) (*model.PayoutBatchHeader, error)
+	GetPayoutBatch(ctx context.Context, payoutBatchID string) (*model.PaypalPayoutBatch, error)
+
+	CreateDraftInvoice(ctx context.Context, invoice *InvoiceRequest, auth *MerchantAuth) (invoiceID string, err error)
+	GetInvoice(ctx context.Context, invoiceID string, auth *MerchantAuth) (*model.PaypalInvoice, error)
+	SendInvoice(ctx context.Context, invoiceID string, auth *MerchantAuth) error
+	RemindInvoice(ctx context.Context, invoiceID string, note string, auth *MerchantAuth) error
+	CancelInvoice(ctx context.Context, invoiceID string, note string, auth *MerchantAuth) error
+	RecordInvoicePayment(ctx context.Context, invoiceID string, payment *InvoicePayment, auth *MerchantAuth) (paymentID string, err error)
+	GenerateInvoiceQRCode(ctx context.Context, invoiceID string, auth *MerchantAuth) (string, error)
 }
 
 type paypalClientImpl struct {
 	httpClient         *http.Client
 	baseApiURL         string
+	webBaseURL         string
 	paypalClientID     string
 	paypalClientSecret string
 	paypalWebhookID    string
 	paypalRedirectURL  string
+	partnerMerchantID  string
+	partnerAttribution string
 }
 
 type HandleOrderResponse struct {
@@ -51,6 +80,128 @@ type HandleOrderResponse struct {
 	ApproveURL string
 	Status     string
 	PayerID    string
+	Captures   []*UnitCapture
+	Card       *model.Card // set when the order is paid by card
+	// PayerAddress is the country (and region when shared) of the PayPal payer
+	PayerAddress model.PaypalAddress
+}
+
+// UnitCapture is the capture outcome of one purchase_unit
+type UnitCapture struct {
+	ReferenceID string
+	CaptureID   string
+	Status      string // COMPLETED, PENDING, DECLINED, FAILED
+}
+
+// MerchantAuth selects whose credentials an order call is made with. A set
+// AccessToken is the merchant's own OAuth token; otherwise the platform's
+// partner token is used, acting on behalf of PayerID via PayPal-Auth-Assertion.
+// An empty MerchantAuth is the partner itself, as used for multi-payee orders.
+type MerchantAuth struct {
+	AccessToken string
+	PayerID     string
+}
+
+// OrderUnit describes a single purchase_unit of an order.
+type OrderUnit struct {
+	ReferenceID     string // also sent as invoice_id so capture webhooks can be matched to the unit
+	CustomID        string
+	Currency        string
+	Amount          int32
+	PayeeMerchantID string // PayPal merchant id receiving the funds
+	PlatformFee     int64  // minor units (cents), only allowed on partner orders
+	Discount        int64  // minor units (cents) taken off Amount
+	// Items are only sent when the unit is taxed, PayPal wants the tax per item
+	Items []*OrderItem
+	Tax   int64 // minor units (cents), the sum of the item tax
+}
+
+// OrderItem is a line of a purchase_unit. UnitAmount excludes tax, so for
+// tax inclusive prices it is the price minus the tax it contains.
+type OrderItem struct {
+	Name       string
+	SKU        string
+	Quantity   int32
+	UnitAmount int64 // minor units (cents)
+	Tax        int64 // minor units (cents) per unit
+}
+
+// Experience is the experience_context of an approval: where PayPal sends the
+// buyer back to and how its pages are presented.
+type Experience struct {
+	ReturnURL          string
+	CancelURL          string
+	BrandName          string
+	Locale             string
+	ShippingPreference string
+}
+
+func (e *Experience) context(userAction string) map[string]interface{} {
+	experienceContext := map[string]interface{}{
+		"return_url":  e.ReturnURL,
+		"cancel_url":  e.CancelURL,
+		"user_action": userAction,
+	}
+	if e.ShippingPreference != "" {
+		experienceContext["shipping_preference"] = e.ShippingPreference
+	}
+	if e.BrandName != "" {
+		experienceContext["brand_name"] = e.BrandName
+	}
+	if e.Locale != "" {
+		experienceContext["locale"] = e.Locale
+	}
+	return experienceContext
+}
+
+// APIError is a non-2xx response from the Orders API. Issue and Field hold
+// the first details[].issue code and its field, e.g. INSTRUMENT_DECLINED.
+// Links are the HATEOAS links of the error and of its details.
+type APIError struct {
+	StatusCode int
+	Name       string
+	Issue      string
+	Field      string
+	DebugID    string
+	Body       string
+	Links      []model.PaypalLink
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("paypal error %d: %s", e.StatusCode, e.Body)
+}
+
+func _newAPIError(resp *http.Response) *APIError {
+	b, _ := io.ReadAll(resp.Body)
+
+	var body struct {
+		Name    string `json:"name"`
+		DebugID string `json:"debug_id"`
+		Details []struct {
+			Field string             `json:"field"`
+			Issue string             `json:"issue"`
+			Link  []model.PaypalLink `json:"link"`
+		} `json:"details"`
+		Links []model.PaypalLink `json:"links"`
+	}
+	_ = json.Unmarshal(b, &body)
+
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Name:       body.Name,
+		DebugID:    body.DebugID,
+		Body:       string(b),
+		Links:      body.Links,
+	}
+	if len(body.Details) > 0 {
+		apiErr.Issue = body.Details[0].Issue
+		apiErr.Field = body.Details[0].Field
+	}
+	for _, detail := range body.Details {
+		apiErr.Links = append(apiErr.Links, detail.Link...)
+	}
+
+	return apiErr
 }
 
 type ConnectResponse struct {
@@ -62,11 +213,14 @@ func NewPaypalClient(paypalCfg *config.Paypal) PaypalClient {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		baseApiURL:         paypalCfg.BaseApiURL,
+		baseApiURL:         paypalCfg.APIBaseURL(),
+		webBaseURL:         paypalCfg.WebBaseURL(),
 		paypalClientID:     paypalCfg.ClientID,
 		paypalClientSecret: paypalCfg.ClientSecret,
 		paypalWebhookID:    paypalCfg.WebhookID,
 		paypalRedirectURL:  paypalCfg.RedirectURL,
+		partnerMerchantID:  paypalCfg.PartnerMerchantID,
+		partnerAttribution: paypalCfg.PartnerAttributionID,
 	}
 }
 
@@ -89,21 +243,60 @@ func (c *paypalClientImpl) getAccessToken() (string, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("paypal access token failed: status=%d body=%s", resp.StatusCode, b)
+	}
+
 	var res struct {
 		AccessToken string `json:"access_token"`
 	}
-	json.NewDecoder(resp.Body).Decode(&res)
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("decode paypal access token: %w", err)
+	}
 
 	return res.AccessToken, nil
 }
 
+// VerifyCredentials requests a partner token from the configured API host,
+// which fails when sandbox credentials are used against live or vice versa.
+func (c *paypalClientImpl) VerifyCredentials(ctx context.Context) error {
+	if _, err := c.getAccessToken(); err != nil {
+		return fmt.Errorf("paypal credentials rejected by %s: %w", c.baseApiURL, err)
+	}
+	return nil
+}
+
+func (c *paypalClientImpl) authorize(req *http.Request, auth *MerchantAuth) error {
+	if auth.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+auth.AccessToken)
+		return nil
+	}
+
+	accessToken, err := c.getAccessToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	if auth.PayerID != "" {
+		req.Header.Set("PayPal-Auth-Assertion", c.getAuthAssertionHeader(auth.PayerID))
+	}
+	if c.partnerAttribution != "" {
+		req.Header.Set("PayPal-Partner-Attribution-Id", c.partnerAttribution)
+	}
+
+	return nil
+}
+
 func (c *paypalClientImpl) BuildConnectURL(merchantID string) string {
 	// scopes := "openid https://uri.paypal.com/services/payments"
 	scopes := "openid profile email https://uri.paypal.com/services/paypalattributes"
 	// "https://uri.paypal.com/services/subscriptions " +
 	// "https://uri.paypal.com/services/payments/realtimepayment "
 	return fmt.Sprintf(
-		"https://www.sandbox.paypal.com/connect?flowEntry=static&client_id=%s&scope=%s&redirect_uri=%s&state=%s&response_type=code",
+		"%s/connect?flowEntry=static&client_id=%s&scope=%s&redirect_uri=%s&state=%s&response_type=code",
+		c.webBaseURL,
 		c.paypalClientID,
 		url.QueryEscape(scopes),
 		url.QueryEscape(c.paypalRedirectURL),
@@ -227,38 +420,137 @@ func (c *paypalClientImpl) GetMerchantUserInfo(ctx context.Context, merchantToke
 	return parts[len(parts)-1], nil
 }
 
-func (c *paypalClientImpl) CreateOrderForApproval(ctx context.Context, serviceBaseUrl string, userID string, currency string, cost int32, merchantToken string) (*HandleOrderResponse, error) {
+func (c *paypalClientImpl) CreatePartnerReferral(ctx context.Context, trackingID string, returnURL string) (string, error) {
+	accessToken, err := c.getAccessToken()
+	if err != nil {
+		return "", err
+	}
+
 	payload := map[string]interface{}{
-		"intent": "CAPTURE",
-		"purchase_units": []map[string]interface{}{
+		"tracking_id": trackingID,
+		"partner_config_override": map[string]interface{}{
+			"return_url": returnURL,
+		},
+		"operations": []map[string]interface{}{
 			{
-				"custom_id": userID,
-				"amount": map[string]string{
-					"currency_code": currency,
-					"value":         fmt.Sprintf("%.2f", float64(cost)),
+				"operation": "API_INTEGRATION",
+				"api_integration_preference": map[string]interface{}{
+					"rest_api_integration": map[string]interface{}{
+						"integration_method": "PAYPAL",
+						"integration_type":   "THIRD_PARTY",
+						"third_party_details": map[string]interface{}{
+							"features": []string{"PAYMENT", "REFUND", "PARTNER_FEE"},
+						},
+					},
 				},
 			},
 		},
-		"payment_source": map[string]interface{}{
-			"paypal": map[string]interface{}{
-				"experience_context": map[string]interface{}{
-					"return_url":   fmt.Sprintf("%s/api/paypal/success", serviceBaseUrl),
-					"cancel_url":   fmt.Sprintf("%s", serviceBaseUrl),
-					"landing_page": "LOGIN",
-					"user_action":  "PAY_NOW",
-				},
-				"attributes": map[string]interface{}{
-					"vault": map[string]interface{}{
-						"store_in_vault": "ON_SUCCESS",
-						"usage_type":     "MERCHANT",
-						"customer_type":  "CONSUMER",
-					},
-				},
+		"products": []string{"EXPRESS_CHECKOUT"},
+		"legal_consents": []map[string]interface{}{
+			{
+				"type":    "SHARE_DATA_CONSENT",
+				"granted": true,
 			},
 		},
 	}
 
-	result, err := c.createOrder(payload, merchantToken)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		c.baseApiURL+"/v2/customer/partner-referrals",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("paypal partner referral failed: %s", b)
+	}
+
+	var result model.PaypalResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	for _, link := range result.Links {
+		if link.Rel == "action_url" {
+			return link.Href, nil
+		}
+	}
+
+	return "", fmt.Errorf("action url not found")
+}
+
+func (c *paypalClientImpl) GetMerchantIntegration(ctx context.Context, paypalMerchantID string) (*model.MerchantIntegration, error) {
+	accessToken, err := c.getAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf(
+			"%s/v1/customer/partners/%s/merchant-integrations/%s",
+			c.baseApiURL,
+			c.partnerMerchantID,
+			paypalMerchantID,
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("paypal merchant integration failed: status=%d body=%s", resp.StatusCode, b)
+	}
+
+	var integration model.MerchantIntegration
+	if err := json.NewDecoder(resp.Body).Decode(&integration); err != nil {
+		return nil, err
+	}
+
+	return &integration, nil
+}
+
+// CreateOrderForApproval creates an order the buyer approves on PayPal with
+// the chosen funding source. Pay Later is a paypal payment source, the buyer
+// picks it on the PayPal side.
+func (c *paypalClientImpl) CreateOrderForApproval(ctx context.Context, experience *Experience, fundingSource model.FundingSource, units []*OrderUnit, auth *MerchantAuth) (*HandleOrderResponse, error) {
+	payload := map[string]interface{}{
+		"intent":         "CAPTURE",
+		"purchase_units": _purchaseUnits(units),
+		"payment_source": _approvalPaymentSource(experience, fundingSource),
+	}
+
+	result, err := c.createOrder(ctx, payload, auth, "")
 	if err != nil {
 		return nil, err
 	}
@@ -271,57 +563,210 @@ func (c *paypalClientImpl) CreateOrderForApproval(ctx context.Context, serviceBa
 	}, nil
 }
 
-func (c *paypalClientImpl) CreateOrderWithVault(ctx context.Context, userID string, vaultID string, currency string, cost int32, merchantToken string) (string, error) {
-	payload := map[string]interface{}{
-		"intent": "CAPTURE",
-		"purchase_units": []map[string]interface{}{
-			{
-				"custom_id": userID,
-				"amount": map[string]string{
-					"currency_code": currency,
-					"value":         fmt.Sprintf("%.2f", float64(cost)),
+func _approvalPaymentSource(experience *Experience, fundingSource model.FundingSource) map[string]interface{} {
+	experienceContext := experience.context("PAY_NOW")
+
+	switch fundingSource {
+	case model.FUNDING_VENMO:
+		return map[string]interface{}{
+			"venmo": map[string]interface{}{
+				"experience_context": experienceContext,
+			},
+		}
+	case model.FUNDING_PAYLATER:
+		// Pay Later plans cannot be vaulted
+		return map[string]interface{}{
+			"paypal": map[string]interface{}{
+				"experience_context": experienceContext,
+			},
+		}
+	}
+
+	experienceContext["landing_page"] = "LOGIN"
+	return map[string]interface{}{
+		"paypal": map[string]interface{}{
+			"experience_context": experienceContext,
+			"attributes": map[string]interface{}{
+				"vault": map[string]interface{}{
+					"store_in_vault": "ON_SUCCESS",
+					"usage_type":     "MERCHANT",
+					"customer_type":  "CONSUMER",
 				},
 			},
 		},
+	}
+}
+
+// CreateOrderWithVault charges a saved payment token. PayPal captures these
+// orders immediately, so the response carries the capture outcome per unit.
+// clientMetadataID is the buyer's device fingerprint for PayPal's risk checks.
+func (c *paypalClientImpl) CreateOrderWithVault(ctx context.Context, provider model.VaultProvider, vaultID string, clientMetadataID string, units []*OrderUnit, auth *MerchantAuth) (*HandleOrderResponse, error) {
+	payload := map[string]interface{}{
+		"intent":         "CAPTURE",
+		"purchase_units": _purchaseUnits(units),
 		"payment_source": map[string]interface{}{
-			"paypal": map[string]string{
+			string(provider): map[string]string{
 				"vault_id": vaultID,
 			},
 		},
 	}
 
-	result, err := c.createOrder(payload, merchantToken)
+	result, err := c.createOrder(ctx, payload, auth, clientMetadataID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return result.ID, nil
+	return _orderResponse(result), nil
+}
+
+// CreateCardOrder creates an order paid with a single-use token from the hosted
+// card fields. 3-D Secure runs when the issuer requires it, in which case the
+// order comes back PAYER_ACTION_REQUIRED and ApproveURL is the challenge page.
+func (c *paypalClientImpl) CreateCardOrder(ctx context.Context, serviceBaseUrl string, singleUseToken string, saveCard bool, units []*OrderUnit, auth *MerchantAuth) (*HandleOrderResponse, error) {
+	attributes := map[string]interface{}{
+		"verification": map[string]string{
+			"method": "SCA_WHEN_REQUIRED",
+		},
+	}
+	if saveCard {
+		attributes["vault"] = map[string]string{
+			"store_in_vault": "ON_SUCCESS",
+		}
+	}
+
+	payload := map[string]interface{}{
+		"intent":         "CAPTURE",
+		"purchase_units": _purchaseUnits(units),
+		"payment_source": map[string]interface{}{
+			"card": map[string]interface{}{
+				"single_use_token": singleUseToken,
+				"attributes":       attributes,
+				"experience_context": map[string]string{
+					"return_url": fmt.Sprintf("%s/api/paypal/card/return", serviceBaseUrl),
+					"cancel_url": fmt.Sprintf("%s/api/paypal/card/return", serviceBaseUrl),
+				},
+			},
+		},
+	}
+
+	result, err := c.createOrder(ctx, payload, auth, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return _orderResponse(result), nil
+}
+
+func (c *paypalClientImpl) GetOrder(ctx context.Context, orderID string, auth *MerchantAuth) (*HandleOrderResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		c.baseApiURL+"/v2/checkout/orders/"+url.PathEscape(orderID),
+		nil)
+	if err != nil {
+		return nil, fmt.Errorf("http new request: %w", err)
+	}
+
+	if err := c.authorize(req, auth); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http client do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("paypal get order failed: %w", _newAPIError(resp))
+	}
+
+	var result model.PaypalResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode paypal response: %w", err)
+	}
+
+	return _orderResponse(&result), nil
+}
+
+// UpdateOrderAmounts replaces the amount and items of the given purchase units
+// of an approved order that is not captured yet
+func (c *paypalClientImpl) UpdateOrderAmounts(ctx context.Context, orderID string, units []*OrderUnit, auth *MerchantAuth) error {
+	var patch []map[string]interface{}
+	for _, unit := range units {
+		path := fmt.Sprintf("/purchase_units/@reference_id=='%s'", unit.ReferenceID)
+		patch = append(patch, map[string]interface{}{
+			"op":    "replace",
+			"path":  path + "/amount",
+			"value": _unitAmount(unit),
+		})
+		if len(unit.Items) > 0 {
+			patch = append(patch, map[string]interface{}{
+				"op":    "add",
+				"path":  path + "/items",
+				"value": _purchaseItems(unit),
+			})
+		}
+	}
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("marshal req payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch,
+		c.baseApiURL+"/v2/checkout/orders/"+url.PathEscape(orderID),
+		bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("http new request: %w", err)
+	}
+
+	if err := c.authorize(req, auth); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http client do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("paypal update order failed: %w", _newAPIError(resp))
+	}
+
+	return nil
 }
 
-func (c *paypalClientImpl) createOrder(payload map[string]interface{}, merchantToken string) (*model.PaypalResult, error) {
+func (c *paypalClientImpl) createOrder(ctx context.Context, payload map[string]interface{}, auth *MerchantAuth, clientMetadataID string) (*model.PaypalResult, error) {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return nil, fmt.Errorf("marshal req payload: %w", err)
 	}
-	req, err := http.NewRequest("POST",
+	req, err := http.NewRequestWithContext(ctx, "POST",
 		c.baseApiURL+"/v2/checkout/orders",
 		bytes.NewBuffer(body))
 	if err != nil {
 		return nil, fmt.Errorf("http new request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+merchantToken)
+	if err := c.authorize(req, auth); err != nil {
+		return nil, err
+	}
 	req.Header.Set("Content-Type", "application/json")
 
 	// REQUIRED for vault charge
 	req.Header.Set("PayPal-Request-Id", uuid.NewString())
+	if clientMetadataID != "" {
+		req.Header.Set("PayPal-Client-Metadata-Id", clientMetadataID)
+	}
 
-	resp, _ := c.httpClient.Do(req)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http client do: %w", err)
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("paypal error %d: %s", resp.StatusCode, string(b))
+		return nil, _newAPIError(resp)
 	}
 
 	var result model.PaypalResult
@@ -332,7 +777,7 @@ func (c *paypalClientImpl) createOrder(payload map[string]interface{}, merchantT
 	return &result, nil
 }
 
-func (c *paypalClientImpl) CaptureOrder(ctx context.Context, orderID string, merchantToken string) (*HandleOrderResponse, error) {
+func (c *paypalClientImpl) CaptureOrder(ctx context.Context, orderID string, auth *MerchantAuth) (*HandleOrderResponse, error) {
 	url := fmt.Sprintf(
 		"%s/v2/checkout/orders/%s/capture",
 		c.baseApiURL,
@@ -349,7 +794,9 @@ func (c *paypalClientImpl) CaptureOrder(ctx context.Context, orderID string, mer
 		return nil, fmt.Errorf("create capture request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+merchantToken)
+	if err := c.authorize(req, auth); err != nil {
+		return nil, err
+	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.httpClient.Do(req)
@@ -359,12 +806,7 @@ func (c *paypalClientImpl) CaptureOrder(ctx context.Context, orderID string, mer
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf(
-			"paypal capture failed: status=%d body=%s",
-			resp.StatusCode,
-			string(b),
-		)
+		return nil, fmt.Errorf("paypal capture failed: %w", _newAPIError(resp))
 	}
 
 	var result model.PaypalResult
@@ -372,11 +814,163 @@ func (c *paypalClientImpl) CaptureOrder(ctx context.Context, orderID string, mer
 		return nil, fmt.Errorf("decode paypal response: %w", err)
 	}
 
-	return &HandleOrderResponse{
-		OrderID: result.ID,
-		PayerID: result.Payer.PayerID,
-		Status:  result.Status,
-	}, nil
+	return _orderResponse(&result), nil
+}
+
+// RefundCapture refunds the full captured amount
+func (c *paypalClientImpl) RefundCapture(ctx context.Context, captureID string, auth *MerchantAuth) error {
+	path := "/v2/payments/captures/" + url.PathEscape(captureID) + "/refund"
+	if err := c.apiCall(ctx, http.MethodPost, path, "application/json", strings.NewReader("{}"), auth, nil); err != nil {
+		return fmt.Errorf("paypal refund capture: %w", err)
+	}
+	return nil
+}
+
+func (c *paypalClientImpl) DeletePaymentToken(ctx context.Context, vaultID string, auth *MerchantAuth) error {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodDelete,
+		c.baseApiURL+"/v3/vault/payment-tokens/"+url.PathEscape(vaultID),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := c.authorize(req, auth); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// already deleted on PayPal's side
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("paypal delete payment token failed: status=%d body=%s", resp.StatusCode, b)
+	}
+
+	return nil
+}
+
+func (c *paypalClientImpl) CreateSetupToken(ctx context.Context, returnURL string, cancelURL string, auth *MerchantAuth) (string, string, error) {
+	payload := map[string]interface{}{
+		"payment_source": map[string]interface{}{
+			"paypal": map[string]interface{}{
+				"usage_type":                     "MERCHANT",
+				"customer_type":                  "CONSUMER",
+				"permit_multiple_payment_tokens": true,
+				"experience_context": map[string]interface{}{
+					"return_url":          returnURL,
+					"cancel_url":          cancelURL,
+					"shipping_preference": "NO_SHIPPING",
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		c.baseApiURL+"/v3/vault/setup-tokens",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := c.authorize(req, auth); err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("PayPal-Request-Id", uuid.NewString())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return "", "", fmt.Errorf("paypal create setup token failed: status=%d body=%s", resp.StatusCode, b)
+	}
+
+	var result model.PaypalResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", "", err
+	}
+
+	approveURL := _extractApproveURL(result.Links)
+	if approveURL == "" {
+		return result.ID, "", fmt.Errorf("approve url not found")
+	}
+
+	return result.ID, approveURL, nil
+}
+
+func (c *paypalClientImpl) CreatePaymentToken(ctx context.Context, setupTokenID string, auth *MerchantAuth) (*model.VaultPaymentToken, error) {
+	payload := map[string]interface{}{
+		"payment_source": map[string]interface{}{
+			"token": map[string]string{
+				"id":   setupTokenID,
+				"type": "SETUP_TOKEN",
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		c.baseApiURL+"/v3/vault/payment-tokens",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.authorize(req, auth); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// exchanging the same setup token twice must not create two payment tokens
+	req.Header.Set("PayPal-Request-Id", setupTokenID)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("paypal create payment token failed: status=%d body=%s", resp.StatusCode, b)
+	}
+
+	var token model.VaultPaymentToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
 }
 
 func (c *paypalClientImpl) VerifyWebhookSignature(ctx context.Context, headers http.Header, body []byte) error {
@@ -428,15 +1022,11 @@ func (c *paypalClientImpl) VerifyWebhookSignature(ctx context.Context, headers h
 	return nil
 }
 
-func (c *paypalClientImpl) CreateUserSubscription(ctx context.Context, serviceBaseUrl string, planID string, userID string, merchantAccessToken string) (subscriptionID string, approveURL string, err error) {
+func (c *paypalClientImpl) CreateUserSubscription(ctx context.Context, experience *Experience, planID string, userID string, merchantAccessToken string) (subscriptionID string, approveURL string, err error) {
 	payload := map[string]interface{}{
-		"plan_id":   planID,
-		"custom_id": userID,
-		"application_context": map[string]interface{}{
-			"user_action": "SUBSCRIBE_NOW",
-			"return_url":  fmt.Sprintf("%s/api/paypal/subscription/success", serviceBaseUrl),
-			"cancel_url":  serviceBaseUrl,
-		},
+		"plan_id":             planID,
+		"custom_id":           userID,
+		"application_context": experience.context("SUBSCRIBE_NOW"),
 	}
 
 	body, _ := json.Marshal(payload)
@@ -648,27 +1238,147 @@ func (c *paypalClientImpl) CancelSubscription(ctx context.Context, merchantAcces
 	return nil
 }
 
-func _extractApproveURL(links []model.PaypalLink) string {
-	for _, link := range links {
-		if link.Rel == "approve" || link.Rel == "payer-action" {
-			return link.Href
+func _purchaseUnits(units []*OrderUnit) []map[string]interface{} {
+	purchaseUnits := make([]map[string]interface{}, len(units))
+	for i, unit := range units {
+		purchaseUnits[i] = _purchaseUnit(unit)
+	}
+	return purchaseUnits
+}
+
+func _purchaseUnit(unit *OrderUnit) map[string]interface{} {
+	purchaseUnit := map[string]interface{}{
+		"reference_id": unit.ReferenceID,
+		"invoice_id":   unit.ReferenceID,
+		"custom_id":    unit.CustomID,
+		"amount":       _unitAmount(unit),
+	}
+
+	if len(unit.Items) > 0 {
+		purchaseUnit["items"] = _purchaseItems(unit)
+	}
+
+	if unit.PayeeMerchantID != "" {
+		purchaseUnit["payee"] = map[string]string{
+			"merchant_id": unit.PayeeMerchantID,
 		}
 	}
-	return ""
+
+	if unit.PlatformFee > 0 {
+		purchaseUnit["payment_instruction"] = map[string]interface{}{
+			"platform_fees": []map[string]interface{}{
+				{
+					"amount": map[string]string{
+						"currency_code": unit.Currency,
+						"value":         _formatMinorAmount(unit.PlatformFee),
+					},
+				},
+			},
+		}
+	}
+
+	return purchaseUnit
 }
 
-func GenerateAuthAssertion(merchantID string) (string, error) {
-	claims := jwt.MapClaims{
-		"iss":      os.Getenv("PAYPAL_CLIENT_ID"),
-		"payer_id": merchantID,
+// _unitAmount is the amount to pay, with a breakdown when promotions took a
+// discount off the items or the items are taxed
+func _unitAmount(unit *OrderUnit) map[string]interface{} {
+	if unit.Discount <= 0 && len(unit.Items) == 0 {
+		return map[string]interface{}{
+			"currency_code": unit.Currency,
+			"value":         fmt.Sprintf("%.2f", float64(unit.Amount)),
+		}
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	itemTotal := int64(unit.Amount) * 100
+	if len(unit.Items) > 0 {
+		itemTotal = 0
+		for _, item := range unit.Items {
+			itemTotal += item.UnitAmount * int64(item.Quantity)
+		}
+	}
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(os.Getenv("PAYPAL_PRIVATE_KEY")))
-	if err != nil {
-		return "", err
+	breakdown := map[string]interface{}{
+		"item_total": _money(unit.Currency, itemTotal),
 	}
+	if unit.Discount > 0 {
+		breakdown["discount"] = _money(unit.Currency, unit.Discount)
+	}
+	if len(unit.Items) > 0 {
+		breakdown["tax_total"] = _money(unit.Currency, unit.Tax)
+	}
+
+	return map[string]interface{}{
+		"currency_code": unit.Currency,
+		"value":         _formatMinorAmount(itemTotal + unit.Tax - unit.Discount),
+		"breakdown":     breakdown,
+	}
+}
 
-	return token.SignedString(privateKey)
+func _purchaseItems(unit *OrderUnit) []map[string]interface{} {
+	items := make([]map[string]interface{}, len(unit.Items))
+	for i, item := range unit.Items {
+		items[i] = map[string]interface{}{
+			"name":        item.Name,
+			"sku":         item.SKU,
+			"quantity":    strconv.Itoa(int(item.Quantity)),
+			"unit_amount": _money(unit.Currency, item.UnitAmount),
+			"tax":         _money(unit.Currency, item.Tax),
+			"category":    "DIGITAL_GOODS",
+		}
+	}
+	return items
+}
+
+func _money(currency string, minor int64) map[string]string {
+	return map[string]string{
+		"currency_code": currency,
+		"value":         _formatMinorAmount(minor),
+	}
+}
+
+// _formatMinorAmount renders an amount in cents as a PayPal decimal string
+func _formatMinorAmount(minor int64) string {
+	return fmt.Sprintf("%d.%02d", minor/100, minor%100)
+}
+
+func _extractUnitCaptures(purchaseUnits []model.PurchaseUnit) []*UnitCapture {
+	var captures []*UnitCapture
+	for _, unit := range purchaseUnits {
+		for _, capture := range unit.Payments.Captures {
+			captures = append(captures, &UnitCapture{
+				ReferenceID: unit.ReferenceID,
+				CaptureID:   capture.ID,
+				Status:      capture.Status,
+			})
+		}
+	}
+	return captures
+}
+
+func _orderResponse(result *model.PaypalResult) *HandleOrderResponse {
+	resp := &HandleOrderResponse{
+		OrderID:    result.ID,
+		ApproveURL: _extractApproveURL(result.Links),
+		Status:     result.Status,
+		PayerID:    result.Payer.PayerID,
+		Captures:   _extractUnitCaptures(result.PurchaseUnits),
+	}
+	resp.PayerAddress = result.Payer.Address
+	if resp.PayerAddress.CountryCode == "" {
+		resp.PayerAddress = result.PaymentSource.PayPal.Address
+	}
+	if result.PaymentSource.Card.LastDigits != "" {
+		resp.Card = &result.PaymentSource.Card
+	}
+	return resp
+}
+
+func _extractApproveURL(links []model.PaypalLink) string {
+	for _, link := range links {
+		if link.Rel == "approve" || link.Rel == "payer-action" {
+			return link.Href
+		}
+	}
+	return ""
 }
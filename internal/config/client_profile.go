@@ -0,0 +1,112 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultClientProfile is the web page served by this service, used when a
+// request does not name a profile.
+const DefaultClientProfile = "web"
+
+// ClientProfile is an allow-listed client (web, Unity, mobile) with its own
+// PayPal experience and the deep links buyers are sent back to.
+type ClientProfile struct {
+	Name string `json:"-"`
+	// ReturnURL receives order_id/subscription_id and status once PayPal is done
+	ReturnURL string `json:"return_url"`
+	CancelURL string `json:"cancel_url"`
+	BrandName string `json:"brand_name"`
+	// Locale is a BCP 47 tag such as en-US, empty lets PayPal pick
+	Locale string `json:"locale"`
+	// ShippingPreference is NO_SHIPPING, GET_FROM_FILE or SET_PROVIDED_ADDRESS
+	ShippingPreference string `json:"shipping_preference"`
+}
+
+type ClientProfiles map[string]*ClientProfile
+
+// LoadClientProfiles reads the profiles file (a JSON object keyed by profile
+// name) and adds the default web profile pointing back at baseURL.
+func LoadClientProfiles(path string, baseURL string) (ClientProfiles, error) {
+	profiles := ClientProfiles{}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read client profiles: %w", err)
+		}
+		if err := json.Unmarshal(b, &profiles); err != nil {
+			return nil, fmt.Errorf("decode client profiles: %w", err)
+		}
+	}
+
+	if _, ok := profiles[DefaultClientProfile]; !ok {
+		profiles[DefaultClientProfile] = &ClientProfile{
+			ReturnURL: strings.TrimSuffix(baseURL, "/") + "/",
+			CancelURL: strings.TrimSuffix(baseURL, "/") + "/",
+		}
+	}
+
+	for name, profile := range profiles {
+		profile.Name = name
+		if profile.ShippingPreference == "" {
+			profile.ShippingPreference = "NO_SHIPPING"
+		}
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("client profile %s: %w", name, err)
+		}
+	}
+
+	return profiles, nil
+}
+
+func (p *ClientProfile) validate() error {
+	for _, raw := range []string{p.ReturnURL, p.CancelURL} {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" {
+			return fmt.Errorf("invalid url %q", raw)
+		}
+	}
+
+	switch p.ShippingPreference {
+	case "NO_SHIPPING", "GET_FROM_FILE", "SET_PROVIDED_ADDRESS":
+	default:
+		return fmt.Errorf("invalid shipping_preference %q", p.ShippingPreference)
+	}
+
+	return nil
+}
+
+// Get returns the named profile, the default one when name is empty
+func (p ClientProfiles) Get(name string) (*ClientProfile, bool) {
+	if name == "" {
+		name = DefaultClientProfile
+	}
+	profile, ok := p[name]
+	return profile, ok
+}
+
+// ResultURL is ReturnURL (or CancelURL) with the result appended as query params
+func (p *ClientProfile) ResultURL(cancelled bool, params url.Values) string {
+	target := p.ReturnURL
+	if cancelled {
+		target = p.CancelURL
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
@@ -1,21 +1,111 @@
 package config
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	PaypalModeSandbox = "sandbox"
+	PaypalModeLive    = "live"
+)
+
 type Config struct {
 	Environment Environment
 	Log         Log
 	HTTP        HTTPServer
 	BaseURL     string `env:"BASE_URL"`
 	DatabaseURL string `env:"DATABASE_URL"`
+	// ClientProfilesFile is a JSON file of allow-listed client profiles, see ClientProfile
+	ClientProfilesFile string `env:"CLIENT_PROFILES_FILE"`
+	// TaxRatesFile is a JSON table of tax rates by country or region, see TaxRates
+	TaxRatesFile string `env:"TAX_RATES_FILE"`
+	// TemplatesOverrideDir holds per-merchant result pages: <dir>/<merchantID>/<page>.html
+	TemplatesOverrideDir string `env:"TEMPLATES_OVERRIDE_DIR"`
+	// AdminToken is the bearer token support staff use for /api/admin
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// GameServerAPIKey authenticates game servers calling /api/game with X-API-Key
+	GameServerAPIKey string `env:"GAME_SERVER_API_KEY"`
 
-	Paypal Paypal `envPrefix:"PAYPAL_"`
+	Paypal         Paypal         `envPrefix:"PAYPAL_"`
+	PurchaseLimits PurchaseLimits `envPrefix:"PURCHASE_LIMIT_"`
+	RiskRules      RiskRules      `envPrefix:"RISK_"`
+	Disputes       Disputes       `envPrefix:"DISPUTE_"`
+	Payouts        Payouts        `envPrefix:"PAYOUT_"`
 }
 
 type Paypal struct {
+	// Mode is sandbox or live and selects the PayPal API, web and JS SDK hosts.
+	Mode string `env:"MODE" envDefault:"sandbox"`
+	// BaseApiURL overrides the API host derived from Mode, e.g. for a mock server.
 	BaseApiURL   string `env:"BASE_API_URL"`
 	ClientID     string `env:"CLIENT_ID"`
 	ClientSecret string `env:"CLIENT_SECRET"`
 	WebhookID    string `env:"WEBHOOK_ID"`
 	RedirectURL  string `env:"REDIRECT_URL"`
+
+	// PartnerMerchantID is the platform's own PayPal merchant id, used to
+	// look up the onboarding status of merchants it referred.
+	PartnerMerchantID string `env:"PARTNER_MERCHANT_ID"`
+	// PartnerAttributionID is the BN code sent on calls made on behalf of merchants.
+	PartnerAttributionID string `env:"PARTNER_ATTRIBUTION_ID"`
+}
+
+// PurchaseLimits are the default caps of every user, 0 means unlimited.
+// Amounts are in minor units (cents) of what the buyer pays, spend windows
+// are rolling: the last 24 hours, 7 days and 30 days.
+type PurchaseLimits struct {
+	MaxOrderAmount       int64 `env:"MAX_ORDER_AMOUNT"`
+	DailySpend           int64 `env:"DAILY_SPEND"`
+	WeeklySpend          int64 `env:"WEEKLY_SPEND"`
+	MonthlySpend         int64 `env:"MONTHLY_SPEND"`
+	MerchantDailySpend   int64 `env:"MERCHANT_DAILY_SPEND"`
+	MerchantWeeklySpend  int64 `env:"MERCHANT_WEEKLY_SPEND"`
+	MerchantMonthlySpend int64 `env:"MERCHANT_MONTHLY_SPEND"`
+	MaxSKUQuantity       int32 `env:"MAX_SKU_QUANTITY"`
+	// MaxPayAttempts Pay and PayAgain calls are allowed per PayAttemptWindow
+	MaxPayAttempts   int32         `env:"MAX_PAY_ATTEMPTS"`
+	PayAttemptWindow time.Duration `env:"PAY_ATTEMPT_WINDOW" envDefault:"1m"`
+}
+
+const (
+	RiskActionReview = "review"
+	RiskActionDeny   = "deny"
+)
+
+// RiskRules are checked before a saved payment method is charged. Each rule
+// is off while its threshold is 0, a hit answers with the rule's action
+// (review or deny). Amounts are in minor units (cents).
+type RiskRules struct {
+	// NewVaultAmount is the largest charge allowed on a method saved less than NewVaultAge ago
+	NewVaultAmount int64         `env:"NEW_VAULT_AMOUNT"`
+	NewVaultAge    time.Duration `env:"NEW_VAULT_AGE" envDefault:"24h"`
+	NewVaultAction string        `env:"NEW_VAULT_ACTION" envDefault:"review"`
+	// MaxDistinctPayers is how many PayPal accounts or cards a user may save per DistinctPayerWindow
+	MaxDistinctPayers   int64         `env:"MAX_DISTINCT_PAYERS"`
+	DistinctPayerWindow time.Duration `env:"DISTINCT_PAYER_WINDOW" envDefault:"720h"`
+	DistinctPayerAction string        `env:"DISTINCT_PAYER_ACTION" envDefault:"review"`
+	// MaxDeclines is how many declined charges a user may retry after per DeclineWindow
+	MaxDeclines   int64         `env:"MAX_DECLINES"`
+	DeclineWindow time.Duration `env:"DECLINE_WINDOW" envDefault:"15m"`
+	DeclineAction string        `env:"DECLINE_ACTION" envDefault:"deny"`
+}
+
+// Disputes tells support about disputes that need an answer soon
+type Disputes struct {
+	// AlertLead is how long before the seller response due date support is alerted
+	AlertLead time.Duration `env:"ALERT_LEAD" envDefault:"48h"`
+	// AlertWebhookURL receives a JSON POST per alert, alerts are only logged when empty
+	AlertWebhookURL string `env:"ALERT_WEBHOOK_URL"`
+}
+
+// Payouts are cash-outs of creator earnings from the platform's PayPal balance
+type Payouts struct {
+	// ApprovalThreshold in minor units (cents), larger payouts wait for support to approve them
+	ApprovalThreshold int64 `env:"APPROVAL_THRESHOLD" envDefault:"10000"`
+	// EmailSubject is the subject of the email PayPal sends to the receiver
+	EmailSubject string `env:"EMAIL_SUBJECT" envDefault:"You have a payout"`
 }
 
 type Environment struct {
@@ -31,3 +121,64 @@ type HTTPServer struct {
 	Host string `env:"HTTP_HOST" envDefault:"0.0.0.0"`
 	Port string `env:"HTTP_PORT" envDefault:"8080"`
 }
+
+func (p *Paypal) APIBaseURL() string {
+	if p.BaseApiURL != "" {
+		return strings.TrimSuffix(p.BaseApiURL, "/")
+	}
+	if p.Mode == PaypalModeLive {
+		return "https://api-m.paypal.com"
+	}
+	return "https://api-m.sandbox.paypal.com"
+}
+
+func (p *Paypal) WebBaseURL() string {
+	if p.Mode == PaypalModeLive {
+		return "https://www.paypal.com"
+	}
+	return "https://www.sandbox.paypal.com"
+}
+
+func (p *Paypal) JSSDKURL() string {
+	return p.WebBaseURL() + "/sdk/js"
+}
+
+// Validate rejects configurations that would mix sandbox and live PayPal,
+// and refuses live mode in a development environment.
+func (c *Config) Validate() error {
+	mode := c.Paypal.Mode
+	if mode != PaypalModeSandbox && mode != PaypalModeLive {
+		return fmt.Errorf("PAYPAL_MODE must be %q or %q, got %q", PaypalModeSandbox, PaypalModeLive, mode)
+	}
+
+	if mode == PaypalModeLive && c.Environment.Name == "development" {
+		return fmt.Errorf("PAYPAL_MODE=live is not allowed when ENVIRONMENT=development")
+	}
+
+	if c.Paypal.ClientID == "" || c.Paypal.ClientSecret == "" {
+		return fmt.Errorf("PAYPAL_CLIENT_ID and PAYPAL_CLIENT_SECRET are required")
+	}
+
+	for name, action := range map[string]string{
+		"RISK_NEW_VAULT_ACTION":      c.RiskRules.NewVaultAction,
+		"RISK_DISTINCT_PAYER_ACTION": c.RiskRules.DistinctPayerAction,
+		"RISK_DECLINE_ACTION":        c.RiskRules.DeclineAction,
+	} {
+		if action != RiskActionReview && action != RiskActionDeny {
+			return fmt.Errorf("%s must be %q or %q, got %q", name, RiskActionReview, RiskActionDeny, action)
+		}
+	}
+
+	if c.Paypal.BaseApiURL != "" {
+		isSandboxURL := strings.Contains(c.Paypal.BaseApiURL, "sandbox.paypal.com")
+		isLiveURL := strings.Contains(c.Paypal.BaseApiURL, "paypal.com") && !isSandboxURL
+		if mode == PaypalModeLive && !isLiveURL {
+			return fmt.Errorf("PAYPAL_BASE_API_URL %s is not a live PayPal API", c.Paypal.BaseApiURL)
+		}
+		if mode == PaypalModeSandbox && isLiveURL {
+			return fmt.Errorf("PAYPAL_BASE_API_URL %s is a live PayPal API but PAYPAL_MODE=sandbox", c.Paypal.BaseApiURL)
+		}
+	}
+
+	return nil
+}
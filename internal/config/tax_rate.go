@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TaxRate is the tax on digital goods sold to buyers in a country or region
+type TaxRate struct {
+	// Name is shown on tax reports, e.g. "DE VAT" or "California sales tax"
+	Name string `json:"name"`
+	// RateBps is the rate in basis points, 1900 = 19%
+	RateBps int64 `json:"rate_bps"`
+	// Inclusive prices already contain the tax (EU VAT), exclusive prices get
+	// it added on top (US sales tax)
+	Inclusive bool `json:"inclusive"`
+}
+
+// TaxRates is keyed by ISO 3166-1 country code ("DE"), or country and region
+// for regional rates ("US-CA"). Buyers in countries missing from the table
+// are not taxed.
+type TaxRates map[string]*TaxRate
+
+// LoadTaxRates reads the tax table file, an empty path disables tax
+func LoadTaxRates(path string) (TaxRates, error) {
+	rates := TaxRates{}
+	if path == "" {
+		return rates, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read tax rates: %w", err)
+	}
+	var raw TaxRates
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("decode tax rates: %w", err)
+	}
+
+	for key, rate := range raw {
+		if rate == nil || rate.RateBps < 0 || rate.RateBps > 10000 {
+			return nil, fmt.Errorf("tax rate %s: rate_bps must be 0-10000", key)
+		}
+		rates[strings.ToUpper(key)] = rate
+	}
+
+	return rates, nil
+}
+
+// Lookup returns the regional rate when there is one, else the country rate,
+// together with the key it was found under
+func (r TaxRates) Lookup(country string, region string) (string, *TaxRate, bool) {
+	country = strings.ToUpper(country)
+	if region != "" {
+		key := country + "-" + strings.ToUpper(region)
+		if rate, ok := r[key]; ok {
+			return key, rate, true
+		}
+	}
+
+	rate, ok := r[country]
+	return country, rate, ok
+}
@@ -1,5 +1,10 @@
 package dto
 
+import (
+	"paypal-integration-demo/internal/model"
+	"time"
+)
+
 type Item struct {
 	Sku      string `json:"sku"`
 	Quantity int32  `json:"quantity"`
@@ -7,11 +12,134 @@ type Item struct {
 
 type PayRequest struct {
 	Items []*Item `json:"items"`
+	// PaymentMethodID picks the saved payment method for pay-again, the default one when empty
+	PaymentMethodID string `json:"payment_method_id,omitempty"`
+	// FundingSource picks paypal (default), venmo or paylater for pay
+	FundingSource model.FundingSource `json:"funding_source,omitempty"`
+	CouponCode    string              `json:"coupon_code,omitempty"`
+	// ClientMetadataID is the device fingerprint sent to PayPal with pay-again charges
+	ClientMetadataID string `json:"client_metadata_id,omitempty"`
+}
+
+type FundingSourcesResponse struct {
+	FundingSources []model.FundingSource `json:"funding_sources"`
+}
+
+type CreateOrderResponse struct {
+	ID string `json:"id"`
+}
+
+type CaptureResponse struct {
+	OrderID  string         `json:"order_id"`
+	Status   string         `json:"status"` // order status, see model.Order
+	Captures []*UnitCapture `json:"captures"`
+}
+
+type UnitCapture struct {
+	ReferenceID string `json:"reference_id"`
+	MerchantID  string `json:"merchant_id"`
+	CaptureID   string `json:"capture_id,omitempty"`
+	Status      string `json:"status"`
+}
+
+type CaptureErrorResponse struct {
+	Error   string `json:"error"`
+	OrderID string `json:"order_id"`
+	// Restart tells the JS SDK to call actions.restart() so the buyer can pick another funding source
+	Restart bool `json:"restart"`
+}
+
+// OrderEvent is one state change of an order, streamed as Server-Sent Events
+type OrderEvent struct {
+	OrderID string    `json:"order_id"`
+	Type    string    `json:"type"`   // status (initial snapshot), approved, captured, granted, failed
+	Status  string    `json:"status"` // order status after the change
+	At      time.Time `json:"at"`
+}
+
+// OrderResult is what the buyer sees when coming back from PayPal
+type OrderResult struct {
+	OrderID    string
+	MerchantID string
+	Status     string
+	Outcome    string // captured, partial, pending, failed, cancelled
+	Amount     string // paid, after Discount and with Tax
+	Discount   string
+	Tax        string
+	Currency   string
+	Items      []*OrderResultItem
+	// RedirectURL is set for client profiles other than the web page
+	RedirectURL string
+	HomeURL     string
+}
+
+type OrderResultItem struct {
+	ProductID string
+	Name      string
+	Quantity  int32
+}
+
+type SubscriptionResult struct {
+	SubscriptionID string
+	MerchantID     string
+	ProductName    string
+	Status         string
+	Outcome        string // active, pending, failed, cancelled
+	RedirectURL    string
+	HomeURL        string
+}
+
+type CardPayRequest struct {
+	Items []*Item `json:"items"`
+	// SingleUseToken is the card token returned by the hosted card fields
+	SingleUseToken string `json:"single_use_token"`
+	SaveCard       bool   `json:"save_card"`
+	CouponCode     string `json:"coupon_code,omitempty"`
+	// BillingAddress is entered with the card and decides the tax
+	BillingAddress *BillingAddress `json:"billing_address,omitempty"`
+}
+
+type BillingAddress struct {
+	CountryCode string `json:"country_code"`     // ISO 3166-1 alpha-2
+	Region      string `json:"region,omitempty"` // state or province, e.g. CA
 }
 
 type PayResponse struct {
 	OrderID          string `json:"order_id"`
 	OrderApprovalURL string `json:"order_approval_url"`
+	Status           string `json:"status,omitempty"`
+	Discount         string `json:"discount,omitempty"` // taken off by promotions, e.g. "1.50"
+	Tax              string `json:"tax,omitempty"`      // empty until the buyer's address is known
+}
+
+type VaultChargeErrorResponse struct {
+	Error    string `json:"error"`
+	OrderID  string `json:"order_id,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+	Fallback string `json:"fallback"` // "approval": retry through /api/paypal/pay
+}
+
+// RiskErrorResponse refuses a saved payment method charge without telling
+// which rules hit, DecisionID is the reference support investigates with
+type RiskErrorResponse struct {
+	Error      string `json:"error"`
+	DecisionID uint   `json:"decision_id"`
+	Decision   string `json:"decision"`           // review or deny
+	Fallback   string `json:"fallback,omitempty"` // "approval" on review: retry through /api/paypal/pay
+}
+
+type CouponErrorResponse struct {
+	Error  string `json:"error"`
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type CardPaymentErrorResponse struct {
+	Error   string `json:"error"`
+	OrderID string `json:"order_id,omitempty"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
 }
 
 type SubscribeRequest struct {
@@ -29,3 +157,496 @@ type CreateMerchantRequest struct {
 type PaypalConnectRequest struct {
 	MerchantID string `json:"merchant_id"`
 }
+
+type PayPalStatusResponse struct {
+	Connected             bool   `json:"connected"`
+	PayPalMerchantID      string `json:"paypal_merchant_id,omitempty"`
+	OnboardingStatus      string `json:"onboarding_status,omitempty"`
+	PaymentsReceivable    bool   `json:"payments_receivable"`
+	PrimaryEmailConfirmed bool   `json:"primary_email_confirmed"`
+	PermissionsGranted    bool   `json:"permissions_granted"`
+}
+
+type TaxReportResponse struct {
+	From      time.Time           `json:"from"`
+	To        time.Time           `json:"to"`
+	Summaries []*model.TaxSummary `json:"summaries"`
+}
+
+type FeeRuleRequest struct {
+	PercentBps int32  `json:"percent_bps"`
+	FixedFee   int64  `json:"fixed_fee"` // minor units (cents)
+	Currency   string `json:"currency"`
+}
+
+type FeeReportResponse struct {
+	MerchantID string                      `json:"merchant_id"`
+	From       time.Time                   `json:"from"`
+	To         time.Time                   `json:"to"`
+	Summaries  []*model.PlatformFeeSummary `json:"summaries"`
+}
+
+type CreateProductRequest struct {
+	Sku         string `json:"sku"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       int32  `json:"price"`
+	Currency    string `json:"currency"`
+	// Entitlements granted per unit, empty to grant the product itself
+	Entitlements []*ProductEntitlement `json:"entitlements"`
+}
+
+type ProductEntitlement struct {
+	EntitlementID string `json:"entitlement_id"`
+	Quantity      int64  `json:"quantity"`      // coins or items, unused for passes
+	DurationDays  int32  `json:"duration_days"` // passes only
+}
+
+type CreateEntitlementRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"` // CURRENCY, CONSUMABLE, DURABLE, PASS
+}
+
+type CreatePromotionRequest struct {
+	Code         string `json:"code"` // empty for an automatic promotion
+	Name         string `json:"name"`
+	DiscountType string `json:"discount_type"` // PERCENT, FIXED, SALE_PRICE
+	// Value is basis points for PERCENT and minor units (cents) otherwise
+	Value             int64      `json:"value"`
+	ProductID         string     `json:"product_id"`
+	MerchantID        string     `json:"merchant_id"`
+	FirstPurchaseOnly bool       `json:"first_purchase_only"`
+	StartsAt          *time.Time `json:"starts_at"`
+	EndsAt            *time.Time `json:"ends_at"`
+	MaxRedemptions    int32      `json:"max_redemptions"`
+	MaxPerUser        int32      `json:"max_per_user"`
+}
+
+type SetPromotionActiveRequest struct {
+	Active bool `json:"active"`
+}
+
+type PaymentMethod struct {
+	ID         string    `json:"id"`
+	Provider   string    `json:"provider"`
+	PayerEmail string    `json:"payer_email,omitempty"`
+	CardBrand  string    `json:"card_brand,omitempty"`
+	LastDigits string    `json:"last_digits,omitempty"`
+	MerchantID string    `json:"merchant_id,omitempty"`
+	IsDefault  bool      `json:"is_default"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type VaultSetupResponse struct {
+	ApprovalURL string `json:"approval_url"`
+}
+
+type OrderListQuery struct {
+	Status     string
+	MerchantID string
+	ProductID  string
+	From       time.Time
+	To         time.Time
+	Cursor     string
+	Limit      int
+}
+
+type OrderListResponse struct {
+	Orders []*OrderSummary `json:"orders"`
+	// NextCursor fetches the next page, empty on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type OrderSummary struct {
+	OrderID       string    `json:"order_id"`
+	Status        string    `json:"status"`
+	Amount        string    `json:"amount"`
+	Discount      string    `json:"discount,omitempty"` // taken off Amount by promotions
+	Tax           string    `json:"tax,omitempty"`
+	TaxInclusive  bool      `json:"tax_inclusive,omitempty"` // the tax is part of Amount rather than added to it
+	Currency      string    `json:"currency"`
+	MerchantID    string    `json:"merchant_id,omitempty"`
+	FundingSource string    `json:"funding_source,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type OrderDetail struct {
+	OrderSummary
+	CardBrand      string               `json:"card_brand,omitempty"`
+	CardLastDigits string               `json:"card_last_digits,omitempty"`
+	Units          []*OrderUnitDetail   `json:"units"`
+	Items          []*OrderItemDetail   `json:"items"`
+	Refunds        []*OrderRefund       `json:"refunds"`
+	StatusHistory  []*OrderStatusChange `json:"status_history"`
+}
+
+type OrderUnitDetail struct {
+	ReferenceID string `json:"reference_id"`
+	MerchantID  string `json:"merchant_id"`
+	Amount      string `json:"amount"`
+	Discount    string `json:"discount,omitempty"`
+	Tax         string `json:"tax,omitempty"`
+	Currency    string `json:"currency"`
+	CaptureID   string `json:"capture_id,omitempty"`
+	Status      string `json:"status"`
+}
+
+type OrderItemDetail struct {
+	ProductID   string `json:"product_id"`
+	Name        string `json:"name"`
+	ReferenceID string `json:"reference_id,omitempty"`
+	Quantity    int32  `json:"quantity"`
+	UnitPrice   string `json:"unit_price"`
+	Discount    string `json:"discount,omitempty"` // for the whole line
+	Tax         string `json:"tax,omitempty"`      // for the whole line
+	Currency    string `json:"currency"`
+}
+
+type OrderRefund struct {
+	RefundID  string    `json:"refund_id"`
+	CaptureID string    `json:"capture_id,omitempty"`
+	Amount    string    `json:"amount"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrderStatusChange struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+type AdminOrderQuery struct {
+	OrderID    string
+	CaptureID  string
+	UserID     string
+	MerchantID string
+	Status     string
+	MinAmount  *int32
+	MaxAmount  *int32
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+type AdminOrder struct {
+	OrderID       string    `json:"order_id"`
+	UserID        string    `json:"user_id"`
+	MerchantID    string    `json:"merchant_id"`
+	Status        string    `json:"status"`
+	Amount        string    `json:"amount"`
+	Currency      string    `json:"currency"`
+	PlatformFee   int64     `json:"platform_fee"`
+	FundingSource string    `json:"funding_source"`
+	CaptureIDs    []string  `json:"capture_ids"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type AdminSubscriptionQuery struct {
+	SubscriptionID string
+	UserID         string
+	MerchantID     string
+	ProductID      string
+	Status         string
+	From           time.Time
+	To             time.Time
+	Limit          int
+}
+
+type AdminSubscription struct {
+	SubscriptionID  string     `json:"subscription_id"`
+	UserID          string     `json:"user_id"`
+	MerchantID      string     `json:"merchant_id"`
+	ProductID       string     `json:"product_id"`
+	Status          string     `json:"status"`
+	StartTime       *time.Time `json:"start_time"`
+	NextBillingTime *time.Time `json:"next_billing_time"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type AdminDisputeQuery struct {
+	UserID  string
+	OrderID string
+	Status  string
+	Limit   int
+}
+
+// DisputeActionRequest is an answer support sends to PayPal for a dispute
+type DisputeActionRequest struct {
+	// Actor is the support agent answering, kept with the dispute
+	Actor string `json:"actor"`
+	Note  string `json:"note"`
+	// Amount is the refund offered in minor units (cents), make-offer only
+	Amount int64 `json:"amount,omitempty"`
+}
+
+type DisputeDetail struct {
+	Dispute *model.Dispute         `json:"dispute"`
+	Freezes []*model.ItemFreeze    `json:"freezes"`
+	Actions []*model.DisputeAction `json:"actions"`
+	// Evidence is what provide-evidence would send, built from the order history and deliveries
+	Evidence string `json:"evidence"`
+}
+
+type DisputeSyncResponse struct {
+	Stored int `json:"stored"`
+}
+
+type EarningsBalance struct {
+	Currency string `json:"currency"`
+	Balance  int64  `json:"balance"` // minor units (cents)
+}
+
+type EarningsResponse struct {
+	UserID   string                       `json:"user_id"`
+	Balances []*EarningsBalance           `json:"balances"`
+	Ledger   []*model.EarningsLedgerEntry `json:"ledger"`
+}
+
+// EarningsCreditRequest adds creator earnings, minor units (cents)
+type EarningsCreditRequest struct {
+	// OperationID is chosen by the caller, retrying with the same id is safe
+	OperationID string `json:"operation_id"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Reason      string `json:"reason"`
+}
+
+type EarningsCreditResponse struct {
+	// Applied is false when the operation id was already used
+	Applied  bool               `json:"applied"`
+	Balances []*EarningsBalance `json:"balances"`
+}
+
+// PayoutRequest withdraws earnings to a PayPal account
+type PayoutRequest struct {
+	// PayoutID is chosen by the caller, retrying with the same id is safe
+	PayoutID string `json:"payout_id"`
+	Receiver string `json:"receiver"` // PayPal account email
+	Amount   int64  `json:"amount"`   // minor units (cents)
+	Currency string `json:"currency"`
+	Note     string `json:"note"`
+}
+
+// PayoutReviewRequest approves or rejects a payout above the approval threshold
+type PayoutReviewRequest struct {
+	Actor string `json:"actor"`
+	Note  string `json:"note"`
+}
+
+type PayoutQuery struct {
+	UserID string
+	Status string
+	Limit  int
+}
+
+// CreateInvoiceRequest bills a bulk buyer for catalog products of one merchant
+type CreateInvoiceRequest struct {
+	MerchantID string `json:"merchant_id"`
+	// UserID receives the items once the invoice is paid
+	UserID         string  `json:"user_id"`
+	RecipientEmail string  `json:"recipient_email"`
+	Items          []*Item `json:"items"`
+	Note           string  `json:"note"`
+	DueDate        string  `json:"due_date"` // YYYY-MM-DD, empty when due on receipt
+	// Actor is the support agent creating the invoice
+	Actor string `json:"actor"`
+	// Send emails the invoice right away instead of keeping a draft
+	Send bool `json:"send"`
+}
+
+type InvoiceActionRequest struct {
+	Note string `json:"note"`
+}
+
+// RecordInvoicePaymentRequest marks an invoice paid outside PayPal, for its full amount
+type RecordInvoicePaymentRequest struct {
+	Method      string `json:"method"`       // BANK_TRANSFER, CASH, CHECK, WIRE_TRANSFER, OTHER
+	PaymentDate string `json:"payment_date"` // YYYY-MM-DD, defaults to today
+	Note        string `json:"note"`
+}
+
+type InvoiceDetail struct {
+	Invoice *model.Invoice     `json:"invoice"`
+	Items   []*OrderItemDetail `json:"items"`
+}
+
+type InvoiceQRCode struct {
+	InvoiceID string `json:"invoice_id"`
+	// Image is a base64 encoded PNG that opens the invoice's payment page
+	Image string `json:"image"`
+}
+
+type InvoiceQuery struct {
+	UserID     string
+	MerchantID string
+	Status     string
+	Limit      int
+}
+
+type AdminRiskDecisionQuery struct {
+	UserID   string
+	Decision string // allow, review or deny
+	OrderID  string
+	Limit    int
+}
+
+type AdminWebhookEventQuery struct {
+	EventID    string
+	EventType  string
+	ResourceID string
+	OrderID    string
+	Status     string
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+type AdminWebhookEvent struct {
+	EventID     string     `json:"event_id"`
+	EventType   string     `json:"event_type"`
+	ResourceID  string     `json:"resource_id"`
+	OrderID     string     `json:"order_id"`
+	Status      string     `json:"status"`
+	LastError   string     `json:"last_error,omitempty"`
+	ProcessedAt *time.Time `json:"processed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type AdminSearchResponse[T any] struct {
+	Results []T `json:"results"`
+	// Truncated is set when more rows matched than the limit, use format=csv or ndjson to export all
+	Truncated bool `json:"truncated"`
+}
+
+type WalletSpendRequest struct {
+	// OperationID is chosen by the caller, retrying with the same id is safe
+	OperationID string `json:"operation_id"`
+	Amount      int64  `json:"amount"`
+	Reason      string `json:"reason"`
+}
+
+type WalletTransferRequest struct {
+	OperationID string `json:"operation_id"`
+	ToUserID    string `json:"to_user_id"`
+	Amount      int64  `json:"amount"`
+	Reason      string `json:"reason"`
+}
+
+type WalletAdjustRequest struct {
+	OperationID string `json:"operation_id"`
+	Amount      int64  `json:"amount"`
+	Reason      string `json:"reason"`
+}
+
+type WalletLedgerEntry struct {
+	OperationID        string    `json:"operation_id"`
+	UserID             string    `json:"user_id"`
+	Type               string    `json:"type"`
+	Amount             int64     `json:"amount"`
+	BalanceAfter       int64     `json:"balance_after"`
+	CounterpartyUserID string    `json:"counterparty_user_id,omitempty"`
+	Reason             string    `json:"reason,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+type WalletResponse struct {
+	UserID  string `json:"user_id"`
+	Balance int64  `json:"balance"`
+	// Frozen is the part of Balance that cannot be spent while a dispute is open
+	Frozen int64                `json:"frozen"`
+	Ledger []*WalletLedgerEntry `json:"ledger"`
+}
+
+type WalletOperationResponse struct {
+	OperationID string `json:"operation_id"`
+	Type        string `json:"type"`
+	// Replayed is set when the operation id was already applied and nothing changed
+	Replayed bool                 `json:"replayed"`
+	Balance  int64                `json:"balance"` // the caller's balance right after the operation
+	Entries  []*WalletLedgerEntry `json:"entries"`
+}
+
+type WalletReconciliation struct {
+	UserID        string `json:"user_id"`
+	Balance       int64  `json:"balance"`
+	LedgerTotal   int64  `json:"ledger_total"`
+	LedgerEntries int64  `json:"ledger_entries"`
+	Balanced      bool   `json:"balanced"`
+}
+
+type InventoryAdjustRequest struct {
+	// IdempotencyKey is chosen by the admin tool, retrying with the same key is safe
+	IdempotencyKey string `json:"idempotency_key"`
+	ProductID      string `json:"product_id"`
+	Delta          int32  `json:"delta"` // negative to take items away
+	Reason         string `json:"reason"`
+}
+
+type InventoryAdjustResponse struct {
+	// Applied is false when the idempotency key was already used
+	Applied   bool                   `json:"applied"`
+	Inventory []*model.UserInventory `json:"inventory"`
+}
+
+type InventoryVerifyReport struct {
+	Drifts []*model.InventoryDrift `json:"drifts"`
+	Fixed  int                     `json:"fixed"`
+}
+
+// PurchaseLimits are in minor units (cents), 0 means unlimited
+type PurchaseLimits struct {
+	MaxOrderAmount       int64 `json:"max_order_amount"`
+	DailySpend           int64 `json:"daily_spend"`
+	WeeklySpend          int64 `json:"weekly_spend"`
+	MonthlySpend         int64 `json:"monthly_spend"`
+	MerchantDailySpend   int64 `json:"merchant_daily_spend"`
+	MerchantWeeklySpend  int64 `json:"merchant_weekly_spend"`
+	MerchantMonthlySpend int64 `json:"merchant_monthly_spend"`
+	MaxSKUQuantity       int32 `json:"max_sku_quantity"`
+	MaxPayAttempts       int32 `json:"max_pay_attempts"`
+}
+
+// PurchaseLimitOverride replaces the defaults of one user, null keeps the default
+type PurchaseLimitOverride struct {
+	MaxOrderAmount       *int64 `json:"max_order_amount"`
+	DailySpend           *int64 `json:"daily_spend"`
+	WeeklySpend          *int64 `json:"weekly_spend"`
+	MonthlySpend         *int64 `json:"monthly_spend"`
+	MerchantDailySpend   *int64 `json:"merchant_daily_spend"`
+	MerchantWeeklySpend  *int64 `json:"merchant_weekly_spend"`
+	MerchantMonthlySpend *int64 `json:"merchant_monthly_spend"`
+	MaxSKUQuantity       *int32 `json:"max_sku_quantity"`
+	MaxPayAttempts       *int32 `json:"max_pay_attempts"`
+}
+
+type SetPurchaseLimitsRequest struct {
+	Override PurchaseLimitOverride `json:"override"`
+	// Actor is the support agent making the change, kept in the audit log
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+}
+
+type PurchaseLimitsResponse struct {
+	UserID    string                `json:"user_id"`
+	Defaults  PurchaseLimits        `json:"defaults"`
+	Override  PurchaseLimitOverride `json:"override"`
+	Effective PurchaseLimits        `json:"effective"`
+	UpdatedBy string                `json:"updated_by,omitempty"`
+	Reason    string                `json:"reason,omitempty"`
+}
+
+type PurchaseLimitErrorResponse struct {
+	Error             string `json:"error"`
+	Limit             string `json:"limit"`
+	MerchantID        string `json:"merchant_id,omitempty"`
+	ProductID         string `json:"product_id,omitempty"`
+	Max               int64  `json:"max"`
+	Current           int64  `json:"current"`
+	Requested         int64  `json:"requested"`
+	RetryAfterSeconds int64  `json:"retry_after_seconds,omitempty"`
+}
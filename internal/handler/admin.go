@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultAdminSearchLimit = 100
+	maxAdminSearchLimit     = 1000
+	// streamed exports are flushed to the client every this many rows
+	exportFlushEvery = 100
+)
+
+var errStopSearch = errors.New("stop search")
+
+type AdminHandler struct {
+	adminService service.AdminService
+}
+
+func NewAdminHandler(adminService service.AdminService) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+	}
+}
+
+func (h *AdminHandler) SearchOrders(c echo.Context) error {
+	query := &dto.AdminOrderQuery{
+		OrderID:    c.QueryParam("order_id"),
+		CaptureID:  c.QueryParam("capture_id"),
+		UserID:     c.QueryParam("user_id"),
+		MerchantID: c.QueryParam("merchant_id"),
+		Status:     c.QueryParam("status"),
+	}
+
+	var err error
+	if query.MinAmount, err = _queryAmount(c, "min_amount"); err != nil {
+		return err
+	}
+	if query.MaxAmount, err = _queryAmount(c, "max_amount"); err != nil {
+		return err
+	}
+	if query.From, query.To, err = _queryTimeRange(c); err != nil {
+		return err
+	}
+
+	return _export(c, "orders",
+		[]string{"order_id", "user_id", "merchant_id", "status", "amount", "currency", "platform_fee", "funding_source", "capture_ids", "created_at", "updated_at"},
+		func(o *dto.AdminOrder) []string {
+			return []string{
+				o.OrderID, o.UserID, o.MerchantID, o.Status, o.Amount, o.Currency,
+				strconv.FormatInt(o.PlatformFee, 10), o.FundingSource, strings.Join(o.CaptureIDs, " "),
+				_csvTime(&o.CreatedAt), _csvTime(&o.UpdatedAt),
+			}
+		},
+		func(limit int, fn func(*dto.AdminOrder) error) error {
+			query.Limit = limit
+			return h.adminService.SearchOrders(c.Request().Context(), query, fn)
+		},
+	)
+}
+
+func (h *AdminHandler) SearchSubscriptions(c echo.Context) error {
+	query := &dto.AdminSubscriptionQuery{
+		SubscriptionID: c.QueryParam("subscription_id"),
+		UserID:         c.QueryParam("user_id"),
+		MerchantID:     c.QueryParam("merchant_id"),
+		ProductID:      c.QueryParam("product_id"),
+		Status:         c.QueryParam("status"),
+	}
+
+	var err error
+	if query.From, query.To, err = _queryTimeRange(c); err != nil {
+		return err
+	}
+
+	return _export(c, "subscriptions",
+		[]string{"subscription_id", "user_id", "merchant_id", "product_id", "status", "start_time", "next_billing_time", "created_at", "updated_at"},
+		func(s *dto.AdminSubscription) []string {
+			return []string{
+				s.SubscriptionID, s.UserID, s.MerchantID, s.ProductID, s.Status,
+				_csvTime(s.StartTime), _csvTime(s.NextBillingTime), _csvTime(&s.CreatedAt), _csvTime(&s.UpdatedAt),
+			}
+		},
+		func(limit int, fn func(*dto.AdminSubscription) error) error {
+			query.Limit = limit
+			return h.adminService.SearchSubscriptions(c.Request().Context(), query, fn)
+		},
+	)
+}
+
+func (h *AdminHandler) SearchWebhookEvents(c echo.Context) error {
+	query := &dto.AdminWebhookEventQuery{
+		EventID:   c.QueryParam("event_id"),
+		EventType: c.QueryParam("event_type"),
+		// capture, subscription or payment token id
+		ResourceID: c.QueryParam("resource_id"),
+		OrderID:    c.QueryParam("order_id"),
+		Status:     c.QueryParam("status"),
+	}
+
+	var err error
+	if query.From, query.To, err = _queryTimeRange(c); err != nil {
+		return err
+	}
+
+	return _export(c, "webhook_events",
+		[]string{"event_id", "event_type", "resource_id", "order_id", "status", "last_error", "processed_at", "created_at"},
+		func(e *dto.AdminWebhookEvent) []string {
+			return []string{
+				e.EventID, e.EventType, e.ResourceID, e.OrderID, e.Status, e.LastError,
+				_csvTime(e.ProcessedAt), _csvTime(&e.CreatedAt),
+			}
+		},
+		func(limit int, fn func(*dto.AdminWebhookEvent) error) error {
+			query.Limit = limit
+			return h.adminService.SearchWebhookEvents(c.Request().Context(), query, fn)
+		},
+	)
+}
+
+// _export answers a search as a capped JSON page (format=json, the default)
+// or streams every match as CSV (format=csv) or NDJSON (format=ndjson).
+func _export[T any](c echo.Context, name string, header []string, record func(T) []string, search func(limit int, fn func(T) error) error) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	switch format {
+	case "", "json":
+		if limit <= 0 {
+			limit = defaultAdminSearchLimit
+		}
+		if limit > maxAdminSearchLimit {
+			limit = maxAdminSearchLimit
+		}
+
+		resp := &dto.AdminSearchResponse[T]{Results: make([]T, 0)}
+		// one extra row tells whether the result was cut off
+		err := search(limit+1, func(row T) error {
+			if len(resp.Results) == limit {
+				resp.Truncated = true
+				return errStopSearch
+			}
+			resp.Results = append(resp.Results, row)
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopSearch) {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, resp)
+	case "csv", "ndjson":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be json, csv or ndjson")
+	}
+
+	res := c.Response()
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102T150405Z"), format)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	var write func(T) error
+	var flush func() error
+	if format == "csv" {
+		res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		w := csv.NewWriter(res)
+		write = func(row T) error { return w.Write(record(row)) }
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+	} else {
+		res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		enc := json.NewEncoder(res)
+		write = func(row T) error { return enc.Encode(row) }
+		flush = func() error { return nil }
+	}
+	res.WriteHeader(http.StatusOK)
+
+	// the status is already sent, an error past this point only cuts the file short
+	rows := 0
+	err = search(limit, func(row T) error {
+		if err := write(row); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			res.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("export %s: %w", name, err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	res.Flush()
+
+	return nil
+}
+
+// GetTaxReport sums the tax collected per jurisdiction, for the current month
+// unless from and to are given
+func (h *AdminHandler) GetTaxReport(c echo.Context) error {
+	from, to, err := _queryTimeRange(c)
+	if err != nil {
+		return err
+	}
+	if from.IsZero() && to.IsZero() {
+		now := time.Now().UTC()
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to = from.AddDate(0, 1, 0)
+	}
+
+	summaries, err := h.adminService.GetTaxReport(c.Request().Context(), from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &dto.TaxReportResponse{
+		From:      from,
+		To:        to,
+		Summaries: summaries,
+	})
+}
+
+// SearchRiskDecisions lists saved payment method charges the risk rules
+// looked at, newest first, with the rules that hit
+func (h *AdminHandler) SearchRiskDecisions(c echo.Context) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+	if limit <= 0 {
+		limit = defaultAdminSearchLimit
+	}
+	if limit > maxAdminSearchLimit {
+		limit = maxAdminSearchLimit
+	}
+
+	decisions, err := h.adminService.SearchRiskDecisions(c.Request().Context(), &dto.AdminRiskDecisionQuery{
+		UserID:   c.QueryParam("user_id"),
+		Decision: c.QueryParam("decision"),
+		OrderID:  c.QueryParam("order_id"),
+		Limit:    limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, decisions)
+}
+
+func _queryTime(c echo.Context, name string) (time.Time, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, name+" must be RFC3339")
+	}
+	return t, nil
+}
+
+func _queryTimeRange(c echo.Context) (time.Time, time.Time, error) {
+	from, err := _queryTime(c, "from")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := _queryTime(c, "to")
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
+
+func _queryInt(c echo.Context, name string) (int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, name+" must be a number")
+	}
+	return n, nil
+}
+
+func _queryAmount(c echo.Context, name string) (*int32, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, name+" must be a whole amount")
+	}
+	amount := int32(n)
+	return &amount, nil
+}
+
+func _csvTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
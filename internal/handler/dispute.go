@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type DisputeHandler struct {
+	disputeService service.DisputeService
+}
+
+func NewDisputeHandler(disputeService service.DisputeService) *DisputeHandler {
+	return &DisputeHandler{
+		disputeService: disputeService,
+	}
+}
+
+func (h *DisputeHandler) ListDisputes(c echo.Context) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+	if limit <= 0 {
+		limit = defaultAdminSearchLimit
+	}
+	if limit > maxAdminSearchLimit {
+		limit = maxAdminSearchLimit
+	}
+
+	disputes, err := h.disputeService.ListDisputes(c.Request().Context(), &dto.AdminDisputeQuery{
+		UserID:  c.QueryParam("user_id"),
+		OrderID: c.QueryParam("order_id"),
+		Status:  c.QueryParam("status"),
+		Limit:   limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, disputes)
+}
+
+// GetDispute shows the dispute with its frozen items, the answers already
+// sent and the evidence provide-evidence would send
+func (h *DisputeHandler) GetDispute(c echo.Context) error {
+	detail, err := h.disputeService.GetDispute(c.Request().Context(), c.Param("disputeID"))
+	if err != nil {
+		return _disputeError(err)
+	}
+
+	return c.JSON(http.StatusOK, detail)
+}
+
+// SyncDisputes pulls the disputes PayPal lists for merchant_id, or for the platform
+func (h *DisputeHandler) SyncDisputes(c echo.Context) error {
+	stored, err := h.disputeService.SyncDisputes(c.Request().Context(), c.QueryParam("merchant_id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &dto.DisputeSyncResponse{Stored: stored})
+}
+
+func (h *DisputeHandler) ProvideEvidence(c echo.Context) error {
+	return h.answer(c, h.disputeService.ProvideDisputeEvidence)
+}
+
+func (h *DisputeHandler) AcceptClaim(c echo.Context) error {
+	return h.answer(c, h.disputeService.AcceptDisputeClaim)
+}
+
+func (h *DisputeHandler) MakeOffer(c echo.Context) error {
+	return h.answer(c, h.disputeService.MakeDisputeOffer)
+}
+
+func (h *DisputeHandler) answer(c echo.Context, send func(ctx context.Context, disputeID string, req *dto.DisputeActionRequest) error) error {
+	var req dto.DisputeActionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	if err := send(c.Request().Context(), c.Param("disputeID"), &req); err != nil {
+		return _disputeError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetUserFreezes lists what game servers must not let the user spend or use
+func (h *DisputeHandler) GetUserFreezes(c echo.Context) error {
+	freezes, err := h.disputeService.GetUserFreezes(c.Request().Context(), c.Param("userID"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, freezes)
+}
+
+func _disputeError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "dispute not found")
+	case errors.Is(err, service.ErrInvalidDisputeAction):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrDisputeResolved):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrDisputeActionRejected):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+type EntitlementHandler struct {
+	entitlementService service.EntitlementService
+}
+
+func NewEntitlementHandler(entitlementService service.EntitlementService) *EntitlementHandler {
+	return &EntitlementHandler{
+		entitlementService: entitlementService,
+	}
+}
+
+func (h *EntitlementHandler) CreateEntitlement(c echo.Context) error {
+	var req dto.CreateEntitlementRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	entitlement, err := h.entitlementService.CreateEntitlement(c.Request().Context(), &req)
+	if errors.Is(err, service.ErrInvalidEntitlement) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, entitlement)
+}
+
+func (h *EntitlementHandler) GetPasses(c echo.Context) error {
+	passes, err := h.entitlementService.GetPasses(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, passes)
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type InvoiceHandler struct {
+	invoiceService service.InvoiceService
+}
+
+func NewInvoiceHandler(invoiceService service.InvoiceService) *InvoiceHandler {
+	return &InvoiceHandler{
+		invoiceService: invoiceService,
+	}
+}
+
+func (h *InvoiceHandler) CreateInvoice(c echo.Context) error {
+	var req dto.CreateInvoiceRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	invoice, err := h.invoiceService.CreateInvoice(c.Request().Context(), &req)
+	if err != nil {
+		return _invoiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func (h *InvoiceHandler) ListInvoices(c echo.Context) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+	if limit <= 0 {
+		limit = defaultAdminSearchLimit
+	}
+	if limit > maxAdminSearchLimit {
+		limit = maxAdminSearchLimit
+	}
+
+	invoices, err := h.invoiceService.ListInvoices(c.Request().Context(), &dto.InvoiceQuery{
+		UserID:     c.QueryParam("user_id"),
+		MerchantID: c.QueryParam("merchant_id"),
+		Status:     c.QueryParam("status"),
+		Limit:      limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, invoices)
+}
+
+func (h *InvoiceHandler) GetInvoice(c echo.Context) error {
+	invoice, err := h.invoiceService.GetInvoice(c.Request().Context(), c.Param("invoiceID"))
+	if err != nil {
+		return _invoiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func (h *InvoiceHandler) SendInvoice(c echo.Context) error {
+	invoice, err := h.invoiceService.SendInvoice(c.Request().Context(), c.Param("invoiceID"))
+	if err != nil {
+		return _invoiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func (h *InvoiceHandler) RemindInvoice(c echo.Context) error {
+	var req dto.InvoiceActionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	invoice, err := h.invoiceService.RemindInvoice(c.Request().Context(), c.Param("invoiceID"), &req)
+	if err != nil {
+		return _invoiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func (h *InvoiceHandler) CancelInvoice(c echo.Context) error {
+	var req dto.InvoiceActionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	invoice, err := h.invoiceService.CancelInvoice(c.Request().Context(), c.Param("invoiceID"), &req)
+	if err != nil {
+		return _invoiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+// RecordPayment marks the invoice paid in full outside PayPal, e.g. by bank transfer
+func (h *InvoiceHandler) RecordPayment(c echo.Context) error {
+	var req dto.RecordInvoicePaymentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	invoice, err := h.invoiceService.RecordInvoicePayment(c.Request().Context(), c.Param("invoiceID"), &req)
+	if err != nil {
+		return _invoiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func (h *InvoiceHandler) GetQRCode(c echo.Context) error {
+	qrCode, err := h.invoiceService.GetInvoiceQRCode(c.Request().Context(), c.Param("invoiceID"))
+	if err != nil {
+		return _invoiceError(err)
+	}
+
+	return c.JSON(http.StatusOK, qrCode)
+}
+
+func _invoiceError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "invoice not found")
+	case errors.Is(err, service.ErrInvalidInvoice):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrInvoiceState):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrInvoiceActionRejected):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return err
+}
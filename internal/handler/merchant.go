@@ -3,7 +3,9 @@ package handler
 import (
 	"net/http"
 	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
 	"paypal-integration-demo/internal/service"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -46,8 +48,13 @@ func (h *MerchantHandler) PayPalStatus(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
 
-	return c.JSON(http.StatusOK, map[string]bool{
-		"connected": merchant.PayPalAccessToken != "",
+	return c.JSON(http.StatusOK, &dto.PayPalStatusResponse{
+		Connected:             merchant.PayPalAccessToken != "" || merchant.OnboardingStatus == string(model.ONBOARDING_COMPLETED),
+		PayPalMerchantID:      merchant.PayPalMerchantID,
+		OnboardingStatus:      merchant.OnboardingStatus,
+		PaymentsReceivable:    merchant.PaymentsReceivable,
+		PrimaryEmailConfirmed: merchant.PrimaryEmailConfirmed,
+		PermissionsGranted:    merchant.PermissionsGranted,
 	})
 }
 
@@ -65,3 +72,106 @@ func (h *MerchantHandler) DisconnectPayPal(c echo.Context) error {
 		"status": "disconnected",
 	})
 }
+
+func (h *MerchantHandler) SetFeeRule(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	merchantID := c.Param("merchantID")
+
+	var req dto.FeeRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid req body")
+	}
+
+	rule := &model.MerchantFeeRule{
+		MerchantID: merchantID,
+		PercentBps: req.PercentBps,
+		FixedFee:   req.FixedFee,
+		Currency:   req.Currency,
+	}
+	if err := h.merchantService.SetFeeRule(ctx, rule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, rule)
+}
+
+func (h *MerchantHandler) GetFeeReport(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	merchantID := c.Param("merchantID")
+
+	// defaults to the current month
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	var err error
+	if v := c.QueryParam("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "from must be RFC3339")
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "to must be RFC3339")
+		}
+	}
+
+	summaries, err := h.merchantService.GetFeeReport(ctx, merchantID, from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &dto.FeeReportResponse{
+		MerchantID: merchantID,
+		From:       from,
+		To:         to,
+		Summaries:  summaries,
+	})
+}
+
+func (h *MerchantHandler) CreateProduct(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	merchantID := c.Param("merchantID")
+
+	var req dto.CreateProductRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid req body")
+	}
+
+	product := &model.Product{
+		ID:          req.Sku,
+		Name:        req.Name,
+		Description: req.Description,
+		Price:       req.Price,
+		Currency:    req.Currency,
+		MerchantID:  merchantID,
+	}
+	entitlements := make([]*model.ProductEntitlement, len(req.Entitlements))
+	for i, e := range req.Entitlements {
+		entitlements[i] = &model.ProductEntitlement{
+			EntitlementID: e.EntitlementID,
+			Quantity:      e.Quantity,
+			DurationDays:  e.DurationDays,
+		}
+	}
+
+	if err := h.merchantService.CreateProduct(ctx, product, entitlements); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, product)
+}
+
+func (h *MerchantHandler) GetProducts(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	products, err := h.merchantService.GetProducts(ctx, c.Param("merchantID"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, products)
+}
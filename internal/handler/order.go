@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type OrderHandler struct {
+	orderService service.OrderService
+}
+
+func NewOrderHandler(orderService service.OrderService) *OrderHandler {
+	return &OrderHandler{
+		orderService: orderService,
+	}
+}
+
+func (h *OrderHandler) ListOrders(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	query := &dto.OrderListQuery{
+		Status:     c.QueryParam("status"),
+		MerchantID: c.QueryParam("merchant_id"),
+		ProductID:  c.QueryParam("product_id"),
+		Cursor:     c.QueryParam("cursor"),
+	}
+
+	var err error
+	if query.From, query.To, err = _queryTimeRange(c); err != nil {
+		return err
+	}
+	if query.Limit, err = _queryInt(c, "limit"); err != nil {
+		return err
+	}
+
+	result, err := h.orderService.ListOrders(ctx, userID, query)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *OrderHandler) GetOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	result, err := h.orderService.GetOrder(ctx, userID, c.Param("orderID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
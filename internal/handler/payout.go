@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type PayoutHandler struct {
+	payoutService service.PayoutService
+}
+
+func NewPayoutHandler(payoutService service.PayoutService) *PayoutHandler {
+	return &PayoutHandler{
+		payoutService: payoutService,
+	}
+}
+
+// GetMyEarnings is the web page's view of the signed in creator's earnings
+func (h *PayoutHandler) GetMyEarnings(c echo.Context) error {
+	return h.getEarnings(c, userID)
+}
+
+func (h *PayoutHandler) GetEarnings(c echo.Context) error {
+	return h.getEarnings(c, c.Param("userID"))
+}
+
+func (h *PayoutHandler) getEarnings(c echo.Context, earningsUserID string) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+
+	earnings, err := h.payoutService.GetEarnings(c.Request().Context(), earningsUserID, limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, earnings)
+}
+
+func (h *PayoutHandler) CreditEarnings(c echo.Context) error {
+	var req dto.EarningsCreditRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	result, err := h.payoutService.CreditEarnings(c.Request().Context(), c.Param("userID"), &req)
+	if err != nil {
+		return _payoutError(err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// RequestPayout withdraws the signed in creator's earnings to PayPal
+func (h *PayoutHandler) RequestPayout(c echo.Context) error {
+	var req dto.PayoutRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	payout, err := h.payoutService.RequestPayout(c.Request().Context(), userID, &req)
+	if err != nil {
+		return _payoutError(err)
+	}
+
+	return c.JSON(http.StatusOK, payout)
+}
+
+func (h *PayoutHandler) ListMyPayouts(c echo.Context) error {
+	return h.listPayouts(c, userID)
+}
+
+func (h *PayoutHandler) ListPayouts(c echo.Context) error {
+	return h.listPayouts(c, c.QueryParam("user_id"))
+}
+
+func (h *PayoutHandler) listPayouts(c echo.Context, payoutUserID string) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+	if limit <= 0 {
+		limit = defaultAdminSearchLimit
+	}
+	if limit > maxAdminSearchLimit {
+		limit = maxAdminSearchLimit
+	}
+
+	payouts, err := h.payoutService.ListPayouts(c.Request().Context(), &dto.PayoutQuery{
+		UserID: payoutUserID,
+		Status: c.QueryParam("status"),
+		Limit:  limit,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, payouts)
+}
+
+func (h *PayoutHandler) GetPayout(c echo.Context) error {
+	payout, err := h.payoutService.GetPayout(c.Request().Context(), c.Param("payoutID"))
+	if err != nil {
+		return _payoutError(err)
+	}
+
+	return c.JSON(http.StatusOK, payout)
+}
+
+func (h *PayoutHandler) ApprovePayout(c echo.Context) error {
+	var req dto.PayoutReviewRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	payout, err := h.payoutService.ApprovePayout(c.Request().Context(), c.Param("payoutID"), &req)
+	if err != nil {
+		return _payoutError(err)
+	}
+
+	return c.JSON(http.StatusOK, payout)
+}
+
+func (h *PayoutHandler) RejectPayout(c echo.Context) error {
+	var req dto.PayoutReviewRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	payout, err := h.payoutService.RejectPayout(c.Request().Context(), c.Param("payoutID"), &req)
+	if err != nil {
+		return _payoutError(err)
+	}
+
+	return c.JSON(http.StatusOK, payout)
+}
+
+func _payoutError(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "payout not found")
+	case errors.Is(err, service.ErrInvalidPayout):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrPayoutConflict), errors.Is(err, service.ErrPayoutNotPending):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrInsufficientEarnings):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return err
+}
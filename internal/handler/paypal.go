@@ -1,14 +1,23 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
 	"paypal-integration-demo/internal/service"
+	"paypal-integration-demo/internal/view"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 // for demo purpose: user who receive items from merchant
@@ -17,12 +26,14 @@ const userID = "demo-user-001"
 type PaypalHandler struct {
 	paypalService   service.PaypalService
 	merchantService service.MerchantService
+	views           *view.Renderer
 }
 
-func NewPaypalHandler(paypalService service.PaypalService, merchantService service.MerchantService) *PaypalHandler {
+func NewPaypalHandler(paypalService service.PaypalService, merchantService service.MerchantService, views *view.Renderer) *PaypalHandler {
 	return &PaypalHandler{
 		paypalService:   paypalService,
 		merchantService: merchantService,
+		views:           views,
 	}
 }
 
@@ -34,6 +45,11 @@ func merchantIDFromHeader(c echo.Context) (string, error) {
 	return merchantID, nil
 }
 
+// clientProfile names the client (web, unity, ...) the buyer returns to
+func clientProfile(c echo.Context) string {
+	return c.Request().Header.Get("X-Client-Profile")
+}
+
 func (h *PaypalHandler) ConnectMerchant(c echo.Context) error {
 	merchantID := c.Param("merchantID")
 
@@ -68,20 +84,106 @@ func (h *PaypalHandler) OAuthCallback(c echo.Context) error {
 	return c.String(http.StatusOK, "PayPal connected successfully")
 }
 
-func (h *PaypalHandler) Pay(c echo.Context) error {
+func (h *PaypalHandler) OnboardMerchant(c echo.Context) error {
 	ctx := c.Request().Context()
+	merchantID := c.Param("merchantID")
 
-	merchantID, err := merchantIDFromHeader(c)
+	actionURL, err := h.paypalService.StartOnboarding(ctx, merchantID)
+	if err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, actionURL)
+}
+
+func (h *PaypalHandler) OnboardingReturn(c echo.Context) error {
+	ctx := c.Request().Context()
+	merchantID := c.Param("merchantID")
+
+	// PayPal appends merchantIdInPayPal (and merchantId, our tracking id) to the return url
+	paypalMerchantID := c.QueryParam("merchantIdInPayPal")
+	if paypalMerchantID == "" {
+		return c.String(http.StatusBadRequest, "invalid onboarding return")
+	}
+
+	merchant, err := h.paypalService.CompleteOnboarding(ctx, merchantID, paypalMerchantID)
 	if err != nil {
 		return err
 	}
 
+	if merchant.OnboardingStatus != string(model.ONBOARDING_COMPLETED) {
+		log.Printf("merchant %s onboarding incomplete: payments_receivable=%t primary_email_confirmed=%t permissions_granted=%t",
+			merchantID, merchant.PaymentsReceivable, merchant.PrimaryEmailConfirmed, merchant.PermissionsGranted)
+	}
+
+	return c.Redirect(http.StatusFound, "/")
+}
+
+func (h *PaypalHandler) Pay(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// optional: products owned by a merchant are routed to that merchant,
+	// the header only names the seller of unowned catalog products
+	merchantID := c.Request().Header.Get("X-Merchant-Id")
+
 	var req dto.PayRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid req body")
 	}
 
-	result, err := h.paypalService.Pay(ctx, merchantID, userID, req.Items)
+	result, err := h.paypalService.Pay(ctx, merchantID, userID, clientProfile(c), req.FundingSource, req.CouponCode, req.Items)
+	if errors.Is(err, service.ErrUnknownClientProfile) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return couponError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// CreateOrder is the createOrder callback of the JS SDK buttons
+func (h *PaypalHandler) CreateOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	merchantID := c.Request().Header.Get("X-Merchant-Id")
+
+	var req dto.PayRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid req body")
+	}
+
+	result, err := h.paypalService.Pay(ctx, merchantID, userID, clientProfile(c), req.FundingSource, req.CouponCode, req.Items)
+	if errors.Is(err, service.ErrUnknownClientProfile) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return couponError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &dto.CreateOrderResponse{
+		ID: result.OrderID,
+	})
+}
+
+// CaptureOrder is the onApprove callback of the JS SDK buttons
+func (h *PaypalHandler) CaptureOrder(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	orderID := c.Param("orderID")
+
+	result, err := h.paypalService.CaptureOrder(ctx, orderID)
+	var declinedErr *service.InstrumentDeclinedError
+	if errors.As(err, &declinedErr) {
+		return c.JSON(http.StatusUnprocessableEntity, &dto.CaptureErrorResponse{
+			Error:   "instrument_declined",
+			OrderID: orderID,
+			Restart: true,
+		})
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+	}
 	if err != nil {
 		return err
 	}
@@ -89,6 +191,83 @@ func (h *PaypalHandler) Pay(c echo.Context) error {
 	return c.JSON(http.StatusOK, result)
 }
 
+// OrderEvents streams the order's state changes as Server-Sent Events, starting
+// with its current status
+func (h *PaypalHandler) OrderEvents(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	current, events, unsubscribe, err := h.paypalService.SubscribeOrderEvents(ctx, userID, c.Param("orderID"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+	}
+	if err != nil {
+		return err
+	}
+	defer unsubscribe()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeOrderEvent(w, current); err != nil {
+		return nil
+	}
+
+	// comments keep proxies from closing an idle stream
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case event := <-events:
+			if err := writeOrderEvent(w, event); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+func writeOrderEvent(w *echo.Response, event *dto.OrderEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// FundingSources lists the funding sources the cart can be paid with
+func (h *PaypalHandler) FundingSources(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req dto.PayRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid req body")
+	}
+
+	sources, err := h.paypalService.EligibleFundingSources(ctx, req.Items)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &dto.FundingSourcesResponse{
+		FundingSources: sources,
+	})
+}
+
 func (h *PaypalHandler) PayAgain(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -102,14 +281,140 @@ func (h *PaypalHandler) PayAgain(c echo.Context) error {
 		return err
 	}
 
-	result, err := h.paypalService.PayAgain(ctx, merchantID, userID, req.Items)
+	result, err := h.paypalService.PayAgain(ctx, merchantID, userID, req.PaymentMethodID, req.ClientMetadataID, req.CouponCode, req.Items)
+	if errors.Is(err, service.ErrInvalidClientMetadataID) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	var riskErr *service.RiskError
+	if errors.As(err, &riskErr) {
+		if riskErr.Decision == service.RiskReview {
+			return c.JSON(http.StatusPaymentRequired, &dto.RiskErrorResponse{
+				Error:      "risk_review",
+				DecisionID: riskErr.DecisionID,
+				Decision:   riskErr.Decision,
+				Fallback:   "approval",
+			})
+		}
+		return c.JSON(http.StatusForbidden, &dto.RiskErrorResponse{
+			Error:      "risk_denied",
+			DecisionID: riskErr.DecisionID,
+			Decision:   riskErr.Decision,
+		})
+	}
+	var chargeErr *service.VaultChargeError
+	if errors.As(err, &chargeErr) {
+		return c.JSON(http.StatusPaymentRequired, &dto.VaultChargeErrorResponse{
+			Error:    "vault_charge_failed",
+			OrderID:  chargeErr.OrderID,
+			Status:   chargeErr.Status,
+			Reason:   chargeErr.Reason,
+			Fallback: "approval",
+		})
+	}
 	if err != nil {
-		return err
+		return couponError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, result)
 }
 
+func (h *PaypalHandler) PayWithCard(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	merchantID := c.Request().Header.Get("X-Merchant-Id")
+
+	var req dto.CardPayRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid req body")
+	}
+
+	result, err := h.paypalService.PayWithCard(ctx, merchantID, userID, req.SingleUseToken, req.SaveCard, req.CouponCode, req.BillingAddress, req.Items)
+	if err != nil {
+		return cardPaymentError(c, err)
+	}
+
+	// PAYER_ACTION_REQUIRED: order_approval_url is the 3-D Secure challenge
+	return c.JSON(http.StatusOK, result)
+}
+
+// CardReturn is where PayPal sends the buyer back after the 3-D Secure challenge
+func (h *PaypalHandler) CardReturn(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	orderID := c.QueryParam("token")
+	if orderID == "" {
+		return c.String(http.StatusBadRequest, "missing order token")
+	}
+
+	_, err := h.paypalService.CompleteCardOrder(ctx, orderID)
+	var cardErr *service.CardPaymentError
+	if errors.As(err, &cardErr) {
+		log.Printf("card order %s not captured: %s", orderID, cardErr)
+		return c.Redirect(http.StatusFound, "/?card_payment=failed")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/?card_payment=completed")
+}
+
+func cardPaymentError(c echo.Context, err error) error {
+	var cardErr *service.CardPaymentError
+	if errors.As(err, &cardErr) {
+		return c.JSON(http.StatusPaymentRequired, &dto.CardPaymentErrorResponse{
+			Error:   "card_payment_failed",
+			OrderID: cardErr.OrderID,
+			Status:  cardErr.Status,
+			Reason:  cardErr.Reason,
+		})
+	}
+	return couponError(c, err)
+}
+
+// couponError answers 422 when the coupon or a promotion cannot be used, so
+// the buyer can remove the code and pay full price
+func couponError(c echo.Context, err error) error {
+	var couponErr *service.CouponError
+	if errors.As(err, &couponErr) {
+		return c.JSON(http.StatusUnprocessableEntity, &dto.CouponErrorResponse{
+			Error:  "coupon_not_applicable",
+			Code:   couponErr.Code,
+			Reason: couponErr.Reason,
+		})
+	}
+	return purchaseLimitError(c, err)
+}
+
+// purchaseLimitError answers 429 with Retry-After when the user pays too often
+// and 403 when the checkout would go over one of their spending caps
+func purchaseLimitError(c echo.Context, err error) error {
+	var limitErr *service.PurchaseLimitError
+	if !errors.As(err, &limitErr) {
+		return err
+	}
+
+	status := http.StatusForbidden
+	retryAfter := int64(math.Ceil(limitErr.RetryAfter.Seconds()))
+	if retryAfter > 0 {
+		status = http.StatusTooManyRequests
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	}
+
+	return c.JSON(status, &dto.PurchaseLimitErrorResponse{
+		Error:             "purchase_limit_exceeded",
+		Limit:             limitErr.Limit,
+		MerchantID:        limitErr.MerchantID,
+		ProductID:         limitErr.ProductID,
+		Max:               limitErr.Max,
+		Current:           limitErr.Current,
+		Requested:         limitErr.Requested,
+		RetryAfterSeconds: retryAfter,
+	})
+}
+
+// HandleSuccess captures the approved order and shows its outcome, or sends
+// the buyer back to the client profile the order was created for
 func (h *PaypalHandler) HandleSuccess(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -118,53 +423,59 @@ func (h *PaypalHandler) HandleSuccess(c echo.Context) error {
 		return c.String(400, "missing order token")
 	}
 
-	err := h.paypalService.CaptureOrder(ctx, orderID)
+	status := ""
+	_, err := h.paypalService.CaptureOrder(ctx, orderID)
+	var declinedErr *service.InstrumentDeclinedError
+	if errors.As(err, &declinedErr) {
+		status = "DECLINED"
+	} else if err != nil {
+		return err
+	}
+
+	return h.renderOrderResult(c, orderID, status)
+}
+
+// HandleCancel is PayPal's cancel_url for approval orders
+func (h *PaypalHandler) HandleCancel(c echo.Context) error {
+	orderID := c.QueryParam("token")
+	if orderID == "" {
+		return c.Redirect(http.StatusFound, "/")
+	}
+
+	err := h.paypalService.CancelOrder(c.Request().Context(), userID, orderID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "order not found")
+	}
 	if err != nil {
 		return err
 	}
 
-	html := `
-	<!DOCTYPE html>
-	<html>
-	<head>
-		<meta charset="utf-8">
-		<title>Payment Processing</title>
-		<style>
-			body {
-				font-family: Arial, sans-serif;
-				text-align: center;
-				margin-top: 80px;
-			}
-			.countdown {
-				font-size: 24px;
-				font-weight: bold;
-			}
-		</style>
-	</head>
-	<body>
-		<h2>Payment approved</h2>
-		<p>We are processing your payment and grant items to you if success</p>
-		<p>Redirecting to homepage in <span class="countdown" id="countdown">15</span> seconds…</p>
-
-		<script>
-			let seconds = 15;
-			const el = document.getElementById("countdown");
-
-			const timer = setInterval(function () {
-				seconds--;
-				el.textContent = seconds;
-
-				if (seconds <= 0) {
-					clearInterval(timer);
-					window.location.href = "/";
-				}
-			}, 1000);
-		</script>
-	</body>
-	</html>
-	`
-
-	return c.HTML(http.StatusOK, html)
+	return h.renderOrderResult(c, orderID, "CANCELLED")
+}
+
+func (h *PaypalHandler) renderOrderResult(c echo.Context, orderID string, status string) error {
+	result, err := h.paypalService.OrderResult(c.Request().Context(), orderID, status)
+	if err != nil {
+		return err
+	}
+
+	if result.RedirectURL != "" {
+		return c.Redirect(http.StatusFound, result.RedirectURL)
+	}
+
+	return h.render(c, result.MerchantID, "order_result", result)
+}
+
+// render writes a result page in the locale asked for by ?locale= or Accept-Language
+func (h *PaypalHandler) render(c echo.Context, merchantID string, page string, data interface{}) error {
+	locale := view.ResolveLocale(c.QueryParam("locale"), c.Request().Header.Get("Accept-Language"))
+
+	var buf bytes.Buffer
+	if err := h.views.Render(&buf, merchantID, page, locale, data); err != nil {
+		return fmt.Errorf("render %s: %w", page, err)
+	}
+
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
 }
 
 func (h *PaypalHandler) PayPalWebhook(c echo.Context) error {
@@ -196,6 +507,93 @@ func (h *PaypalHandler) CheckUserHaveSavedPayment(c echo.Context) error {
 	})
 }
 
+func (h *PaypalHandler) ListPaymentMethods(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	vaults, err := h.paypalService.ListPaymentMethods(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("list payment methods: %w", err)
+	}
+
+	methods := make([]*dto.PaymentMethod, len(vaults))
+	for i, vault := range vaults {
+		methods[i] = &dto.PaymentMethod{
+			ID:         vault.VaultID,
+			Provider:   vault.Provider,
+			PayerEmail: vault.PayerEmail,
+			CardBrand:  vault.CardBrand,
+			LastDigits: vault.CardLastDigits,
+			MerchantID: vault.MerchantID,
+			IsDefault:  vault.IsDefault,
+			CreatedAt:  vault.CreatedAt,
+		}
+	}
+
+	return c.JSON(http.StatusOK, methods)
+}
+
+func (h *PaypalHandler) SetDefaultPaymentMethod(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	err := h.paypalService.SetDefaultPaymentMethod(ctx, userID, c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "payment method not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *PaypalHandler) DeletePaymentMethod(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	err := h.paypalService.DeletePaymentMethod(ctx, userID, c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "payment method not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *PaypalHandler) StartVaultSetup(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// optional: without a merchant the payment method is saved with the platform
+	merchantID := c.Request().Header.Get("X-Merchant-Id")
+
+	approveURL, err := h.paypalService.StartVaultSetup(ctx, merchantID, userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &dto.VaultSetupResponse{
+		ApprovalURL: approveURL,
+	})
+}
+
+func (h *PaypalHandler) VaultSetupReturn(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	setupTokenID := c.QueryParam("approval_token_id")
+	if setupTokenID == "" {
+		setupTokenID = c.QueryParam("token")
+	}
+	if setupTokenID == "" {
+		return c.String(http.StatusBadRequest, "missing setup token")
+	}
+
+	if _, err := h.paypalService.CompleteVaultSetup(ctx, setupTokenID); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusFound, "/")
+}
+
 func (h *PaypalHandler) SubscribeSubscription(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -209,7 +607,10 @@ func (h *PaypalHandler) SubscribeSubscription(c echo.Context) error {
 		return err
 	}
 
-	approveURL, err := h.paypalService.SubscribeSubscription(ctx, userID, req.ProductID, merchantID)
+	approveURL, err := h.paypalService.SubscribeSubscription(ctx, userID, clientProfile(c), req.ProductID, merchantID)
+	if errors.Is(err, service.ErrUnknownClientProfile) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		return err
 	}
@@ -220,60 +621,34 @@ func (h *PaypalHandler) SubscribeSubscription(c echo.Context) error {
 }
 
 func (h *PaypalHandler) HandleSubscriptionSuccess(c echo.Context) error {
+	return h.subscriptionReturn(c, false)
+}
+
+func (h *PaypalHandler) HandleSubscriptionCancelled(c echo.Context) error {
+	return h.subscriptionReturn(c, true)
+}
+
+func (h *PaypalHandler) subscriptionReturn(c echo.Context, cancelled bool) error {
 	ctx := c.Request().Context()
 
 	subscriptionID := c.QueryParam("subscription_id")
+	if subscriptionID == "" && cancelled {
+		return c.Redirect(http.StatusFound, "/")
+	}
 	if subscriptionID == "" {
 		return c.String(400, "missing subscription id")
 	}
 
-	err := h.paypalService.HandleSubscriptionSuccess(ctx, subscriptionID)
+	result, err := h.paypalService.HandleSubscriptionSuccess(ctx, subscriptionID, cancelled)
 	if err != nil {
 		return err
 	}
 
-	html := `
-	<!DOCTYPE html>
-	<html>
-	<head>
-		<meta charset="utf-8">
-		<title>Subscription Processing</title>
-		<style>
-			body {
-				font-family: Arial, sans-serif;
-				text-align: center;
-				margin-top: 80px;
-			}
-			.countdown {
-				font-size: 24px;
-				font-weight: bold;
-			}
-		</style>
-	</head>
-	<body>
-		<h2>Subscription approved</h2>
-		<p>We are processing your product vip_monthly subscription</p>
-		<p>Redirecting to homepage in <span class="countdown" id="countdown">5</span> seconds…</p>
-
-		<script>
-			let seconds = 5;
-			const el = document.getElementById("countdown");
-
-			const timer = setInterval(function () {
-				seconds--;
-				el.textContent = seconds;
-
-				if (seconds <= 0) {
-					clearInterval(timer);
-					window.location.href = "/";
-				}
-			}, 1000);
-		</script>
-	</body>
-	</html>
-	`
-
-	return c.HTML(http.StatusOK, html)
+	if result.RedirectURL != "" {
+		return c.Redirect(http.StatusFound, result.RedirectURL)
+	}
+
+	return h.render(c, result.MerchantID, "subscription_result", result)
 }
 
 func (h *PaypalHandler) GetSubscriptionStatus(c echo.Context) error {
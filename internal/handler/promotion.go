@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type PromotionHandler struct {
+	promotionService service.PromotionService
+}
+
+func NewPromotionHandler(promotionService service.PromotionService) *PromotionHandler {
+	return &PromotionHandler{
+		promotionService: promotionService,
+	}
+}
+
+func (h *PromotionHandler) CreatePromotion(c echo.Context) error {
+	var req dto.CreatePromotionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	promotion, err := h.promotionService.CreatePromotion(c.Request().Context(), &req)
+	if errors.Is(err, service.ErrInvalidPromotion) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, promotion)
+}
+
+func (h *PromotionHandler) ListPromotions(c echo.Context) error {
+	promotions, err := h.promotionService.ListPromotions(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, promotions)
+}
+
+// SetPromotionActive pauses or resumes a promotion, redemptions already made are kept
+func (h *PromotionHandler) SetPromotionActive(c echo.Context) error {
+	var req dto.SetPromotionActiveRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	err := h.promotionService.SetPromotionActive(c.Request().Context(), c.Param("promotionID"), req.Active)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "promotion not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
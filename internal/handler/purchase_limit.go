@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+type PurchaseLimitHandler struct {
+	purchaseLimitService service.PurchaseLimitService
+}
+
+func NewPurchaseLimitHandler(purchaseLimitService service.PurchaseLimitService) *PurchaseLimitHandler {
+	return &PurchaseLimitHandler{
+		purchaseLimitService: purchaseLimitService,
+	}
+}
+
+// GetLimits shows the defaults, the user's override and the limits in effect
+func (h *PurchaseLimitHandler) GetLimits(c echo.Context) error {
+	limits, err := h.purchaseLimitService.GetLimits(c.Request().Context(), c.Param("userID"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, limits)
+}
+
+// SetLimits replaces the user's override, a null limit falls back to the default
+func (h *PurchaseLimitHandler) SetLimits(c echo.Context) error {
+	var req dto.SetPurchaseLimitsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	limits, err := h.purchaseLimitService.SetLimits(c.Request().Context(), c.Param("userID"), &req)
+	if errors.Is(err, service.ErrInvalidPurchaseLimit) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, limits)
+}
+
+func (h *PurchaseLimitHandler) GetAudit(c echo.Context) error {
+	audit, err := h.purchaseLimitService.GetAudit(c.Request().Context(), c.Param("userID"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, audit)
+}
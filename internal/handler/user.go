@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"paypal-integration-demo/internal/dto"
 	"paypal-integration-demo/internal/service"
 
 	"github.com/labstack/echo/v4"
@@ -27,3 +29,43 @@ func (h *UserHandler) GetUsersInventory(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, inventories)
 }
+
+// GetMyInventoryLedger explains where the signed in user's items came from
+func (h *UserHandler) GetMyInventoryLedger(c echo.Context) error {
+	return h.getInventoryLedger(c, userID)
+}
+
+func (h *UserHandler) GetInventoryLedger(c echo.Context) error {
+	return h.getInventoryLedger(c, c.Param("userID"))
+}
+
+func (h *UserHandler) getInventoryLedger(c echo.Context, ledgerUserID string) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+
+	entries, err := h.userService.GetInventoryLedger(c.Request().Context(), ledgerUserID, c.QueryParam("product_id"), limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+func (h *UserHandler) AdjustInventory(c echo.Context) error {
+	var req dto.InventoryAdjustRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	result, err := h.userService.AdjustInventory(c.Request().Context(), c.Param("userID"), &req)
+	if errors.Is(err, service.ErrInvalidInventoryAdjustment) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/service"
+
+	"github.com/labstack/echo/v4"
+)
+
+type WalletHandler struct {
+	walletService service.WalletService
+}
+
+func NewWalletHandler(walletService service.WalletService) *WalletHandler {
+	return &WalletHandler{
+		walletService: walletService,
+	}
+}
+
+// GetMyWallet is the web page's view of the signed in user's wallet
+func (h *WalletHandler) GetMyWallet(c echo.Context) error {
+	return h.getWallet(c, userID)
+}
+
+func (h *WalletHandler) GetWallet(c echo.Context) error {
+	return h.getWallet(c, c.Param("userID"))
+}
+
+func (h *WalletHandler) getWallet(c echo.Context, walletUserID string) error {
+	limit, err := _queryInt(c, "limit")
+	if err != nil {
+		return err
+	}
+
+	result, err := h.walletService.GetWallet(c.Request().Context(), walletUserID, limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *WalletHandler) Spend(c echo.Context) error {
+	var req dto.WalletSpendRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	result, err := h.walletService.Spend(c.Request().Context(), c.Param("userID"), &req)
+	if err != nil {
+		return _walletError(err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *WalletHandler) Transfer(c echo.Context) error {
+	var req dto.WalletTransferRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	result, err := h.walletService.Transfer(c.Request().Context(), c.Param("userID"), &req)
+	if err != nil {
+		return _walletError(err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *WalletHandler) Grant(c echo.Context) error {
+	var req dto.WalletAdjustRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	result, err := h.walletService.Grant(c.Request().Context(), c.Param("userID"), &req)
+	if err != nil {
+		return _walletError(err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *WalletHandler) Revoke(c echo.Context) error {
+	var req dto.WalletAdjustRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	result, err := h.walletService.Revoke(c.Request().Context(), c.Param("userID"), &req)
+	if err != nil {
+		return _walletError(err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *WalletHandler) Reconcile(c echo.Context) error {
+	result, err := h.walletService.Reconcile(c.Request().Context(), c.Param("userID"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func _walletError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidWalletOperation):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrWalletOperationConflict):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrInsufficientBalance), errors.Is(err, service.ErrBalanceFrozen):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return err
+}
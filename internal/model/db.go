@@ -9,6 +9,53 @@ const (
 	ONE_TIME     ProductType = "ONE_TIME"
 )
 
+type OnboardingStatus string
+
+const (
+	ONBOARDING_PENDING         OnboardingStatus = "PENDING"         // referral link created, merchant has not returned yet
+	ONBOARDING_ACTION_REQUIRED OnboardingStatus = "ACTION_REQUIRED" // merchant returned but cannot receive payments yet
+	ONBOARDING_COMPLETED       OnboardingStatus = "COMPLETED"
+)
+
+type VaultProvider string
+
+const (
+	VAULT_PAYPAL VaultProvider = "paypal"
+	VAULT_CARD   VaultProvider = "card"
+)
+
+type FundingSource string
+
+const (
+	FUNDING_PAYPAL   FundingSource = "paypal"
+	FUNDING_VENMO    FundingSource = "venmo"
+	FUNDING_PAYLATER FundingSource = "paylater"
+	FUNDING_CARD     FundingSource = "card"
+	FUNDING_INVOICE  FundingSource = "invoice" // paid through a PayPal invoice, see Invoice
+)
+
+type InventorySourceType string
+
+const (
+	INVENTORY_SOURCE_ORDER        InventorySourceType = "ORDER"        // source id is the order id
+	INVENTORY_SOURCE_SUBSCRIPTION InventorySourceType = "SUBSCRIPTION" // source id is the paypal subscription id
+	INVENTORY_SOURCE_ADMIN        InventorySourceType = "ADMIN"        // source id is the admin's idempotency key
+	INVENTORY_SOURCE_OPENING      InventorySourceType = "OPENING"      // balance held before the ledger existed
+	INVENTORY_SOURCE_DISPUTE      InventorySourceType = "DISPUTE"      // source id is the dispute id the buyer won
+)
+
+type WalletOperationType string
+
+const (
+	WALLET_PURCHASE     WalletOperationType = "PURCHASE" // coins bought with a paid order
+	WALLET_SPEND        WalletOperationType = "SPEND"
+	WALLET_TRANSFER     WalletOperationType = "TRANSFER"
+	WALLET_ADMIN_GRANT  WalletOperationType = "ADMIN_GRANT"
+	WALLET_ADMIN_REVOKE WalletOperationType = "ADMIN_REVOKE"
+	// coins of a purchase the buyer got refunded through a dispute
+	WALLET_DISPUTE_REVOKE WalletOperationType = "DISPUTE_REVOKE"
+)
+
 type Product struct {
 	ID          string `gorm:"primaryKey;size:64;not null"` // product sku
 	Name        string
@@ -16,57 +63,544 @@ type Product struct {
 	Price       int32  `gorm:"not null"`
 	Currency    string `gorm:"size:8;not null"`
 	Type        string `gorm:"size:32;index;not null"` // ONE_TIME, SUBSCRIPTION
+	MerchantID  string `gorm:"size:64;index"`          // owning merchant, empty for platform catalog products
+}
+
+type DiscountType string
+
+const (
+	DISCOUNT_PERCENT    DiscountType = "PERCENT"    // Value in basis points, 1000 = 10%
+	DISCOUNT_FIXED      DiscountType = "FIXED"      // Value off the eligible items, minor units
+	DISCOUNT_SALE_PRICE DiscountType = "SALE_PRICE" // Value is the unit price, minor units
+)
+
+// Promotion is a coupon (Code set) or an automatic promotion applied to every
+// eligible cart. Zero limits and empty scopes mean unlimited.
+type Promotion struct {
+	ID           string `gorm:"primaryKey;size:64"`
+	Code         string `gorm:"size:32;index"` // upper case, empty for automatic promotions
+	Name         string
+	DiscountType string `gorm:"size:16;not null"`
+	Value        int64  `gorm:"not null"`
+
+	// scope
+	ProductID         string `gorm:"size:64"`
+	MerchantID        string `gorm:"size:64"`
+	FirstPurchaseOnly bool   `gorm:"not null;default:false"`
+
+	// validity window, open ended when nil
+	StartsAt *time.Time
+	EndsAt   *time.Time
+
+	MaxRedemptions int32 `gorm:"not null;default:0"`
+	MaxPerUser     int32 `gorm:"not null;default:0"`
+	Redemptions    int32 `gorm:"not null;default:0"` // reserved or applied, released ones are given back
+	Active         bool  `gorm:"not null;default:true"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// PromotionRedemption is reserved before the PayPal order is created and
+// attached to the order in the transaction that stores it
+type PromotionRedemption struct {
+	ID            uint   `gorm:"primaryKey"`
+	PromotionID   string `gorm:"size:64;index:idx_redemption_user,priority:1;not null"`
+	UserID        string `gorm:"size:32;index:idx_redemption_user,priority:2;not null"`
+	ReservationID string `gorm:"size:64;index;not null"`
+	OrderID       string `gorm:"size:64;index"`
+	Discount      int64  `gorm:"not null"`         // minor units (cents)
+	Status        string `gorm:"size:16;not null"` // RESERVED, APPLIED, RELEASED
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// UserPurchaseLimit overrides the configured purchase limits of one user, nil
+// fields keep the default and 0 means unlimited. The row is also locked while
+// a checkout checks the user's spend, so it exists for every buyer.
+type UserPurchaseLimit struct {
+	UserID               string `gorm:"primaryKey;size:32"`
+	MaxOrderAmount       *int64 // minor units (cents), as are the spend limits
+	DailySpend           *int64
+	WeeklySpend          *int64
+	MonthlySpend         *int64
+	MerchantDailySpend   *int64
+	MerchantWeeklySpend  *int64
+	MerchantMonthlySpend *int64
+	MaxSKUQuantity       *int32
+	MaxPayAttempts       *int32
+	UpdatedBy            string `gorm:"size:64"`
+	Reason               string
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// PurchaseLimitAudit records every admin change of a user's limit override
+type PurchaseLimitAudit struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    string    `gorm:"size:32;index;not null" json:"user_id"`
+	Actor     string    `gorm:"size:64;not null" json:"actor"`
+	Reason    string    `gorm:"not null" json:"reason"`
+	Before    string    `gorm:"type:text" json:"before"` // JSON of the override, empty when there was none
+	After     string    `gorm:"type:text;not null" json:"after"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SpendHold counts a checkout against the spend limits while its PayPal order
+// is being created. Holds are removed when the order is stored, or expire.
+type SpendHold struct {
+	ID         uint      `gorm:"primaryKey"`
+	HoldID     string    `gorm:"size:64;index;not null"` // one per checkout, shared by its merchants
+	UserID     string    `gorm:"size:32;index:idx_spend_hold_user,priority:1;not null"`
+	MerchantID string    `gorm:"size:64;not null"`
+	Amount     int64     `gorm:"not null"` // minor units (cents)
+	ExpiresAt  time.Time `gorm:"index:idx_spend_hold_user,priority:2;not null"`
+	CreatedAt  time.Time
+}
+
+// PayAttempt is one Pay or PayAgain call, counted by the velocity limit
+type PayAttempt struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    string    `gorm:"size:32;index:idx_pay_attempt_user,priority:1;not null"`
+	CreatedAt time.Time `gorm:"index:idx_pay_attempt_user,priority:2"`
+}
+
+// RiskDecision is the outcome of the risk rules for one saved payment method
+// charge, kept with the rules that hit for investigation
+type RiskDecision struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	UserID     string `gorm:"size:32;index:idx_risk_decision_user,priority:1;not null" json:"user_id"`
+	MerchantID string `gorm:"size:64" json:"merchant_id"`
+	VaultID    string `gorm:"size:64" json:"vault_id"`
+	// ClientMetadataID is the device fingerprint sent to PayPal as PayPal-Client-Metadata-Id
+	ClientMetadataID string `gorm:"size:64" json:"client_metadata_id"`
+	Amount           int64  `gorm:"not null" json:"amount"`           // minor units (cents)
+	Decision         string `gorm:"size:16;not null" json:"decision"` // allow, review, deny
+	// Outcome is the charge result once PayPal answered: COMPLETED, PENDING, DECLINED or FAILED
+	Outcome   string         `gorm:"size:32" json:"outcome,omitempty"`
+	OrderID   string         `gorm:"size:64" json:"order_id,omitempty"`
+	Hits      []*RiskRuleHit `gorm:"foreignKey:DecisionID" json:"hits"`
+	CreatedAt time.Time      `gorm:"index:idx_risk_decision_user,priority:2" json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// RiskRuleHit is a rule that matched a RiskDecision
+type RiskRuleHit struct {
+	ID         uint   `gorm:"primaryKey" json:"-"`
+	DecisionID uint   `gorm:"index;not null" json:"-"`
+	Rule       string `gorm:"size:32;not null" json:"rule"` // new_vault_amount, distinct_payers, declines
+	Action     string `gorm:"size:16;not null" json:"action"`
+	Detail     string `json:"detail"`
+}
+
+type EntitlementType string
+
+const (
+	ENTITLEMENT_CURRENCY   EntitlementType = "CURRENCY"   // credited to the wallet
+	ENTITLEMENT_CONSUMABLE EntitlementType = "CONSUMABLE" // stacks in the inventory
+	ENTITLEMENT_DURABLE    EntitlementType = "DURABLE"    // owned at most once
+	ENTITLEMENT_PASS       EntitlementType = "PASS"       // time-boxed, see UserPass
+)
+
+// Entitlement is what a buyer actually receives, a product grants one or more
+type Entitlement struct {
+	ID   string `gorm:"primaryKey;size:64;not null"` // coins, skin_starter, vip
+	Name string
+	Type string `gorm:"size:16;not null"` // EntitlementType
+}
+
+// ProductEntitlement maps a product to what one unit of it grants.
+// Products without any mapping grant themselves as a consumable.
+type ProductEntitlement struct {
+	ProductID     string `gorm:"primaryKey;size:64"`
+	EntitlementID string `gorm:"primaryKey;size:64"`
+	Quantity      int64  `gorm:"not null;default:1"` // coins or items per unit, unused for passes
+	DurationDays  int32  `gorm:"not null;default:0"` // pass length per unit
+}
+
+// EntitlementGrant is a ProductEntitlement joined with its entitlement type
+type EntitlementGrant struct {
+	ProductID     string
+	EntitlementID string
+	Type          string
+	Quantity      int64
+	DurationDays  int32
 }
 
 type Order struct {
-	OrderID    string `gorm:"primaryKey;size:64;not null"` // paypal order id
-	Status     string `gorm:"size:32;index;not null"`      // CREATED, APPROVED, PAID, FAILED
-	UserID     string `gorm:"size:32;index"`
-	Amount     int32  `gorm:"not null"` // total amount (sum of items)
+	OrderID    string `gorm:"primaryKey;size:64;not null"` // paypal order id, or invoice id when paid by invoice
+	Status     string `gorm:"size:32;index;not null"`      // CREATED, APPROVED, COMPLETED, PARTIALLY_COMPLETED, PAID, PARTIALLY_PAID, FAILED, CANCELLED
+	UserID     string `gorm:"size:32;index;index:idx_orders_user_created,priority:1"`
+	Amount     int32  `gorm:"not null;index"` // total amount (sum of items)
 	Currency   string `gorm:"size:8;not null"`
-	MerchantID string `gorm:"not null"`
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	MerchantID string `gorm:"size:64;not null;index"` // empty when the order is split across several merchants
+	// PlatformFee is the partner fee taken from this order, in minor units (cents)
+	PlatformFee int64 `gorm:"not null;default:0"`
+	// Discount is taken off Amount by promotions, in minor units (cents)
+	Discount int64 `gorm:"not null;default:0"`
+	// Tax is in minor units (cents). Inclusive tax is part of Amount, exclusive
+	// tax is paid on top of it.
+	Tax          int64  `gorm:"not null;default:0"`
+	TaxInclusive bool   `gorm:"not null;default:false"`
+	TaxCountry   string `gorm:"size:2;index"`
+	// TaxJurisdiction is the key of the rate applied, e.g. DE or US-CA, empty when untaxed
+	TaxJurisdiction string `gorm:"size:16"`
+	// FundingSource is how the buyer paid: paypal, venmo, paylater, card
+	FundingSource string `gorm:"size:16"`
+	// ClientProfile is the client the buyer is sent back to, see config.ClientProfile
+	ClientProfile string `gorm:"size:32"`
+	// card details, only set for card payments
+	CardBrand      string    `gorm:"size:32"`
+	CardLastDigits string    `gorm:"size:4"`
+	CreatedAt      time.Time `gorm:"index;index:idx_orders_user_created,priority:2"`
+	UpdatedAt      time.Time
+}
+
+// OrderUnit is one purchase_unit of an order, paid to a single merchant
+type OrderUnit struct {
+	ID uint `gorm:"primaryKey"`
+	// FK → order.order_id
+	OrderID     string `gorm:"size:64;index;not null"`
+	ReferenceID string `gorm:"size:64;uniqueIndex;not null"` // purchase_unit reference_id / invoice_id
+	MerchantID  string `gorm:"size:64;index;not null"`
+	Amount      int32  `gorm:"not null"`
+	Currency    string `gorm:"size:8;not null"`
+	PlatformFee int64  `gorm:"not null;default:0"` // minor units (cents)
+	Discount    int64  `gorm:"not null;default:0"` // minor units (cents), Amount is before discount
+	Tax         int64  `gorm:"not null;default:0"` // minor units (cents), see Order.TaxInclusive
+	CaptureID   string `gorm:"size:64;index"`
+	Status      string `gorm:"size:32;index;not null"` // CREATED, PENDING, COMPLETED, PAID, FAILED
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// OrderStatusHistory records every status an order went through
+type OrderStatusHistory struct {
+	ID        uint   `gorm:"primaryKey"`
+	OrderID   string `gorm:"size:64;index;not null"`
+	Status    string `gorm:"size:32;not null"`
+	CreatedAt time.Time
+}
+
+// OrderRefund is a refund of one capture, recorded from PAYMENT.CAPTURE.REFUNDED
+type OrderRefund struct {
+	RefundID    string `gorm:"primaryKey;size:64;not null"`
+	OrderID     string `gorm:"size:64;index;not null"`
+	ReferenceID string `gorm:"size:64"` // purchase unit the refunded capture belongs to
+	CaptureID   string `gorm:"size:64"`
+	Amount      string `gorm:"size:16;not null"` // decimal as sent by PayPal, refunds can be partial
+	Currency    string `gorm:"size:8;not null"`
+	Status      string `gorm:"size:32;not null"` // COMPLETED, PENDING, FAILED, CANCELLED
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Dispute is a PayPal dispute against one of our captures. The items the
+// disputed unit granted are frozen while it is open.
+type Dispute struct {
+	DisputeID   string `gorm:"primaryKey;size:64;not null" json:"dispute_id"`
+	OrderID     string `gorm:"size:64;index;not null" json:"order_id"`
+	ReferenceID string `gorm:"size:64" json:"reference_id"` // disputed purchase unit, empty for orders without units
+	CaptureID   string `gorm:"size:64;index" json:"capture_id"`
+	UserID      string `gorm:"size:32;index;not null" json:"user_id"`
+	MerchantID  string `gorm:"size:64" json:"merchant_id"`
+	Reason      string `gorm:"size:64" json:"reason"`
+	Status      string `gorm:"size:32;index;not null" json:"status"`
+	Stage       string `gorm:"size:32" json:"stage"`  // INQUIRY, CHARGEBACK, PRE_ARBITRATION, ARBITRATION
+	Amount      string `gorm:"size:16" json:"amount"` // decimal as sent by PayPal
+	Currency    string `gorm:"size:8" json:"currency"`
+	Outcome     string `gorm:"size:32" json:"outcome,omitempty"` // set once RESOLVED
+	// SellerResponseDueDate is when PayPal closes the dispute for the buyer without our answer
+	SellerResponseDueDate *time.Time `gorm:"index" json:"seller_response_due_date,omitempty"`
+	// AlertedAt is when support was warned about SellerResponseDueDate
+	AlertedAt *time.Time `json:"alerted_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ItemFreeze blocks an entitlement granted by a disputed purchase until the
+// dispute is resolved. Quantity is the currency amount, item count or pass days.
+// When the buyer wins the freeze is revoked instead of given back, capped at
+// what the user still held.
+type ItemFreeze struct {
+	ID              uint       `gorm:"primaryKey" json:"-"`
+	DisputeID       string     `gorm:"size:64;index;not null" json:"dispute_id"`
+	UserID          string     `gorm:"size:32;index:idx_item_freeze_user,priority:1;not null" json:"user_id"`
+	EntitlementID   string     `gorm:"size:64;not null" json:"entitlement_id"`
+	Type            string     `gorm:"size:16;not null" json:"type"` // EntitlementType
+	Quantity        int64      `gorm:"not null" json:"quantity"`
+	RevokedQuantity int64      `gorm:"not null;default:0" json:"revoked_quantity"`
+	ReleasedAt      *time.Time `gorm:"index:idx_item_freeze_user,priority:2" json:"released_at,omitempty"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// DisputeAction is a response support sent to PayPal for a dispute
+type DisputeAction struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	DisputeID string    `gorm:"size:64;index;not null" json:"dispute_id"`
+	Action    string    `gorm:"size:16;not null" json:"action"` // evidence, accept_claim, make_offer
+	Actor     string    `gorm:"size:64;not null" json:"actor"`
+	Note      string    `gorm:"type:text" json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invoice is a PayPal invoice for a bulk purchase from the catalog. Its items
+// are stored as an Order whose OrderID is the invoice id, and are granted to
+// the user once the invoice is paid.
+type Invoice struct {
+	InvoiceID      string `gorm:"primaryKey;size:64;not null" json:"invoice_id"` // PayPal invoice id, INV2-...
+	InvoiceNumber  string `gorm:"size:32" json:"invoice_number"`
+	UserID         string `gorm:"size:32;index;not null" json:"user_id"`
+	MerchantID     string `gorm:"size:64;index;not null" json:"merchant_id"`
+	RecipientEmail string `gorm:"size:128;not null" json:"recipient_email"`
+	Amount         int64  `gorm:"not null" json:"amount"` // minor units (cents)
+	Currency       string `gorm:"size:8;not null" json:"currency"`
+	// Status is PayPal's: DRAFT, SENT, PAID, MARKED_AS_PAID, CANCELLED, ...
+	Status    string     `gorm:"size:32;index;not null" json:"status"`
+	DueDate   string     `gorm:"size:10" json:"due_date,omitempty"` // YYYY-MM-DD, empty when due on receipt
+	Note      string     `json:"note,omitempty"`
+	CreatedBy string     `gorm:"size:64" json:"created_by"`
+	PaidAt    *time.Time `json:"paid_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
 }
 
 type OrderItem struct {
 	ID uint `gorm:"primaryKey"`
 	// FK → order.order_id
 	OrderID string `gorm:"size:64;index;not null"`
+	// FK → order_unit.reference_id
+	ReferenceID string `gorm:"size:64;index"`
 	// FK → product.id
 	ProductID string `gorm:"index;not null"`
 	Quantity  int32  `gorm:"not null"`
 	UnitPrice int32  `gorm:"not null"`
 	Currency  string `gorm:"size:8;not null"`
+	Discount  int64  `gorm:"not null;default:0"` // for the whole line, minor units (cents)
+	Tax       int64  `gorm:"not null;default:0"` // for the whole line, minor units (cents)
 
 	CreatedAt time.Time
 }
 
 type WebhookEvent struct {
-	EventID     string `gorm:"primaryKey;size:128;uniqueIndex;not null"`
-	EventType   string `gorm:"size:64;index"`
-	ProcessedAt time.Time
-	CreatedAt   time.Time
+	EventID   string `gorm:"primaryKey;size:128;uniqueIndex;not null"`
+	EventType string `gorm:"size:64;index"`
+	// ResourceID is the capture, subscription, payment token or dispute the event is about
+	ResourceID string `gorm:"size:64;index"`
+	OrderID    string `gorm:"size:64;index"`
+	Status     string `gorm:"size:16;index"` // PROCESSED, FAILED
+	LastError  string `gorm:"type:text"`
+	// ProcessedAt is nil until the event was handled successfully, PayPal retries until then
+	ProcessedAt *time.Time
+	CreatedAt   time.Time `gorm:"index"`
+	UpdatedAt   time.Time
 }
 
+// UserInventory is the materialized balance of InventoryLedgerEntry, only
+// changed together with a new ledger entry
 type UserInventory struct {
-	UserID    string `gorm:"primaryKey;size:32;"`
+	UserID string `gorm:"primaryKey;size:32;"`
+	// ProductID holds the entitlement id, or the product id for products without entitlements
 	ProductID string `gorm:"primaryKey;index;not null"`
 	Quantity  int32  `gorm:"not null"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// InventoryLedgerEntry is an append-only record of one inventory change
+type InventoryLedgerEntry struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     string `gorm:"size:32;index:idx_inventory_ledger_user,priority:1;not null"`
+	ProductID  string `gorm:"size:64;index:idx_inventory_ledger_user,priority:2;not null"`
+	Delta      int32  `gorm:"not null"` // negative when items are taken away
+	Reason     string
+	SourceType string `gorm:"size:16;index:idx_inventory_ledger_source,priority:1;not null"` // InventorySourceType
+	SourceID   string `gorm:"size:64;index:idx_inventory_ledger_source,priority:2"`
+	// IdempotencyKey makes replays of the same grant a no-op
+	IdempotencyKey string `gorm:"size:128;uniqueIndex;not null"`
+	CreatedAt      time.Time
+}
+
+// InventoryDrift is a balance that does not match the sum of its ledger entries
+type InventoryDrift struct {
+	UserID      string `json:"user_id"`
+	ProductID   string `json:"product_id"`
+	Balance     int32  `json:"balance"`
+	LedgerTotal int32  `json:"ledger_total"`
+	// LedgerEntries is 0 for balances granted before the ledger existed
+	LedgerEntries int64 `json:"ledger_entries"`
+}
+
 type UserVault struct {
 	UserID   string `gorm:"primaryKey;not null"`
 	VaultID  string `gorm:"primaryKey;uniqueIndex;not null"`
-	Provider string
+	Provider string // VaultProvider: paypal, card
+	// MerchantID is the merchant account the token was vaulted under, empty for the partner
+	MerchantID string `gorm:"size:64"`
+	PayerEmail string
+	// card details, only set when Provider is card
+	CardBrand      string `gorm:"size:32"`
+	CardLastDigits string `gorm:"size:4"`
+	// Country and Region are the payer or billing address, used to tax vault charges
+	Country   string `gorm:"size:2"`
+	Region    string `gorm:"size:8"`
+	IsDefault bool   `gorm:"not null;default:false"`
 
 	// IsActive  bool `gorm:"not null;default:true"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// Wallet is a user's virtual currency balance. It only changes together with
+// a WalletLedgerEntry, so the ledger always sums up to Balance.
+type Wallet struct {
+	UserID    string `gorm:"primaryKey;size:32"`
+	Balance   int64  `gorm:"not null;default:0"` // never negative
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WalletOperation is one client request against the wallets, keyed by the
+// client-supplied operation id so a retried request is applied only once.
+type WalletOperation struct {
+	OperationID        string `gorm:"primaryKey;size:64;not null"`
+	Type               string `gorm:"size:16;not null"` // WalletOperationType
+	UserID             string `gorm:"size:32;index;not null"`
+	CounterpartyUserID string `gorm:"size:32"` // receiver of a transfer
+	Amount             int64  `gorm:"not null"`
+	Reason             string
+	CreatedAt          time.Time
+}
+
+// WalletLedgerEntry is an append-only record of a balance change. A transfer
+// writes one entry for each side.
+type WalletLedgerEntry struct {
+	ID                 uint   `gorm:"primaryKey"`
+	OperationID        string `gorm:"size:64;index;not null"`
+	UserID             string `gorm:"size:32;index:idx_wallet_ledger_user,priority:1;not null"`
+	Type               string `gorm:"size:16;not null"` // WalletOperationType
+	Amount             int64  `gorm:"not null"`         // signed, negative for debits
+	BalanceAfter       int64  `gorm:"not null"`
+	CounterpartyUserID string `gorm:"size:32"`
+	Reason             string
+	CreatedAt          time.Time `gorm:"index:idx_wallet_ledger_user,priority:2"`
+}
+
+type EarningsEntryType string
+
+const (
+	EARNINGS_CREDIT        EarningsEntryType = "CREDIT" // earnings credited by support
+	EARNINGS_PAYOUT        EarningsEntryType = "PAYOUT" // debited when a payout is requested
+	EARNINGS_PAYOUT_RETURN EarningsEntryType = "PAYOUT_RETURN"
+)
+
+// Earnings is what a creator can withdraw in one currency. Like Wallet it
+// only changes together with an EarningsLedgerEntry.
+type Earnings struct {
+	UserID    string `gorm:"primaryKey;size:32"`
+	Currency  string `gorm:"primaryKey;size:8"`
+	Balance   int64  `gorm:"not null;default:0"` // minor units (cents), never negative
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// EarningsLedgerEntry is an append-only record of an earnings balance change
+type EarningsLedgerEntry struct {
+	ID       uint   `gorm:"primaryKey" json:"-"`
+	UserID   string `gorm:"size:32;index:idx_earnings_ledger_user,priority:1;not null" json:"user_id"`
+	Currency string `gorm:"size:8;not null" json:"currency"`
+	Type     string `gorm:"size:16;not null" json:"type"` // EarningsEntryType
+	Amount   int64  `gorm:"not null" json:"amount"`       // signed minor units, negative for payouts
+	// BalanceAfter is the balance of Currency right after the entry
+	BalanceAfter int64  `gorm:"not null" json:"balance_after"`
+	PayoutID     string `gorm:"size:64;index" json:"payout_id,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	// IdempotencyKey makes replays of the same credit or return a no-op
+	IdempotencyKey string    `gorm:"size:128;uniqueIndex;not null" json:"-"`
+	CreatedAt      time.Time `gorm:"index:idx_earnings_ledger_user,priority:2" json:"created_at"`
+}
+
+type PayoutStatus string
+
+const (
+	PAYOUT_PENDING_APPROVAL PayoutStatus = "PENDING_APPROVAL" // above the approval threshold
+	PAYOUT_APPROVED         PayoutStatus = "APPROVED"         // waiting to be sent to PayPal
+	PAYOUT_REJECTED         PayoutStatus = "REJECTED"         // by support, the earnings are given back
+	PAYOUT_SUBMITTED        PayoutStatus = "SUBMITTED"        // batch created, item status not known yet
+	// the statuses below are PayPal's transaction_status of the item
+	PAYOUT_PENDING   PayoutStatus = "PENDING"
+	PAYOUT_ONHOLD    PayoutStatus = "ONHOLD"
+	PAYOUT_UNCLAIMED PayoutStatus = "UNCLAIMED" // receiver has no PayPal account yet, returned after 30 days
+	PAYOUT_SUCCESS   PayoutStatus = "SUCCESS"
+	PAYOUT_RETURNED  PayoutStatus = "RETURNED"
+	PAYOUT_FAILED    PayoutStatus = "FAILED"
+	PAYOUT_BLOCKED   PayoutStatus = "BLOCKED"
+	PAYOUT_REFUNDED  PayoutStatus = "REFUNDED"
+	PAYOUT_REVERSED  PayoutStatus = "REVERSED"
+)
+
+// Payout is a cash-out of a user's earnings, sent as a PayPal payout batch
+// of one item. PayoutID is the sender_batch_id and the sender_item_id.
+type Payout struct {
+	PayoutID string `gorm:"primaryKey;size:64;not null" json:"payout_id"`
+	UserID   string `gorm:"size:32;index;not null" json:"user_id"`
+	Receiver string `gorm:"size:128;not null" json:"receiver"` // PayPal account email
+	Amount   int64  `gorm:"not null" json:"amount"`            // minor units (cents)
+	Currency string `gorm:"size:8;not null" json:"currency"`
+	Note     string `json:"note,omitempty"`
+	Status   string `gorm:"size:16;index;not null" json:"status"` // PayoutStatus
+
+	PayoutBatchID string `gorm:"size:64;index" json:"payout_batch_id,omitempty"`
+	PayoutItemID  string `gorm:"size:64;index" json:"payout_item_id,omitempty"`
+	TransactionID string `gorm:"size:64" json:"transaction_id,omitempty"`
+	LastError     string `gorm:"type:text" json:"last_error,omitempty"`
+
+	ReviewedBy string `gorm:"size:64" json:"reviewed_by,omitempty"` // support member who approved or rejected
+	ReviewNote string `json:"review_note,omitempty"`
+	// ReturnedAt is set once the amount went back to the earnings balance
+	ReturnedAt  *time.Time `json:"returned_at,omitempty"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"index" json:"updated_at"`
+}
+
+// UserPass is a time-boxed entitlement. Buying the pass again extends it.
+type UserPass struct {
+	ID            uint      `gorm:"primaryKey"`
+	UserID        string    `gorm:"size:32;uniqueIndex:idx_user_pass,priority:1;not null"`
+	EntitlementID string    `gorm:"size:64;uniqueIndex:idx_user_pass,priority:2;not null"`
+	Status        string    `gorm:"size:16;index:idx_user_pass_expiry,priority:1;not null"` // ACTIVE, EXPIRED
+	ExpiresAt     time.Time `gorm:"index:idx_user_pass_expiry,priority:2;not null"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// PassGrant is an append-only record of time added to a UserPass
+type PassGrant struct {
+	ID             uint      `gorm:"primaryKey"`
+	UserID         string    `gorm:"size:32;index;not null"`
+	EntitlementID  string    `gorm:"size:64;not null"`
+	Days           int32     `gorm:"not null"`
+	SourceType     string    `gorm:"size:16;not null"` // InventorySourceType
+	SourceID       string    `gorm:"size:64"`
+	IdempotencyKey string    `gorm:"size:128;uniqueIndex;not null"`
+	ExpiresAt      time.Time // pass expiry right after this grant
+	CreatedAt      time.Time
+}
+
+// VaultSetup tracks a "save PayPal for later" setup token until the user approves it
+type VaultSetup struct {
+	SetupTokenID string `gorm:"primaryKey;size:64;not null"`
+	UserID       string `gorm:"size:32;index;not null"`
+	MerchantID   string `gorm:"size:64"`          // empty when vaulted under the partner
+	Status       string `gorm:"size:32;not null"` // CREATED, VAULTED
+	VaultID      string `gorm:"size:64"`          // payment token exchanged on approval
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
 type SubscriptionPlan struct {
 	MerchantID      string `gorm:"primaryKey"`
 	ProductID       string `gorm:"primaryKey"` // vip_monthly
@@ -78,12 +612,13 @@ type UserSubscription struct {
 	ID                   uint   `gorm:"primaryKey"`
 	UserID               string `gorm:"index"`
 	ProductID            string
-	MerchantID           string
+	MerchantID           string `gorm:"size:64;index"`
 	PayPalSubscriptionID string `gorm:"size:64;uniqueIndex"`
-	Status               string // ACTIVE, CANCELLED, SUSPENDED
+	Status               string `gorm:"size:32;index"` // ACTIVE, CANCELLED, SUSPENDED
+	ClientProfile        string `gorm:"size:32"`
 	StartTime            *time.Time
 	NextBillingTime      *time.Time
-	CreatedAt            time.Time
+	CreatedAt            time.Time `gorm:"index"`
 	UpdatedAt            time.Time
 }
 
@@ -96,6 +631,42 @@ type Merchant struct {
 	PayPalRefreshToken string
 	TokenExpiresAt     *time.Time
 
+	// Partner Referrals onboarding state
+	OnboardingStatus      string `gorm:"size:32"` // PENDING, ACTION_REQUIRED, COMPLETED
+	PaymentsReceivable    bool   `gorm:"not null;default:false"`
+	PrimaryEmailConfirmed bool   `gorm:"not null;default:false"`
+	PermissionsGranted    bool   `gorm:"not null;default:false"`
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
+
+// MerchantFeeRule is the platform fee charged on orders the platform creates
+// on behalf of a merchant: PercentBps of the order amount plus FixedFee.
+type MerchantFeeRule struct {
+	MerchantID string `gorm:"primaryKey"`
+	PercentBps int32  `gorm:"not null;default:0"` // basis points, 250 = 2.5%
+	FixedFee   int64  `gorm:"not null;default:0"` // minor units (cents)
+	Currency   string `gorm:"size:8;not null"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// TaxSummary is a per-jurisdiction aggregate of tax collected on paid purchase units
+type TaxSummary struct {
+	Country         string `json:"country"`
+	TaxJurisdiction string `json:"tax_jurisdiction"`
+	Currency        string `json:"currency"`
+	TaxInclusive    bool   `json:"tax_inclusive"`
+	UnitCount       int64  `json:"unit_count"`
+	TaxableAmount   int64  `json:"taxable_amount"` // minor units (cents), after discounts and without tax
+	Tax             int64  `json:"tax"`            // minor units (cents)
+}
+
+// PlatformFeeSummary is a per-currency aggregate of fees collected on paid orders
+type PlatformFeeSummary struct {
+	Currency     string `json:"currency"`
+	OrderCount   int64  `json:"order_count"`
+	GrossAmount  int64  `json:"gross_amount"`
+	PlatformFees int64  `json:"platform_fees"` // minor units (cents)
+}
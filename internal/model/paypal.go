@@ -3,8 +3,15 @@ package model
 import "time"
 
 type Payer struct {
-	PayerID string `json:"payer_id"`
-	Email   string `json:"email_address"`
+	PayerID string        `json:"payer_id"`
+	Email   string        `json:"email_address"`
+	Address PaypalAddress `json:"address"`
+}
+
+// PaypalAddress is the part of a payer or billing address used for tax
+type PaypalAddress struct {
+	CountryCode string `json:"country_code"`
+	AdminArea1  string `json:"admin_area_1"` // state or province
 }
 
 type PaypalLink struct {
@@ -13,10 +20,12 @@ type PaypalLink struct {
 }
 
 type PaypalResult struct {
-	ID     string       `json:"id"`
-	Links  []PaypalLink `json:"links"`
-	Status string       `json:"status"`
-	Payer  Payer        `json:"payer"`
+	ID            string         `json:"id"`
+	Links         []PaypalLink   `json:"links"`
+	Status        string         `json:"status"`
+	Payer         Payer          `json:"payer"`
+	PurchaseUnits []PurchaseUnit `json:"purchase_units"`
+	PaymentSource PaymentSource  `json:"payment_source"`
 }
 
 type Amount struct {
@@ -49,8 +58,38 @@ type SupplementaryData struct {
 	RelatedIDs RelatedIDs `json:"related_ids"`
 }
 
+type ThreeDSecure struct {
+	EnrollmentStatus     string `json:"enrollment_status"`     // Y, N, U, B
+	AuthenticationStatus string `json:"authentication_status"` // Y, N, R, A, U, C, I, D
+}
+
+type AuthenticationResult struct {
+	LiabilityShift string       `json:"liability_shift"` // YES, NO, POSSIBLE, UNKNOWN
+	ThreeDSecure   ThreeDSecure `json:"three_d_secure"`
+}
+
+type VaultAttribute struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type CardAttributes struct {
+	Vault VaultAttribute `json:"vault"`
+}
+
+type Card struct {
+	Name                 string               `json:"name"`
+	LastDigits           string               `json:"last_digits"`
+	Brand                string               `json:"brand"`
+	Type                 string               `json:"type"`
+	AuthenticationResult AuthenticationResult `json:"authentication_result"`
+	Attributes           CardAttributes       `json:"attributes"`
+	BillingAddress       PaypalAddress        `json:"billing_address"`
+}
+
 type PaymentSource struct {
 	PayPal Payer `json:"paypal"`
+	Card   Card  `json:"card"`
 }
 
 type PayPalMetadata struct {
@@ -65,7 +104,10 @@ type PaypalResource struct {
 	PurchaseUnits     []PurchaseUnit    `json:"purchase_units"`
 	SupplementaryData SupplementaryData `json:"supplementary_data"`
 
-	CustomID string `json:"custom_id"`
+	CustomID  string       `json:"custom_id"`
+	InvoiceID string       `json:"invoice_id"`
+	Amount    Amount       `json:"amount"`
+	Links     []PaypalLink `json:"links"`
 
 	// Vault-specific
 	Metadata        PayPalMetadata `json:"metadata"`
@@ -74,6 +116,105 @@ type PaypalResource struct {
 	// SUBSCRIPTION
 	BillingTime *SubscriptionBillingTime `json:"billing_info,omitempty"`
 	CreateTime  time.Time                `json:"create_time"`
+
+	// CUSTOMER.DISPUTE.*, the dispute is identified by dispute_id instead of id
+	DisputeID             string                `json:"dispute_id"`
+	DisputedTransactions  []DisputedTransaction `json:"disputed_transactions"`
+	Reason                string                `json:"reason"`
+	DisputeAmount         Amount                `json:"dispute_amount"`
+	DisputeOutcome        DisputeOutcome        `json:"dispute_outcome"`
+	DisputeLifeCycleStage string                `json:"dispute_life_cycle_stage"`
+	SellerResponseDueDate *time.Time            `json:"seller_response_due_date"`
+
+	// PAYMENT.PAYOUTS-ITEM.*, id is empty and the item is payout_item_id
+	PayoutItemID      string           `json:"payout_item_id"`
+	PayoutBatchID     string           `json:"payout_batch_id"`
+	TransactionID     string           `json:"transaction_id"`
+	TransactionStatus string           `json:"transaction_status"`
+	PayoutItem        PayoutItemDetail `json:"payout_item"`
+	Errors            PayoutItemError  `json:"errors"`
+
+	// INVOICING.INVOICE.*
+	Invoice PaypalInvoice `json:"invoice"`
+}
+
+// DisputedTransaction is a capture the buyer disputes, SellerTransactionID
+// is the capture id
+type DisputedTransaction struct {
+	SellerTransactionID string `json:"seller_transaction_id"`
+	BuyerTransactionID  string `json:"buyer_transaction_id"`
+}
+
+type DisputeOutcome struct {
+	// OutcomeCode is e.g. RESOLVED_BUYER_FAVOUR, RESOLVED_SELLER_FAVOUR or RESOLVED_WITH_PAYOUT
+	OutcomeCode    string `json:"outcome_code"`
+	AmountRefunded Amount `json:"amount_refunded"`
+}
+
+// PaypalDispute is a dispute as returned by /v1/customer/disputes
+type PaypalDispute struct {
+	DisputeID             string                `json:"dispute_id"`
+	CreateTime            time.Time             `json:"create_time"`
+	UpdateTime            time.Time             `json:"update_time"`
+	DisputedTransactions  []DisputedTransaction `json:"disputed_transactions"`
+	Reason                string                `json:"reason"`
+	Status                string                `json:"status"` // OPEN, WAITING_FOR_BUYER_RESPONSE, WAITING_FOR_SELLER_RESPONSE, UNDER_REVIEW, RESOLVED, OTHER
+	DisputeAmount         Amount                `json:"dispute_amount"`
+	DisputeOutcome        DisputeOutcome        `json:"dispute_outcome"`
+	DisputeLifeCycleStage string                `json:"dispute_life_cycle_stage"` // INQUIRY, CHARGEBACK, PRE_ARBITRATION, ARBITRATION
+	DisputeChannel        string                `json:"dispute_channel"`
+	SellerResponseDueDate *time.Time            `json:"seller_response_due_date"`
+}
+
+type PaypalDisputeList struct {
+	Items []*PaypalDispute `json:"items"`
+	Links []PaypalLink     `json:"links"`
+}
+
+// PayoutItemDetail is the item as it was sent in the payout batch
+type PayoutItemDetail struct {
+	SenderItemID string `json:"sender_item_id"`
+	Receiver     string `json:"receiver"`
+	Amount       Amount `json:"amount"`
+}
+
+type PayoutItemError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// PaypalPayoutItem is one item of a batch returned by /v1/payments/payouts/{id}
+type PaypalPayoutItem struct {
+	PayoutItemID      string           `json:"payout_item_id"`
+	PayoutBatchID     string           `json:"payout_batch_id"`
+	TransactionID     string           `json:"transaction_id"`
+	TransactionStatus string           `json:"transaction_status"` // SUCCESS, PENDING, UNCLAIMED, RETURNED, FAILED, ONHOLD, BLOCKED, REFUNDED, REVERSED
+	PayoutItem        PayoutItemDetail `json:"payout_item"`
+	Errors            PayoutItemError  `json:"errors"`
+}
+
+type PayoutBatchHeader struct {
+	PayoutBatchID string `json:"payout_batch_id"`
+	BatchStatus   string `json:"batch_status"` // PENDING, PROCESSING, SUCCESS, DENIED, CANCELED
+}
+
+type PaypalPayoutBatch struct {
+	BatchHeader PayoutBatchHeader  `json:"batch_header"`
+	Items       []PaypalPayoutItem `json:"items"`
+}
+
+// PaypalInvoice is an invoice of /v2/invoicing/invoices
+type PaypalInvoice struct {
+	ID     string        `json:"id"`
+	Status string        `json:"status"` // DRAFT, SENT, UNPAID, PARTIALLY_PAID, PAID, MARKED_AS_PAID, CANCELLED, REFUNDED, ...
+	Detail InvoiceDetail `json:"detail"`
+	Amount Amount        `json:"amount"`
+}
+
+type InvoiceDetail struct {
+	InvoiceNumber string `json:"invoice_number"`
+	Reference     string `json:"reference"`
+	CurrencyCode  string `json:"currency_code"`
 }
 
 type PayPalWebhookEvent struct {
@@ -92,3 +233,33 @@ type PayPalToken struct {
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int    `json:"expires_in"`
 }
+
+type OAuthThirdParty struct {
+	PartnerClientID  string   `json:"partner_client_id"`
+	MerchantClientID string   `json:"merchant_client_id"`
+	Scopes           []string `json:"scopes"`
+}
+
+type OAuthIntegration struct {
+	IntegrationType   string            `json:"integration_type"`
+	IntegrationMethod string            `json:"integration_method"`
+	OAuthThirdParty   []OAuthThirdParty `json:"oauth_third_party"`
+}
+
+type MerchantIntegration struct {
+	MerchantID            string             `json:"merchant_id"`
+	TrackingID            string             `json:"tracking_id"`
+	PaymentsReceivable    bool               `json:"payments_receivable"`
+	PrimaryEmailConfirmed bool               `json:"primary_email_confirmed"`
+	OAuthIntegrations     []OAuthIntegration `json:"oauth_integrations"`
+}
+
+type VaultCustomer struct {
+	ID string `json:"id"`
+}
+
+type VaultPaymentToken struct {
+	ID            string        `json:"id"`
+	Customer      VaultCustomer `json:"customer"`
+	PaymentSource PaymentSource `json:"payment_source"`
+}
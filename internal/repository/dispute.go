@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"paypal-integration-demo/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DisputeFilter struct {
+	UserID  string
+	OrderID string
+	Status  string
+	Limit   int
+}
+
+type DisputeRepository interface {
+	// Upsert stores the dispute as last sent by PayPal
+	Upsert(ctx context.Context, tx *gorm.DB, dispute *model.Dispute) error
+	Get(ctx context.Context, disputeID string) (*model.Dispute, error)
+	Search(ctx context.Context, filter *DisputeFilter) ([]*model.Dispute, error)
+	// FindDueSoon returns unresolved disputes whose answer is due before the
+	// deadline and that support was not warned about yet
+	FindDueSoon(ctx context.Context, deadline time.Time) ([]*model.Dispute, error)
+	MarkAlerted(ctx context.Context, disputeID string, at time.Time) error
+
+	// HasFreezes tells whether the dispute froze items, released or not
+	HasFreezes(ctx context.Context, tx *gorm.DB, disputeID string) (bool, error)
+	CreateFreezes(ctx context.Context, tx *gorm.DB, freezes []*model.ItemFreeze) error
+	ReleaseFreezes(ctx context.Context, tx *gorm.DB, disputeID string) error
+	// GetActiveFreezes locks the dispute's freezes that were neither released nor revoked
+	GetActiveFreezes(ctx context.Context, tx *gorm.DB, disputeID string) ([]*model.ItemFreeze, error)
+	MarkRevoked(ctx context.Context, tx *gorm.DB, freeze *model.ItemFreeze, quantity int64) error
+	GetFreezes(ctx context.Context, disputeID string) ([]*model.ItemFreeze, error)
+	// GetUserFreezes returns what is currently frozen for the user
+	GetUserFreezes(ctx context.Context, userID string) ([]*model.ItemFreeze, error)
+
+	AddAction(ctx context.Context, action *model.DisputeAction) error
+	GetActions(ctx context.Context, disputeID string) ([]*model.DisputeAction, error)
+}
+
+type disputeRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewDisputeRepository(db *gorm.DB) DisputeRepository {
+	return &disputeRepoImpl{
+		db: db,
+	}
+}
+
+func (r *disputeRepoImpl) Upsert(ctx context.Context, tx *gorm.DB, dispute *model.Dispute) error {
+	return tx.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "dispute_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"reason", "status", "stage", "amount", "currency", "outcome", "seller_response_due_date", "updated_at"}),
+		}).
+		Create(dispute).Error
+}
+
+func (r *disputeRepoImpl) Get(ctx context.Context, disputeID string) (*model.Dispute, error) {
+	var dispute model.Dispute
+	err := r.db.WithContext(ctx).
+		Where("dispute_id = ?", disputeID).
+		First(&dispute).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &dispute, nil
+}
+
+func (r *disputeRepoImpl) Search(ctx context.Context, filter *DisputeFilter) ([]*model.Dispute, error) {
+	q := r.db.WithContext(ctx)
+	if filter.UserID != "" {
+		q = q.Where("user_id = ?", filter.UserID)
+	}
+	if filter.OrderID != "" {
+		q = q.Where("order_id = ?", filter.OrderID)
+	}
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+
+	var disputes []*model.Dispute
+	err := q.Order("created_at DESC").Limit(filter.Limit).Find(&disputes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return disputes, nil
+}
+
+func (r *disputeRepoImpl) FindDueSoon(ctx context.Context, deadline time.Time) ([]*model.Dispute, error) {
+	var disputes []*model.Dispute
+	err := r.db.WithContext(ctx).
+		Where("status <> ? AND alerted_at IS NULL", "RESOLVED").
+		Where("seller_response_due_date IS NOT NULL AND seller_response_due_date <= ?", deadline).
+		Order("seller_response_due_date").
+		Find(&disputes).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return disputes, nil
+}
+
+func (r *disputeRepoImpl) MarkAlerted(ctx context.Context, disputeID string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&model.Dispute{}).
+		Where("dispute_id = ?", disputeID).
+		Update("alerted_at", at).Error
+}
+
+func (r *disputeRepoImpl) HasFreezes(ctx context.Context, tx *gorm.DB, disputeID string) (bool, error) {
+	var count int64
+	err := tx.WithContext(ctx).Model(&model.ItemFreeze{}).
+		Where("dispute_id = ?", disputeID).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+func (r *disputeRepoImpl) CreateFreezes(ctx context.Context, tx *gorm.DB, freezes []*model.ItemFreeze) error {
+	if len(freezes) == 0 {
+		return nil
+	}
+	return tx.WithContext(ctx).Create(&freezes).Error
+}
+
+func (r *disputeRepoImpl) ReleaseFreezes(ctx context.Context, tx *gorm.DB, disputeID string) error {
+	return tx.WithContext(ctx).Model(&model.ItemFreeze{}).
+		Where("dispute_id = ? AND released_at IS NULL", disputeID).
+		Update("released_at", time.Now()).Error
+}
+
+func (r *disputeRepoImpl) GetActiveFreezes(ctx context.Context, tx *gorm.DB, disputeID string) ([]*model.ItemFreeze, error) {
+	var freezes []*model.ItemFreeze
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("dispute_id = ? AND released_at IS NULL", disputeID).
+		Order("id").
+		Find(&freezes).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return freezes, nil
+}
+
+func (r *disputeRepoImpl) MarkRevoked(ctx context.Context, tx *gorm.DB, freeze *model.ItemFreeze, quantity int64) error {
+	// a revoked freeze no longer blocks anything, so it is released as well
+	now := time.Now()
+	return tx.WithContext(ctx).Model(freeze).
+		Updates(map[string]interface{}{
+			"revoked_quantity": quantity,
+			"revoked_at":       now,
+			"released_at":      now,
+		}).Error
+}
+
+func (r *disputeRepoImpl) GetFreezes(ctx context.Context, disputeID string) ([]*model.ItemFreeze, error) {
+	var freezes []*model.ItemFreeze
+	err := r.db.WithContext(ctx).
+		Where("dispute_id = ?", disputeID).
+		Order("id").
+		Find(&freezes).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return freezes, nil
+}
+
+func (r *disputeRepoImpl) GetUserFreezes(ctx context.Context, userID string) ([]*model.ItemFreeze, error) {
+	var freezes []*model.ItemFreeze
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND released_at IS NULL", userID).
+		Order("id").
+		Find(&freezes).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return freezes, nil
+}
+
+func (r *disputeRepoImpl) AddAction(ctx context.Context, action *model.DisputeAction) error {
+	return r.db.WithContext(ctx).Create(action).Error
+}
+
+func (r *disputeRepoImpl) GetActions(ctx context.Context, disputeID string) ([]*model.DisputeAction, error) {
+	var actions []*model.DisputeAction
+	err := r.db.WithContext(ctx).
+		Where("dispute_id = ?", disputeID).
+		Order("id").
+		Find(&actions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"paypal-integration-demo/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type EntitlementRepository interface {
+	Seed(ctx context.Context) error
+	CreateEntitlement(ctx context.Context, entitlement *model.Entitlement) error
+	FindEntitlements(ctx context.Context, entitlementIDs []string) ([]*model.Entitlement, error)
+	SetProductEntitlements(ctx context.Context, productID string, mappings []*model.ProductEntitlement) error
+	GetProductEntitlements(ctx context.Context, tx *gorm.DB, productIDs []string) ([]*model.EntitlementGrant, error)
+
+	// GrantPass adds days to a user's pass, starting now when it is missing or
+	// expired. It returns nil without changing anything when the idempotency
+	// key was seen before.
+	GrantPass(ctx context.Context, tx *gorm.DB, grant *model.PassGrant) (*model.UserPass, error)
+	// RevokePass takes grant.Days off a user's pass and records the grant with
+	// negative days. It returns the days actually taken, none when the pass is
+	// missing or the idempotency key was seen before.
+	RevokePass(ctx context.Context, tx *gorm.DB, grant *model.PassGrant) (int32, error)
+	GetPasses(ctx context.Context, userID string) ([]*model.UserPass, error)
+	GetPassGrantsBySource(ctx context.Context, sourceType model.InventorySourceType, sourceID string) ([]*model.PassGrant, error)
+	ExpirePasses(ctx context.Context, now time.Time) (int64, error)
+}
+
+type entitlementRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewEntitlementRepository(db *gorm.DB) EntitlementRepository {
+	return &entitlementRepoImpl{
+		db: db,
+	}
+}
+
+func (r *entitlementRepoImpl) Seed(ctx context.Context) error {
+	entitlements := []model.Entitlement{
+		{ID: "coins", Name: "Coins", Type: string(model.ENTITLEMENT_CURRENCY)},
+		{ID: "skin_starter", Name: "Starter Skin", Type: string(model.ENTITLEMENT_DURABLE)},
+		{ID: "vip", Name: "VIP", Type: string(model.ENTITLEMENT_PASS)},
+	}
+	mappings := []model.ProductEntitlement{
+		{ProductID: "coin_100", EntitlementID: "coins", Quantity: 100},
+		{ProductID: "coin_200", EntitlementID: "coins", Quantity: 200},
+		{ProductID: "starter_pack", EntitlementID: "coins", Quantity: 500},
+		{ProductID: "starter_pack", EntitlementID: "skin_starter", Quantity: 1},
+		{ProductID: "starter_pack", EntitlementID: "vip", DurationDays: 7},
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entitlements).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&mappings).Error
+	})
+}
+
+func (r *entitlementRepoImpl) CreateEntitlement(ctx context.Context, entitlement *model.Entitlement) error {
+	return r.db.WithContext(ctx).Create(entitlement).Error
+}
+
+func (r *entitlementRepoImpl) FindEntitlements(ctx context.Context, entitlementIDs []string) ([]*model.Entitlement, error) {
+	var entitlements []*model.Entitlement
+	err := r.db.WithContext(ctx).
+		Where("id IN ?", entitlementIDs).
+		Find(&entitlements).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entitlements, nil
+}
+
+func (r *entitlementRepoImpl) SetProductEntitlements(ctx context.Context, productID string, mappings []*model.ProductEntitlement) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", productID).Delete(&model.ProductEntitlement{}).Error; err != nil {
+			return err
+		}
+		if len(mappings) == 0 {
+			return nil
+		}
+		return tx.Create(&mappings).Error
+	})
+}
+
+func (r *entitlementRepoImpl) GetProductEntitlements(ctx context.Context, tx *gorm.DB, productIDs []string) ([]*model.EntitlementGrant, error) {
+	var grants []*model.EntitlementGrant
+	err := tx.WithContext(ctx).Model(&model.ProductEntitlement{}).
+		Select("product_entitlements.product_id, product_entitlements.entitlement_id, entitlements.type, product_entitlements.quantity, product_entitlements.duration_days").
+		Joins("JOIN entitlements ON entitlements.id = product_entitlements.entitlement_id").
+		Where("product_entitlements.product_id IN ?", productIDs).
+		Order("product_entitlements.product_id, product_entitlements.entitlement_id").
+		Scan(&grants).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+func (r *entitlementRepoImpl) GrantPass(ctx context.Context, tx *gorm.DB, grant *model.PassGrant) (*model.UserPass, error) {
+	var seen int64
+	err := tx.WithContext(ctx).Model(&model.PassGrant{}).
+		Where("idempotency_key = ?", grant.IdempotencyKey).
+		Count(&seen).Error
+	if err != nil {
+		return nil, err
+	}
+	if seen > 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var pass model.UserPass
+	err = tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND entitlement_id = ?", grant.UserID, grant.EntitlementID).
+		First(&pass).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	start := now
+	if pass.Status == "ACTIVE" && pass.ExpiresAt.After(now) {
+		start = pass.ExpiresAt
+	}
+	pass.UserID = grant.UserID
+	pass.EntitlementID = grant.EntitlementID
+	pass.Status = "ACTIVE"
+	pass.ExpiresAt = start.AddDate(0, 0, int(grant.Days))
+
+	grant.ExpiresAt = pass.ExpiresAt
+	// the unique idempotency key rejects a concurrent duplicate
+	if err := tx.WithContext(ctx).Create(grant).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.WithContext(ctx).Save(&pass).Error; err != nil {
+		return nil, err
+	}
+
+	return &pass, nil
+}
+
+func (r *entitlementRepoImpl) RevokePass(ctx context.Context, tx *gorm.DB, grant *model.PassGrant) (int32, error) {
+	var seen int64
+	err := tx.WithContext(ctx).Model(&model.PassGrant{}).
+		Where("idempotency_key = ?", grant.IdempotencyKey).
+		Count(&seen).Error
+	if err != nil {
+		return 0, err
+	}
+	if seen > 0 {
+		return 0, nil
+	}
+
+	var pass model.UserPass
+	err = tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND entitlement_id = ?", grant.UserID, grant.EntitlementID).
+		First(&pass).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// only the time left can be taken back
+	now := time.Now()
+	days := grant.Days
+	if pass.Status != "ACTIVE" || !pass.ExpiresAt.After(now) {
+		days = 0
+	} else {
+		expiresAt := pass.ExpiresAt.AddDate(0, 0, -int(days))
+		if !expiresAt.After(now) {
+			days = int32(math.Ceil(pass.ExpiresAt.Sub(now).Hours() / 24))
+			expiresAt = now
+			pass.Status = "EXPIRED"
+		}
+		pass.ExpiresAt = expiresAt
+		if err := tx.WithContext(ctx).Save(&pass).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	grant.Days = -days
+	grant.ExpiresAt = pass.ExpiresAt
+	// the unique idempotency key rejects a concurrent duplicate
+	if err := tx.WithContext(ctx).Create(grant).Error; err != nil {
+		return 0, err
+	}
+
+	return days, nil
+}
+
+func (r *entitlementRepoImpl) GetPasses(ctx context.Context, userID string) ([]*model.UserPass, error) {
+	var passes []*model.UserPass
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("expires_at DESC").
+		Find(&passes).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return passes, nil
+}
+
+func (r *entitlementRepoImpl) ExpirePasses(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&model.UserPass{}).
+		Where("status = ? AND expires_at <= ?", "ACTIVE", now).
+		Updates(map[string]interface{}{
+			"status":     "EXPIRED",
+			"updated_at": now,
+		})
+
+	return result.RowsAffected, result.Error
+}
+
+func (r *entitlementRepoImpl) GetPassGrantsBySource(ctx context.Context, sourceType model.InventorySourceType, sourceID string) ([]*model.PassGrant, error) {
+	var grants []*model.PassGrant
+	err := r.db.WithContext(ctx).
+		Where("source_type = ? AND source_id = ?", string(sourceType), sourceID).
+		Order("id").
+		Find(&grants).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"paypal-integration-demo/internal/model"
 	"time"
 
@@ -9,9 +10,22 @@ import (
 	"gorm.io/gorm/clause"
 )
 
+// ErrInsufficientQuantity is returned by Apply when an entry would take a balance below zero
+var ErrInsufficientQuantity = errors.New("insufficient inventory quantity")
+
 type InventoryRepository interface {
-	Upsert(ctx context.Context, tx *gorm.DB, inventory *model.UserInventory) error
+	// Apply appends entry to the ledger and updates the materialized balance.
+	// It returns false without changing anything when the idempotency key was seen before.
+	Apply(ctx context.Context, tx *gorm.DB, entry *model.InventoryLedgerEntry) (bool, error)
 	Get(ctx context.Context, userID string) ([]*model.UserInventory, error)
+	GetQuantity(ctx context.Context, tx *gorm.DB, userID string, productID string) (int32, error)
+	GetLedger(ctx context.Context, userID string, productID string, limit int) ([]*model.InventoryLedgerEntry, error)
+	// GetLedgerBySource returns every change made by one order or admin adjustment
+	GetLedgerBySource(ctx context.Context, sourceType model.InventorySourceType, sourceID string) ([]*model.InventoryLedgerEntry, error)
+
+	// FindDrift compares every balance with its ledger, both ways
+	FindDrift(ctx context.Context) ([]*model.InventoryDrift, error)
+	SetBalance(ctx context.Context, tx *gorm.DB, userID string, productID string, quantity int32) error
 }
 
 type inventoryRepoImpl struct {
@@ -24,14 +38,49 @@ func NewInventoryRepository(db *gorm.DB) InventoryRepository {
 	}
 }
 
-func (r *inventoryRepoImpl) Upsert(ctx context.Context, tx *gorm.DB, inventory *model.UserInventory) error {
-	return tx.WithContext(ctx).Clauses(clause.OnConflict{
+func (r *inventoryRepoImpl) Apply(ctx context.Context, tx *gorm.DB, entry *model.InventoryLedgerEntry) (bool, error) {
+	result := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(entry)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	if entry.Delta < 0 {
+		update := tx.WithContext(ctx).Model(&model.UserInventory{}).
+			Where("user_id = ? AND product_id = ? AND quantity >= ?", entry.UserID, entry.ProductID, -entry.Delta).
+			Updates(map[string]interface{}{
+				"quantity":   gorm.Expr("quantity + ?", entry.Delta),
+				"updated_at": time.Now(),
+			})
+		if update.Error != nil {
+			return false, update.Error
+		}
+		if update.RowsAffected == 0 {
+			return false, ErrInsufficientQuantity
+		}
+		return true, nil
+	}
+
+	err := tx.WithContext(ctx).Clauses(clause.OnConflict{
 		Columns: []clause.Column{{Name: "user_id"}, {Name: "product_id"}},
 		DoUpdates: clause.Assignments(map[string]interface{}{
-			"quantity":   gorm.Expr("user_inventories.quantity + ?", inventory.Quantity),
+			"quantity":   gorm.Expr("user_inventories.quantity + ?", entry.Delta),
 			"updated_at": time.Now(),
 		}),
-	}).Create(&inventory).Error
+	}).Create(&model.UserInventory{
+		UserID:    entry.UserID,
+		ProductID: entry.ProductID,
+		Quantity:  entry.Delta,
+	}).Error
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 func (r *inventoryRepoImpl) Get(ctx context.Context, userID string) ([]*model.UserInventory, error) {
@@ -46,3 +95,94 @@ func (r *inventoryRepoImpl) Get(ctx context.Context, userID string) ([]*model.Us
 
 	return inventories, nil
 }
+
+func (r *inventoryRepoImpl) GetQuantity(ctx context.Context, tx *gorm.DB, userID string, productID string) (int32, error) {
+	var inventory model.UserInventory
+	err := tx.WithContext(ctx).
+		Where("user_id = ? AND product_id = ?", userID, productID).
+		First(&inventory).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+
+	return inventory.Quantity, err
+}
+
+func (r *inventoryRepoImpl) GetLedger(ctx context.Context, userID string, productID string, limit int) ([]*model.InventoryLedgerEntry, error) {
+	query := r.db.WithContext(ctx).
+		Where("user_id = ?", userID)
+	if productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+
+	var entries []*model.InventoryLedgerEntry
+	err := query.
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&entries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (r *inventoryRepoImpl) FindDrift(ctx context.Context) ([]*model.InventoryDrift, error) {
+	// MySQL has no FULL OUTER JOIN, so balances without entries and entries
+	// without a balance are two halves of a UNION
+	var drifts []*model.InventoryDrift
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT i.user_id, i.product_id, i.quantity AS balance,
+			COALESCE(l.total, 0) AS ledger_total, COALESCE(l.entries, 0) AS ledger_entries
+		FROM user_inventories i
+		LEFT JOIN (
+			SELECT user_id, product_id, SUM(delta) AS total, COUNT(*) AS entries
+			FROM inventory_ledger_entries
+			GROUP BY user_id, product_id
+		) l ON l.user_id = i.user_id AND l.product_id = i.product_id
+		WHERE i.quantity <> COALESCE(l.total, 0)
+		UNION ALL
+		SELECT l.user_id, l.product_id, 0 AS balance, SUM(l.delta) AS ledger_total, COUNT(*) AS ledger_entries
+		FROM inventory_ledger_entries l
+		LEFT JOIN user_inventories i ON i.user_id = l.user_id AND i.product_id = l.product_id
+		WHERE i.user_id IS NULL
+		GROUP BY l.user_id, l.product_id
+		HAVING SUM(l.delta) <> 0
+		ORDER BY user_id, product_id
+	`).Scan(&drifts).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return drifts, nil
+}
+
+func (r *inventoryRepoImpl) SetBalance(ctx context.Context, tx *gorm.DB, userID string, productID string, quantity int32) error {
+	return tx.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "product_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"quantity":   quantity,
+			"updated_at": time.Now(),
+		}),
+	}).Create(&model.UserInventory{
+		UserID:    userID,
+		ProductID: productID,
+		Quantity:  quantity,
+	}).Error
+}
+
+func (r *inventoryRepoImpl) GetLedgerBySource(ctx context.Context, sourceType model.InventorySourceType, sourceID string) ([]*model.InventoryLedgerEntry, error) {
+	var entries []*model.InventoryLedgerEntry
+	err := r.db.WithContext(ctx).
+		Where("source_type = ? AND source_id = ?", string(sourceType), sourceID).
+		Order("id").
+		Find(&entries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"paypal-integration-demo/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type InvoiceFilter struct {
+	UserID     string
+	MerchantID string
+	Status     string
+	Limit      int
+}
+
+type InvoiceRepository interface {
+	Create(ctx context.Context, tx *gorm.DB, invoice *model.Invoice) error
+	Get(ctx context.Context, invoiceID string) (*model.Invoice, error)
+	// GetForUpdate locks the invoice until tx ends
+	GetForUpdate(ctx context.Context, tx *gorm.DB, invoiceID string) (*model.Invoice, error)
+	Update(ctx context.Context, tx *gorm.DB, invoice *model.Invoice) error
+	Search(ctx context.Context, filter *InvoiceFilter) ([]*model.Invoice, error)
+}
+
+type invoiceRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewInvoiceRepository(db *gorm.DB) InvoiceRepository {
+	return &invoiceRepoImpl{
+		db: db,
+	}
+}
+
+func (r *invoiceRepoImpl) Create(ctx context.Context, tx *gorm.DB, invoice *model.Invoice) error {
+	return tx.WithContext(ctx).Create(invoice).Error
+}
+
+func (r *invoiceRepoImpl) Get(ctx context.Context, invoiceID string) (*model.Invoice, error) {
+	return r.get(r.db.WithContext(ctx), invoiceID)
+}
+
+func (r *invoiceRepoImpl) GetForUpdate(ctx context.Context, tx *gorm.DB, invoiceID string) (*model.Invoice, error) {
+	return r.get(tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}), invoiceID)
+}
+
+func (r *invoiceRepoImpl) get(q *gorm.DB, invoiceID string) (*model.Invoice, error) {
+	var invoice model.Invoice
+	err := q.Where("invoice_id = ?", invoiceID).
+		First(&invoice).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+func (r *invoiceRepoImpl) Update(ctx context.Context, tx *gorm.DB, invoice *model.Invoice) error {
+	return tx.WithContext(ctx).Save(invoice).Error
+}
+
+func (r *invoiceRepoImpl) Search(ctx context.Context, filter *InvoiceFilter) ([]*model.Invoice, error) {
+	q := r.db.WithContext(ctx)
+	if filter.UserID != "" {
+		q = q.Where("user_id = ?", filter.UserID)
+	}
+	if filter.MerchantID != "" {
+		q = q.Where("merchant_id = ?", filter.MerchantID)
+	}
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+
+	var invoices []*model.Invoice
+	err := q.Order("created_at DESC").Limit(filter.Limit).Find(&invoices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return invoices, nil
+}
@@ -13,6 +13,9 @@ type MerchantRepository interface {
 	Upsert(ctx context.Context, merchant *model.Merchant) error
 	Get(ctx context.Context, merchantID string) (*model.Merchant, error)
 	ClearPayPalTokens(ctx context.Context, merchantID string) error
+	UpdateOnboarding(ctx context.Context, merchant *model.Merchant) error
+	GetFeeRule(ctx context.Context, merchantID string) (*model.MerchantFeeRule, error)
+	UpsertFeeRule(ctx context.Context, rule *model.MerchantFeeRule) error
 }
 
 type merchantRepoImpl struct {
@@ -54,9 +57,14 @@ func (r *merchantRepoImpl) ClearPayPalTokens(ctx context.Context, merchantID str
 		Model(&model.Merchant{}).
 		Where("id = ?", merchantID).
 		Updates(map[string]interface{}{
-			"pay_pal_access_token":  "",
-			"pay_pal_refresh_token": "",
-			"token_expires_at":      nil,
+			"pay_pal_access_token":    "",
+			"pay_pal_refresh_token":   "",
+			"token_expires_at":        nil,
+			"pay_pal_merchant_id":     "",
+			"onboarding_status":       "",
+			"payments_receivable":     false,
+			"primary_email_confirmed": false,
+			"permissions_granted":     false,
 		})
 
 	if result.Error != nil {
@@ -69,3 +77,52 @@ func (r *merchantRepoImpl) ClearPayPalTokens(ctx context.Context, merchantID str
 
 	return nil
 }
+
+func (r *merchantRepoImpl) UpdateOnboarding(ctx context.Context, merchant *model.Merchant) error {
+	result := r.db.
+		WithContext(ctx).
+		Model(&model.Merchant{}).
+		Where("id = ?", merchant.ID).
+		Updates(map[string]interface{}{
+			"pay_pal_merchant_id":     merchant.PayPalMerchantID,
+			"onboarding_status":       merchant.OnboardingStatus,
+			"payments_receivable":     merchant.PaymentsReceivable,
+			"primary_email_confirmed": merchant.PrimaryEmailConfirmed,
+			"permissions_granted":     merchant.PermissionsGranted,
+			"updated_at":              time.Now(),
+		})
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *merchantRepoImpl) GetFeeRule(ctx context.Context, merchantID string) (*model.MerchantFeeRule, error) {
+	var rule model.MerchantFeeRule
+	err := r.db.WithContext(ctx).
+		Where("merchant_id = ?", merchantID).
+		First(&rule).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (r *merchantRepoImpl) UpsertFeeRule(ctx context.Context, rule *model.MerchantFeeRule) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "merchant_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"percent_bps": rule.PercentBps,
+			"fixed_fee":   rule.FixedFee,
+			"currency":    rule.Currency,
+			"updated_at":  time.Now(),
+		}),
+	}).Create(rule).Error
+}
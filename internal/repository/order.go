@@ -6,16 +6,76 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// OrderFilter narrows a user's order history. Zero values do not filter.
+// Orders are returned newest first, strictly after the cursor.
+type OrderFilter struct {
+	UserID     string
+	Status     string
+	MerchantID string
+	ProductID  string
+	From       time.Time
+	To         time.Time
+
+	// cursor: the created_at and order_id of the last order of the previous page
+	AfterCreatedAt time.Time
+	AfterOrderID   string
+	Limit          int
+}
+
+// OrderSearch is the support staff view over every user's orders.
+// Zero values do not filter, amounts are inclusive.
+type OrderSearch struct {
+	OrderID    string
+	CaptureID  string
+	UserID     string
+	MerchantID string
+	Status     string
+	MinAmount  *int32
+	MaxAmount  *int32
+	From       time.Time
+	To         time.Time
+	Limit      int // 0 means no limit
+}
+
 type OrderRepository interface {
 	Create(ctx context.Context, tx *gorm.DB, order *model.Order) error
 	FindByOrderID(ctx context.Context, orderID string) (*model.Order, error)
 	MarkCompleted(ctx context.Context, tx *gorm.DB, orderID string) error
-	MarkPaid(ctx context.Context, tx *gorm.DB, orderID string) (*model.Order, error)
+	MarkPaid(ctx context.Context, tx *gorm.DB, orderID string) (*model.Order, bool, error)
 	IsPaid(ctx context.Context, orderID string) (bool, error)
 	CreateOrderItems(ctx context.Context, tx *gorm.DB, items []*model.OrderItem) error
 	GetOrderItems(ctx context.Context, tx *gorm.DB, orderID string) ([]*model.OrderItem, error)
+	SumPlatformFees(ctx context.Context, merchantID string, from time.Time, to time.Time) ([]*model.PlatformFeeSummary, error)
+	// SumTaxes aggregates the tax of purchase units paid in [from, to)
+	SumTaxes(ctx context.Context, from time.Time, to time.Time) ([]*model.TaxSummary, error)
+	// UpdateTax stores the tax of an order computed after creation, together
+	// with the tax of its units and items
+	UpdateTax(ctx context.Context, tx *gorm.DB, order *model.Order, units []*model.OrderUnit, items []*model.OrderItem) error
+
+	UpdateStatus(ctx context.Context, tx *gorm.DB, orderID string, status string) error
+	MarkCancelled(ctx context.Context, tx *gorm.DB, orderID string) (bool, error)
+	UpdateCardDetails(ctx context.Context, tx *gorm.DB, orderID string, brand string, lastDigits string) error
+	CreateOrderUnits(ctx context.Context, tx *gorm.DB, units []*model.OrderUnit) error
+	GetOrderUnits(ctx context.Context, tx *gorm.DB, orderID string) ([]*model.OrderUnit, error)
+	FindUnitByReferenceID(ctx context.Context, tx *gorm.DB, referenceID string) (*model.OrderUnit, error)
+	FindUnitByCaptureID(ctx context.Context, tx *gorm.DB, captureID string) (*model.OrderUnit, error)
+	UpdateUnitCapture(ctx context.Context, tx *gorm.DB, referenceID string, captureID string, status string) error
+	MarkUnitPaid(ctx context.Context, tx *gorm.DB, referenceID string, captureID string) (bool, error)
+	GetUnitItems(ctx context.Context, tx *gorm.DB, referenceID string) ([]*model.OrderItem, error)
+
+	List(ctx context.Context, filter *OrderFilter) ([]*model.Order, error)
+	GetStatusHistory(ctx context.Context, orderID string) ([]*model.OrderStatusHistory, error)
+	UpsertRefund(ctx context.Context, refund *model.OrderRefund) error
+	GetRefunds(ctx context.Context, orderID string) ([]*model.OrderRefund, error)
+
+	// Search streams matching orders newest first without loading them all in memory
+	Search(ctx context.Context, search *OrderSearch, fn func(*model.Order) error) error
+	GetUnitsByOrderIDs(ctx context.Context, orderIDs []string) ([]*model.OrderUnit, error)
+	// CountPaidOrders counts the user's orders that were at least partly paid
+	CountPaidOrders(ctx context.Context, userID string) (int64, error)
 }
 
 type orderRepoImpl struct {
@@ -29,7 +89,17 @@ func NewOrderRepository(db *gorm.DB) OrderRepository {
 }
 
 func (r *orderRepoImpl) Create(ctx context.Context, tx *gorm.DB, order *model.Order) error {
-	return tx.WithContext(ctx).Create(order).Error
+	if err := tx.WithContext(ctx).Create(order).Error; err != nil {
+		return err
+	}
+	return r.addStatusHistory(ctx, tx, order.OrderID, order.Status)
+}
+
+func (r *orderRepoImpl) addStatusHistory(ctx context.Context, tx *gorm.DB, orderID string, status string) error {
+	return tx.WithContext(ctx).Create(&model.OrderStatusHistory{
+		OrderID: orderID,
+		Status:  status,
+	}).Error
 }
 
 func (r *orderRepoImpl) FindByOrderID(ctx context.Context, orderID string) (*model.Order, error) {
@@ -46,7 +116,7 @@ func (r *orderRepoImpl) FindByOrderID(ctx context.Context, orderID string) (*mod
 }
 
 func (r *orderRepoImpl) MarkCompleted(ctx context.Context, tx *gorm.DB, orderID string) error {
-	return tx.WithContext(ctx).Model(&model.Order{}).
+	result := tx.WithContext(ctx).Model(&model.Order{}).
 		Where(`
 			order_id = ?
 			AND status IN ?
@@ -57,15 +127,23 @@ func (r *orderRepoImpl) MarkCompleted(ctx context.Context, tx *gorm.DB, orderID
 		Updates(map[string]interface{}{
 			"status":     "COMPLETED",
 			"updated_at": time.Now(),
-		}).Error
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	return r.addStatusHistory(ctx, tx, orderID, "COMPLETED")
 }
 
-func (r *orderRepoImpl) MarkPaid(ctx context.Context, tx *gorm.DB, orderID string) (*model.Order, error) {
+// MarkPaid reports whether the order transitioned to PAID, so a second
+// capture event for the same order does not grant its items twice.
+func (r *orderRepoImpl) MarkPaid(ctx context.Context, tx *gorm.DB, orderID string) (*model.Order, bool, error) {
 	var order model.Order
+	var paid bool
 	err := tx.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// Update the record
 		result := tx.Model(&order).
-			Where("order_id = ?", orderID).
+			Where("order_id = ? AND status <> ?", orderID, "PAID").
 			Updates(map[string]interface{}{
 				"status":     "PAID",
 				"updated_at": time.Now(),
@@ -74,15 +152,20 @@ func (r *orderRepoImpl) MarkPaid(ctx context.Context, tx *gorm.DB, orderID strin
 		if result.Error != nil {
 			return result.Error
 		}
-		if result.RowsAffected == 0 {
-			return gorm.ErrRecordNotFound
+		paid = result.RowsAffected > 0
+
+		if paid {
+			if err := r.addStatusHistory(ctx, tx, orderID, "PAID"); err != nil {
+				return err
+			}
 		}
 
-		// Fetch the updated record within the same transaction
+		// Fetch the updated record within the same transaction, this is also
+		// where an unknown order id turns into ErrRecordNotFound
 		return tx.Where("order_id = ?", orderID).First(&order).Error
 	})
 
-	return &order, err
+	return &order, paid, err
 }
 
 func (r *orderRepoImpl) IsPaid(ctx context.Context, orderID string) (bool, error) {
@@ -110,3 +193,371 @@ func (r *orderRepoImpl) GetOrderItems(ctx context.Context, tx *gorm.DB, orderID
 
 	return items, nil
 }
+
+func (r *orderRepoImpl) SumPlatformFees(ctx context.Context, merchantID string, from time.Time, to time.Time) ([]*model.PlatformFeeSummary, error) {
+	var summaries []*model.PlatformFeeSummary
+	err := r.db.WithContext(ctx).Model(&model.OrderUnit{}).
+		Select("currency, COUNT(*) AS order_count, SUM(amount) AS gross_amount, SUM(platform_fee) AS platform_fees").
+		Where("merchant_id = ?", merchantID).
+		Where("status IN ?", []string{"PAID", "COMPLETED"}).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("currency").
+		Scan(&summaries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+func (r *orderRepoImpl) SumTaxes(ctx context.Context, from time.Time, to time.Time) ([]*model.TaxSummary, error) {
+	var summaries []*model.TaxSummary
+	err := r.db.WithContext(ctx).Model(&model.OrderUnit{}).
+		Select("orders.tax_country AS country, orders.tax_jurisdiction, order_units.currency, orders.tax_inclusive, "+
+			"COUNT(*) AS unit_count, "+
+			"SUM(order_units.amount * 100 - order_units.discount - CASE WHEN orders.tax_inclusive THEN order_units.tax ELSE 0 END) AS taxable_amount, "+
+			"SUM(order_units.tax) AS tax").
+		Joins("JOIN orders ON orders.order_id = order_units.order_id").
+		Where("order_units.status IN ?", []string{"PAID", "COMPLETED"}).
+		Where("order_units.created_at >= ? AND order_units.created_at < ?", from, to).
+		Group("orders.tax_country, orders.tax_jurisdiction, order_units.currency, orders.tax_inclusive").
+		Order("country, orders.tax_jurisdiction").
+		Scan(&summaries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+func (r *orderRepoImpl) UpdateTax(ctx context.Context, tx *gorm.DB, order *model.Order, units []*model.OrderUnit, items []*model.OrderItem) error {
+	err := tx.WithContext(ctx).Model(&model.Order{}).
+		Where("order_id = ?", order.OrderID).
+		Updates(map[string]interface{}{
+			"tax":              order.Tax,
+			"tax_inclusive":    order.TaxInclusive,
+			"tax_country":      order.TaxCountry,
+			"tax_jurisdiction": order.TaxJurisdiction,
+			"updated_at":       time.Now(),
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	for _, unit := range units {
+		err := tx.WithContext(ctx).Model(&model.OrderUnit{}).
+			Where("reference_id = ?", unit.ReferenceID).
+			Updates(map[string]interface{}{
+				"tax":        unit.Tax,
+				"updated_at": time.Now(),
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range items {
+		err := tx.WithContext(ctx).Model(&model.OrderItem{}).
+			Where("id = ?", item.ID).
+			Update("tax", item.Tax).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *orderRepoImpl) UpdateStatus(ctx context.Context, tx *gorm.DB, orderID string, status string) error {
+	result := tx.WithContext(ctx).Model(&model.Order{}).
+		Where("order_id = ? AND status <> ?", orderID, status).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	return r.addStatusHistory(ctx, tx, orderID, status)
+}
+
+// MarkCancelled reports whether the order was still waiting for approval and
+// is now CANCELLED, an order the buyer already paid is left alone.
+func (r *orderRepoImpl) MarkCancelled(ctx context.Context, tx *gorm.DB, orderID string) (bool, error) {
+	result := tx.WithContext(ctx).Model(&model.Order{}).
+		Where("order_id = ? AND status IN ?", orderID, []string{"CREATED", "APPROVED"}).
+		Updates(map[string]interface{}{
+			"status":     "CANCELLED",
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+
+	return true, r.addStatusHistory(ctx, tx, orderID, "CANCELLED")
+}
+
+func (r *orderRepoImpl) UpdateCardDetails(ctx context.Context, tx *gorm.DB, orderID string, brand string, lastDigits string) error {
+	return tx.WithContext(ctx).Model(&model.Order{}).
+		Where("order_id = ?", orderID).
+		Updates(map[string]interface{}{
+			"card_brand":       brand,
+			"card_last_digits": lastDigits,
+			"updated_at":       time.Now(),
+		}).Error
+}
+
+func (r *orderRepoImpl) CreateOrderUnits(ctx context.Context, tx *gorm.DB, units []*model.OrderUnit) error {
+	return tx.WithContext(ctx).Create(&units).Error
+}
+
+func (r *orderRepoImpl) GetOrderUnits(ctx context.Context, tx *gorm.DB, orderID string) ([]*model.OrderUnit, error) {
+	var units []*model.OrderUnit
+	err := tx.WithContext(ctx).Where("order_id = ?", orderID).
+		Order("id").
+		Find(&units).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+func (r *orderRepoImpl) FindUnitByReferenceID(ctx context.Context, tx *gorm.DB, referenceID string) (*model.OrderUnit, error) {
+	var unit model.OrderUnit
+	err := tx.WithContext(ctx).
+		Where("reference_id = ?", referenceID).
+		First(&unit).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &unit, nil
+}
+
+func (r *orderRepoImpl) UpdateUnitCapture(ctx context.Context, tx *gorm.DB, referenceID string, captureID string, status string) error {
+	return tx.WithContext(ctx).Model(&model.OrderUnit{}).
+		Where("reference_id = ? AND status <> ?", referenceID, "PAID").
+		Updates(map[string]interface{}{
+			"capture_id": captureID,
+			"status":     status,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// MarkUnitPaid reports whether the unit transitioned to PAID, so a replayed
+// capture webhook does not grant its items twice.
+func (r *orderRepoImpl) MarkUnitPaid(ctx context.Context, tx *gorm.DB, referenceID string, captureID string) (bool, error) {
+	result := tx.WithContext(ctx).Model(&model.OrderUnit{}).
+		Where("reference_id = ? AND status <> ?", referenceID, "PAID").
+		Updates(map[string]interface{}{
+			"capture_id": captureID,
+			"status":     "PAID",
+			"updated_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (r *orderRepoImpl) GetUnitItems(ctx context.Context, tx *gorm.DB, referenceID string) ([]*model.OrderItem, error) {
+	var items []*model.OrderItem
+	err := tx.WithContext(ctx).Where("reference_id = ?", referenceID).
+		Find(&items).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (r *orderRepoImpl) List(ctx context.Context, filter *OrderFilter) ([]*model.Order, error) {
+	query := r.db.WithContext(ctx).Model(&model.Order{}).
+		Where("user_id = ?", filter.UserID)
+
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.MerchantID != "" {
+		// multi-merchant orders only name their merchants on the units
+		query = query.Where(
+			"(merchant_id = ? OR order_id IN (?))",
+			filter.MerchantID,
+			r.db.Model(&model.OrderUnit{}).Select("order_id").Where("merchant_id = ?", filter.MerchantID),
+		)
+	}
+	if filter.ProductID != "" {
+		query = query.Where(
+			"order_id IN (?)",
+			r.db.Model(&model.OrderItem{}).Select("order_id").Where("product_id = ?", filter.ProductID),
+		)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.AfterOrderID != "" {
+		query = query.Where(
+			"(created_at < ? OR (created_at = ? AND order_id < ?))",
+			filter.AfterCreatedAt, filter.AfterCreatedAt, filter.AfterOrderID,
+		)
+	}
+
+	var orders []*model.Order
+	err := query.
+		Order("created_at DESC, order_id DESC").
+		Limit(filter.Limit).
+		Find(&orders).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (r *orderRepoImpl) GetStatusHistory(ctx context.Context, orderID string) ([]*model.OrderStatusHistory, error) {
+	var history []*model.OrderStatusHistory
+	err := r.db.WithContext(ctx).
+		Where("order_id = ?", orderID).
+		Order("id ASC").
+		Find(&history).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (r *orderRepoImpl) UpsertRefund(ctx context.Context, refund *model.OrderRefund) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "refund_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"status":     refund.Status,
+			"updated_at": time.Now(),
+		}),
+	}).Create(refund).Error
+}
+
+func (r *orderRepoImpl) GetRefunds(ctx context.Context, orderID string) ([]*model.OrderRefund, error) {
+	var refunds []*model.OrderRefund
+	err := r.db.WithContext(ctx).
+		Where("order_id = ?", orderID).
+		Order("created_at ASC").
+		Find(&refunds).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+func (r *orderRepoImpl) Search(ctx context.Context, search *OrderSearch, fn func(*model.Order) error) error {
+	query := r.db.WithContext(ctx).Model(&model.Order{})
+
+	if search.OrderID != "" {
+		query = query.Where("order_id = ?", search.OrderID)
+	}
+	if search.CaptureID != "" {
+		query = query.Where(
+			"order_id IN (?)",
+			r.db.Model(&model.OrderUnit{}).Select("order_id").Where("capture_id = ?", search.CaptureID),
+		)
+	}
+	if search.UserID != "" {
+		query = query.Where("user_id = ?", search.UserID)
+	}
+	if search.MerchantID != "" {
+		query = query.Where(
+			"(merchant_id = ? OR order_id IN (?))",
+			search.MerchantID,
+			r.db.Model(&model.OrderUnit{}).Select("order_id").Where("merchant_id = ?", search.MerchantID),
+		)
+	}
+	if search.Status != "" {
+		query = query.Where("status = ?", search.Status)
+	}
+	if search.MinAmount != nil {
+		query = query.Where("amount >= ?", *search.MinAmount)
+	}
+	if search.MaxAmount != nil {
+		query = query.Where("amount <= ?", *search.MaxAmount)
+	}
+	if !search.From.IsZero() {
+		query = query.Where("created_at >= ?", search.From)
+	}
+	if !search.To.IsZero() {
+		query = query.Where("created_at < ?", search.To)
+	}
+	if search.Limit > 0 {
+		query = query.Limit(search.Limit)
+	}
+
+	rows, err := query.Order("created_at DESC, order_id DESC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var order model.Order
+		if err := r.db.ScanRows(rows, &order); err != nil {
+			return err
+		}
+		if err := fn(&order); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *orderRepoImpl) GetUnitsByOrderIDs(ctx context.Context, orderIDs []string) ([]*model.OrderUnit, error) {
+	var units []*model.OrderUnit
+	err := r.db.WithContext(ctx).
+		Where("order_id IN ?", orderIDs).
+		Order("id ASC").
+		Find(&units).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+func (r *orderRepoImpl) CountPaidOrders(ctx context.Context, userID string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.Order{}).
+		Where("user_id = ?", userID).
+		Where("status IN ?", []string{"COMPLETED", "PARTIALLY_COMPLETED", "PAID", "PARTIALLY_PAID"}).
+		Count(&count).Error
+
+	return count, err
+}
+
+func (r *orderRepoImpl) FindUnitByCaptureID(ctx context.Context, tx *gorm.DB, captureID string) (*model.OrderUnit, error) {
+	var unit model.OrderUnit
+	err := tx.WithContext(ctx).
+		Where("capture_id = ?", captureID).
+		First(&unit).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &unit, nil
+}
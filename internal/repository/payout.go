@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"paypal-integration-demo/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInsufficientEarnings is returned by ApplyEarnings when an entry would take a balance below zero
+var ErrInsufficientEarnings = errors.New("insufficient earnings")
+
+type PayoutFilter struct {
+	UserID string
+	Status string
+	Limit  int
+}
+
+type PayoutRepository interface {
+	// ApplyEarnings appends entry to the earnings ledger and updates the balance.
+	// It returns false without changing anything when the idempotency key was seen before.
+	ApplyEarnings(ctx context.Context, tx *gorm.DB, entry *model.EarningsLedgerEntry) (bool, error)
+	GetEarnings(ctx context.Context, userID string) ([]*model.Earnings, error)
+	GetEarningsLedger(ctx context.Context, userID string, limit int) ([]*model.EarningsLedgerEntry, error)
+	FindEarningsEntry(ctx context.Context, idempotencyKey string) (*model.EarningsLedgerEntry, error)
+
+	// Create returns false when the payout id was already used
+	Create(ctx context.Context, tx *gorm.DB, payout *model.Payout) (bool, error)
+	Get(ctx context.Context, payoutID string) (*model.Payout, error)
+	// GetForUpdate locks the payout until tx ends
+	GetForUpdate(ctx context.Context, tx *gorm.DB, payoutID string) (*model.Payout, error)
+	Update(ctx context.Context, tx *gorm.DB, payout *model.Payout) error
+	Search(ctx context.Context, filter *PayoutFilter) ([]*model.Payout, error)
+	// FindUnsettled returns payouts waiting to be sent or sent but not settled
+	// by PayPal yet, that did not change since before
+	FindUnsettled(ctx context.Context, before time.Time, limit int) ([]*model.Payout, error)
+}
+
+type payoutRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewPayoutRepository(db *gorm.DB) PayoutRepository {
+	return &payoutRepoImpl{
+		db: db,
+	}
+}
+
+func (r *payoutRepoImpl) ApplyEarnings(ctx context.Context, tx *gorm.DB, entry *model.EarningsLedgerEntry) (bool, error) {
+	result := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(entry)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	if entry.Amount < 0 {
+		update := tx.WithContext(ctx).Model(&model.Earnings{}).
+			Where("user_id = ? AND currency = ? AND balance >= ?", entry.UserID, entry.Currency, -entry.Amount).
+			Updates(map[string]interface{}{
+				"balance":    gorm.Expr("balance + ?", entry.Amount),
+				"updated_at": time.Now(),
+			})
+		if update.Error != nil {
+			return false, update.Error
+		}
+		if update.RowsAffected == 0 {
+			return false, ErrInsufficientEarnings
+		}
+	} else {
+		err := tx.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "currency"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"balance":    gorm.Expr("earnings.balance + ?", entry.Amount),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&model.Earnings{
+			UserID:   entry.UserID,
+			Currency: entry.Currency,
+			Balance:  entry.Amount,
+		}).Error
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var earnings model.Earnings
+	err := tx.WithContext(ctx).
+		Where("user_id = ? AND currency = ?", entry.UserID, entry.Currency).
+		First(&earnings).Error
+	if err != nil {
+		return false, err
+	}
+
+	entry.BalanceAfter = earnings.Balance
+	err = tx.WithContext(ctx).Model(entry).
+		Update("balance_after", earnings.Balance).Error
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *payoutRepoImpl) GetEarnings(ctx context.Context, userID string) ([]*model.Earnings, error) {
+	var earnings []*model.Earnings
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("currency").
+		Find(&earnings).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return earnings, nil
+}
+
+func (r *payoutRepoImpl) GetEarningsLedger(ctx context.Context, userID string, limit int) ([]*model.EarningsLedgerEntry, error) {
+	var entries []*model.EarningsLedgerEntry
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&entries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (r *payoutRepoImpl) FindEarningsEntry(ctx context.Context, idempotencyKey string) (*model.EarningsLedgerEntry, error) {
+	var entry model.EarningsLedgerEntry
+	err := r.db.WithContext(ctx).
+		Where("idempotency_key = ?", idempotencyKey).
+		First(&entry).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (r *payoutRepoImpl) Create(ctx context.Context, tx *gorm.DB, payout *model.Payout) (bool, error) {
+	result := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(payout)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (r *payoutRepoImpl) Get(ctx context.Context, payoutID string) (*model.Payout, error) {
+	return r.get(r.db.WithContext(ctx), payoutID)
+}
+
+func (r *payoutRepoImpl) GetForUpdate(ctx context.Context, tx *gorm.DB, payoutID string) (*model.Payout, error) {
+	return r.get(tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}), payoutID)
+}
+
+func (r *payoutRepoImpl) get(q *gorm.DB, payoutID string) (*model.Payout, error) {
+	var payout model.Payout
+	err := q.Where("payout_id = ?", payoutID).
+		First(&payout).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &payout, nil
+}
+
+func (r *payoutRepoImpl) Update(ctx context.Context, tx *gorm.DB, payout *model.Payout) error {
+	return tx.WithContext(ctx).Save(payout).Error
+}
+
+func (r *payoutRepoImpl) Search(ctx context.Context, filter *PayoutFilter) ([]*model.Payout, error) {
+	q := r.db.WithContext(ctx)
+	if filter.UserID != "" {
+		q = q.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+
+	var payouts []*model.Payout
+	err := q.Order("created_at DESC").Limit(filter.Limit).Find(&payouts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return payouts, nil
+}
+
+func (r *payoutRepoImpl) FindUnsettled(ctx context.Context, before time.Time, limit int) ([]*model.Payout, error) {
+	var payouts []*model.Payout
+	err := r.db.WithContext(ctx).
+		Where("status IN ?", []string{
+			string(model.PAYOUT_APPROVED),
+			string(model.PAYOUT_SUBMITTED),
+			string(model.PAYOUT_PENDING),
+			string(model.PAYOUT_ONHOLD),
+			string(model.PAYOUT_UNCLAIMED),
+		}).
+		Where("updated_at < ?", before).
+		Order("updated_at").
+		Limit(limit).
+		Find(&payouts).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return payouts, nil
+}
@@ -13,6 +13,8 @@ type ProductRepository interface {
 	FindByID(ctx context.Context, productID string) (*model.Product, error)
 	FindMany(ctx context.Context, productIDs []string) ([]*model.Product, error)
 	GetByType(ctx context.Context, productType model.ProductType) ([]*model.Product, error)
+	Create(ctx context.Context, product *model.Product) error
+	GetByMerchant(ctx context.Context, merchantID string) ([]*model.Product, error)
 }
 
 type productRepoImpl struct {
@@ -29,6 +31,7 @@ func (r *productRepoImpl) Seed(ctx context.Context) error {
 	products := []model.Product{
 		{ID: "coin_100", Name: "100 Coins", Description: "100 Coins for buying stuff", Price: 100, Currency: "USD", Type: "ONE_TIME"},
 		{ID: "coin_200", Name: "200 Coins", Description: "200 Coins for buying stuff", Price: 200, Currency: "USD", Type: "ONE_TIME"},
+		{ID: "starter_pack", Name: "Starter Pack", Description: "500 Coins, the starter skin and 7 days of VIP", Price: 5, Currency: "USD", Type: "ONE_TIME"},
 		{ID: "vip_monthly", Name: "Vip product monthly", Description: "Susbcribe this to earn stuff every month", Price: 999, Currency: "USD", Type: "SUBSCRIPTION"},
 	}
 
@@ -75,3 +78,21 @@ func (r *productRepoImpl) GetByType(ctx context.Context, productType model.Produ
 
 	return products, nil
 }
+
+func (r *productRepoImpl) Create(ctx context.Context, product *model.Product) error {
+	return r.db.WithContext(ctx).Create(product).Error
+}
+
+func (r *productRepoImpl) GetByMerchant(ctx context.Context, merchantID string) ([]*model.Product, error) {
+	var products []*model.Product
+	err := r.db.WithContext(ctx).
+		Where("merchant_id = ?", merchantID).
+		Find(&products).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
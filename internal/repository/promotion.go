@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"paypal-integration-demo/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrPromotionExhausted is returned by Reserve when a global or per-user limit is reached
+var ErrPromotionExhausted = errors.New("promotion redemption limit reached")
+
+type PromotionRepository interface {
+	Create(ctx context.Context, promotion *model.Promotion) error
+	List(ctx context.Context) ([]*model.Promotion, error)
+	FindByCode(ctx context.Context, code string) (*model.Promotion, error)
+	// FindAutomatic returns the active automatic promotions valid at now
+	FindAutomatic(ctx context.Context, now time.Time) ([]*model.Promotion, error)
+	SetActive(ctx context.Context, promotionID string, active bool) error
+	CountUserRedemptions(ctx context.Context, promotionID string, userID string) (int64, error)
+
+	// Reserve counts the redemption against the promotion's limits and stores it
+	Reserve(ctx context.Context, tx *gorm.DB, promotion *model.Promotion, redemption *model.PromotionRedemption) error
+	AttachReservation(ctx context.Context, tx *gorm.DB, reservationID string, orderID string) error
+	ReleaseReservation(ctx context.Context, reservationID string) error
+	// ReleaseOrderRedemptions gives back the redemptions applied to an order that will not be paid
+	ReleaseOrderRedemptions(ctx context.Context, tx *gorm.DB, orderID string) error
+	// FindAbandonedOrders returns orders with applied redemptions still waiting
+	// for approval although they were created before createdBefore
+	FindAbandonedOrders(ctx context.Context, createdBefore time.Time, limit int) ([]string, error)
+	// ReleaseAbandonedOrder releases the order's redemptions if it is still waiting for approval
+	ReleaseAbandonedOrder(ctx context.Context, orderID string, createdBefore time.Time) error
+}
+
+type promotionRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewPromotionRepository(db *gorm.DB) PromotionRepository {
+	return &promotionRepoImpl{
+		db: db,
+	}
+}
+
+func (r *promotionRepoImpl) Create(ctx context.Context, promotion *model.Promotion) error {
+	return r.db.WithContext(ctx).Create(promotion).Error
+}
+
+func (r *promotionRepoImpl) List(ctx context.Context) ([]*model.Promotion, error) {
+	var promotions []*model.Promotion
+	err := r.db.WithContext(ctx).
+		Order("created_at DESC").
+		Find(&promotions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+func (r *promotionRepoImpl) FindByCode(ctx context.Context, code string) (*model.Promotion, error) {
+	var promotion model.Promotion
+	err := r.db.WithContext(ctx).
+		Where("code = ? AND active = ?", code, true).
+		First(&promotion).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &promotion, nil
+}
+
+func (r *promotionRepoImpl) FindAutomatic(ctx context.Context, now time.Time) ([]*model.Promotion, error) {
+	var promotions []*model.Promotion
+	err := r.db.WithContext(ctx).
+		Where("code = '' AND active = ?", true).
+		Where("(starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", now, now).
+		Order("created_at ASC").
+		Find(&promotions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
+
+func (r *promotionRepoImpl) SetActive(ctx context.Context, promotionID string, active bool) error {
+	result := r.db.WithContext(ctx).Model(&model.Promotion{}).
+		Where("id = ?", promotionID).
+		Updates(map[string]interface{}{
+			"active":     active,
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *promotionRepoImpl) CountUserRedemptions(ctx context.Context, promotionID string, userID string) (int64, error) {
+	return r.countUserRedemptions(ctx, r.db, promotionID, userID)
+}
+
+func (r *promotionRepoImpl) countUserRedemptions(ctx context.Context, tx *gorm.DB, promotionID string, userID string) (int64, error) {
+	var count int64
+	err := tx.WithContext(ctx).Model(&model.PromotionRedemption{}).
+		Where("promotion_id = ? AND user_id = ? AND status <> ?", promotionID, userID, "RELEASED").
+		Count(&count).Error
+
+	return count, err
+}
+
+func (r *promotionRepoImpl) Reserve(ctx context.Context, tx *gorm.DB, promotion *model.Promotion, redemption *model.PromotionRedemption) error {
+	// the update locks the promotion row until tx ends, so the per-user count
+	// below cannot race with another checkout of the same promotion
+	result := tx.WithContext(ctx).Model(&model.Promotion{}).
+		Where("id = ? AND active = ?", promotion.ID, true).
+		Where("(max_redemptions = 0 OR redemptions < max_redemptions)").
+		Updates(map[string]interface{}{
+			"redemptions": gorm.Expr("redemptions + 1"),
+			"updated_at":  time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPromotionExhausted
+	}
+
+	if promotion.MaxPerUser > 0 {
+		count, err := r.countUserRedemptions(ctx, tx, promotion.ID, redemption.UserID)
+		if err != nil {
+			return err
+		}
+		if count >= int64(promotion.MaxPerUser) {
+			return ErrPromotionExhausted
+		}
+	}
+
+	redemption.Status = "RESERVED"
+	return tx.WithContext(ctx).Create(redemption).Error
+}
+
+func (r *promotionRepoImpl) AttachReservation(ctx context.Context, tx *gorm.DB, reservationID string, orderID string) error {
+	return tx.WithContext(ctx).Model(&model.PromotionRedemption{}).
+		Where("reservation_id = ? AND status = ?", reservationID, "RESERVED").
+		Updates(map[string]interface{}{
+			"order_id":   orderID,
+			"status":     "APPLIED",
+			"updated_at": time.Now(),
+		}).Error
+}
+
+func (r *promotionRepoImpl) ReleaseReservation(ctx context.Context, reservationID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var redemptions []*model.PromotionRedemption
+		err := tx.Where("reservation_id = ? AND status = ?", reservationID, "RESERVED").
+			Find(&redemptions).Error
+		if err != nil {
+			return err
+		}
+
+		return r.releaseRedemptions(tx, redemptions)
+	})
+}
+
+func (r *promotionRepoImpl) ReleaseOrderRedemptions(ctx context.Context, tx *gorm.DB, orderID string) error {
+	var redemptions []*model.PromotionRedemption
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", orderID, "APPLIED").
+		Find(&redemptions).Error
+	if err != nil {
+		return err
+	}
+
+	return r.releaseRedemptions(tx.WithContext(ctx), redemptions)
+}
+
+func (r *promotionRepoImpl) FindAbandonedOrders(ctx context.Context, createdBefore time.Time, limit int) ([]string, error) {
+	var orderIDs []string
+	err := r.db.WithContext(ctx).Model(&model.PromotionRedemption{}).
+		Distinct("promotion_redemptions.order_id").
+		Joins("JOIN orders ON orders.order_id = promotion_redemptions.order_id").
+		Where("promotion_redemptions.status = ?", "APPLIED").
+		Where("orders.status IN ? AND orders.created_at < ?", []string{"CREATED", "APPROVED"}, createdBefore).
+		Limit(limit).
+		Pluck("promotion_redemptions.order_id", &orderIDs).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return orderIDs, nil
+}
+
+func (r *promotionRepoImpl) ReleaseAbandonedOrder(ctx context.Context, orderID string, createdBefore time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the order row lock keeps a capture from paying the order while its
+		// redemptions are given back
+		var order model.Order
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ? AND status IN ? AND created_at < ?", orderID, []string{"CREATED", "APPROVED"}, createdBefore).
+			First(&order).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return r.ReleaseOrderRedemptions(ctx, tx, orderID)
+	})
+}
+
+func (r *promotionRepoImpl) releaseRedemptions(tx *gorm.DB, redemptions []*model.PromotionRedemption) error {
+	for _, redemption := range redemptions {
+		err := tx.Model(&model.Promotion{}).
+			Where("id = ? AND redemptions > 0", redemption.PromotionID).
+			Update("redemptions", gorm.Expr("redemptions - 1")).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(redemption).
+			Updates(map[string]interface{}{
+				"status":     "RELEASED",
+				"updated_at": time.Now(),
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"paypal-integration-demo/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SpendQuery sums what a user spent since Since, only with MerchantID when set.
+// Orders still waiting for approval only count when created after PendingSince.
+type SpendQuery struct {
+	UserID       string
+	MerchantID   string
+	Since        time.Time
+	PendingSince time.Time
+}
+
+type PurchaseLimitRepository interface {
+	GetOverride(ctx context.Context, userID string) (*model.UserPurchaseLimit, error)
+	// SaveOverride replaces the user's override and records the audit entry with it
+	SaveOverride(ctx context.Context, override *model.UserPurchaseLimit, audit *model.PurchaseLimitAudit) error
+	GetAudit(ctx context.Context, userID string) ([]*model.PurchaseLimitAudit, error)
+
+	// LockUser locks the user's limit row until tx ends, creating it when missing
+	LockUser(ctx context.Context, tx *gorm.DB, userID string) (*model.UserPurchaseLimit, error)
+	CountPayAttempts(ctx context.Context, tx *gorm.DB, userID string, since time.Time) (int64, error)
+	AddPayAttempt(ctx context.Context, tx *gorm.DB, attempt *model.PayAttempt) error
+	// SumSpend adds up the stored orders and the unexpired holds, in minor units
+	SumSpend(ctx context.Context, tx *gorm.DB, query *SpendQuery) (int64, error)
+	CreateHolds(ctx context.Context, tx *gorm.DB, holds []*model.SpendHold) error
+	DeleteHolds(ctx context.Context, tx *gorm.DB, holdID string) error
+}
+
+type purchaseLimitRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewPurchaseLimitRepository(db *gorm.DB) PurchaseLimitRepository {
+	return &purchaseLimitRepoImpl{
+		db: db,
+	}
+}
+
+func (r *purchaseLimitRepoImpl) GetOverride(ctx context.Context, userID string) (*model.UserPurchaseLimit, error) {
+	var override model.UserPurchaseLimit
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		First(&override).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &override, nil
+}
+
+func (r *purchaseLimitRepoImpl) SaveOverride(ctx context.Context, override *model.UserPurchaseLimit, audit *model.PurchaseLimitAudit) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(override).Error; err != nil {
+			return err
+		}
+		return tx.Create(audit).Error
+	})
+}
+
+func (r *purchaseLimitRepoImpl) GetAudit(ctx context.Context, userID string) ([]*model.PurchaseLimitAudit, error) {
+	var audit []*model.PurchaseLimitAudit
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Find(&audit).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return audit, nil
+}
+
+func (r *purchaseLimitRepoImpl) LockUser(ctx context.Context, tx *gorm.DB, userID string) (*model.UserPurchaseLimit, error) {
+	err := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.UserPurchaseLimit{UserID: userID}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var override model.UserPurchaseLimit
+	err = tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).
+		First(&override).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &override, nil
+}
+
+func (r *purchaseLimitRepoImpl) CountPayAttempts(ctx context.Context, tx *gorm.DB, userID string, since time.Time) (int64, error) {
+	var count int64
+	err := tx.WithContext(ctx).Model(&model.PayAttempt{}).
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Count(&count).Error
+
+	return count, err
+}
+
+func (r *purchaseLimitRepoImpl) AddPayAttempt(ctx context.Context, tx *gorm.DB, attempt *model.PayAttempt) error {
+	return tx.WithContext(ctx).Create(attempt).Error
+}
+
+func (r *purchaseLimitRepoImpl) SumSpend(ctx context.Context, tx *gorm.DB, query *SpendQuery) (int64, error) {
+	// what the buyer pays: after discounts, exclusive tax on top
+	orders := tx.WithContext(ctx).Model(&model.OrderUnit{}).
+		Select("COALESCE(SUM(order_units.amount * 100 - order_units.discount + CASE WHEN orders.tax_inclusive THEN 0 ELSE order_units.tax END), 0)").
+		Joins("JOIN orders ON orders.order_id = order_units.order_id").
+		Where("orders.user_id = ? AND orders.created_at >= ?", query.UserID, query.Since).
+		Where("orders.status NOT IN ? AND order_units.status <> ?", []string{"FAILED", "CANCELLED"}, "FAILED").
+		Where("(orders.status NOT IN ? OR orders.created_at >= ?)", []string{"CREATED", "APPROVED"}, query.PendingSince)
+	holds := tx.WithContext(ctx).Model(&model.SpendHold{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("user_id = ? AND expires_at > ?", query.UserID, time.Now())
+	if query.MerchantID != "" {
+		orders = orders.Where("order_units.merchant_id = ?", query.MerchantID)
+		holds = holds.Where("merchant_id = ?", query.MerchantID)
+	}
+
+	var ordered, held int64
+	if err := orders.Scan(&ordered).Error; err != nil {
+		return 0, err
+	}
+	if err := holds.Scan(&held).Error; err != nil {
+		return 0, err
+	}
+
+	return ordered + held, nil
+}
+
+func (r *purchaseLimitRepoImpl) CreateHolds(ctx context.Context, tx *gorm.DB, holds []*model.SpendHold) error {
+	return tx.WithContext(ctx).Create(&holds).Error
+}
+
+func (r *purchaseLimitRepoImpl) DeleteHolds(ctx context.Context, tx *gorm.DB, holdID string) error {
+	return tx.WithContext(ctx).
+		Where("hold_id = ?", holdID).
+		Delete(&model.SpendHold{}).Error
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"paypal-integration-demo/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RiskDecisionFilter struct {
+	UserID   string
+	Decision string
+	OrderID  string
+	Limit    int
+}
+
+type RiskRepository interface {
+	// CreateDecision stores the decision together with its rule hits
+	CreateDecision(ctx context.Context, decision *model.RiskDecision) error
+	SetOutcome(ctx context.Context, decisionID uint, orderID string, outcome string) error
+	// CountDistinctPayers counts the PayPal accounts and cards the user saved since
+	CountDistinctPayers(ctx context.Context, userID string, since time.Time) (int64, error)
+	// CountDeclines counts the user's saved payment method charges declined since
+	CountDeclines(ctx context.Context, userID string, since time.Time) (int64, error)
+	Search(ctx context.Context, filter *RiskDecisionFilter) ([]*model.RiskDecision, error)
+}
+
+type riskRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewRiskRepository(db *gorm.DB) RiskRepository {
+	return &riskRepoImpl{
+		db: db,
+	}
+}
+
+func (r *riskRepoImpl) CreateDecision(ctx context.Context, decision *model.RiskDecision) error {
+	return r.db.WithContext(ctx).Create(decision).Error
+}
+
+func (r *riskRepoImpl) SetOutcome(ctx context.Context, decisionID uint, orderID string, outcome string) error {
+	return r.db.WithContext(ctx).Model(&model.RiskDecision{}).
+		Where("id = ?", decisionID).
+		Updates(map[string]interface{}{
+			"order_id": orderID,
+			"outcome":  outcome,
+		}).Error
+}
+
+func (r *riskRepoImpl) CountDistinctPayers(ctx context.Context, userID string, since time.Time) (int64, error) {
+	// a card is told apart by brand and last digits, a PayPal account by its email
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.UserVault{}).
+		Select("COUNT(DISTINCT CASE WHEN provider = ? THEN CONCAT(card_brand, ':', card_last_digits) WHEN payer_email <> '' THEN payer_email ELSE vault_id END)", string(model.VAULT_CARD)).
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Scan(&count).Error
+
+	return count, err
+}
+
+func (r *riskRepoImpl) CountDeclines(ctx context.Context, userID string, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.RiskDecision{}).
+		Where("user_id = ? AND created_at >= ? AND outcome IN ?", userID, since, []string{"DECLINED", "FAILED"}).
+		Count(&count).Error
+
+	return count, err
+}
+
+func (r *riskRepoImpl) Search(ctx context.Context, filter *RiskDecisionFilter) ([]*model.RiskDecision, error) {
+	q := r.db.WithContext(ctx).Preload("Hits")
+	if filter.UserID != "" {
+		q = q.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Decision != "" {
+		q = q.Where("decision = ?", filter.Decision)
+	}
+	if filter.OrderID != "" {
+		q = q.Where("order_id = ?", filter.OrderID)
+	}
+
+	var decisions []*model.RiskDecision
+	err := q.Order("id DESC").Limit(filter.Limit).Find(&decisions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return decisions, nil
+}
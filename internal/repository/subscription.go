@@ -9,6 +9,19 @@ import (
 	"gorm.io/gorm"
 )
 
+// SubscriptionSearch is the support staff view over every user's subscriptions.
+// Zero values do not filter.
+type SubscriptionSearch struct {
+	SubscriptionID string
+	UserID         string
+	MerchantID     string
+	ProductID      string
+	Status         string
+	From           time.Time
+	To             time.Time
+	Limit          int // 0 means no limit
+}
+
 type SubscriptionRepository interface {
 	GetSubPlanByProductID(ctx context.Context, merchantID string, productID string) (*model.SubscriptionPlan, error)
 	StoreSubPlan(ctx context.Context, plan *model.SubscriptionPlan) error
@@ -18,6 +31,7 @@ type SubscriptionRepository interface {
 	CancelSubscription(ctx context.Context, subscriptionID string) error
 	GetBySubscriptionID(ctx context.Context, subscriptionID string) (*model.UserSubscription, error)
 	GetActiveByUser(ctx context.Context, userID string, merchantID string) (*model.UserSubscription, error)
+	Search(ctx context.Context, search *SubscriptionSearch, fn func(*model.UserSubscription) error) error
 }
 
 type subscriptionRepoImpl struct {
@@ -100,3 +114,50 @@ func (r *subscriptionRepoImpl) GetActiveByUser(ctx context.Context, userID strin
 
 	return &sub, err
 }
+
+func (r *subscriptionRepoImpl) Search(ctx context.Context, search *SubscriptionSearch, fn func(*model.UserSubscription) error) error {
+	query := r.db.WithContext(ctx).Model(&model.UserSubscription{})
+
+	if search.SubscriptionID != "" {
+		query = query.Where("pay_pal_subscription_id = ?", search.SubscriptionID)
+	}
+	if search.UserID != "" {
+		query = query.Where("user_id = ?", search.UserID)
+	}
+	if search.MerchantID != "" {
+		query = query.Where("merchant_id = ?", search.MerchantID)
+	}
+	if search.ProductID != "" {
+		query = query.Where("product_id = ?", search.ProductID)
+	}
+	if search.Status != "" {
+		query = query.Where("status = ?", search.Status)
+	}
+	if !search.From.IsZero() {
+		query = query.Where("created_at >= ?", search.From)
+	}
+	if !search.To.IsZero() {
+		query = query.Where("created_at < ?", search.To)
+	}
+	if search.Limit > 0 {
+		query = query.Limit(search.Limit)
+	}
+
+	rows, err := query.Order("created_at DESC, id DESC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sub model.UserSubscription
+		if err := r.db.ScanRows(rows, &sub); err != nil {
+			return err
+		}
+		if err := fn(&sub); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -13,7 +13,15 @@ import (
 
 type VaultRepository interface {
 	Create(ctx context.Context, vault *model.UserVault) error
-	GetVaultID(ctx context.Context, userID string) (string, error)
+	List(ctx context.Context, userID string) ([]*model.UserVault, error)
+	Get(ctx context.Context, userID string, vaultID string) (*model.UserVault, error)
+	GetDefault(ctx context.Context, userID string) (*model.UserVault, error)
+	SetDefault(ctx context.Context, userID string, vaultID string) error
+	Delete(ctx context.Context, userID string, vaultID string) error
+
+	CreateSetup(ctx context.Context, setup *model.VaultSetup) error
+	GetSetup(ctx context.Context, setupTokenID string) (*model.VaultSetup, error)
+	CompleteSetup(ctx context.Context, setupTokenID string, vaultID string) error
 }
 
 type vaultRepoImpl struct {
@@ -26,29 +34,164 @@ func NewVaultRepository(db *gorm.DB) VaultRepository {
 	}
 }
 
+// Create stores the vault, making it the default when the user has none yet
 func (r *vaultRepoImpl) Create(ctx context.Context, vault *model.UserVault) error {
-	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
-		Columns: []clause.Column{{Name: "user_id"}, {Name: "vault_id"}},
-		DoUpdates: clause.Assignments(map[string]interface{}{
-			"updated_at": time.Now(),
-		}),
-	}).Create(vault).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "vault_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"payer_email":      vault.PayerEmail,
+				"card_brand":       vault.CardBrand,
+				"card_last_digits": vault.CardLastDigits,
+				"updated_at":       time.Now(),
+			}),
+		}).Create(vault).Error
+		if err != nil {
+			return err
+		}
+
+		var defaults int64
+		err = tx.Model(&model.UserVault{}).
+			Where("user_id = ? AND is_default = ?", vault.UserID, true).
+			Count(&defaults).Error
+		if err != nil || defaults > 0 {
+			return err
+		}
+
+		return tx.Model(&model.UserVault{}).
+			Where("user_id = ? AND vault_id = ?", vault.UserID, vault.VaultID).
+			Update("is_default", true).Error
+	})
+}
+
+func (r *vaultRepoImpl) List(ctx context.Context, userID string) ([]*model.UserVault, error) {
+	var vaults []*model.UserVault
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("is_default DESC, created_at DESC").
+		Find(&vaults).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return vaults, nil
 }
 
-func (r *vaultRepoImpl) GetVaultID(ctx context.Context, userID string) (string, error) {
-	var vaultID string
+func (r *vaultRepoImpl) Get(ctx context.Context, userID string, vaultID string) (*model.UserVault, error) {
+	var vault model.UserVault
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND vault_id = ?", userID, vaultID).
+		First(&vault).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &vault, nil
+}
+
+// GetDefault returns the user's default vault, falling back to the most recent one
+func (r *vaultRepoImpl) GetDefault(ctx context.Context, userID string) (*model.UserVault, error) {
+	var vault model.UserVault
 	err := r.db.WithContext(ctx).
-		Model(&model.UserVault{}).
 		Where("user_id = ?", userID).
-		Pluck("vault_id", &vaultID).
-		Error
+		Order("is_default DESC, created_at DESC").
+		First(&vault).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", fmt.Errorf("no vault id found for user %s", userID)
+			return nil, fmt.Errorf("no vault id found for user %s: %w", userID, err)
+		}
+		return nil, err
+	}
+
+	return &vault, nil
+}
+
+func (r *vaultRepoImpl) SetDefault(ctx context.Context, userID string, vaultID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.UserVault{}).
+			Where("user_id = ? AND vault_id <> ?", userID, vaultID).
+			Update("is_default", false).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(&model.UserVault{}).
+			Where("user_id = ? AND vault_id = ?", userID, vaultID).
+			Updates(map[string]interface{}{
+				"is_default": true,
+				"updated_at": time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return nil
+	})
+}
+
+// Delete removes the vault and promotes the most recent remaining one if it was the default
+func (r *vaultRepoImpl) Delete(ctx context.Context, userID string, vaultID string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var vault model.UserVault
+		err := tx.Where("user_id = ? AND vault_id = ?", userID, vaultID).
+			First(&vault).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&vault).Error; err != nil {
+			return err
+		}
+
+		if !vault.IsDefault {
+			return nil
+		}
+
+		var next model.UserVault
+		err = tx.Where("user_id = ?", userID).
+			Order("created_at DESC").
+			First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
-		return "", err
+
+		return tx.Model(&next).Update("is_default", true).Error
+	})
+}
+
+func (r *vaultRepoImpl) CreateSetup(ctx context.Context, setup *model.VaultSetup) error {
+	return r.db.WithContext(ctx).Create(setup).Error
+}
+
+func (r *vaultRepoImpl) GetSetup(ctx context.Context, setupTokenID string) (*model.VaultSetup, error) {
+	var setup model.VaultSetup
+	err := r.db.WithContext(ctx).
+		Where("setup_token_id = ?", setupTokenID).
+		First(&setup).Error
+
+	if err != nil {
+		return nil, err
 	}
 
-	return vaultID, nil
+	return &setup, nil
+}
+
+func (r *vaultRepoImpl) CompleteSetup(ctx context.Context, setupTokenID string, vaultID string) error {
+	return r.db.WithContext(ctx).
+		Model(&model.VaultSetup{}).
+		Where("setup_token_id = ?", setupTokenID).
+		Updates(map[string]interface{}{
+			"status":     "VAULTED",
+			"vault_id":   vaultID,
+			"updated_at": time.Now(),
+		}).Error
 }
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"paypal-integration-demo/internal/model"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInsufficientBalance is returned by Debit when the wallet cannot cover the amount
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+type WalletRepository interface {
+	// CreateOperation returns false when the operation id was already used
+	CreateOperation(ctx context.Context, tx *gorm.DB, op *model.WalletOperation) (bool, error)
+	FindOperation(ctx context.Context, tx *gorm.DB, operationID string) (*model.WalletOperation, error)
+
+	Credit(ctx context.Context, tx *gorm.DB, userID string, amount int64) (int64, error)
+	// Debit takes amount out of the wallet as long as at least keep remains
+	Debit(ctx context.Context, tx *gorm.DB, userID string, amount int64, keep int64) (int64, error)
+	AddLedgerEntry(ctx context.Context, tx *gorm.DB, entry *model.WalletLedgerEntry) error
+
+	GetBalance(ctx context.Context, userID string) (int64, error)
+	// GetBalanceForUpdate locks the wallet row until tx ends, 0 when there is none
+	GetBalanceForUpdate(ctx context.Context, tx *gorm.DB, userID string) (int64, error)
+	GetLedger(ctx context.Context, userID string, limit int) ([]*model.WalletLedgerEntry, error)
+	GetOperationEntries(ctx context.Context, operationID string) ([]*model.WalletLedgerEntry, error)
+	// GetEntriesByOperationPrefix returns the entries of every operation whose id starts with prefix
+	GetEntriesByOperationPrefix(ctx context.Context, prefix string) ([]*model.WalletLedgerEntry, error)
+	SumLedger(ctx context.Context, userID string) (int64, int64, error)
+	// FrozenBalance is the currency granted by purchases under an open dispute
+	FrozenBalance(ctx context.Context, tx *gorm.DB, userID string) (int64, error)
+}
+
+type walletRepoImpl struct {
+	db *gorm.DB
+}
+
+func NewWalletRepository(db *gorm.DB) WalletRepository {
+	return &walletRepoImpl{
+		db: db,
+	}
+}
+
+func (r *walletRepoImpl) CreateOperation(ctx context.Context, tx *gorm.DB, op *model.WalletOperation) (bool, error) {
+	// a concurrent request with the same id waits on the primary key until this one commits
+	result := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(op)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (r *walletRepoImpl) FindOperation(ctx context.Context, tx *gorm.DB, operationID string) (*model.WalletOperation, error) {
+	var op model.WalletOperation
+	err := tx.WithContext(ctx).
+		Where("operation_id = ?", operationID).
+		First(&op).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &op, nil
+}
+
+func (r *walletRepoImpl) Credit(ctx context.Context, tx *gorm.DB, userID string, amount int64) (int64, error) {
+	err := tx.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"balance":    gorm.Expr("wallets.balance + ?", amount),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&model.Wallet{
+		UserID:  userID,
+		Balance: amount,
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return r.balance(ctx, tx, userID)
+}
+
+func (r *walletRepoImpl) Debit(ctx context.Context, tx *gorm.DB, userID string, amount int64, keep int64) (int64, error) {
+	// the balance check and the update are one statement so concurrent spends cannot overdraw
+	result := tx.WithContext(ctx).Model(&model.Wallet{}).
+		Where("user_id = ? AND balance >= ?", userID, amount+keep).
+		Updates(map[string]interface{}{
+			"balance":    gorm.Expr("balance - ?", amount),
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, ErrInsufficientBalance
+	}
+
+	return r.balance(ctx, tx, userID)
+}
+
+func (r *walletRepoImpl) balance(ctx context.Context, tx *gorm.DB, userID string) (int64, error) {
+	var wallet model.Wallet
+	err := tx.WithContext(ctx).
+		Where("user_id = ?", userID).
+		First(&wallet).Error
+
+	return wallet.Balance, err
+}
+
+func (r *walletRepoImpl) AddLedgerEntry(ctx context.Context, tx *gorm.DB, entry *model.WalletLedgerEntry) error {
+	return tx.WithContext(ctx).Create(entry).Error
+}
+
+func (r *walletRepoImpl) GetBalance(ctx context.Context, userID string) (int64, error) {
+	balance, err := r.balance(ctx, r.db, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+
+	return balance, err
+}
+
+func (r *walletRepoImpl) GetBalanceForUpdate(ctx context.Context, tx *gorm.DB, userID string) (int64, error) {
+	balance, err := r.balance(ctx, tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+
+	return balance, err
+}
+
+func (r *walletRepoImpl) GetLedger(ctx context.Context, userID string, limit int) ([]*model.WalletLedgerEntry, error) {
+	var entries []*model.WalletLedgerEntry
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&entries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (r *walletRepoImpl) GetOperationEntries(ctx context.Context, operationID string) ([]*model.WalletLedgerEntry, error) {
+	var entries []*model.WalletLedgerEntry
+	err := r.db.WithContext(ctx).
+		Where("operation_id = ?", operationID).
+		Order("id ASC").
+		Find(&entries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// SumLedger returns the sum of a user's ledger amounts and the number of entries
+func (r *walletRepoImpl) SumLedger(ctx context.Context, userID string) (int64, int64, error) {
+	var result struct {
+		Total   int64
+		Entries int64
+	}
+	err := r.db.WithContext(ctx).Model(&model.WalletLedgerEntry{}).
+		Select("COALESCE(SUM(amount), 0) AS total, COUNT(*) AS entries").
+		Where("user_id = ?", userID).
+		Scan(&result).Error
+
+	return result.Total, result.Entries, err
+}
+
+func (r *walletRepoImpl) FrozenBalance(ctx context.Context, tx *gorm.DB, userID string) (int64, error) {
+	var frozen int64
+	err := tx.WithContext(ctx).Model(&model.ItemFreeze{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("user_id = ? AND type = ? AND released_at IS NULL", userID, string(model.ENTITLEMENT_CURRENCY)).
+		Scan(&frozen).Error
+
+	return frozen, err
+}
+
+func (r *walletRepoImpl) GetEntriesByOperationPrefix(ctx context.Context, prefix string) ([]*model.WalletLedgerEntry, error) {
+	var entries []*model.WalletLedgerEntry
+	err := r.db.WithContext(ctx).
+		Where("operation_id LIKE ?", strings.NewReplacer("%", `\%`, "_", `\_`).Replace(prefix)+"%").
+		Order("id").
+		Find(&entries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -6,11 +6,25 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+type WebhookEventFilter struct {
+	EventID    string
+	EventType  string
+	ResourceID string
+	OrderID    string
+	Status     string
+	From       time.Time
+	To         time.Time
+	Limit      int // 0 means no limit
+}
+
 type WebhookEventRepository interface {
 	Exists(ctx context.Context, payPalEventID string) (bool, error)
-	MarkProcessed(ctx context.Context, eventID, eventType string) error
+	// Record stores the outcome of handling an event, a retry overwrites the previous attempt
+	Record(ctx context.Context, event *model.WebhookEvent) error
+	Search(ctx context.Context, filter *WebhookEventFilter, fn func(*model.WebhookEvent) error) error
 }
 
 type webhookEventRepositoryIml struct {
@@ -21,19 +35,68 @@ func NewWebhookEventRepository(db *gorm.DB) WebhookEventRepository {
 	return &webhookEventRepositoryIml{db: db}
 }
 
+// Exists reports whether the event was already processed successfully
 func (r *webhookEventRepositoryIml) Exists(ctx context.Context, payPalEventID string) (bool, error) {
 	var count int64
 	err := r.db.WithContext(ctx).Model(&model.WebhookEvent{}).
-		Where("event_id = ?", payPalEventID).
+		Where("event_id = ? AND processed_at IS NOT NULL", payPalEventID).
 		Count(&count).Error
 
 	return count > 0, err
 }
 
-func (r *webhookEventRepositoryIml) MarkProcessed(ctx context.Context, eventID string, eventType string) error {
-	return r.db.WithContext(ctx).Create(&model.WebhookEvent{
-		EventID:     eventID,
-		EventType:   eventType,
-		ProcessedAt: time.Now(),
-	}).Error
+func (r *webhookEventRepositoryIml) Record(ctx context.Context, event *model.WebhookEvent) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "event_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"status", "last_error", "processed_at", "updated_at"}),
+		}).
+		Create(event).Error
+}
+
+func (r *webhookEventRepositoryIml) Search(ctx context.Context, filter *WebhookEventFilter, fn func(*model.WebhookEvent) error) error {
+	query := r.db.WithContext(ctx).Model(&model.WebhookEvent{})
+
+	if filter.EventID != "" {
+		query = query.Where("event_id = ?", filter.EventID)
+	}
+	if filter.EventType != "" {
+		query = query.Where("event_type = ?", filter.EventType)
+	}
+	if filter.ResourceID != "" {
+		query = query.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.OrderID != "" {
+		query = query.Where("order_id = ?", filter.OrderID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	rows, err := query.Order("created_at DESC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event model.WebhookEvent
+		if err := r.db.ScanRows(rows, &event); err != nil {
+			return err
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
 }
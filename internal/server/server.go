@@ -1,21 +1,37 @@
 package server
 
 import (
+	"crypto/subtle"
+	"net/url"
+	"paypal-integration-demo/internal/config"
 	"paypal-integration-demo/internal/handler"
 	"paypal-integration-demo/internal/service"
+	"paypal-integration-demo/internal/view"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
 type Server struct {
-	echo            *echo.Echo
-	paypalHandler   *handler.PaypalHandler
-	userHandler     *handler.UserHandler
-	merchantHandler *handler.MerchantHandler
+	echo                 *echo.Echo
+	paypalCfg            *config.Paypal
+	adminToken           string
+	gameServerKey        string
+	paypalHandler        *handler.PaypalHandler
+	userHandler          *handler.UserHandler
+	merchantHandler      *handler.MerchantHandler
+	orderHandler         *handler.OrderHandler
+	adminHandler         *handler.AdminHandler
+	walletHandler        *handler.WalletHandler
+	entitlementHandler   *handler.EntitlementHandler
+	promotionHandler     *handler.PromotionHandler
+	purchaseLimitHandler *handler.PurchaseLimitHandler
+	disputeHandler       *handler.DisputeHandler
+	payoutHandler        *handler.PayoutHandler
+	invoiceHandler       *handler.InvoiceHandler
 }
 
-func NewServer(paypalService service.PaypalService, userService service.UserService, merchantService service.MerchantService) *Server {
+func NewServer(paypalCfg *config.Paypal, adminToken string, gameServerKey string, views *view.Renderer, paypalService service.PaypalService, userService service.UserService, merchantService service.MerchantService, orderService service.OrderService, adminService service.AdminService, walletService service.WalletService, entitlementService service.EntitlementService, promotionService service.PromotionService, purchaseLimitService service.PurchaseLimitService, disputeService service.DisputeService, payoutService service.PayoutService, invoiceService service.InvoiceService) *Server {
 	e := echo.New()
 
 	e.File("/", "../../web/index.html")
@@ -24,15 +40,36 @@ func NewServer(paypalService service.PaypalService, userService service.UserServ
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
-	paypalHandler := handler.NewPaypalHandler(paypalService, merchantService)
+	paypalHandler := handler.NewPaypalHandler(paypalService, merchantService, views)
 	userHandler := handler.NewUserHandler(userService)
 	merchantHandler := handler.NewMerchantHandler(merchantService)
+	orderHandler := handler.NewOrderHandler(orderService)
+	adminHandler := handler.NewAdminHandler(adminService)
+	walletHandler := handler.NewWalletHandler(walletService)
+	entitlementHandler := handler.NewEntitlementHandler(entitlementService)
+	promotionHandler := handler.NewPromotionHandler(promotionService)
+	purchaseLimitHandler := handler.NewPurchaseLimitHandler(purchaseLimitService)
+	disputeHandler := handler.NewDisputeHandler(disputeService)
+	payoutHandler := handler.NewPayoutHandler(payoutService)
+	invoiceHandler := handler.NewInvoiceHandler(invoiceService)
 
 	s := &Server{
-		echo:            e,
-		paypalHandler:   paypalHandler,
-		userHandler:     userHandler,
-		merchantHandler: merchantHandler,
+		echo:                 e,
+		paypalCfg:            paypalCfg,
+		adminToken:           adminToken,
+		gameServerKey:        gameServerKey,
+		paypalHandler:        paypalHandler,
+		userHandler:          userHandler,
+		merchantHandler:      merchantHandler,
+		orderHandler:         orderHandler,
+		adminHandler:         adminHandler,
+		walletHandler:        walletHandler,
+		entitlementHandler:   entitlementHandler,
+		promotionHandler:     promotionHandler,
+		purchaseLimitHandler: purchaseLimitHandler,
+		disputeHandler:       disputeHandler,
+		payoutHandler:        payoutHandler,
+		invoiceHandler:       invoiceHandler,
 	}
 
 	s.setupRoutes()
@@ -43,32 +80,148 @@ func (s *Server) setupRoutes() {
 	api := s.echo.Group("/api")
 
 	api.GET("/health", func(c echo.Context) error {
-		return c.JSON(200, map[string]string{"status": "ok"})
+		return c.JSON(200, map[string]string{"status": "ok", "paypal_mode": s.paypalCfg.Mode})
 	})
 
 	api.GET("/inventories", s.userHandler.GetUsersInventory)
+	api.GET("/inventories/ledger", s.userHandler.GetMyInventoryLedger)
 	api.POST("/merchants/create", s.merchantHandler.CreateMerchant)
 	api.GET("/merchants/:merchantID/paypal/connect", s.paypalHandler.ConnectMerchant)
+	api.GET("/merchants/:merchantID/paypal/onboard", s.paypalHandler.OnboardMerchant)
+	api.GET("/merchants/:merchantID/paypal/onboard/return", s.paypalHandler.OnboardingReturn)
 	api.GET("/merchants/:merchantID/paypal/status", s.merchantHandler.PayPalStatus)
 	api.POST("/merchants/:merchantID/paypal/disconnect", s.merchantHandler.DisconnectPayPal)
+	api.GET("/merchants/:merchantID/products", s.merchantHandler.GetProducts)
+
+	api.GET("/wallet", s.walletHandler.GetMyWallet)
+	api.GET("/passes", s.entitlementHandler.GetPasses)
+	api.GET("/earnings", s.payoutHandler.GetMyEarnings)
+	api.GET("/payouts", s.payoutHandler.ListMyPayouts)
+	api.POST("/payouts", s.payoutHandler.RequestPayout)
+
+	api.GET("/orders", s.orderHandler.ListOrders)
+	api.GET("/orders/:orderID", s.orderHandler.GetOrder)
+	api.GET("/orders/:orderID/events", s.paypalHandler.OrderEvents)
+
+	// -------- support staff --------
+	admin := api.Group("/admin", s.adminAuth())
+	admin.GET("/orders", s.adminHandler.SearchOrders)
+	admin.GET("/subscriptions", s.adminHandler.SearchSubscriptions)
+	admin.GET("/webhook-events", s.adminHandler.SearchWebhookEvents)
+	admin.GET("/tax-report", s.adminHandler.GetTaxReport)
+	admin.GET("/risk-decisions", s.adminHandler.SearchRiskDecisions)
+	admin.PUT("/merchants/:merchantID/fee-rule", s.merchantHandler.SetFeeRule)
+	admin.GET("/merchants/:merchantID/fees", s.merchantHandler.GetFeeReport)
+	admin.POST("/merchants/:merchantID/products", s.merchantHandler.CreateProduct)
+	admin.GET("/disputes", s.disputeHandler.ListDisputes)
+	admin.POST("/disputes/sync", s.disputeHandler.SyncDisputes)
+	admin.GET("/disputes/:disputeID", s.disputeHandler.GetDispute)
+	admin.POST("/disputes/:disputeID/evidence", s.disputeHandler.ProvideEvidence)
+	admin.POST("/disputes/:disputeID/accept-claim", s.disputeHandler.AcceptClaim)
+	admin.POST("/disputes/:disputeID/offer", s.disputeHandler.MakeOffer)
+	admin.GET("/earnings/:userID", s.payoutHandler.GetEarnings)
+	admin.POST("/earnings/:userID/credit", s.payoutHandler.CreditEarnings)
+	admin.GET("/payouts", s.payoutHandler.ListPayouts)
+	admin.GET("/payouts/:payoutID", s.payoutHandler.GetPayout)
+	admin.POST("/payouts/:payoutID/approve", s.payoutHandler.ApprovePayout)
+	admin.POST("/payouts/:payoutID/reject", s.payoutHandler.RejectPayout)
+	admin.GET("/invoices", s.invoiceHandler.ListInvoices)
+	admin.POST("/invoices", s.invoiceHandler.CreateInvoice)
+	admin.GET("/invoices/:invoiceID", s.invoiceHandler.GetInvoice)
+	admin.POST("/invoices/:invoiceID/send", s.invoiceHandler.SendInvoice)
+	admin.POST("/invoices/:invoiceID/remind", s.invoiceHandler.RemindInvoice)
+	admin.POST("/invoices/:invoiceID/cancel", s.invoiceHandler.CancelInvoice)
+	admin.POST("/invoices/:invoiceID/payments", s.invoiceHandler.RecordPayment)
+	admin.GET("/invoices/:invoiceID/qr-code", s.invoiceHandler.GetQRCode)
+	admin.POST("/entitlements", s.entitlementHandler.CreateEntitlement)
+	admin.GET("/promotions", s.promotionHandler.ListPromotions)
+	admin.POST("/promotions", s.promotionHandler.CreatePromotion)
+	admin.POST("/promotions/:promotionID/active", s.promotionHandler.SetPromotionActive)
+	admin.GET("/users/:userID/purchase-limits", s.purchaseLimitHandler.GetLimits)
+	admin.PUT("/users/:userID/purchase-limits", s.purchaseLimitHandler.SetLimits)
+	admin.GET("/users/:userID/purchase-limits/audit", s.purchaseLimitHandler.GetAudit)
+	admin.GET("/inventories/:userID/ledger", s.userHandler.GetInventoryLedger)
+	admin.POST("/inventories/:userID/adjust", s.userHandler.AdjustInventory)
+	admin.POST("/wallets/:userID/grant", s.walletHandler.Grant)
+	admin.POST("/wallets/:userID/revoke", s.walletHandler.Revoke)
+	admin.GET("/wallets/:userID/reconcile", s.walletHandler.Reconcile)
+
+	// -------- game servers --------
+	game := api.Group("/game", s.gameServerAuth())
+	game.GET("/wallets/:userID", s.walletHandler.GetWallet)
+	game.POST("/wallets/:userID/spend", s.walletHandler.Spend)
+	game.POST("/wallets/:userID/transfer", s.walletHandler.Transfer)
+	game.GET("/users/:userID/freezes", s.disputeHandler.GetUserFreezes)
 
 	// -------- paypal --------
 	paypal := api.Group("/paypal")
+	paypal.GET("/config", s.paypalClientConfig)
 	paypal.GET("/oauth/callback", s.paypalHandler.OAuthCallback)
 	paypal.POST("/pay", s.paypalHandler.Pay)
+	paypal.POST("/funding-sources", s.paypalHandler.FundingSources)
+	paypal.POST("/orders", s.paypalHandler.CreateOrder)
+	paypal.POST("/orders/:orderID/capture", s.paypalHandler.CaptureOrder)
 	paypal.POST("/pay-again", s.paypalHandler.PayAgain)
+	paypal.POST("/card/pay", s.paypalHandler.PayWithCard)
+	paypal.GET("/card/return", s.paypalHandler.CardReturn)
 	paypal.GET("/have-saved-payment", s.paypalHandler.CheckUserHaveSavedPayment)
+	paypal.GET("/payment-methods", s.paypalHandler.ListPaymentMethods)
+	paypal.POST("/payment-methods/setup", s.paypalHandler.StartVaultSetup)
+	paypal.GET("/payment-methods/setup/return", s.paypalHandler.VaultSetupReturn)
+	paypal.POST("/payment-methods/:id/default", s.paypalHandler.SetDefaultPaymentMethod)
+	paypal.DELETE("/payment-methods/:id", s.paypalHandler.DeletePaymentMethod)
 	// -------- paypal webhooks / callbacks --------
 	paypal.GET("/success", s.paypalHandler.HandleSuccess)
+	paypal.GET("/cancel", s.paypalHandler.HandleCancel)
 	paypal.POST("/webhook", s.paypalHandler.PayPalWebhook)
 
 	subscription := paypal.Group("/subscription")
 	subscription.POST("/subscribe", s.paypalHandler.SubscribeSubscription)
 	subscription.GET("/success", s.paypalHandler.HandleSubscriptionSuccess)
+	subscription.GET("/cancelled", s.paypalHandler.HandleSubscriptionCancelled)
 	subscription.GET("/status", s.paypalHandler.GetSubscriptionStatus)
 	subscription.POST("/cancel", s.paypalHandler.CancelSubscription)
 }
 
+// paypalClientConfig tells the web page which JS SDK to load for the current mode
+func (s *Server) paypalClientConfig(c echo.Context) error {
+	query := url.Values{}
+	query.Set("client-id", s.paypalCfg.ClientID)
+	query.Set("currency", "USD")
+	query.Set("intent", "capture")
+	query.Set("components", "buttons")
+	query.Set("enable-funding", "venmo,paylater")
+
+	return c.JSON(200, map[string]string{
+		"mode":      s.paypalCfg.Mode,
+		"client_id": s.paypalCfg.ClientID,
+		"sdk_url":   s.paypalCfg.JSSDKURL() + "?" + query.Encode(),
+	})
+}
+
+// adminAuth requires "Authorization: Bearer <ADMIN_TOKEN>", every request is
+// rejected when no token is configured
+func (s *Server) adminAuth() echo.MiddlewareFunc {
+	return _keyAuth("header:"+echo.HeaderAuthorization+":Bearer ", s.adminToken)
+}
+
+// gameServerAuth requires "X-API-Key: <GAME_SERVER_API_KEY>"
+func (s *Server) gameServerAuth() echo.MiddlewareFunc {
+	return _keyAuth("header:X-API-Key", s.gameServerKey)
+}
+
+func _keyAuth(keyLookup string, secret string) echo.MiddlewareFunc {
+	return middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		KeyLookup: keyLookup,
+		Validator: func(key string, c echo.Context) (bool, error) {
+			if secret == "" {
+				return false, nil
+			}
+			return subtle.ConstantTimeCompare([]byte(key), []byte(secret)) == 1, nil
+		},
+	})
+}
+
 func (s *Server) Start(address string) error {
 	return s.echo.Start(address)
 }
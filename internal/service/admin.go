@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"time"
+)
+
+// capture ids are looked up for this many orders at a time while streaming
+const adminOrderBatchSize = 200
+
+// AdminService answers support staff lookups across all users. Results are
+// streamed to fn so exports never hold the whole result set in memory.
+type AdminService interface {
+	SearchOrders(ctx context.Context, query *dto.AdminOrderQuery, fn func(*dto.AdminOrder) error) error
+	SearchSubscriptions(ctx context.Context, query *dto.AdminSubscriptionQuery, fn func(*dto.AdminSubscription) error) error
+	SearchWebhookEvents(ctx context.Context, query *dto.AdminWebhookEventQuery, fn func(*dto.AdminWebhookEvent) error) error
+	// GetTaxReport sums the tax collected per jurisdiction on units paid in [from, to)
+	GetTaxReport(ctx context.Context, from time.Time, to time.Time) ([]*model.TaxSummary, error)
+	// SearchRiskDecisions lists the newest risk decisions with their rule hits
+	SearchRiskDecisions(ctx context.Context, query *dto.AdminRiskDecisionQuery) ([]*model.RiskDecision, error)
+}
+
+type adminServiceImpl struct {
+	orderRepo        repository.OrderRepository
+	subscriptionRepo repository.SubscriptionRepository
+	webhookEventRepo repository.WebhookEventRepository
+	riskRepo         repository.RiskRepository
+}
+
+func NewAdminService(
+	orderRepo repository.OrderRepository,
+	subscriptionRepo repository.SubscriptionRepository,
+	webhookEventRepo repository.WebhookEventRepository,
+	riskRepo repository.RiskRepository,
+) AdminService {
+	return &adminServiceImpl{
+		orderRepo:        orderRepo,
+		subscriptionRepo: subscriptionRepo,
+		webhookEventRepo: webhookEventRepo,
+		riskRepo:         riskRepo,
+	}
+}
+
+func (s *adminServiceImpl) SearchOrders(ctx context.Context, query *dto.AdminOrderQuery, fn func(*dto.AdminOrder) error) error {
+	search := &repository.OrderSearch{
+		OrderID:    query.OrderID,
+		CaptureID:  query.CaptureID,
+		UserID:     query.UserID,
+		MerchantID: query.MerchantID,
+		Status:     query.Status,
+		MinAmount:  query.MinAmount,
+		MaxAmount:  query.MaxAmount,
+		From:       query.From,
+		To:         query.To,
+		Limit:      query.Limit,
+	}
+
+	batch := make([]*model.Order, 0, adminOrderBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.emitOrders(ctx, batch, fn); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	err := s.orderRepo.Search(ctx, search, func(order *model.Order) error {
+		batch = append(batch, order)
+		if len(batch) < adminOrderBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return fmt.Errorf("search orders: %w", err)
+	}
+
+	return flush()
+}
+
+func (s *adminServiceImpl) emitOrders(ctx context.Context, orders []*model.Order, fn func(*dto.AdminOrder) error) error {
+	orderIDs := make([]string, len(orders))
+	for i, order := range orders {
+		orderIDs[i] = order.OrderID
+	}
+
+	units, err := s.orderRepo.GetUnitsByOrderIDs(ctx, orderIDs)
+	if err != nil {
+		return fmt.Errorf("get order units: %w", err)
+	}
+	captureIDs := make(map[string][]string, len(orders))
+	for _, unit := range units {
+		if unit.CaptureID != "" {
+			captureIDs[unit.OrderID] = append(captureIDs[unit.OrderID], unit.CaptureID)
+		}
+	}
+
+	for _, order := range orders {
+		err := fn(&dto.AdminOrder{
+			OrderID:       order.OrderID,
+			UserID:        order.UserID,
+			MerchantID:    order.MerchantID,
+			Status:        order.Status,
+			Amount:        _formatAmount(order.Amount),
+			Currency:      order.Currency,
+			PlatformFee:   order.PlatformFee,
+			FundingSource: order.FundingSource,
+			CaptureIDs:    captureIDs[order.OrderID],
+			CreatedAt:     order.CreatedAt,
+			UpdatedAt:     order.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *adminServiceImpl) SearchSubscriptions(ctx context.Context, query *dto.AdminSubscriptionQuery, fn func(*dto.AdminSubscription) error) error {
+	search := &repository.SubscriptionSearch{
+		SubscriptionID: query.SubscriptionID,
+		UserID:         query.UserID,
+		MerchantID:     query.MerchantID,
+		ProductID:      query.ProductID,
+		Status:         query.Status,
+		From:           query.From,
+		To:             query.To,
+		Limit:          query.Limit,
+	}
+
+	err := s.subscriptionRepo.Search(ctx, search, func(sub *model.UserSubscription) error {
+		return fn(&dto.AdminSubscription{
+			SubscriptionID:  sub.PayPalSubscriptionID,
+			UserID:          sub.UserID,
+			MerchantID:      sub.MerchantID,
+			ProductID:       sub.ProductID,
+			Status:          sub.Status,
+			StartTime:       sub.StartTime,
+			NextBillingTime: sub.NextBillingTime,
+			CreatedAt:       sub.CreatedAt,
+			UpdatedAt:       sub.UpdatedAt,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("search subscriptions: %w", err)
+	}
+
+	return nil
+}
+
+func (s *adminServiceImpl) SearchWebhookEvents(ctx context.Context, query *dto.AdminWebhookEventQuery, fn func(*dto.AdminWebhookEvent) error) error {
+	filter := &repository.WebhookEventFilter{
+		EventID:    query.EventID,
+		EventType:  query.EventType,
+		ResourceID: query.ResourceID,
+		OrderID:    query.OrderID,
+		Status:     query.Status,
+		From:       query.From,
+		To:         query.To,
+		Limit:      query.Limit,
+	}
+
+	err := s.webhookEventRepo.Search(ctx, filter, func(event *model.WebhookEvent) error {
+		return fn(&dto.AdminWebhookEvent{
+			EventID:     event.EventID,
+			EventType:   event.EventType,
+			ResourceID:  event.ResourceID,
+			OrderID:     event.OrderID,
+			Status:      event.Status,
+			LastError:   event.LastError,
+			ProcessedAt: event.ProcessedAt,
+			CreatedAt:   event.CreatedAt,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("search webhook events: %w", err)
+	}
+
+	return nil
+}
+
+func (s *adminServiceImpl) GetTaxReport(ctx context.Context, from time.Time, to time.Time) ([]*model.TaxSummary, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	return s.orderRepo.SumTaxes(ctx, from, to)
+}
+
+func (s *adminServiceImpl) SearchRiskDecisions(ctx context.Context, query *dto.AdminRiskDecisionQuery) ([]*model.RiskDecision, error) {
+	decisions, err := s.riskRepo.Search(ctx, &repository.RiskDecisionFilter{
+		UserID:   query.UserID,
+		Decision: query.Decision,
+		OrderID:  query.OrderID,
+		Limit:    query.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search risk decisions: %w", err)
+	}
+	return decisions, nil
+}
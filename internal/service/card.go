@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// PayWithCard creates an order paid by a card entered in the hosted card
+// fields. When the issuer asks for 3-D Secure the buyer is sent to the
+// challenge first and the order is completed on return.
+func (s *paypalServiceImpl) PayWithCard(ctx context.Context, merchantID string, userID string, singleUseToken string, saveCard bool, couponCode string, billingAddress *dto.BillingAddress, items []*dto.Item) (*dto.PayResponse, error) {
+	if singleUseToken == "" {
+		return nil, fmt.Errorf("missing card token")
+	}
+
+	var address *TaxAddress
+	if billingAddress != nil {
+		address = &TaxAddress{Country: billingAddress.CountryCode, Region: billingAddress.Region}
+	}
+
+	co, err := s.prepareCheckout(ctx, merchantID, userID, couponCode, address, items)
+	if err != nil {
+		return nil, err
+	}
+
+	co.fundingSource = model.FUNDING_CARD
+
+	if err := s.holdSpend(ctx, userID, co); err != nil {
+		return nil, err
+	}
+	if err := s.reservePromotions(ctx, userID, co); err != nil {
+		s.releaseSpend(ctx, co)
+		return nil, err
+	}
+
+	resp, err := s.paypalClient.CreateCardOrder(ctx, s.serviceBaseUrl, singleUseToken, saveCard, co.units, co.merchantAuth)
+	if err != nil {
+		s.releasePromotions(ctx, co)
+		s.releaseSpend(ctx, co)
+
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+			return nil, &CardPaymentError{Status: "DECLINED", Reason: apiErr.Issue}
+		}
+		return nil, fmt.Errorf("paypal api create card order: %w", err)
+	}
+
+	if err := s.storeOrder(ctx, resp.OrderID, userID, "CREATED", co); err != nil {
+		s.releasePromotions(ctx, co)
+		s.releaseSpend(ctx, co)
+		return nil, err
+	}
+
+	if resp.Status == "PAYER_ACTION_REQUIRED" {
+		return &dto.PayResponse{
+			OrderID:          resp.OrderID,
+			OrderApprovalURL: resp.ApproveURL,
+			Status:           resp.Status,
+			Discount:         _formatDiscount(co.discount),
+			Tax:              _formatDiscount(co.tax),
+		}, nil
+	}
+
+	return s.CompleteCardOrder(ctx, resp.OrderID)
+}
+
+// CompleteCardOrder captures a card order once 3-D Secure is done, but only
+// when the authentication result allows it.
+func (s *paypalServiceImpl) CompleteCardOrder(ctx context.Context, orderID string) (*dto.PayResponse, error) {
+	orderDetail, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order detail: %w", err)
+	}
+
+	// the 3-D Secure return url may be hit more than once
+	if orderDetail.Status != "CREATED" {
+		return &dto.PayResponse{OrderID: orderID, Status: orderDetail.Status}, nil
+	}
+
+	merchantAuth, err := s.orderMerchantAuth(ctx, orderDetail)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := s.paypalClient.GetOrder(ctx, orderID, merchantAuth)
+	if err != nil {
+		return nil, fmt.Errorf("paypal api get order: %w", err)
+	}
+
+	switch order.Status {
+	case "COMPLETED":
+		// captured by an earlier return, the capture webhook settles the units
+		return &dto.PayResponse{OrderID: orderID, Status: orderDetail.Status}, nil
+	case "PAYER_ACTION_REQUIRED":
+		// the buyer left the 3-D Secure challenge without finishing it
+		if err := s.failCardOrder(ctx, orderID, order.Card); err != nil {
+			return nil, err
+		}
+		return nil, &CardPaymentError{OrderID: orderID, Status: "AUTHENTICATION_FAILED", Reason: order.Status}
+	}
+
+	if order.Card != nil && !_cardCaptureAllowed(order.Card.AuthenticationResult) {
+		if err := s.failCardOrder(ctx, orderID, order.Card); err != nil {
+			return nil, err
+		}
+		return nil, &CardPaymentError{
+			OrderID: orderID,
+			Status:  "AUTHENTICATION_FAILED",
+			Reason:  _describeAuthentication(order.Card.AuthenticationResult),
+		}
+	}
+
+	captured, err := s.paypalClient.CaptureOrder(ctx, orderID, merchantAuth)
+	if err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+			if err := s.failCardOrder(ctx, orderID, order.Card); err != nil {
+				return nil, err
+			}
+			return nil, &CardPaymentError{OrderID: orderID, Status: "DECLINED", Reason: apiErr.Issue}
+		}
+		return nil, fmt.Errorf("paypal api capture order: %w", err)
+	}
+
+	card := captured.Card
+	if card == nil {
+		card = order.Card
+	}
+
+	err = s.orderTransaction(ctx, func(tx *gorm.DB, events *orderEvents) error {
+		if card != nil {
+			if err := s.orderRepo.UpdateCardDetails(ctx, tx, orderID, card.Brand, card.LastDigits); err != nil {
+				return fmt.Errorf("store card details: %w", err)
+			}
+		}
+
+		return s.recordCaptures(ctx, tx, events, orderID, captured.Captures)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if card != nil && card.Attributes.Vault.ID != "" && card.Attributes.Vault.Status == "VAULTED" {
+		err := s.vaultRepo.Create(ctx, &model.UserVault{
+			UserID:         orderDetail.UserID,
+			VaultID:        card.Attributes.Vault.ID,
+			Provider:       string(model.VAULT_CARD),
+			MerchantID:     orderDetail.MerchantID,
+			CardBrand:      card.Brand,
+			CardLastDigits: card.LastDigits,
+			Country:        card.BillingAddress.CountryCode,
+			Region:         card.BillingAddress.AdminArea1,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("save user card vault: %w", err)
+		}
+	}
+
+	status := _capturedOrderStatus(captured)
+	if status != "COMPLETED" && status != "PENDING" {
+		return nil, &CardPaymentError{OrderID: orderID, Status: "DECLINED", Reason: status}
+	}
+
+	// items are granted once PAYMENT.CAPTURE.COMPLETED arrives for each unit
+	return &dto.PayResponse{
+		OrderID: orderID,
+		Status:  status,
+	}, nil
+}
+
+func (s *paypalServiceImpl) failCardOrder(ctx context.Context, orderID string, card *model.Card) error {
+	return s.orderTransaction(ctx, func(tx *gorm.DB, events *orderEvents) error {
+		if card != nil {
+			if err := s.orderRepo.UpdateCardDetails(ctx, tx, orderID, card.Brand, card.LastDigits); err != nil {
+				return fmt.Errorf("store card details: %w", err)
+			}
+		}
+
+		units, err := s.orderRepo.GetOrderUnits(ctx, tx, orderID)
+		if err != nil {
+			return fmt.Errorf("get order units: %w", err)
+		}
+		for _, unit := range units {
+			if err := s.orderRepo.UpdateUnitCapture(ctx, tx, unit.ReferenceID, "", "FAILED"); err != nil {
+				return fmt.Errorf("mark order unit failed: %w", err)
+			}
+		}
+		// a declined card must not cost the buyer their coupon
+		if err := s.releaseOrderPromotions(ctx, tx, orderID); err != nil {
+			return err
+		}
+
+		_, err = s.refreshOrderStatus(ctx, tx, events, orderID)
+		return err
+	})
+}
+
+// _cardCaptureAllowed follows PayPal's recommended actions for 3-D Secure
+// results. An empty result means no authentication was requested.
+func _cardCaptureAllowed(result model.AuthenticationResult) bool {
+	switch result.LiabilityShift {
+	case "", "YES", "POSSIBLE":
+		return true
+	case "NO":
+		// the card is not enrolled, or 3-D Secure was unavailable or bypassed
+		switch result.ThreeDSecure.EnrollmentStatus {
+		case "N", "U", "B":
+			return result.ThreeDSecure.AuthenticationStatus == ""
+		}
+	}
+	return false
+}
+
+func _describeAuthentication(result model.AuthenticationResult) string {
+	return fmt.Sprintf("liability_shift=%s enrollment_status=%s authentication_status=%s",
+		result.LiabilityShift,
+		result.ThreeDSecure.EnrollmentStatus,
+		result.ThreeDSecure.AuthenticationStatus,
+	)
+}
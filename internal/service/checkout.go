@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PayPal accepts at most 10 purchase units in a single order
+const maxPurchaseUnits = 10
+
+// a vault charge that cannot be stored is retried this often before it is refunded
+const (
+	storeOrderAttempts = 3
+	storeOrderBackoff  = 500 * time.Millisecond
+)
+
+// checkout is a cart split into one purchase unit per payee merchant
+type checkout struct {
+	merchantAuth  *client.MerchantAuth
+	merchantID    string // empty when the cart spans several merchants
+	fundingSource model.FundingSource
+	clientProfile string
+	total         int32
+	platformFee   int64
+	discount      int64 // minor units, already taken off the units sent to PayPal
+	tax           int64 // minor units, see TaxQuote.Inclusive
+	taxQuote      *TaxQuote
+	promotions    []*appliedPromotion
+	reservationID string // promotion redemptions reserved for this checkout
+	holdID        string // spend held against the buyer's purchase limits
+	units         []*client.OrderUnit
+	orderUnits    []*model.OrderUnit
+	orderItems    []*model.OrderItem
+}
+
+// prepareCheckout groups the cart by the merchant owning each product.
+// Products without an owner are sold by merchantID (the X-Merchant-Id header).
+// Carts spanning several merchants are created by the partner itself, so every
+// merchant involved must have completed Partner Referrals onboarding.
+// The cart is taxed when the buyer's address is already known (cards and
+// saved payment methods), approval orders are taxed before capture.
+func (s *paypalServiceImpl) prepareCheckout(ctx context.Context, merchantID string, userID string, couponCode string, address *TaxAddress, items []*dto.Item) (*checkout, error) {
+	productIDs := make([]string, len(items))
+	itemQuantityMap := make(map[string]int32)
+	for i, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("item quantity must be positive")
+		}
+		productIDs[i] = item.Sku
+
+		itemQuantityMap[item.Sku] = item.Quantity
+	}
+	products, err := s.productRepo.FindMany(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get many products by item ids")
+	}
+
+	if len(products) != len(items) {
+		return nil, fmt.Errorf("some products not found")
+	}
+
+	var merchantIDs []string
+	productsByMerchant := make(map[string][]*model.Product)
+	for _, product := range products {
+		owner := product.MerchantID
+		if owner == "" {
+			owner = merchantID
+		}
+		if owner == "" {
+			return nil, fmt.Errorf("product %s has no merchant, missing X-Merchant-Id header", product.ID)
+		}
+
+		if _, ok := productsByMerchant[owner]; !ok {
+			merchantIDs = append(merchantIDs, owner)
+		}
+		productsByMerchant[owner] = append(productsByMerchant[owner], product)
+	}
+
+	if len(merchantIDs) > maxPurchaseUnits {
+		return nil, fmt.Errorf("cart spans %d merchants, at most %d are allowed", len(merchantIDs), maxPurchaseUnits)
+	}
+
+	var lines []*checkoutLine
+	linesByMerchant := make(map[string][]*checkoutLine)
+	for _, owner := range merchantIDs {
+		for _, product := range productsByMerchant[owner] {
+			line := &checkoutLine{
+				productID:  product.ID,
+				name:       product.Name,
+				merchantID: owner,
+				quantity:   itemQuantityMap[product.ID],
+				unitPrice:  int64(product.Price) * 100,
+			}
+			lines = append(lines, line)
+			linesByMerchant[owner] = append(linesByMerchant[owner], line)
+		}
+	}
+	promotions, err := s.applyPromotions(ctx, userID, couponCode, lines)
+	if err != nil {
+		return nil, err
+	}
+	taxQuote, err := s.quoteTax(ctx, address, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	co := &checkout{promotions: promotions, taxQuote: taxQuote}
+	for _, owner := range merchantIDs {
+		merchantAuth, merchant, err := s.resolveMerchantAuth(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		if len(merchantIDs) > 1 && merchantAuth.PayerID == "" {
+			return nil, fmt.Errorf("merchant %s must complete PayPal onboarding to sell in multi-merchant carts", owner)
+		}
+
+		referenceID := uuid.NewString()
+		amount := int32(0)
+		discount := int64(0)
+		for i, product := range productsByMerchant[owner] {
+			line := linesByMerchant[owner][i]
+			amount += product.Price * line.quantity
+			discount += line.discount
+
+			co.orderItems = append(co.orderItems, &model.OrderItem{
+				ReferenceID: referenceID,
+				ProductID:   product.ID,
+				Quantity:    line.quantity,
+				UnitPrice:   product.Price,
+				Currency:    product.Currency,
+				Discount:    line.discount,
+				Tax:         line.unitTax * int64(line.quantity),
+			})
+		}
+		// PayPal rejects purchase units that are free after discounts
+		if int64(amount)*100-discount <= 0 {
+			return nil, fmt.Errorf("items sold by merchant %s are free after discounts, at least 0.01 must be paid", owner)
+		}
+
+		unit, err := s.buildOrderUnit(ctx, merchant, merchantAuth, referenceID, userID, "USD", amount, discount)
+		if err != nil {
+			return nil, err
+		}
+		if taxQuote != nil {
+			unit.Items, unit.Tax = _taxedItems(linesByMerchant[owner], taxQuote.Inclusive)
+		}
+
+		co.units = append(co.units, unit)
+		co.orderUnits = append(co.orderUnits, &model.OrderUnit{
+			ReferenceID: referenceID,
+			MerchantID:  owner,
+			Amount:      amount,
+			Currency:    "USD",
+			PlatformFee: unit.PlatformFee,
+			Discount:    discount,
+			Tax:         unit.Tax,
+			Status:      "CREATED",
+		})
+		co.total += amount
+		co.discount += discount
+		co.tax += unit.Tax
+		co.platformFee += unit.PlatformFee
+		co.merchantAuth = merchantAuth
+	}
+
+	if len(merchantIDs) == 1 {
+		co.merchantID = merchantIDs[0]
+	} else {
+		// the partner creates the order, each unit names its own payee
+		co.merchantAuth = &client.MerchantAuth{}
+	}
+
+	return co, nil
+}
+
+func (s *paypalServiceImpl) buildOrderUnit(ctx context.Context, merchant *model.Merchant, merchantAuth *client.MerchantAuth, referenceID string, userID string, currency string, amount int32, discount int64) (*client.OrderUnit, error) {
+	unit := &client.OrderUnit{
+		ReferenceID: referenceID,
+		CustomID:    userID,
+		Currency:    currency,
+		Amount:      amount,
+		Discount:    discount,
+	}
+
+	// payee and platform fees are only accepted when the platform acts for the merchant
+	if merchantAuth.PayerID == "" {
+		return unit, nil
+	}
+	unit.PayeeMerchantID = merchant.PayPalMerchantID
+
+	rule, err := s.merchantRepo.GetFeeRule(ctx, merchant.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return unit, nil
+		}
+		return nil, fmt.Errorf("get merchant fee rule: %w", err)
+	}
+	// the fee is taken from the price after discounts, without tax
+	unit.PlatformFee = _platformFee(rule, currency, int64(amount)*100-discount)
+
+	return unit, nil
+}
+
+// storeOrder persists the order with its units and items. Captures returned
+// together with the order (vault charges) set the status of their unit.
+func (s *paypalServiceImpl) storeOrder(ctx context.Context, orderID string, userID string, status string, co *checkout, captures ...*client.UnitCapture) error {
+	for _, orderUnit := range co.orderUnits {
+		orderUnit.OrderID = orderID
+		orderUnit.Status = _unitStatus(status)
+		for _, capture := range captures {
+			if capture.ReferenceID == orderUnit.ReferenceID {
+				orderUnit.CaptureID = capture.CaptureID
+				orderUnit.Status = _unitStatus(capture.Status)
+			}
+		}
+	}
+	for _, orderItem := range co.orderItems {
+		orderItem.OrderID = orderID
+	}
+
+	order := &model.Order{
+		OrderID:       orderID,
+		UserID:        userID,
+		Status:        status,
+		Amount:        co.total,
+		Currency:      "USD",
+		MerchantID:    co.merchantID,
+		PlatformFee:   co.platformFee,
+		Discount:      co.discount,
+		Tax:           co.tax,
+		FundingSource: string(co.fundingSource),
+		ClientProfile: co.clientProfile,
+	}
+	if co.taxQuote != nil {
+		order.TaxInclusive = co.taxQuote.Inclusive
+		order.TaxCountry = co.taxQuote.Country
+		order.TaxJurisdiction = co.taxQuote.Jurisdiction
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.orderRepo.Create(ctx, tx, order); err != nil {
+			return fmt.Errorf("store order in db: %w", err)
+		}
+
+		if err := s.orderRepo.CreateOrderUnits(ctx, tx, co.orderUnits); err != nil {
+			return fmt.Errorf("store order units in db: %w", err)
+		}
+
+		if err := s.orderRepo.CreateOrderItems(ctx, tx, co.orderItems); err != nil {
+			return fmt.Errorf("store order items in db: %w", err)
+		}
+
+		if co.reservationID != "" {
+			if err := s.promotionRepo.AttachReservation(ctx, tx, co.reservationID, orderID); err != nil {
+				return fmt.Errorf("attach promotion redemptions: %w", err)
+			}
+		}
+
+		// the stored order counts against the limits from now on
+		if co.holdID != "" {
+			if err := s.purchaseLimitRepo.DeleteHolds(ctx, tx, co.holdID); err != nil {
+				return fmt.Errorf("release spend holds: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// recordCaptures stores the capture result of each unit and refreshes the order
+func (s *paypalServiceImpl) recordCaptures(ctx context.Context, tx *gorm.DB, events *orderEvents, orderID string, captures []*client.UnitCapture) error {
+	for _, capture := range captures {
+		err := s.orderRepo.UpdateUnitCapture(ctx, tx, capture.ReferenceID, capture.CaptureID, _unitStatus(capture.Status))
+		if err != nil {
+			return fmt.Errorf("update order unit capture: %w", err)
+		}
+	}
+
+	_, err := s.refreshOrderStatus(ctx, tx, events, orderID)
+	return err
+}
+
+// refreshOrderStatus derives the order status from its purchase units and
+// queues a captured or failed event when the capture outcome is final. It
+// returns the new status, empty while some captures are still pending.
+func (s *paypalServiceImpl) refreshOrderStatus(ctx context.Context, tx *gorm.DB, events *orderEvents, orderID string) (string, error) {
+	units, err := s.orderRepo.GetOrderUnits(ctx, tx, orderID)
+	if err != nil {
+		return "", fmt.Errorf("get order units: %w", err)
+	}
+	if len(units) == 0 {
+		if err := s.orderRepo.MarkCompleted(ctx, tx, orderID); err != nil {
+			return "", err
+		}
+		events.add(orderID, OrderEventCaptured, "COMPLETED")
+		return "COMPLETED", nil
+	}
+
+	var paid, completed, failed int
+	for _, unit := range units {
+		switch unit.Status {
+		case "PAID":
+			paid++
+		case "COMPLETED":
+			completed++
+		case "FAILED":
+			failed++
+		}
+	}
+
+	var status string
+	switch {
+	case failed == len(units):
+		status = "FAILED"
+	case paid == len(units):
+		status = "PAID"
+	case paid+failed == len(units):
+		status = "PARTIALLY_PAID"
+	case paid+completed == len(units):
+		status = "COMPLETED"
+	case paid+completed+failed == len(units):
+		status = "PARTIALLY_COMPLETED"
+	default:
+		// some captures are still pending
+		return "", nil
+	}
+
+	if err := s.orderRepo.UpdateStatus(ctx, tx, orderID, status); err != nil {
+		return "", err
+	}
+
+	switch status {
+	case "FAILED":
+		// nothing was paid, so the coupon can be used again
+		if err := s.releaseOrderPromotions(ctx, tx, orderID); err != nil {
+			return "", err
+		}
+		events.add(orderID, OrderEventFailed, status)
+	case "COMPLETED", "PARTIALLY_COMPLETED":
+		events.add(orderID, OrderEventCaptured, status)
+	}
+	return status, nil
+}
+
+// _unitStatus maps a PayPal capture status to a purchase unit status
+func _unitStatus(captureStatus string) string {
+	switch captureStatus {
+	case "COMPLETED", "PENDING", "CREATED":
+		return captureStatus
+	case "DECLINED", "FAILED", "PAYER_ACTION_REQUIRED":
+		return "FAILED"
+	}
+	return "PENDING"
+}
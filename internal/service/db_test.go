@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/model"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a migrated database of its own for the test. It is a
+// file so reads on the root connection work while a transaction is open.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=off"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := client.AutoMigrate(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// fakePaypalClient answers the calls a test sets, any other call panics on
+// the nil embedded client.
+type fakePaypalClient struct {
+	client.PaypalClient
+	createPayout func(payout *client.PayoutRequest) (*model.PayoutBatchHeader, error)
+	getInvoice   func(invoiceID string) (*model.PaypalInvoice, error)
+}
+
+func (c *fakePaypalClient) CreatePayout(ctx context.Context, payout *client.PayoutRequest) (*model.PayoutBatchHeader, error) {
+	return c.createPayout(payout)
+}
+
+func (c *fakePaypalClient) GetInvoice(ctx context.Context, invoiceID string, auth *client.MerchantAuth) (*model.PaypalInvoice, error) {
+	return c.getInvoice(invoiceID)
+}
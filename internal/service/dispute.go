@@ -0,0 +1,676 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// PayPal rejects evidence notes longer than this
+	maxEvidenceNotes = 2000
+	disputePageSize  = 50
+)
+
+var alertHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+type DisputeService interface {
+	ListDisputes(ctx context.Context, query *dto.AdminDisputeQuery) ([]*model.Dispute, error)
+	GetDispute(ctx context.Context, disputeID string) (*dto.DisputeDetail, error)
+	SyncDisputes(ctx context.Context, merchantID string) (int, error)
+	ProvideDisputeEvidence(ctx context.Context, disputeID string, req *dto.DisputeActionRequest) error
+	AcceptDisputeClaim(ctx context.Context, disputeID string, req *dto.DisputeActionRequest) error
+	MakeDisputeOffer(ctx context.Context, disputeID string, req *dto.DisputeActionRequest) error
+	// GetUserFreezes lists the user's items frozen by open disputes, game servers must not let them be used
+	GetUserFreezes(ctx context.Context, userID string) ([]*model.ItemFreeze, error)
+	// HandleDisputeEvent stores a CUSTOMER.DISPUTE.* webhook event
+	HandleDisputeEvent(ctx context.Context, event *model.PayPalWebhookEvent) error
+	// RunDisputeAlerts alerts support about due responses every interval until ctx is done
+	RunDisputeAlerts(ctx context.Context, interval time.Duration)
+}
+
+type disputeServiceImpl struct {
+	merchantAuth
+	itemGranter
+	db            *gorm.DB
+	disputeRepo   repository.DisputeRepository
+	disputeAlerts config.Disputes
+}
+
+func NewDisputeService(
+	db *gorm.DB,
+	paypalClient client.PaypalClient,
+	merchantRepo repository.MerchantRepository,
+	orderRepo repository.OrderRepository,
+	entitlementRepo repository.EntitlementRepository,
+	walletRepo repository.WalletRepository,
+	inventoryRepo repository.InventoryRepository,
+	disputeRepo repository.DisputeRepository,
+	disputeAlerts config.Disputes,
+) DisputeService {
+	return &disputeServiceImpl{
+		merchantAuth: merchantAuth{
+			merchantRepo: merchantRepo,
+			paypalClient: paypalClient,
+		},
+		itemGranter: itemGranter{
+			orderRepo:       orderRepo,
+			entitlementRepo: entitlementRepo,
+			walletRepo:      walletRepo,
+			inventoryRepo:   inventoryRepo,
+		},
+		db:            db,
+		disputeRepo:   disputeRepo,
+		disputeAlerts: disputeAlerts,
+	}
+}
+
+func (s *disputeServiceImpl) HandleDisputeEvent(ctx context.Context, event *model.PayPalWebhookEvent) error {
+	resource := event.Resource
+	if resource.DisputeID == "" {
+		return fmt.Errorf("missing dispute_id in %s event payload", event.EventType)
+	}
+
+	_, err := s.storeDispute(ctx, &model.PaypalDispute{
+		DisputeID:             resource.DisputeID,
+		DisputedTransactions:  resource.DisputedTransactions,
+		Reason:                resource.Reason,
+		Status:                resource.Status,
+		DisputeAmount:         resource.DisputeAmount,
+		DisputeOutcome:        resource.DisputeOutcome,
+		DisputeLifeCycleStage: resource.DisputeLifeCycleStage,
+		SellerResponseDueDate: resource.SellerResponseDueDate,
+	})
+	return err
+}
+
+// storeDispute links the dispute to the purchase unit of the disputed capture.
+// The unit's items are frozen the first time the dispute is seen open and
+// settled once it is resolved, see settleFreezes. It returns nil for disputes
+// about captures that are not ours.
+func (s *disputeServiceImpl) storeDispute(ctx context.Context, paypalDispute *model.PaypalDispute) (*model.Dispute, error) {
+	var captureID string
+	for _, transaction := range paypalDispute.DisputedTransactions {
+		if transaction.SellerTransactionID != "" {
+			captureID = transaction.SellerTransactionID
+			break
+		}
+	}
+	if captureID == "" {
+		return nil, nil
+	}
+
+	unit, err := s.orderRepo.FindUnitByCaptureID(ctx, s.db, captureID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get order unit: %w", err)
+	}
+	order, err := s.orderRepo.FindByOrderID(ctx, unit.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order detail: %w", err)
+	}
+
+	dispute := &model.Dispute{
+		DisputeID:             paypalDispute.DisputeID,
+		OrderID:               unit.OrderID,
+		ReferenceID:           unit.ReferenceID,
+		CaptureID:             captureID,
+		UserID:                order.UserID,
+		MerchantID:            unit.MerchantID,
+		Reason:                paypalDispute.Reason,
+		Status:                paypalDispute.Status,
+		Stage:                 paypalDispute.DisputeLifeCycleStage,
+		Amount:                paypalDispute.DisputeAmount.Value,
+		Currency:              paypalDispute.DisputeAmount.Currency,
+		Outcome:               paypalDispute.DisputeOutcome.OutcomeCode,
+		SellerResponseDueDate: paypalDispute.SellerResponseDueDate,
+	}
+
+	// a new due date, e.g. when the dispute escalates, needs a new alert
+	existing, err := s.disputeRepo.Get(ctx, dispute.DisputeID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("get dispute: %w", err)
+	}
+	if err == nil && _sameTime(existing.SellerResponseDueDate, dispute.SellerResponseDueDate) {
+		dispute.AlertedAt = existing.AlertedAt
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.disputeRepo.Upsert(ctx, tx, dispute); err != nil {
+			return fmt.Errorf("store dispute: %w", err)
+		}
+
+		// a dispute first seen already resolved is frozen first, so settling
+		// it still revokes what the buyer got back the money for
+		frozen, err := s.disputeRepo.HasFreezes(ctx, tx, dispute.DisputeID)
+		if err != nil {
+			return fmt.Errorf("check frozen items: %w", err)
+		}
+		if !frozen {
+			freezes, err := s.unitFreezes(ctx, tx, dispute)
+			if err != nil {
+				return err
+			}
+			if err := s.disputeRepo.CreateFreezes(ctx, tx, freezes); err != nil {
+				return fmt.Errorf("freeze disputed items: %w", err)
+			}
+		}
+
+		if dispute.Status == "RESOLVED" {
+			return s.settleFreezes(ctx, tx, dispute)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dispute, nil
+}
+
+// settleFreezes gives the frozen items back when the seller kept the money and
+// revokes them when the buyer got it back. Other outcomes keep them frozen
+// for support to look at.
+func (s *disputeServiceImpl) settleFreezes(ctx context.Context, tx *gorm.DB, dispute *model.Dispute) error {
+	switch dispute.Outcome {
+	case "RESOLVED_SELLER_FAVOUR", "CANCELED_BY_BUYER", "DENIED":
+		if err := s.disputeRepo.ReleaseFreezes(ctx, tx, dispute.DisputeID); err != nil {
+			return fmt.Errorf("release frozen items: %w", err)
+		}
+		return nil
+	case "RESOLVED_BUYER_FAVOUR", "RESOLVED_WITH_PAYOUT", "ACCEPTED":
+		return s.revokeFreezes(ctx, tx, dispute)
+	}
+
+	log.Printf("dispute %s resolved with outcome %q, its items stay frozen", dispute.DisputeID, dispute.Outcome)
+	return nil
+}
+
+// revokeFreezes takes the frozen items back from the buyer. What they used
+// before the dispute was opened is gone, so each revoke is capped at what is
+// still there.
+func (s *disputeServiceImpl) revokeFreezes(ctx context.Context, tx *gorm.DB, dispute *model.Dispute) error {
+	freezes, err := s.disputeRepo.GetActiveFreezes(ctx, tx, dispute.DisputeID)
+	if err != nil {
+		return fmt.Errorf("get frozen items: %w", err)
+	}
+
+	for _, freeze := range freezes {
+		if err := s.revokeFreeze(ctx, tx, dispute, freeze); err != nil {
+			return fmt.Errorf("revoke %s: %w", freeze.EntitlementID, err)
+		}
+	}
+	return nil
+}
+
+func (s *disputeServiceImpl) revokeFreeze(ctx context.Context, tx *gorm.DB, dispute *model.Dispute, freeze *model.ItemFreeze) error {
+	key := fmt.Sprintf("dispute:%s:freeze:%d", dispute.DisputeID, freeze.ID)
+	reason := fmt.Sprintf("dispute %s won by the buyer", dispute.DisputeID)
+
+	switch model.EntitlementType(freeze.Type) {
+	case model.ENTITLEMENT_CURRENCY:
+		// locked, so a spend cannot slip in between the balance and the debit
+		balance, err := s.walletRepo.GetBalanceForUpdate(ctx, tx, freeze.UserID)
+		if err != nil {
+			return fmt.Errorf("get wallet balance: %w", err)
+		}
+		frozen, err := s.walletRepo.FrozenBalance(ctx, tx, freeze.UserID)
+		if err != nil {
+			return fmt.Errorf("get frozen wallet balance: %w", err)
+		}
+		// coins frozen by other disputes stay where they are
+		amount := min(freeze.Quantity, balance-(frozen-freeze.Quantity))
+		// released first, so the debit below is not blocked by this freeze
+		if err := s.disputeRepo.MarkRevoked(ctx, tx, freeze, max(amount, 0)); err != nil {
+			return fmt.Errorf("mark freeze revoked: %w", err)
+		}
+		if amount <= 0 {
+			return nil
+		}
+		_, err = applyWalletOperation(ctx, tx, s.walletRepo, &model.WalletOperation{
+			OperationID: key,
+			Type:        string(model.WALLET_DISPUTE_REVOKE),
+			UserID:      freeze.UserID,
+			Amount:      amount,
+			Reason:      reason,
+		}, []walletChange{{userID: freeze.UserID, amount: -amount}})
+		return err
+
+	case model.ENTITLEMENT_PASS:
+		days, err := s.entitlementRepo.RevokePass(ctx, tx, &model.PassGrant{
+			UserID:         freeze.UserID,
+			EntitlementID:  freeze.EntitlementID,
+			Days:           int32(freeze.Quantity),
+			SourceType:     string(model.INVENTORY_SOURCE_DISPUTE),
+			SourceID:       dispute.DisputeID,
+			IdempotencyKey: key,
+		})
+		if err != nil {
+			return err
+		}
+		return s.disputeRepo.MarkRevoked(ctx, tx, freeze, int64(days))
+	}
+
+	owned, err := s.inventoryRepo.GetQuantity(ctx, tx, freeze.UserID, freeze.EntitlementID)
+	if err != nil {
+		return fmt.Errorf("get inventory quantity: %w", err)
+	}
+	quantity := min(freeze.Quantity, int64(owned))
+	if quantity > 0 {
+		_, err := s.inventoryRepo.Apply(ctx, tx, &model.InventoryLedgerEntry{
+			UserID:         freeze.UserID,
+			ProductID:      freeze.EntitlementID,
+			Delta:          -int32(quantity),
+			Reason:         reason,
+			SourceType:     string(model.INVENTORY_SOURCE_DISPUTE),
+			SourceID:       dispute.DisputeID,
+			IdempotencyKey: key,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.disputeRepo.MarkRevoked(ctx, tx, freeze, max(quantity, 0))
+}
+
+// unitFreezes lists what the disputed unit granted the buyer
+func (s *disputeServiceImpl) unitFreezes(ctx context.Context, tx *gorm.DB, dispute *model.Dispute) ([]*model.ItemFreeze, error) {
+	items, err := s.orderRepo.GetUnitItems(ctx, tx, dispute.ReferenceID)
+	if err != nil {
+		return nil, fmt.Errorf("get order unit items: %w", err)
+	}
+	byProduct, err := s.productGrants(ctx, tx, items)
+	if err != nil {
+		return nil, err
+	}
+
+	var freezes []*model.ItemFreeze
+	for _, item := range items {
+		for _, grant := range byProduct[item.ProductID] {
+			quantity := grant.Quantity * int64(item.Quantity)
+			switch model.EntitlementType(grant.Type) {
+			case model.ENTITLEMENT_PASS:
+				quantity = int64(grant.DurationDays) * int64(item.Quantity)
+			case model.ENTITLEMENT_DURABLE:
+				quantity = 1
+			}
+
+			freezes = append(freezes, &model.ItemFreeze{
+				DisputeID:     dispute.DisputeID,
+				UserID:        dispute.UserID,
+				EntitlementID: grant.EntitlementID,
+				Type:          grant.Type,
+				Quantity:      quantity,
+			})
+		}
+	}
+
+	return freezes, nil
+}
+
+func (s *disputeServiceImpl) ListDisputes(ctx context.Context, query *dto.AdminDisputeQuery) ([]*model.Dispute, error) {
+	disputes, err := s.disputeRepo.Search(ctx, &repository.DisputeFilter{
+		UserID:  query.UserID,
+		OrderID: query.OrderID,
+		Status:  query.Status,
+		Limit:   query.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search disputes: %w", err)
+	}
+	return disputes, nil
+}
+
+func (s *disputeServiceImpl) GetDispute(ctx context.Context, disputeID string) (*dto.DisputeDetail, error) {
+	dispute, err := s.disputeRepo.Get(ctx, disputeID)
+	if err != nil {
+		return nil, err
+	}
+
+	freezes, err := s.disputeRepo.GetFreezes(ctx, disputeID)
+	if err != nil {
+		return nil, fmt.Errorf("get frozen items: %w", err)
+	}
+	actions, err := s.disputeRepo.GetActions(ctx, disputeID)
+	if err != nil {
+		return nil, fmt.Errorf("get dispute actions: %w", err)
+	}
+	evidence, err := s.disputeEvidence(ctx, dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.DisputeDetail{
+		Dispute:  dispute,
+		Freezes:  freezes,
+		Actions:  actions,
+		Evidence: evidence,
+	}, nil
+}
+
+func (s *disputeServiceImpl) GetUserFreezes(ctx context.Context, userID string) ([]*model.ItemFreeze, error) {
+	return s.disputeRepo.GetUserFreezes(ctx, userID)
+}
+
+// SyncDisputes stores every dispute PayPal lists for the merchant, or for the
+// platform when merchantID is empty, catching up on missed webhooks
+func (s *disputeServiceImpl) SyncDisputes(ctx context.Context, merchantID string) (int, error) {
+	auth := &client.MerchantAuth{}
+	if merchantID != "" {
+		var err error
+		if auth, _, err = s.resolveMerchantAuth(ctx, merchantID); err != nil {
+			return 0, err
+		}
+	}
+
+	stored := 0
+	query := &client.DisputeListQuery{PageSize: disputePageSize}
+	for {
+		page, err := s.paypalClient.ListDisputes(ctx, query, auth)
+		if err != nil {
+			return stored, err
+		}
+
+		for _, summary := range page.Disputes {
+			// list items leave out the disputed transactions
+			paypalDispute, err := s.paypalClient.GetDispute(ctx, summary.DisputeID, auth)
+			if err != nil {
+				return stored, err
+			}
+			dispute, err := s.storeDispute(ctx, paypalDispute)
+			if err != nil {
+				return stored, err
+			}
+			if dispute != nil {
+				stored++
+			}
+		}
+
+		if page.NextPageToken == "" {
+			return stored, nil
+		}
+		query.NextPageToken = page.NextPageToken
+	}
+}
+
+// ProvideDisputeEvidence answers the dispute with proof that the digital
+// goods were delivered, built from the order history and delivery logs
+func (s *disputeServiceImpl) ProvideDisputeEvidence(ctx context.Context, disputeID string, req *dto.DisputeActionRequest) error {
+	return s.answerDispute(ctx, disputeID, "evidence", req, func(dispute *model.Dispute, auth *client.MerchantAuth) error {
+		evidence, err := s.disputeEvidence(ctx, dispute)
+		if err != nil {
+			return err
+		}
+		if req.Note != "" {
+			evidence = req.Note + "\n\n" + evidence
+		}
+
+		return s.paypalClient.ProvideEvidence(ctx, disputeID, []*client.DisputeEvidence{{
+			Type:  "PROOF_OF_FULFILLMENT",
+			Notes: _truncate(evidence, maxEvidenceNotes),
+		}}, auth)
+	})
+}
+
+func (s *disputeServiceImpl) AcceptDisputeClaim(ctx context.Context, disputeID string, req *dto.DisputeActionRequest) error {
+	if req.Note == "" {
+		return fmt.Errorf("%w: note is required", ErrInvalidDisputeAction)
+	}
+
+	return s.answerDispute(ctx, disputeID, "accept_claim", req, func(dispute *model.Dispute, auth *client.MerchantAuth) error {
+		return s.paypalClient.AcceptClaim(ctx, disputeID, req.Note, auth)
+	})
+}
+
+func (s *disputeServiceImpl) MakeDisputeOffer(ctx context.Context, disputeID string, req *dto.DisputeActionRequest) error {
+	if req.Note == "" {
+		return fmt.Errorf("%w: note is required", ErrInvalidDisputeAction)
+	}
+	if req.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidDisputeAction)
+	}
+
+	return s.answerDispute(ctx, disputeID, "make_offer", req, func(dispute *model.Dispute, auth *client.MerchantAuth) error {
+		disputed, err := _parseMinor(dispute.Amount)
+		if err != nil {
+			return fmt.Errorf("parse disputed amount %q: %w", dispute.Amount, err)
+		}
+		if req.Amount > disputed {
+			return fmt.Errorf("%w: offer %s is more than the disputed %s", ErrInvalidDisputeAction, _formatMinor(req.Amount), dispute.Amount)
+		}
+
+		return s.paypalClient.MakeOffer(ctx, disputeID, req.Note, dispute.Currency, req.Amount, auth)
+	})
+}
+
+// answerDispute sends an answer with the credentials of the disputed merchant
+// and records it. PayPal reports the resulting state with a webhook.
+func (s *disputeServiceImpl) answerDispute(ctx context.Context, disputeID string, action string, req *dto.DisputeActionRequest, send func(dispute *model.Dispute, auth *client.MerchantAuth) error) error {
+	if req.Actor == "" {
+		return fmt.Errorf("%w: actor is required", ErrInvalidDisputeAction)
+	}
+
+	dispute, err := s.disputeRepo.Get(ctx, disputeID)
+	if err != nil {
+		return err
+	}
+	if dispute.Status == "RESOLVED" {
+		return ErrDisputeResolved
+	}
+
+	auth := &client.MerchantAuth{}
+	if dispute.MerchantID != "" {
+		if auth, _, err = s.resolveMerchantAuth(ctx, dispute.MerchantID); err != nil {
+			return err
+		}
+	}
+
+	if err := send(dispute, auth); err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+			return fmt.Errorf("%w: %s", ErrDisputeActionRejected, apiErr.Issue)
+		}
+		return err
+	}
+
+	err = s.disputeRepo.AddAction(ctx, &model.DisputeAction{
+		DisputeID: disputeID,
+		Action:    action,
+		Actor:     req.Actor,
+		Note:      req.Note,
+	})
+	if err != nil {
+		return fmt.Errorf("record dispute action: %w", err)
+	}
+	return nil
+}
+
+// disputeEvidence describes the disputed purchase unit: what was bought, how
+// the order went and when each item reached the buyer's account
+func (s *disputeServiceImpl) disputeEvidence(ctx context.Context, dispute *model.Dispute) (string, error) {
+	order, err := s.orderRepo.FindByOrderID(ctx, dispute.OrderID)
+	if err != nil {
+		return "", fmt.Errorf("get order detail: %w", err)
+	}
+	items, err := s.orderRepo.GetUnitItems(ctx, s.db, dispute.ReferenceID)
+	if err != nil {
+		return "", fmt.Errorf("get order unit items: %w", err)
+	}
+	history, err := s.orderRepo.GetStatusHistory(ctx, dispute.OrderID)
+	if err != nil {
+		return "", fmt.Errorf("get order status history: %w", err)
+	}
+	inventory, err := s.inventoryRepo.GetLedgerBySource(ctx, model.INVENTORY_SOURCE_ORDER, dispute.OrderID)
+	if err != nil {
+		return "", fmt.Errorf("get inventory deliveries: %w", err)
+	}
+	passes, err := s.entitlementRepo.GetPassGrantsBySource(ctx, model.INVENTORY_SOURCE_ORDER, dispute.OrderID)
+	if err != nil {
+		return "", fmt.Errorf("get pass deliveries: %w", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Digital goods bought in order %s (capture %s) by game account %s on %s, paid with %s.\n",
+		order.OrderID, dispute.CaptureID, order.UserID, _evidenceTime(order.CreatedAt), order.FundingSource)
+
+	b.WriteString("Items:\n")
+	for _, item := range items {
+		fmt.Fprintf(&b, "- %d x %s at %s %s\n", item.Quantity, item.ProductID, _formatMinor(int64(item.UnitPrice)*100), item.Currency)
+	}
+
+	b.WriteString("Order history:\n")
+	for _, h := range history {
+		fmt.Fprintf(&b, "- %s %s\n", _evidenceTime(h.CreatedAt), h.Status)
+	}
+
+	b.WriteString("Delivered to the buyer's account:\n")
+	for _, item := range items {
+		prefix := _grantKeyPrefix(item)
+		for _, entry := range inventory {
+			if strings.HasPrefix(entry.IdempotencyKey, prefix) {
+				fmt.Fprintf(&b, "- %s %+d %s to inventory\n", _evidenceTime(entry.CreatedAt), entry.Delta, entry.ProductID)
+			}
+		}
+		for _, grant := range passes {
+			if strings.HasPrefix(grant.IdempotencyKey, prefix) {
+				fmt.Fprintf(&b, "- %s %d days of %s pass\n", _evidenceTime(grant.CreatedAt), grant.Days, grant.EntitlementID)
+			}
+		}
+
+		currency, err := s.walletRepo.GetEntriesByOperationPrefix(ctx, prefix)
+		if err != nil {
+			return "", fmt.Errorf("get wallet deliveries: %w", err)
+		}
+		legacy, err := s.walletRepo.GetOperationEntries(ctx, _legacyGrantKey(item))
+		if err != nil {
+			return "", fmt.Errorf("get wallet deliveries: %w", err)
+		}
+		currency = append(currency, legacy...)
+		for _, entry := range currency {
+			fmt.Fprintf(&b, "- %s %+d currency to wallet\n", _evidenceTime(entry.CreatedAt), entry.Amount)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// RunDisputeAlerts warns support about disputes whose response is due within
+// the configured lead time, once per due date
+func (s *disputeServiceImpl) RunDisputeAlerts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			disputes, err := s.disputeRepo.FindDueSoon(ctx, now.Add(s.disputeAlerts.AlertLead))
+			if err != nil {
+				log.Printf("find disputes due soon: %v", err)
+				continue
+			}
+
+			for _, dispute := range disputes {
+				if err := s.alertDispute(ctx, dispute); err != nil {
+					log.Printf("alert dispute %s: %v", dispute.DisputeID, err)
+					continue
+				}
+				if err := s.disputeRepo.MarkAlerted(ctx, dispute.DisputeID, now); err != nil {
+					log.Printf("mark dispute %s alerted: %v", dispute.DisputeID, err)
+				}
+			}
+		}
+	}
+}
+
+func (s *disputeServiceImpl) alertDispute(ctx context.Context, dispute *model.Dispute) error {
+	log.Printf("dispute %s on order %s (%s %s, %s) needs an answer by %s",
+		dispute.DisputeID, dispute.OrderID, dispute.Amount, dispute.Currency, dispute.Reason,
+		_evidenceTime(*dispute.SellerResponseDueDate))
+
+	if s.disputeAlerts.AlertWebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"event":   "dispute_response_due",
+		"dispute": dispute,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal dispute alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.disputeAlerts.AlertWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("http new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http client do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func _evidenceTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func _sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// _truncate cuts s to at most max characters without splitting one
+func _truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	const more = "\n..."
+	return string([]rune(s)[:max-len(more)]) + more
+}
+
+// _parseMinor turns a PayPal decimal amount like "12.5" into minor units
+func _parseMinor(value string) (int64, error) {
+	whole, fraction, _ := strings.Cut(value, ".")
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("more than 2 decimals")
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	cents, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil || cents < 0 {
+		return 0, fmt.Errorf("invalid decimals %q", fraction)
+	}
+
+	return units*100 + cents, nil
+}
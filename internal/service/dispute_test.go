@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+type disputeFixture struct {
+	db              *gorm.DB
+	service         *disputeServiceImpl
+	walletRepo      repository.WalletRepository
+	inventoryRepo   repository.InventoryRepository
+	entitlementRepo repository.EntitlementRepository
+	disputeRepo     repository.DisputeRepository
+}
+
+// newDisputeFixture delivers a starter pack (500 coins, the starter skin and
+// 7 days of VIP) to user-1 through capture cap-1 of order-1
+func newDisputeFixture(t *testing.T) *disputeFixture {
+	t.Helper()
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	f := &disputeFixture{
+		db:              db,
+		walletRepo:      repository.NewWalletRepository(db),
+		inventoryRepo:   repository.NewInventoryRepository(db),
+		entitlementRepo: repository.NewEntitlementRepository(db),
+		disputeRepo:     repository.NewDisputeRepository(db),
+	}
+	f.service = NewDisputeService(
+		db,
+		nil,
+		repository.NewMerchantRepository(db),
+		repository.NewOrderRepository(db),
+		f.entitlementRepo,
+		f.walletRepo,
+		f.inventoryRepo,
+		f.disputeRepo,
+		config.Disputes{},
+	).(*disputeServiceImpl)
+
+	if err := f.entitlementRepo.Seed(ctx); err != nil {
+		t.Fatalf("seed entitlements: %v", err)
+	}
+
+	order := &model.Order{OrderID: "order-1", Status: "PAID", UserID: "user-1", Amount: 5, Currency: "USD", MerchantID: "merchant-1"}
+	unit := &model.OrderUnit{OrderID: "order-1", ReferenceID: "unit-1", MerchantID: "merchant-1", Amount: 5, Currency: "USD", CaptureID: "cap-1", Status: "PAID"}
+	item := &model.OrderItem{OrderID: "order-1", ReferenceID: "unit-1", ProductID: "starter_pack", Quantity: 1, UnitPrice: 5, Currency: "USD"}
+	for _, row := range []interface{}{order, unit, item} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return f.service.grantItems(ctx, tx, "user-1", []*model.OrderItem{item})
+	})
+	if err != nil {
+		t.Fatalf("grant items: %v", err)
+	}
+	return f
+}
+
+func (f *disputeFixture) store(t *testing.T, status string, outcome string) {
+	t.Helper()
+
+	_, err := f.service.storeDispute(context.Background(), &model.PaypalDispute{
+		DisputeID:            "dispute-1",
+		DisputedTransactions: []model.DisputedTransaction{{SellerTransactionID: "cap-1"}},
+		Status:               status,
+		DisputeOutcome:       model.DisputeOutcome{OutcomeCode: outcome},
+	})
+	if err != nil {
+		t.Fatalf("store %s dispute: %v", status, err)
+	}
+}
+
+func (f *disputeFixture) coins(t *testing.T) int64 {
+	t.Helper()
+
+	balance, err := f.walletRepo.GetBalance(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	return balance
+}
+
+func (f *disputeFixture) skins(t *testing.T) int32 {
+	t.Helper()
+
+	quantity, err := f.inventoryRepo.GetQuantity(context.Background(), f.db, "user-1", "skin_starter")
+	if err != nil {
+		t.Fatalf("get inventory: %v", err)
+	}
+	return quantity
+}
+
+// freezes returns the dispute's freezes by entitlement
+func (f *disputeFixture) freezes(t *testing.T) map[string]*model.ItemFreeze {
+	t.Helper()
+
+	freezes, err := f.disputeRepo.GetFreezes(context.Background(), "dispute-1")
+	if err != nil {
+		t.Fatalf("get freezes: %v", err)
+	}
+	byEntitlement := make(map[string]*model.ItemFreeze, len(freezes))
+	for _, freeze := range freezes {
+		byEntitlement[freeze.EntitlementID] = freeze
+	}
+	return byEntitlement
+}
+
+func TestDisputeFreezesDeliveredItems(t *testing.T) {
+	f := newDisputeFixture(t)
+	f.store(t, "OPEN", "")
+	// redelivered events do not freeze twice
+	f.store(t, "WAITING_FOR_SELLER_RESPONSE", "")
+
+	freezes := f.freezes(t)
+	want := map[string]int64{"coins": 500, "skin_starter": 1, "vip": 7}
+	if len(freezes) != len(want) {
+		t.Fatalf("%d freezes, want %d", len(freezes), len(want))
+	}
+	for entitlementID, quantity := range want {
+		freeze := freezes[entitlementID]
+		if freeze == nil || freeze.Quantity != quantity || freeze.ReleasedAt != nil {
+			t.Fatalf("freeze of %s = %+v, want %d active", entitlementID, freeze, quantity)
+		}
+	}
+
+	// frozen coins cannot be spent
+	walletService := NewWalletService(f.db, f.walletRepo)
+	_, err := walletService.Spend(context.Background(), "user-1", &dto.WalletSpendRequest{OperationID: "spend-1", Amount: 100})
+	if !errors.Is(err, ErrBalanceFrozen) {
+		t.Fatalf("spend of frozen coins: got %v, want ErrBalanceFrozen", err)
+	}
+}
+
+func TestDisputeOutcomes(t *testing.T) {
+	tests := []struct {
+		name       string
+		seenOpen   bool
+		outcome    string
+		revoked    bool
+		keepFrozen bool
+	}{
+		{name: "seller wins", seenOpen: true, outcome: "RESOLVED_SELLER_FAVOUR"},
+		{name: "buyer cancels", seenOpen: true, outcome: "CANCELED_BY_BUYER"},
+		{name: "denied", seenOpen: true, outcome: "DENIED"},
+		{name: "buyer wins", seenOpen: true, outcome: "RESOLVED_BUYER_FAVOUR", revoked: true},
+		{name: "paid out", seenOpen: true, outcome: "RESOLVED_WITH_PAYOUT", revoked: true},
+		{name: "claim accepted", seenOpen: true, outcome: "ACCEPTED", revoked: true},
+		{name: "buyer wins, first seen resolved", outcome: "RESOLVED_BUYER_FAVOUR", revoked: true},
+		{name: "unknown outcome", seenOpen: true, outcome: "SOMETHING_NEW", keepFrozen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newDisputeFixture(t)
+			if tt.seenOpen {
+				f.store(t, "OPEN", "")
+			}
+			f.store(t, "RESOLVED", tt.outcome)
+
+			wantCoins, wantSkins := int64(500), int32(1)
+			if tt.revoked {
+				wantCoins, wantSkins = 0, 0
+			}
+			if got := f.coins(t); got != wantCoins {
+				t.Fatalf("coins = %d, want %d", got, wantCoins)
+			}
+			if got := f.skins(t); got != wantSkins {
+				t.Fatalf("skins = %d, want %d", got, wantSkins)
+			}
+
+			for entitlementID, freeze := range f.freezes(t) {
+				switch {
+				case tt.keepFrozen:
+					if freeze.ReleasedAt != nil {
+						t.Fatalf("%s was released, want it kept frozen", entitlementID)
+					}
+				case tt.revoked:
+					if freeze.RevokedAt == nil || freeze.RevokedQuantity != freeze.Quantity {
+						t.Fatalf("%s revoked %d of %d, want all of it", entitlementID, freeze.RevokedQuantity, freeze.Quantity)
+					}
+				default:
+					if freeze.ReleasedAt == nil || freeze.RevokedAt != nil {
+						t.Fatalf("%s = %+v, want released without a revoke", entitlementID, freeze)
+					}
+				}
+			}
+
+			if tt.revoked {
+				passes, err := f.entitlementRepo.GetPasses(context.Background(), "user-1")
+				if err != nil {
+					t.Fatalf("get passes: %v", err)
+				}
+				for _, pass := range passes {
+					if pass.ExpiresAt.After(time.Now().Add(time.Hour)) {
+						t.Fatalf("vip pass still runs until %s", pass.ExpiresAt)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDisputeRevokeIsCappedAndOnce(t *testing.T) {
+	ctx := context.Background()
+	f := newDisputeFixture(t)
+	walletService := NewWalletService(f.db, f.walletRepo)
+
+	// spent before the dispute was opened, only what is left can be taken back
+	if _, err := walletService.Spend(ctx, "user-1", &dto.WalletSpendRequest{OperationID: "spend-1", Amount: 200}); err != nil {
+		t.Fatalf("spend: %v", err)
+	}
+
+	f.store(t, "OPEN", "")
+	f.store(t, "RESOLVED", "RESOLVED_BUYER_FAVOUR")
+	if got := f.coins(t); got != 0 {
+		t.Fatalf("coins after the revoke = %d, want 0", got)
+	}
+	if freeze := f.freezes(t)["coins"]; freeze.RevokedQuantity != 300 {
+		t.Fatalf("revoked %d coins, want 300", freeze.RevokedQuantity)
+	}
+
+	// coins earned afterwards are not touched by a redelivered resolution
+	if _, err := walletService.Grant(ctx, "user-1", &dto.WalletAdjustRequest{OperationID: "grant-1", Amount: 100}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	f.store(t, "RESOLVED", "RESOLVED_BUYER_FAVOUR")
+	if got := f.coins(t); got != 100 {
+		t.Fatalf("coins after the redelivery = %d, want 100", got)
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EntitlementService interface {
+	CreateEntitlement(ctx context.Context, req *dto.CreateEntitlementRequest) (*model.Entitlement, error)
+	GetPasses(ctx context.Context, userID string) ([]*model.UserPass, error)
+	// RunExpirySweeper marks passes expired every interval until ctx is done
+	RunExpirySweeper(ctx context.Context, interval time.Duration)
+}
+
+type entitlementServiceImpl struct {
+	entitlementRepo repository.EntitlementRepository
+}
+
+func NewEntitlementService(entitlementRepo repository.EntitlementRepository) EntitlementService {
+	return &entitlementServiceImpl{
+		entitlementRepo: entitlementRepo,
+	}
+}
+
+func (s *entitlementServiceImpl) CreateEntitlement(ctx context.Context, req *dto.CreateEntitlementRequest) (*model.Entitlement, error) {
+	if req.ID == "" || req.Name == "" {
+		return nil, fmt.Errorf("%w: id and name are required", ErrInvalidEntitlement)
+	}
+	switch model.EntitlementType(req.Type) {
+	case model.ENTITLEMENT_CURRENCY, model.ENTITLEMENT_CONSUMABLE, model.ENTITLEMENT_DURABLE, model.ENTITLEMENT_PASS:
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidEntitlement, req.Type)
+	}
+
+	entitlement := &model.Entitlement{
+		ID:   req.ID,
+		Name: req.Name,
+		Type: req.Type,
+	}
+	if err := s.entitlementRepo.CreateEntitlement(ctx, entitlement); err != nil {
+		return nil, fmt.Errorf("create entitlement: %w", err)
+	}
+
+	return entitlement, nil
+}
+
+func (s *entitlementServiceImpl) GetPasses(ctx context.Context, userID string) ([]*model.UserPass, error) {
+	return s.entitlementRepo.GetPasses(ctx, userID)
+}
+
+func (s *entitlementServiceImpl) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expired, err := s.entitlementRepo.ExpirePasses(ctx, now)
+			if err != nil {
+				log.Printf("expire passes: %v", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("expired %d pass(es)", expired)
+			}
+		}
+	}
+}
+
+// itemGranter delivers what paid orders bought, for every service that sells
+type itemGranter struct {
+	orderRepo       repository.OrderRepository
+	entitlementRepo repository.EntitlementRepository
+	walletRepo      repository.WalletRepository
+	inventoryRepo   repository.InventoryRepository
+}
+
+// grantItems expands every paid item into the entitlements of its product
+func (s *itemGranter) grantItems(ctx context.Context, tx *gorm.DB, userID string, items []*model.OrderItem) error {
+	byProduct, err := s.productGrants(ctx, tx, items)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		for _, grant := range byProduct[item.ProductID] {
+			if err := s.grantEntitlement(ctx, tx, userID, item, grant); err != nil {
+				return fmt.Errorf("grant %s for %s: %w", grant.EntitlementID, item.ProductID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// productGrants returns the entitlements each item's product grants
+func (s *itemGranter) productGrants(ctx context.Context, tx *gorm.DB, items []*model.OrderItem) (map[string][]*model.EntitlementGrant, error) {
+	productIDs := make([]string, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	grants, err := s.entitlementRepo.GetProductEntitlements(ctx, tx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get product entitlements: %w", err)
+	}
+	byProduct := make(map[string][]*model.EntitlementGrant, len(items))
+	for _, grant := range grants {
+		byProduct[grant.ProductID] = append(byProduct[grant.ProductID], grant)
+	}
+
+	for _, item := range items {
+		if len(byProduct[item.ProductID]) == 0 {
+			// products without entitlements are granted as themselves
+			byProduct[item.ProductID] = []*model.EntitlementGrant{{
+				ProductID:     item.ProductID,
+				EntitlementID: item.ProductID,
+				Type:          string(model.ENTITLEMENT_CONSUMABLE),
+				Quantity:      1,
+			}}
+		}
+	}
+
+	return byProduct, nil
+}
+
+// _grantKeyPrefix starts the idempotency key of every grant made for the item
+func _grantKeyPrefix(item *model.OrderItem) string {
+	return fmt.Sprintf("order_item:%d:", item.ID)
+}
+
+// _legacyGrantKey is the key coins were credited under before products were
+// mapped to entitlements
+func _legacyGrantKey(item *model.OrderItem) string {
+	return fmt.Sprintf("purchase:%d", item.ID)
+}
+
+func (s *itemGranter) grantEntitlement(ctx context.Context, tx *gorm.DB, userID string, item *model.OrderItem, grant *model.EntitlementGrant) error {
+	// a redelivered capture webhook must not grant anything twice
+	key := _grantKeyPrefix(item) + grant.EntitlementID
+	reason := fmt.Sprintf("order %s: %d x %s", item.OrderID, item.Quantity, item.ProductID)
+
+	switch model.EntitlementType(grant.Type) {
+	case model.ENTITLEMENT_CURRENCY:
+		// an item paid before the mapping already got its coins
+		_, err := s.walletRepo.FindOperation(ctx, tx, _legacyGrantKey(item))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("find legacy wallet operation: %w", err)
+		}
+
+		amount := grant.Quantity * int64(item.Quantity)
+		_, err = applyWalletOperation(ctx, tx, s.walletRepo, &model.WalletOperation{
+			OperationID: key,
+			Type:        string(model.WALLET_PURCHASE),
+			UserID:      userID,
+			Amount:      amount,
+			Reason:      reason,
+		}, []walletChange{{userID: userID, amount: amount}})
+		return err
+
+	case model.ENTITLEMENT_PASS:
+		_, err := s.entitlementRepo.GrantPass(ctx, tx, &model.PassGrant{
+			UserID:         userID,
+			EntitlementID:  grant.EntitlementID,
+			Days:           grant.DurationDays * item.Quantity,
+			SourceType:     string(model.INVENTORY_SOURCE_ORDER),
+			SourceID:       item.OrderID,
+			IdempotencyKey: key,
+		})
+		return err
+
+	case model.ENTITLEMENT_DURABLE:
+		owned, err := s.inventoryRepo.GetQuantity(ctx, tx, userID, grant.EntitlementID)
+		if err != nil {
+			return err
+		}
+		if owned > 0 {
+			return nil
+		}
+		_, err = s.inventoryRepo.Apply(ctx, tx, &model.InventoryLedgerEntry{
+			UserID:         userID,
+			ProductID:      grant.EntitlementID,
+			Delta:          1,
+			Reason:         reason,
+			SourceType:     string(model.INVENTORY_SOURCE_ORDER),
+			SourceID:       item.OrderID,
+			IdempotencyKey: key,
+		})
+		return err
+	}
+
+	_, err := s.inventoryRepo.Apply(ctx, tx, &model.InventoryLedgerEntry{
+		UserID:         userID,
+		ProductID:      grant.EntitlementID,
+		Delta:          int32(grant.Quantity) * item.Quantity,
+		Reason:         reason,
+		SourceType:     string(model.INVENTORY_SOURCE_ORDER),
+		SourceID:       item.OrderID,
+		IdempotencyKey: key,
+	})
+	return err
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnknownClientProfile is returned for a client profile that is not allow-listed
+var ErrUnknownClientProfile = errors.New("unknown client profile")
+
+// VaultChargeError means a saved payment method could not be charged. The
+// caller should fall back to the approval flow (Pay) so the buyer can pick
+// or fix a funding source on PayPal.
+type VaultChargeError struct {
+	OrderID string // empty when PayPal rejected the order outright
+	Status  string // DECLINED, PAYER_ACTION_REQUIRED, ...
+	Reason  string // PayPal issue code when available
+}
+
+func (e *VaultChargeError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("vault charge failed: %s (%s)", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("vault charge failed: %s", e.Status)
+}
+
+// CardPaymentError means a card payment was not captured, either because the
+// issuer declined it or because 3-D Secure did not shift liability.
+type CardPaymentError struct {
+	OrderID string
+	Status  string // DECLINED, AUTHENTICATION_FAILED
+	Reason  string // PayPal issue code or the 3-D Secure result
+}
+
+func (e *CardPaymentError) Error() string {
+	return fmt.Sprintf("card payment failed: %s (%s)", e.Status, e.Reason)
+}
+
+// InstrumentDeclinedError means the funding source the buyer approved was
+// declined at capture. The order is still open, so the buyer can pick another
+// funding source and approve it again (actions.restart() in the JS SDK).
+type InstrumentDeclinedError struct {
+	OrderID string
+}
+
+func (e *InstrumentDeclinedError) Error() string {
+	return fmt.Sprintf("instrument declined for order %s", e.OrderID)
+}
+
+var (
+	// ErrInsufficientBalance is returned when a wallet cannot cover a spend, transfer or revoke
+	ErrInsufficientBalance = errors.New("insufficient wallet balance")
+	// ErrBalanceFrozen means the balance would cover it, but part of it is frozen by an open dispute
+	ErrBalanceFrozen = errors.New("wallet balance is frozen by an open dispute")
+	// ErrWalletOperationConflict means the operation id was already used for a different request
+	ErrWalletOperationConflict = errors.New("operation id already used for a different request")
+	// ErrInvalidWalletOperation wraps validation failures of a wallet request
+	ErrInvalidWalletOperation = errors.New("invalid wallet operation")
+)
+
+// ErrInvalidInventoryAdjustment wraps validation failures of an admin inventory change
+var ErrInvalidInventoryAdjustment = errors.New("invalid inventory adjustment")
+
+// ErrInvalidEntitlement wraps validation failures of entitlements and product mappings
+var ErrInvalidEntitlement = errors.New("invalid entitlement")
+
+// ErrInvalidPromotion wraps validation failures of an admin promotion request
+var ErrInvalidPromotion = errors.New("invalid promotion")
+
+// CouponError means the coupon the buyer entered cannot be used for this
+// cart, or a promotion ran out between pricing and reservation
+type CouponError struct {
+	Code   string // empty for automatic promotions
+	Reason string
+}
+
+func (e *CouponError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("promotion not applicable: %s", e.Reason)
+	}
+	return fmt.Sprintf("coupon %s not applicable: %s", e.Code, e.Reason)
+}
+
+// ErrInvalidPurchaseLimit wraps validation failures of an admin limit override
+var ErrInvalidPurchaseLimit = errors.New("invalid purchase limit")
+
+// PurchaseLimitError means the checkout would take the user over one of their
+// purchase limits. Amounts are in minor units (cents).
+type PurchaseLimitError struct {
+	Limit      string // e.g. max_order_amount, daily_spend, merchant_weekly_spend, sku_quantity, pay_attempts
+	MerchantID string // merchant_* limits only
+	ProductID  string // sku_quantity only
+	Max        int64
+	Current    int64 // spent in the window or attempts made, 0 for per order limits
+	Requested  int64
+	// RetryAfter is set for pay_attempts
+	RetryAfter time.Duration
+}
+
+func (e *PurchaseLimitError) Error() string {
+	return fmt.Sprintf("purchase limit %s exceeded: %d + %d > %d", e.Limit, e.Current, e.Requested, e.Max)
+}
+
+// RiskError means the risk rules did not let a saved payment method be
+// charged. On review the buyer may still pay by approving on PayPal.
+type RiskError struct {
+	DecisionID uint
+	Decision   string // review or deny
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("saved payment method charge refused by risk decision %d: %s", e.DecisionID, e.Decision)
+}
+
+// ErrInvalidClientMetadataID is returned for a device fingerprint PayPal would reject
+var ErrInvalidClientMetadataID = errors.New("invalid client metadata id")
+
+// ErrInvalidDisputeAction wraps validation failures of an admin dispute answer
+var ErrInvalidDisputeAction = errors.New("invalid dispute action")
+
+// ErrDisputeResolved is returned when answering a dispute PayPal already closed
+var ErrDisputeResolved = errors.New("dispute is already resolved")
+
+// ErrDisputeActionRejected wraps PayPal refusing an answer, e.g. in the dispute's current stage
+var ErrDisputeActionRejected = errors.New("paypal rejected the dispute action")
+
+var (
+	// ErrInvalidPayout wraps validation failures of a payout or earnings request
+	ErrInvalidPayout = errors.New("invalid payout")
+	// ErrInsufficientEarnings is returned when a payout is larger than the earnings balance
+	ErrInsufficientEarnings = errors.New("insufficient earnings")
+	// ErrPayoutConflict means the payout or operation id was already used for a different request
+	ErrPayoutConflict = errors.New("payout id already used for a different request")
+	// ErrPayoutNotPending is returned when approving or rejecting a payout that is not waiting for approval
+	ErrPayoutNotPending = errors.New("payout is not waiting for approval")
+)
+
+var (
+	// ErrInvalidInvoice wraps validation failures of an admin invoice request
+	ErrInvalidInvoice = errors.New("invalid invoice")
+	// ErrInvoiceState is returned for an action the invoice's status does not allow
+	ErrInvoiceState = errors.New("invoice status does not allow this action")
+	// ErrInvoiceActionRejected wraps PayPal refusing an invoice action
+	ErrInvoiceActionRejected = errors.New("paypal rejected the invoice action")
+)
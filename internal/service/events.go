@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"paypal-integration-demo/internal/dto"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// order event types pushed to GET /api/orders/:id/events
+const (
+	OrderEventApproved = "approved"
+	OrderEventCaptured = "captured"
+	OrderEventGranted  = "granted"
+	OrderEventFailed   = "failed"
+)
+
+// OrderEventBus fans order events out to the SSE streams watching the order.
+// It is in-process only, each instance notifies its own subscribers, so every
+// service that changes orders must share the same one.
+type OrderEventBus struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan *dto.OrderEvent]struct{}
+}
+
+func NewOrderEventBus() *OrderEventBus {
+	return &OrderEventBus{
+		subscribers: map[string]map[chan *dto.OrderEvent]struct{}{},
+	}
+}
+
+func (b *OrderEventBus) subscribe(orderID string) (chan *dto.OrderEvent, func()) {
+	ch := make(chan *dto.OrderEvent, 16)
+
+	b.mu.Lock()
+	if b.subscribers[orderID] == nil {
+		b.subscribers[orderID] = map[chan *dto.OrderEvent]struct{}{}
+	}
+	b.subscribers[orderID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[orderID], ch)
+		if len(b.subscribers[orderID]) == 0 {
+			delete(b.subscribers, orderID)
+		}
+	}
+	return ch, unsubscribe
+}
+
+func (b *OrderEventBus) publish(events ...*dto.OrderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		for ch := range b.subscribers[event.OrderID] {
+			// a slow stream misses events rather than blocking payments
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// orderEvents collects the events of one transaction
+type orderEvents []*dto.OrderEvent
+
+func (e *orderEvents) add(orderID string, eventType string, status string) {
+	*e = append(*e, &dto.OrderEvent{
+		OrderID: orderID,
+		Type:    eventType,
+		Status:  status,
+		At:      time.Now(),
+	})
+}
+
+// transaction runs fn in a transaction and publishes the events it collected
+// only once the transaction has committed
+func (b *OrderEventBus) transaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB, events *orderEvents) error) error {
+	var events orderEvents
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		events = events[:0]
+		return fn(tx, &events)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.publish(events...)
+	return nil
+}
+
+func (s *paypalServiceImpl) orderTransaction(ctx context.Context, fn func(tx *gorm.DB, events *orderEvents) error) error {
+	return s.orderEvents.transaction(ctx, s.db, fn)
+}
+
+// SubscribeOrderEvents returns the current state of the user's order followed
+// by its events. Call the returned func to stop receiving them.
+func (s *paypalServiceImpl) SubscribeOrderEvents(ctx context.Context, userID string, orderID string) (*dto.OrderEvent, <-chan *dto.OrderEvent, func(), error) {
+	// subscribe first so nothing committed after the snapshot is missed
+	ch, unsubscribe := s.orderEvents.subscribe(orderID)
+
+	orderDetail, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		unsubscribe()
+		return nil, nil, nil, fmt.Errorf("get order detail: %w", err)
+	}
+	// someone else's order is reported as not found
+	if orderDetail.UserID != userID {
+		unsubscribe()
+		return nil, nil, nil, fmt.Errorf("get order detail: %w", gorm.ErrRecordNotFound)
+	}
+
+	current := &dto.OrderEvent{
+		OrderID: orderID,
+		Type:    "status",
+		Status:  orderDetail.Status,
+		At:      orderDetail.UpdatedAt,
+	}
+	return current, ch, unsubscribe, nil
+}
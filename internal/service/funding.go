@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"slices"
+)
+
+// Pay Later offers (Pay in 4 and Pay Monthly) only cover purchases in this range
+const (
+	payLaterMinAmount = 30
+	payLaterMaxAmount = 10000
+)
+
+// _eligibleFundingSources lists the approval funding sources PayPal offers for
+// an amount. Venmo and Pay Later are US only, so both require USD.
+func _eligibleFundingSources(currency string, amount int32) []model.FundingSource {
+	sources := []model.FundingSource{model.FUNDING_PAYPAL}
+	if currency != "USD" {
+		return sources
+	}
+
+	sources = append(sources, model.FUNDING_VENMO)
+	if amount >= payLaterMinAmount && amount <= payLaterMaxAmount {
+		sources = append(sources, model.FUNDING_PAYLATER)
+	}
+	return sources
+}
+
+func (s *paypalServiceImpl) EligibleFundingSources(ctx context.Context, items []*dto.Item) ([]model.FundingSource, error) {
+	productIDs := make([]string, len(items))
+	for i, item := range items {
+		productIDs[i] = item.Sku
+	}
+
+	products, err := s.productRepo.FindMany(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get many products by item ids: %w", err)
+	}
+
+	amount := int32(0)
+	for _, product := range products {
+		for _, item := range items {
+			if item.Sku == product.ID {
+				amount += product.Price * item.Quantity
+			}
+		}
+	}
+
+	// orders are always placed in USD
+	return _eligibleFundingSources("USD", amount), nil
+}
+
+func _checkFundingSource(fundingSource model.FundingSource, total int32) error {
+	if !slices.Contains(_eligibleFundingSources("USD", total), fundingSource) {
+		return fmt.Errorf("funding source %s is not available for this purchase", fundingSource)
+	}
+	return nil
+}
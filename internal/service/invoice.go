@@ -0,0 +1,518 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const invoiceDateLayout = "2006-01-02"
+
+// invoiceOpenStatuses are sent invoices still waiting for the money
+var invoiceOpenStatuses = map[string]bool{
+	"SENT":           true,
+	"UNPAID":         true,
+	"PARTIALLY_PAID": true,
+}
+
+type InvoiceService interface {
+	CreateInvoice(ctx context.Context, req *dto.CreateInvoiceRequest) (*model.Invoice, error)
+	ListInvoices(ctx context.Context, query *dto.InvoiceQuery) ([]*model.Invoice, error)
+	GetInvoice(ctx context.Context, invoiceID string) (*dto.InvoiceDetail, error)
+	SendInvoice(ctx context.Context, invoiceID string) (*model.Invoice, error)
+	RemindInvoice(ctx context.Context, invoiceID string, req *dto.InvoiceActionRequest) (*model.Invoice, error)
+	CancelInvoice(ctx context.Context, invoiceID string, req *dto.InvoiceActionRequest) (*model.Invoice, error)
+	RecordInvoicePayment(ctx context.Context, invoiceID string, req *dto.RecordInvoicePaymentRequest) (*model.Invoice, error)
+	GetInvoiceQRCode(ctx context.Context, invoiceID string) (*dto.InvoiceQRCode, error)
+	// HandleInvoiceEvent applies an INVOICING.INVOICE.PAID or CANCELLED webhook event
+	HandleInvoiceEvent(ctx context.Context, event *model.PayPalWebhookEvent) error
+}
+
+type invoiceServiceImpl struct {
+	merchantAuth
+	itemGranter
+	db          *gorm.DB
+	orderEvents *OrderEventBus
+	productRepo repository.ProductRepository
+	invoiceRepo repository.InvoiceRepository
+}
+
+func NewInvoiceService(
+	db *gorm.DB,
+	paypalClient client.PaypalClient,
+	orderEvents *OrderEventBus,
+	merchantRepo repository.MerchantRepository,
+	productRepo repository.ProductRepository,
+	orderRepo repository.OrderRepository,
+	entitlementRepo repository.EntitlementRepository,
+	walletRepo repository.WalletRepository,
+	inventoryRepo repository.InventoryRepository,
+	invoiceRepo repository.InvoiceRepository,
+) InvoiceService {
+	return &invoiceServiceImpl{
+		merchantAuth: merchantAuth{
+			merchantRepo: merchantRepo,
+			paypalClient: paypalClient,
+		},
+		itemGranter: itemGranter{
+			orderRepo:       orderRepo,
+			entitlementRepo: entitlementRepo,
+			walletRepo:      walletRepo,
+			inventoryRepo:   inventoryRepo,
+		},
+		db:          db,
+		orderEvents: orderEvents,
+		productRepo: productRepo,
+		invoiceRepo: invoiceRepo,
+	}
+}
+
+// CreateInvoice creates a PayPal draft invoice for catalog products of one
+// merchant and stores its items as an order of the user, granted when the
+// invoice is paid
+func (s *invoiceServiceImpl) CreateInvoice(ctx context.Context, req *dto.CreateInvoiceRequest) (*model.Invoice, error) {
+	if req.MerchantID == "" || req.UserID == "" || req.Actor == "" {
+		return nil, fmt.Errorf("%w: merchant_id, user_id and actor are required", ErrInvalidInvoice)
+	}
+	if !strings.Contains(req.RecipientEmail, "@") {
+		return nil, fmt.Errorf("%w: recipient_email is required", ErrInvalidInvoice)
+	}
+	if req.DueDate != "" {
+		if _, err := time.Parse(invoiceDateLayout, req.DueDate); err != nil {
+			return nil, fmt.Errorf("%w: due_date must be YYYY-MM-DD", ErrInvalidInvoice)
+		}
+	}
+
+	if _, err := s.merchantRepo.Get(ctx, req.MerchantID); err != nil {
+		return nil, fmt.Errorf("%w: merchant %s not found", ErrInvalidInvoice, req.MerchantID)
+	}
+
+	quantities := make(map[string]int32)
+	var productIDs []string
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: quantity of %s must be positive", ErrInvalidInvoice, item.Sku)
+		}
+		if _, ok := quantities[item.Sku]; !ok {
+			productIDs = append(productIDs, item.Sku)
+		}
+		quantities[item.Sku] += item.Quantity
+	}
+	if len(productIDs) == 0 {
+		return nil, fmt.Errorf("%w: at least one item is required", ErrInvalidInvoice)
+	}
+
+	products, err := s.productRepo.FindMany(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get products: %w", err)
+	}
+	if len(products) != len(productIDs) {
+		return nil, fmt.Errorf("%w: some products not found", ErrInvalidInvoice)
+	}
+
+	currency := products[0].Currency
+	var amount int32
+	var invoiceItems []*client.InvoiceItem
+	var orderItems []*model.OrderItem
+	for _, product := range products {
+		if product.MerchantID != "" && product.MerchantID != req.MerchantID {
+			return nil, fmt.Errorf("%w: product %s is not sold by merchant %s", ErrInvalidInvoice, product.ID, req.MerchantID)
+		}
+		if product.Type != string(model.ONE_TIME) {
+			return nil, fmt.Errorf("%w: product %s is a subscription", ErrInvalidInvoice, product.ID)
+		}
+		if product.Currency != currency {
+			return nil, fmt.Errorf("%w: products are priced in more than one currency", ErrInvalidInvoice)
+		}
+
+		quantity := quantities[product.ID]
+		amount += product.Price * quantity
+		invoiceItems = append(invoiceItems, &client.InvoiceItem{
+			Name:        product.Name,
+			Description: product.Description,
+			Quantity:    quantity,
+			UnitAmount:  int64(product.Price) * 100,
+		})
+		orderItems = append(orderItems, &model.OrderItem{
+			ProductID: product.ID,
+			Quantity:  quantity,
+			UnitPrice: product.Price,
+			Currency:  product.Currency,
+		})
+	}
+
+	auth, _, err := s.resolveMerchantAuth(ctx, req.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	invoiceID, err := s.paypalClient.CreateDraftInvoice(ctx, &client.InvoiceRequest{
+		Reference:      req.UserID,
+		RecipientEmail: req.RecipientEmail,
+		Currency:       currency,
+		Note:           req.Note,
+		DueDate:        req.DueDate,
+		Items:          invoiceItems,
+	}, auth)
+	if err != nil {
+		return nil, _invoiceActionError(err)
+	}
+
+	invoice := &model.Invoice{
+		InvoiceID:      invoiceID,
+		UserID:         req.UserID,
+		MerchantID:     req.MerchantID,
+		RecipientEmail: req.RecipientEmail,
+		Amount:         int64(amount) * 100,
+		Currency:       currency,
+		Status:         "DRAFT",
+		DueDate:        req.DueDate,
+		Note:           req.Note,
+		CreatedBy:      req.Actor,
+	}
+	// the invoice number is only known once PayPal assigned it
+	if paypalInvoice, err := s.paypalClient.GetInvoice(ctx, invoiceID, auth); err != nil {
+		log.Printf("get invoice %s: %v", invoiceID, err)
+	} else {
+		invoice.InvoiceNumber = paypalInvoice.Detail.InvoiceNumber
+	}
+
+	for _, item := range orderItems {
+		item.OrderID = invoiceID
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.invoiceRepo.Create(ctx, tx, invoice); err != nil {
+			return fmt.Errorf("store invoice: %w", err)
+		}
+
+		err := s.orderRepo.Create(ctx, tx, &model.Order{
+			OrderID:       invoiceID,
+			UserID:        req.UserID,
+			Status:        "CREATED",
+			Amount:        amount,
+			Currency:      currency,
+			MerchantID:    req.MerchantID,
+			FundingSource: string(model.FUNDING_INVOICE),
+		})
+		if err != nil {
+			return fmt.Errorf("store invoice order: %w", err)
+		}
+		if err := s.orderRepo.CreateOrderItems(ctx, tx, orderItems); err != nil {
+			return fmt.Errorf("store invoice items: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Send {
+		sent, err := s.SendInvoice(ctx, invoiceID)
+		if err != nil {
+			return nil, fmt.Errorf("invoice %s kept as draft: %w", invoiceID, err)
+		}
+		return sent, nil
+	}
+
+	return invoice, nil
+}
+
+func (s *invoiceServiceImpl) ListInvoices(ctx context.Context, query *dto.InvoiceQuery) ([]*model.Invoice, error) {
+	invoices, err := s.invoiceRepo.Search(ctx, &repository.InvoiceFilter{
+		UserID:     query.UserID,
+		MerchantID: query.MerchantID,
+		Status:     query.Status,
+		Limit:      query.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search invoices: %w", err)
+	}
+	return invoices, nil
+}
+
+func (s *invoiceServiceImpl) GetInvoice(ctx context.Context, invoiceID string) (*dto.InvoiceDetail, error) {
+	invoice, err := s.invoiceRepo.Get(ctx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.orderRepo.GetOrderItems(ctx, s.db, invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("get invoice items: %w", err)
+	}
+	productIDs := make([]string, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	products, err := s.productRepo.FindMany(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get products: %w", err)
+	}
+	productNames := make(map[string]string, len(products))
+	for _, product := range products {
+		productNames[product.ID] = product.Name
+	}
+
+	detail := &dto.InvoiceDetail{Invoice: invoice}
+	for _, item := range items {
+		detail.Items = append(detail.Items, &dto.OrderItemDetail{
+			ProductID: item.ProductID,
+			Name:      productNames[item.ProductID],
+			Quantity:  item.Quantity,
+			UnitPrice: _formatAmount(item.UnitPrice),
+			Currency:  item.Currency,
+		})
+	}
+
+	return detail, nil
+}
+
+func (s *invoiceServiceImpl) SendInvoice(ctx context.Context, invoiceID string) (*model.Invoice, error) {
+	return s.invoiceAction(ctx, invoiceID, map[string]bool{"DRAFT": true}, "SENT", func(auth *client.MerchantAuth) error {
+		return s.paypalClient.SendInvoice(ctx, invoiceID, auth)
+	})
+}
+
+func (s *invoiceServiceImpl) RemindInvoice(ctx context.Context, invoiceID string, req *dto.InvoiceActionRequest) (*model.Invoice, error) {
+	return s.invoiceAction(ctx, invoiceID, invoiceOpenStatuses, "", func(auth *client.MerchantAuth) error {
+		return s.paypalClient.RemindInvoice(ctx, invoiceID, req.Note, auth)
+	})
+}
+
+func (s *invoiceServiceImpl) CancelInvoice(ctx context.Context, invoiceID string, req *dto.InvoiceActionRequest) (*model.Invoice, error) {
+	_, err := s.invoiceAction(ctx, invoiceID, invoiceOpenStatuses, "", func(auth *client.MerchantAuth) error {
+		return s.paypalClient.CancelInvoice(ctx, invoiceID, req.Note, auth)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the CANCELLED webhook finds the invoice already cancelled
+	if err := s.markInvoiceCancelled(ctx, invoiceID); err != nil {
+		return nil, err
+	}
+	return s.invoiceRepo.Get(ctx, invoiceID)
+}
+
+// RecordInvoicePayment tells PayPal the invoice was paid in full outside
+// PayPal and grants the items right away
+func (s *invoiceServiceImpl) RecordInvoicePayment(ctx context.Context, invoiceID string, req *dto.RecordInvoicePaymentRequest) (*model.Invoice, error) {
+	if req.Method == "" {
+		return nil, fmt.Errorf("%w: method is required", ErrInvalidInvoice)
+	}
+	paymentDate := req.PaymentDate
+	if paymentDate == "" {
+		paymentDate = time.Now().UTC().Format(invoiceDateLayout)
+	}
+	if _, err := time.Parse(invoiceDateLayout, paymentDate); err != nil {
+		return nil, fmt.Errorf("%w: payment_date must be YYYY-MM-DD", ErrInvalidInvoice)
+	}
+
+	_, err := s.invoiceAction(ctx, invoiceID, invoiceOpenStatuses, "", func(auth *client.MerchantAuth) error {
+		invoice, err := s.invoiceRepo.Get(ctx, invoiceID)
+		if err != nil {
+			return err
+		}
+		_, err = s.paypalClient.RecordInvoicePayment(ctx, invoiceID, &client.InvoicePayment{
+			Method:      strings.ToUpper(req.Method),
+			PaymentDate: paymentDate,
+			Currency:    invoice.Currency,
+			AmountMinor: invoice.Amount,
+			Note:        req.Note,
+		}, auth)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.markInvoicePaid(ctx, invoiceID, "MARKED_AS_PAID"); err != nil {
+		return nil, err
+	}
+	return s.invoiceRepo.Get(ctx, invoiceID)
+}
+
+func (s *invoiceServiceImpl) GetInvoiceQRCode(ctx context.Context, invoiceID string) (*dto.InvoiceQRCode, error) {
+	var image string
+	_, err := s.invoiceAction(ctx, invoiceID, invoiceOpenStatuses, "", func(auth *client.MerchantAuth) error {
+		var err error
+		image, err = s.paypalClient.GenerateInvoiceQRCode(ctx, invoiceID, auth)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.InvoiceQRCode{InvoiceID: invoiceID, Image: image}, nil
+}
+
+// invoiceAction calls PayPal as the invoice's merchant when the invoice is in
+// one of the allowed statuses, then moves it to status unless that is empty
+func (s *invoiceServiceImpl) invoiceAction(ctx context.Context, invoiceID string, allowed map[string]bool, status string, call func(auth *client.MerchantAuth) error) (*model.Invoice, error) {
+	invoice, err := s.invoiceRepo.Get(ctx, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed[invoice.Status] {
+		return nil, fmt.Errorf("%w: invoice is %s", ErrInvoiceState, invoice.Status)
+	}
+
+	auth, _, err := s.resolveMerchantAuth(ctx, invoice.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+	if err := call(auth); err != nil {
+		return nil, _invoiceActionError(err)
+	}
+	if status == "" {
+		return invoice, nil
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		invoice, err = s.invoiceRepo.GetForUpdate(ctx, tx, invoiceID)
+		if err != nil {
+			return fmt.Errorf("get invoice: %w", err)
+		}
+		invoice.Status = status
+		return s.invoiceRepo.Update(ctx, tx, invoice)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update invoice: %w", err)
+	}
+	return invoice, nil
+}
+
+func _invoiceActionError(err error) error {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+		return fmt.Errorf("%w: %s", ErrInvoiceActionRejected, apiErr.Issue)
+	}
+	return err
+}
+
+func (s *invoiceServiceImpl) HandleInvoiceEvent(ctx context.Context, event *model.PayPalWebhookEvent) error {
+	invoiceID := event.Resource.Invoice.ID
+	if invoiceID == "" {
+		invoiceID = event.Resource.ID
+	}
+	if invoiceID == "" {
+		return fmt.Errorf("missing invoice id in %s event payload", event.EventType)
+	}
+
+	invoice, err := s.invoiceRepo.Get(ctx, invoiceID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// an invoice the merchant created on PayPal, not through us
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get invoice: %w", err)
+	}
+
+	// webhook payloads are not trusted to say the invoice was paid, PayPal is asked
+	auth, _, err := s.resolveMerchantAuth(ctx, invoice.MerchantID)
+	if err != nil {
+		return err
+	}
+	remote, err := s.paypalClient.GetInvoice(ctx, invoiceID, auth)
+	if err != nil {
+		return fmt.Errorf("get paypal invoice: %w", err)
+	}
+
+	switch {
+	case _invoicePaid(remote.Status):
+		return s.markInvoicePaid(ctx, invoiceID, remote.Status)
+	case remote.Status == "CANCELLED":
+		return s.markInvoiceCancelled(ctx, invoiceID)
+	}
+	return s.updateInvoiceStatus(ctx, invoiceID, remote.Status)
+}
+
+// _invoicePaid reports whether PayPal considers the invoice paid in full
+func _invoicePaid(status string) bool {
+	return status == "PAID" || status == "MARKED_AS_PAID"
+}
+
+// markInvoicePaid grants the invoiced items, only once however often PayPal
+// tells the invoice was paid
+func (s *invoiceServiceImpl) markInvoicePaid(ctx context.Context, invoiceID string, status string) error {
+	if !_invoicePaid(status) {
+		return fmt.Errorf("%w: invoice is %s", ErrInvoiceState, status)
+	}
+
+	return s.orderEvents.transaction(ctx, s.db, func(tx *gorm.DB, events *orderEvents) error {
+		invoice, err := s.invoiceRepo.GetForUpdate(ctx, tx, invoiceID)
+		if err != nil {
+			return fmt.Errorf("get invoice: %w", err)
+		}
+		if invoice.PaidAt != nil {
+			return nil
+		}
+
+		now := time.Now()
+		invoice.Status = status
+		invoice.PaidAt = &now
+		if err := s.invoiceRepo.Update(ctx, tx, invoice); err != nil {
+			return fmt.Errorf("update invoice: %w", err)
+		}
+
+		if _, err := s.grantWholeOrder(ctx, tx, invoiceID); err != nil {
+			return err
+		}
+		events.add(invoiceID, OrderEventGranted, "PAID")
+		return nil
+	})
+}
+
+// updateInvoiceStatus records a status without granting anything, e.g.
+// PARTIALLY_PAID. Paid invoices keep their status.
+func (s *invoiceServiceImpl) updateInvoiceStatus(ctx context.Context, invoiceID string, status string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		invoice, err := s.invoiceRepo.GetForUpdate(ctx, tx, invoiceID)
+		if err != nil {
+			return fmt.Errorf("get invoice: %w", err)
+		}
+		if invoice.PaidAt != nil || invoice.Status == status {
+			return nil
+		}
+
+		invoice.Status = status
+		if err := s.invoiceRepo.Update(ctx, tx, invoice); err != nil {
+			return fmt.Errorf("update invoice: %w", err)
+		}
+		return nil
+	})
+}
+
+// markInvoiceCancelled leaves paid invoices alone, their items were granted
+func (s *invoiceServiceImpl) markInvoiceCancelled(ctx context.Context, invoiceID string) error {
+	return s.orderEvents.transaction(ctx, s.db, func(tx *gorm.DB, events *orderEvents) error {
+		invoice, err := s.invoiceRepo.GetForUpdate(ctx, tx, invoiceID)
+		if err != nil {
+			return fmt.Errorf("get invoice: %w", err)
+		}
+		if invoice.PaidAt != nil || invoice.Status == "CANCELLED" {
+			return nil
+		}
+
+		invoice.Status = "CANCELLED"
+		if err := s.invoiceRepo.Update(ctx, tx, invoice); err != nil {
+			return fmt.Errorf("update invoice: %w", err)
+		}
+
+		if err := s.orderRepo.UpdateStatus(ctx, tx, invoiceID, "CANCELLED"); err != nil {
+			return fmt.Errorf("cancel invoice order: %w", err)
+		}
+		events.add(invoiceID, OrderEventFailed, "CANCELLED")
+		return nil
+	})
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+type invoiceFixture struct {
+	db          *gorm.DB
+	service     *invoiceServiceImpl
+	walletRepo  repository.WalletRepository
+	invoiceRepo repository.InvoiceRepository
+	// remoteStatus is what PayPal answers for the invoice
+	remoteStatus string
+}
+
+// newInvoiceFixture stores invoice INV2-1 of merchant-1 billing user-1 for a
+// starter pack (500 coins, the starter skin and 7 days of VIP)
+func newInvoiceFixture(t *testing.T) *invoiceFixture {
+	t.Helper()
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	f := &invoiceFixture{
+		db:          db,
+		walletRepo:  repository.NewWalletRepository(db),
+		invoiceRepo: repository.NewInvoiceRepository(db),
+	}
+	paypalClient := &fakePaypalClient{
+		getInvoice: func(invoiceID string) (*model.PaypalInvoice, error) {
+			return &model.PaypalInvoice{ID: invoiceID, Status: f.remoteStatus}, nil
+		},
+	}
+	entitlementRepo := repository.NewEntitlementRepository(db)
+	f.service = NewInvoiceService(
+		db,
+		paypalClient,
+		NewOrderEventBus(),
+		repository.NewMerchantRepository(db),
+		repository.NewProductRepository(db),
+		repository.NewOrderRepository(db),
+		entitlementRepo,
+		f.walletRepo,
+		repository.NewInventoryRepository(db),
+		f.invoiceRepo,
+	).(*invoiceServiceImpl)
+
+	if err := entitlementRepo.Seed(ctx); err != nil {
+		t.Fatalf("seed entitlements: %v", err)
+	}
+
+	merchant := &model.Merchant{ID: "merchant-1", PayPalMerchantID: "PAYER-1", OnboardingStatus: string(model.ONBOARDING_COMPLETED)}
+	invoice := &model.Invoice{InvoiceID: "INV2-1", UserID: "user-1", MerchantID: "merchant-1", RecipientEmail: "buyer@example.com", Amount: 500, Currency: "USD", Status: "SENT"}
+	order := &model.Order{OrderID: "INV2-1", Status: "CREATED", UserID: "user-1", Amount: 5, Currency: "USD", MerchantID: "merchant-1", FundingSource: string(model.FUNDING_INVOICE)}
+	item := &model.OrderItem{OrderID: "INV2-1", ProductID: "starter_pack", Quantity: 1, UnitPrice: 5, Currency: "USD"}
+	for _, row := range []interface{}{merchant, invoice, order, item} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+	return f
+}
+
+// handle delivers an invoicing webhook event while PayPal reports status
+func (f *invoiceFixture) handle(t *testing.T, status string) {
+	t.Helper()
+
+	f.remoteStatus = status
+	event := &model.PayPalWebhookEvent{EventType: "INVOICING.INVOICE.PAID"}
+	event.Resource.Invoice.ID = "INV2-1"
+	if err := f.service.HandleInvoiceEvent(context.Background(), event); err != nil {
+		t.Fatalf("handle invoice event (%s): %v", status, err)
+	}
+}
+
+func (f *invoiceFixture) coins(t *testing.T) int64 {
+	t.Helper()
+
+	balance, err := f.walletRepo.GetBalance(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	return balance
+}
+
+func (f *invoiceFixture) invoice(t *testing.T) *model.Invoice {
+	t.Helper()
+
+	invoice, err := f.invoiceRepo.Get(context.Background(), "INV2-1")
+	if err != nil {
+		t.Fatalf("get invoice: %v", err)
+	}
+	return invoice
+}
+
+func TestInvoicePaidGrantsOnce(t *testing.T) {
+	f := newInvoiceFixture(t)
+
+	// PayPal redelivers the event, and a late cancel must not undo the payment
+	f.handle(t, "PAID")
+	f.handle(t, "PAID")
+	f.handle(t, "MARKED_AS_PAID")
+	f.handle(t, "CANCELLED")
+
+	if got := f.coins(t); got != 500 {
+		t.Fatalf("coins = %d, want 500", got)
+	}
+	invoice := f.invoice(t)
+	if invoice.Status != "PAID" || invoice.PaidAt == nil {
+		t.Fatalf("invoice = %s, paid at %v; want PAID, paid", invoice.Status, invoice.PaidAt)
+	}
+}
+
+func TestInvoiceNotPaidDoesNotGrant(t *testing.T) {
+	for _, status := range []string{"SENT", "UNPAID", "PARTIALLY_PAID"} {
+		t.Run(status, func(t *testing.T) {
+			f := newInvoiceFixture(t)
+
+			// the event says paid, PayPal does not
+			f.handle(t, status)
+
+			if got := f.coins(t); got != 0 {
+				t.Fatalf("coins = %d, want 0", got)
+			}
+			invoice := f.invoice(t)
+			if invoice.Status != status || invoice.PaidAt != nil {
+				t.Fatalf("invoice = %s, paid at %v; want %s, unpaid", invoice.Status, invoice.PaidAt, status)
+			}
+
+			// paid later, it is granted then
+			f.handle(t, "PAID")
+			if got := f.coins(t); got != 500 {
+				t.Fatalf("coins after paid = %d, want 500", got)
+			}
+		})
+	}
+}
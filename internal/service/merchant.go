@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"paypal-integration-demo/internal/model"
 	"paypal-integration-demo/internal/repository"
 	"time"
@@ -14,17 +15,30 @@ type MerchantService interface {
 	UpdatePaypalTokens(ctx context.Context, merchantID string, tokens *model.PayPalToken) error
 	GetMerchant(ctx context.Context, id string) (*model.Merchant, error)
 	DisconnectPayPal(ctx context.Context, merchantID string) error
+	SetFeeRule(ctx context.Context, rule *model.MerchantFeeRule) error
+	GetFeeReport(ctx context.Context, merchantID string, from time.Time, to time.Time) ([]*model.PlatformFeeSummary, error)
+	CreateProduct(ctx context.Context, product *model.Product, entitlements []*model.ProductEntitlement) error
+	GetProducts(ctx context.Context, merchantID string) ([]*model.Product, error)
 }
 
 type merchantServiceImpl struct {
-	merchantRepo repository.MerchantRepository
+	merchantRepo    repository.MerchantRepository
+	orderRepo       repository.OrderRepository
+	productRepo     repository.ProductRepository
+	entitlementRepo repository.EntitlementRepository
 }
 
 func NewMerchantService(
 	merchantRepo repository.MerchantRepository,
+	orderRepo repository.OrderRepository,
+	productRepo repository.ProductRepository,
+	entitlementRepo repository.EntitlementRepository,
 ) MerchantService {
 	return &merchantServiceImpl{
-		merchantRepo: merchantRepo,
+		merchantRepo:    merchantRepo,
+		orderRepo:       orderRepo,
+		productRepo:     productRepo,
+		entitlementRepo: entitlementRepo,
 	}
 }
 
@@ -58,3 +72,100 @@ func (s *merchantServiceImpl) GetMerchant(ctx context.Context, id string) (*mode
 func (s *merchantServiceImpl) DisconnectPayPal(ctx context.Context, merchantID string) error {
 	return s.merchantRepo.ClearPayPalTokens(ctx, merchantID)
 }
+
+func (s *merchantServiceImpl) SetFeeRule(ctx context.Context, rule *model.MerchantFeeRule) error {
+	if rule.PercentBps < 0 || rule.PercentBps > 10000 {
+		return fmt.Errorf("percent_bps must be between 0 and 10000")
+	}
+	if rule.FixedFee < 0 {
+		return fmt.Errorf("fixed_fee must not be negative")
+	}
+	if rule.Currency == "" {
+		rule.Currency = "USD"
+	}
+
+	if _, err := s.merchantRepo.Get(ctx, rule.MerchantID); err != nil {
+		return fmt.Errorf("merchant not found")
+	}
+
+	return s.merchantRepo.UpsertFeeRule(ctx, rule)
+}
+
+func (s *merchantServiceImpl) GetFeeReport(ctx context.Context, merchantID string, from time.Time, to time.Time) ([]*model.PlatformFeeSummary, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	return s.orderRepo.SumPlatformFees(ctx, merchantID, from, to)
+}
+
+func (s *merchantServiceImpl) CreateProduct(ctx context.Context, product *model.Product, entitlements []*model.ProductEntitlement) error {
+	if product.ID == "" || product.Name == "" {
+		return fmt.Errorf("product id and name are required")
+	}
+	if product.Price <= 0 {
+		return fmt.Errorf("product price must be positive")
+	}
+	if product.Currency == "" {
+		product.Currency = "USD"
+	}
+	product.Type = string(model.ONE_TIME)
+
+	if _, err := s.merchantRepo.Get(ctx, product.MerchantID); err != nil {
+		return fmt.Errorf("merchant not found")
+	}
+
+	if err := s.checkProductEntitlements(ctx, entitlements); err != nil {
+		return err
+	}
+
+	if err := s.productRepo.Create(ctx, product); err != nil {
+		return err
+	}
+
+	for _, mapping := range entitlements {
+		mapping.ProductID = product.ID
+	}
+	return s.entitlementRepo.SetProductEntitlements(ctx, product.ID, entitlements)
+}
+
+func (s *merchantServiceImpl) checkProductEntitlements(ctx context.Context, mappings []*model.ProductEntitlement) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(mappings))
+	for i, mapping := range mappings {
+		ids[i] = mapping.EntitlementID
+	}
+	entitlements, err := s.entitlementRepo.FindEntitlements(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("get entitlements: %w", err)
+	}
+	types := make(map[string]string, len(entitlements))
+	for _, entitlement := range entitlements {
+		types[entitlement.ID] = entitlement.Type
+	}
+
+	for _, mapping := range mappings {
+		entitlementType, ok := types[mapping.EntitlementID]
+		if !ok {
+			return fmt.Errorf("unknown entitlement %q", mapping.EntitlementID)
+		}
+		if entitlementType == string(model.ENTITLEMENT_PASS) {
+			if mapping.DurationDays <= 0 {
+				return fmt.Errorf("entitlement %q is a pass and needs duration_days", mapping.EntitlementID)
+			}
+			continue
+		}
+		if mapping.Quantity <= 0 {
+			return fmt.Errorf("entitlement %q needs a positive quantity", mapping.EntitlementID)
+		}
+	}
+
+	return nil
+}
+
+func (s *merchantServiceImpl) GetProducts(ctx context.Context, merchantID string) ([]*model.Product, error) {
+	return s.productRepo.GetByMerchant(ctx, merchantID)
+}
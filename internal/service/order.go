@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultOrderPageSize = 20
+	maxOrderPageSize     = 100
+)
+
+// ErrInvalidCursor is returned for a cursor not produced by ListOrders
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type OrderService interface {
+	ListOrders(ctx context.Context, userID string, query *dto.OrderListQuery) (*dto.OrderListResponse, error)
+	GetOrder(ctx context.Context, userID string, orderID string) (*dto.OrderDetail, error)
+}
+
+type orderServiceImpl struct {
+	db          *gorm.DB
+	orderRepo   repository.OrderRepository
+	productRepo repository.ProductRepository
+}
+
+func NewOrderService(
+	db *gorm.DB,
+	orderRepo repository.OrderRepository,
+	productRepo repository.ProductRepository,
+) OrderService {
+	return &orderServiceImpl{
+		db:          db,
+		orderRepo:   orderRepo,
+		productRepo: productRepo,
+	}
+}
+
+func (s *orderServiceImpl) ListOrders(ctx context.Context, userID string, query *dto.OrderListQuery) (*dto.OrderListResponse, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultOrderPageSize
+	}
+	if limit > maxOrderPageSize {
+		limit = maxOrderPageSize
+	}
+
+	filter := &repository.OrderFilter{
+		UserID:     userID,
+		Status:     query.Status,
+		MerchantID: query.MerchantID,
+		ProductID:  query.ProductID,
+		From:       query.From,
+		To:         query.To,
+		// one extra row tells whether there is a next page
+		Limit: limit + 1,
+	}
+	if query.Cursor != "" {
+		createdAt, orderID, err := _decodeOrderCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.AfterCreatedAt = createdAt
+		filter.AfterOrderID = orderID
+	}
+
+	orders, err := s.orderRepo.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("list orders: %w", err)
+	}
+
+	resp := &dto.OrderListResponse{
+		Orders: make([]*dto.OrderSummary, 0, len(orders)),
+	}
+	if len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[len(orders)-1]
+		resp.NextCursor = _encodeOrderCursor(last.CreatedAt, last.OrderID)
+	}
+	for _, order := range orders {
+		resp.Orders = append(resp.Orders, _orderSummary(order))
+	}
+
+	return resp, nil
+}
+
+func (s *orderServiceImpl) GetOrder(ctx context.Context, userID string, orderID string) (*dto.OrderDetail, error) {
+	order, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order detail: %w", err)
+	}
+	// other users' orders are reported as not found
+	if order.UserID != userID {
+		return nil, fmt.Errorf("get order detail: %w", gorm.ErrRecordNotFound)
+	}
+
+	units, err := s.orderRepo.GetOrderUnits(ctx, s.db, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order units: %w", err)
+	}
+
+	items, err := s.orderRepo.GetOrderItems(ctx, s.db, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order items: %w", err)
+	}
+
+	refunds, err := s.orderRepo.GetRefunds(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order refunds: %w", err)
+	}
+
+	history, err := s.orderRepo.GetStatusHistory(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order status history: %w", err)
+	}
+
+	productIDs := make([]string, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	products, err := s.productRepo.FindMany(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get many products by item ids: %w", err)
+	}
+	productNames := make(map[string]string, len(products))
+	for _, product := range products {
+		productNames[product.ID] = product.Name
+	}
+
+	detail := &dto.OrderDetail{
+		OrderSummary:   *_orderSummary(order),
+		CardBrand:      order.CardBrand,
+		CardLastDigits: order.CardLastDigits,
+	}
+	for _, unit := range units {
+		detail.Units = append(detail.Units, &dto.OrderUnitDetail{
+			ReferenceID: unit.ReferenceID,
+			MerchantID:  unit.MerchantID,
+			Amount:      _formatAmount(unit.Amount),
+			Discount:    _formatDiscount(unit.Discount),
+			Tax:         _formatDiscount(unit.Tax),
+			Currency:    unit.Currency,
+			CaptureID:   unit.CaptureID,
+			Status:      unit.Status,
+		})
+	}
+	for _, item := range items {
+		detail.Items = append(detail.Items, &dto.OrderItemDetail{
+			ProductID:   item.ProductID,
+			Name:        productNames[item.ProductID],
+			ReferenceID: item.ReferenceID,
+			Quantity:    item.Quantity,
+			UnitPrice:   _formatAmount(item.UnitPrice),
+			Discount:    _formatDiscount(item.Discount),
+			Tax:         _formatDiscount(item.Tax),
+			Currency:    item.Currency,
+		})
+	}
+	for _, refund := range refunds {
+		detail.Refunds = append(detail.Refunds, &dto.OrderRefund{
+			RefundID:  refund.RefundID,
+			CaptureID: refund.CaptureID,
+			Amount:    refund.Amount,
+			Currency:  refund.Currency,
+			Status:    refund.Status,
+			CreatedAt: refund.CreatedAt,
+		})
+	}
+	for _, entry := range history {
+		detail.StatusHistory = append(detail.StatusHistory, &dto.OrderStatusChange{
+			Status: entry.Status,
+			At:     entry.CreatedAt,
+		})
+	}
+
+	return detail, nil
+}
+
+func _orderSummary(order *model.Order) *dto.OrderSummary {
+	return &dto.OrderSummary{
+		OrderID:       order.OrderID,
+		Status:        order.Status,
+		Amount:        _formatAmount(order.Amount),
+		Discount:      _formatDiscount(order.Discount),
+		Tax:           _formatDiscount(order.Tax),
+		TaxInclusive:  order.TaxInclusive,
+		Currency:      order.Currency,
+		MerchantID:    order.MerchantID,
+		FundingSource: order.FundingSource,
+		CreatedAt:     order.CreatedAt,
+		UpdatedAt:     order.UpdatedAt,
+	}
+}
+
+func _formatAmount(amount int32) string {
+	return fmt.Sprintf("%.2f", float64(amount))
+}
+
+// _formatDiscount is _formatMinor, empty when there is no discount (or tax)
+func _formatDiscount(minor int64) string {
+	if minor <= 0 {
+		return ""
+	}
+	return _formatMinor(minor)
+}
+
+// _formatMinor renders minor units (cents) as "1.50"
+func _formatMinor(minor int64) string {
+	return fmt.Sprintf("%d.%02d", minor/100, minor%100)
+}
+
+// the cursor is opaque to clients: base64("<created_at unix nanos>|<order id>")
+func _encodeOrderCursor(createdAt time.Time, orderID string) string {
+	raw := strconv.FormatInt(createdAt.UnixNano(), 10) + "|" + orderID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func _decodeOrderCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	nanos, orderID, ok := strings.Cut(string(raw), "|")
+	if !ok || orderID == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return time.Unix(0, unixNano), orderID, nil
+}
@@ -0,0 +1,500 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const payoutSyncBatch = 100
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// payoutReturnStatuses give the amount back to the earnings balance
+var payoutReturnStatuses = map[model.PayoutStatus]bool{
+	model.PAYOUT_RETURNED: true,
+	model.PAYOUT_FAILED:   true,
+	model.PAYOUT_BLOCKED:  true,
+	model.PAYOUT_REFUNDED: true,
+	model.PAYOUT_REVERSED: true,
+}
+
+type PayoutService interface {
+	GetEarnings(ctx context.Context, userID string, limit int) (*dto.EarningsResponse, error)
+	CreditEarnings(ctx context.Context, userID string, req *dto.EarningsCreditRequest) (*dto.EarningsCreditResponse, error)
+	RequestPayout(ctx context.Context, userID string, req *dto.PayoutRequest) (*model.Payout, error)
+	ListPayouts(ctx context.Context, query *dto.PayoutQuery) ([]*model.Payout, error)
+	GetPayout(ctx context.Context, payoutID string) (*model.Payout, error)
+	ApprovePayout(ctx context.Context, payoutID string, req *dto.PayoutReviewRequest) (*model.Payout, error)
+	RejectPayout(ctx context.Context, payoutID string, req *dto.PayoutReviewRequest) (*model.Payout, error)
+	// HandlePayoutItemEvent applies a PAYMENT.PAYOUTS-ITEM.* webhook event
+	HandlePayoutItemEvent(ctx context.Context, event *model.PayPalWebhookEvent) error
+	// RunPayoutSync polls PayPal for unsettled payouts every interval until ctx is done
+	RunPayoutSync(ctx context.Context, interval time.Duration)
+}
+
+type payoutServiceImpl struct {
+	db           *gorm.DB
+	paypalClient client.PaypalClient
+	payoutRepo   repository.PayoutRepository
+	payouts      config.Payouts
+}
+
+func NewPayoutService(db *gorm.DB, paypalClient client.PaypalClient, payoutRepo repository.PayoutRepository, payouts config.Payouts) PayoutService {
+	return &payoutServiceImpl{
+		db:           db,
+		paypalClient: paypalClient,
+		payoutRepo:   payoutRepo,
+		payouts:      payouts,
+	}
+}
+
+func (s *payoutServiceImpl) GetEarnings(ctx context.Context, userID string, limit int) (*dto.EarningsResponse, error) {
+	if limit <= 0 {
+		limit = defaultLedgerPageSize
+	}
+	if limit > maxLedgerPageSize {
+		limit = maxLedgerPageSize
+	}
+
+	balances, err := s.earningsBalances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := s.payoutRepo.GetEarningsLedger(ctx, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get earnings ledger: %w", err)
+	}
+
+	return &dto.EarningsResponse{
+		UserID:   userID,
+		Balances: balances,
+		Ledger:   ledger,
+	}, nil
+}
+
+func (s *payoutServiceImpl) CreditEarnings(ctx context.Context, userID string, req *dto.EarningsCreditRequest) (*dto.EarningsCreditResponse, error) {
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.OperationID == "" || req.Amount <= 0 || !currencyCodePattern.MatchString(req.Currency) {
+		return nil, fmt.Errorf("%w: operation_id, a positive amount and a currency are required", ErrInvalidPayout)
+	}
+
+	// namespaced so an operation id can never collide with a payout
+	key := "credit:" + req.OperationID
+	var applied bool
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		applied, err = s.payoutRepo.ApplyEarnings(ctx, tx, &model.EarningsLedgerEntry{
+			UserID:         userID,
+			Currency:       req.Currency,
+			Type:           string(model.EARNINGS_CREDIT),
+			Amount:         req.Amount,
+			Reason:         req.Reason,
+			IdempotencyKey: key,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("credit earnings: %w", err)
+	}
+
+	if !applied {
+		existing, err := s.payoutRepo.FindEarningsEntry(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("get earnings entry: %w", err)
+		}
+		if existing.UserID != userID || existing.Currency != req.Currency || existing.Amount != req.Amount {
+			return nil, ErrPayoutConflict
+		}
+	}
+
+	balances, err := s.earningsBalances(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.EarningsCreditResponse{
+		Applied:  applied,
+		Balances: balances,
+	}, nil
+}
+
+func (s *payoutServiceImpl) earningsBalances(ctx context.Context, userID string) ([]*dto.EarningsBalance, error) {
+	earnings, err := s.payoutRepo.GetEarnings(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get earnings: %w", err)
+	}
+
+	balances := make([]*dto.EarningsBalance, len(earnings))
+	for i, e := range earnings {
+		balances[i] = &dto.EarningsBalance{Currency: e.Currency, Balance: e.Balance}
+	}
+	return balances, nil
+}
+
+// RequestPayout debits the earnings and sends the payout to PayPal, unless it
+// is above the approval threshold. A payout that could not be sent is retried
+// by RunPayoutSync.
+func (s *payoutServiceImpl) RequestPayout(ctx context.Context, userID string, req *dto.PayoutRequest) (*model.Payout, error) {
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.PayoutID == "" || req.Amount <= 0 || !currencyCodePattern.MatchString(req.Currency) {
+		return nil, fmt.Errorf("%w: payout_id, a positive amount and a currency are required", ErrInvalidPayout)
+	}
+	if !strings.Contains(req.Receiver, "@") {
+		return nil, fmt.Errorf("%w: receiver must be a PayPal account email", ErrInvalidPayout)
+	}
+
+	payout := &model.Payout{
+		PayoutID: req.PayoutID,
+		UserID:   userID,
+		Receiver: req.Receiver,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Note:     req.Note,
+		Status:   string(model.PAYOUT_APPROVED),
+	}
+	if req.Amount > s.payouts.ApprovalThreshold {
+		payout.Status = string(model.PAYOUT_PENDING_APPROVAL)
+	}
+
+	var replayed bool
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		created, err := s.payoutRepo.Create(ctx, tx, payout)
+		if err != nil {
+			return fmt.Errorf("create payout: %w", err)
+		}
+		if !created {
+			replayed = true
+			return nil
+		}
+
+		_, err = s.payoutRepo.ApplyEarnings(ctx, tx, &model.EarningsLedgerEntry{
+			UserID:         userID,
+			Currency:       payout.Currency,
+			Type:           string(model.EARNINGS_PAYOUT),
+			Amount:         -payout.Amount,
+			PayoutID:       payout.PayoutID,
+			IdempotencyKey: "payout:" + payout.PayoutID,
+		})
+		if errors.Is(err, repository.ErrInsufficientEarnings) {
+			return ErrInsufficientEarnings
+		}
+		if err != nil {
+			return fmt.Errorf("debit earnings: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if replayed {
+		existing, err := s.payoutRepo.Get(ctx, payout.PayoutID)
+		if err != nil {
+			return nil, fmt.Errorf("get payout: %w", err)
+		}
+		if existing.UserID != userID || existing.Receiver != payout.Receiver ||
+			existing.Amount != payout.Amount || existing.Currency != payout.Currency {
+			return nil, ErrPayoutConflict
+		}
+		return existing, nil
+	}
+
+	if payout.Status == string(model.PAYOUT_APPROVED) {
+		if err := s.submitPayout(ctx, payout.PayoutID); err != nil {
+			log.Printf("submit payout %s: %v", payout.PayoutID, err)
+		}
+	}
+
+	return s.payoutRepo.Get(ctx, payout.PayoutID)
+}
+
+func (s *payoutServiceImpl) ListPayouts(ctx context.Context, query *dto.PayoutQuery) ([]*model.Payout, error) {
+	payouts, err := s.payoutRepo.Search(ctx, &repository.PayoutFilter{
+		UserID: query.UserID,
+		Status: query.Status,
+		Limit:  query.Limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search payouts: %w", err)
+	}
+	return payouts, nil
+}
+
+func (s *payoutServiceImpl) GetPayout(ctx context.Context, payoutID string) (*model.Payout, error) {
+	return s.payoutRepo.Get(ctx, payoutID)
+}
+
+func (s *payoutServiceImpl) ApprovePayout(ctx context.Context, payoutID string, req *dto.PayoutReviewRequest) (*model.Payout, error) {
+	err := s.reviewPayout(ctx, payoutID, req, model.PAYOUT_APPROVED)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.submitPayout(ctx, payoutID); err != nil {
+		log.Printf("submit payout %s: %v", payoutID, err)
+	}
+
+	return s.payoutRepo.Get(ctx, payoutID)
+}
+
+// RejectPayout gives the amount back to the user's earnings
+func (s *payoutServiceImpl) RejectPayout(ctx context.Context, payoutID string, req *dto.PayoutReviewRequest) (*model.Payout, error) {
+	if err := s.reviewPayout(ctx, payoutID, req, model.PAYOUT_REJECTED); err != nil {
+		return nil, err
+	}
+	return s.payoutRepo.Get(ctx, payoutID)
+}
+
+func (s *payoutServiceImpl) reviewPayout(ctx context.Context, payoutID string, req *dto.PayoutReviewRequest, status model.PayoutStatus) error {
+	if req.Actor == "" {
+		return fmt.Errorf("%w: actor is required", ErrInvalidPayout)
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		payout, err := s.payoutRepo.GetForUpdate(ctx, tx, payoutID)
+		if err != nil {
+			return err
+		}
+		if payout.Status != string(model.PAYOUT_PENDING_APPROVAL) {
+			return ErrPayoutNotPending
+		}
+
+		payout.Status = string(status)
+		payout.ReviewedBy = req.Actor
+		payout.ReviewNote = req.Note
+		if status == model.PAYOUT_REJECTED {
+			if err := s.returnPayout(ctx, tx, payout); err != nil {
+				return err
+			}
+		}
+
+		if err := s.payoutRepo.Update(ctx, tx, payout); err != nil {
+			return fmt.Errorf("update payout: %w", err)
+		}
+		return nil
+	})
+}
+
+// submitPayout sends an approved payout to PayPal. The payout is marked
+// SUBMITTED before the call so it is never sent twice; when the call fails
+// without an answer it stays SUBMITTED and the item webhook, which carries the
+// payout id as sender_item_id, fills in the rest. An answer rejecting the
+// batch fails the payout and gives the amount back.
+func (s *payoutServiceImpl) submitPayout(ctx context.Context, payoutID string) error {
+	var payout *model.Payout
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		payout, err = s.payoutRepo.GetForUpdate(ctx, tx, payoutID)
+		if err != nil {
+			return fmt.Errorf("get payout: %w", err)
+		}
+		if payout.Status != string(model.PAYOUT_APPROVED) {
+			payout = nil
+			return nil
+		}
+
+		now := time.Now()
+		payout.Status = string(model.PAYOUT_SUBMITTED)
+		payout.SubmittedAt = &now
+		if err := s.payoutRepo.Update(ctx, tx, payout); err != nil {
+			return fmt.Errorf("update payout: %w", err)
+		}
+		return nil
+	})
+	if err != nil || payout == nil {
+		return err
+	}
+
+	return s.sendPayout(ctx, payout)
+}
+
+// sendPayout sends a SUBMITTED payout to PayPal. It is sent again with the
+// same sender_batch_id when the answer got lost, PayPal then points to the
+// batch of the first attempt instead of paying twice.
+func (s *payoutServiceImpl) sendPayout(ctx context.Context, payout *model.Payout) error {
+	payoutID := payout.PayoutID
+	header, err := s.paypalClient.CreatePayout(ctx, &client.PayoutRequest{
+		SenderBatchID: payout.PayoutID,
+		Receiver:      payout.Receiver,
+		Currency:      payout.Currency,
+		AmountMinor:   payout.Amount,
+		Note:          payout.Note,
+		EmailSubject:  s.payouts.EmailSubject,
+	})
+	if err != nil {
+		var apiErr *client.APIError
+		rejected := errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+			!client.IsDuplicatePayout(apiErr)
+		if rejected {
+			reason := apiErr.Name
+			if apiErr.Issue != "" {
+				reason = apiErr.Issue
+			}
+			return s.settlePayout(ctx, payoutID, &model.PaypalPayoutItem{
+				TransactionStatus: string(model.PAYOUT_FAILED),
+				Errors:            model.PayoutItemError{Name: reason, Message: err.Error()},
+			})
+		}
+		if updateErr := s.settlePayout(ctx, payoutID, &model.PaypalPayoutItem{
+			Errors: model.PayoutItemError{Message: err.Error()},
+		}); updateErr != nil {
+			log.Printf("store payout %s error: %v", payoutID, updateErr)
+		}
+		return err
+	}
+
+	return s.settlePayout(ctx, payoutID, &model.PaypalPayoutItem{PayoutBatchID: header.PayoutBatchID})
+}
+
+// settlePayout stores what PayPal told about the payout item. Empty fields
+// leave the payout unchanged. A failed, returned or reversed item gives the
+// amount back to the earnings, only once whatever PayPal sends afterwards.
+func (s *payoutServiceImpl) settlePayout(ctx context.Context, payoutID string, item *model.PaypalPayoutItem) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		payout, err := s.payoutRepo.GetForUpdate(ctx, tx, payoutID)
+		if err != nil {
+			return fmt.Errorf("get payout: %w", err)
+		}
+
+		if item.PayoutBatchID != "" {
+			payout.PayoutBatchID = item.PayoutBatchID
+		}
+		if item.PayoutItemID != "" {
+			payout.PayoutItemID = item.PayoutItemID
+		}
+		if item.TransactionID != "" {
+			payout.TransactionID = item.TransactionID
+		}
+		if item.Errors.Message != "" {
+			payout.LastError = item.Errors.Message
+			if item.Errors.Name != "" {
+				payout.LastError = item.Errors.Name + ": " + item.Errors.Message
+			}
+		}
+
+		// once the amount went back the payout is settled, late events do not move it
+		status := model.PayoutStatus(item.TransactionStatus)
+		if status != "" && payout.ReturnedAt == nil {
+			payout.Status = string(status)
+			if payoutReturnStatuses[status] {
+				if err := s.returnPayout(ctx, tx, payout); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := s.payoutRepo.Update(ctx, tx, payout); err != nil {
+			return fmt.Errorf("update payout: %w", err)
+		}
+		return nil
+	})
+}
+
+// returnPayout credits the payout amount back, the caller saves the payout
+func (s *payoutServiceImpl) returnPayout(ctx context.Context, tx *gorm.DB, payout *model.Payout) error {
+	if payout.ReturnedAt != nil {
+		return nil
+	}
+
+	_, err := s.payoutRepo.ApplyEarnings(ctx, tx, &model.EarningsLedgerEntry{
+		UserID:         payout.UserID,
+		Currency:       payout.Currency,
+		Type:           string(model.EARNINGS_PAYOUT_RETURN),
+		Amount:         payout.Amount,
+		PayoutID:       payout.PayoutID,
+		Reason:         payout.Status,
+		IdempotencyKey: "payout_return:" + payout.PayoutID,
+	})
+	if err != nil {
+		return fmt.Errorf("return payout to earnings: %w", err)
+	}
+
+	now := time.Now()
+	payout.ReturnedAt = &now
+	return nil
+}
+
+func (s *payoutServiceImpl) HandlePayoutItemEvent(ctx context.Context, event *model.PayPalWebhookEvent) error {
+	resource := event.Resource
+	payoutID := resource.PayoutItem.SenderItemID
+	if payoutID == "" || resource.TransactionStatus == "" {
+		return fmt.Errorf("missing sender_item_id or transaction_status in %s event payload", event.EventType)
+	}
+
+	if _, err := s.payoutRepo.Get(ctx, payoutID); errors.Is(err, gorm.ErrRecordNotFound) {
+		// a payout sent from the PayPal dashboard, not one of ours
+		return nil
+	}
+
+	return s.settlePayout(ctx, payoutID, &model.PaypalPayoutItem{
+		PayoutItemID:      resource.PayoutItemID,
+		PayoutBatchID:     resource.PayoutBatchID,
+		TransactionID:     resource.TransactionID,
+		TransactionStatus: resource.TransactionStatus,
+		Errors:            resource.Errors,
+	})
+}
+
+// RunPayoutSync sends approved payouts that could not be sent yet and polls
+// PayPal for payouts whose item webhook did not arrive
+func (s *payoutServiceImpl) RunPayoutSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			payouts, err := s.payoutRepo.FindUnsettled(ctx, now.Add(-interval), payoutSyncBatch)
+			if err != nil {
+				log.Printf("find unsettled payouts: %v", err)
+				continue
+			}
+
+			for _, payout := range payouts {
+				if err := s.syncPayout(ctx, payout); err != nil {
+					log.Printf("sync payout %s: %v", payout.PayoutID, err)
+				}
+			}
+		}
+	}
+}
+
+func (s *payoutServiceImpl) syncPayout(ctx context.Context, payout *model.Payout) error {
+	if payout.Status == string(model.PAYOUT_APPROVED) {
+		return s.submitPayout(ctx, payout.PayoutID)
+	}
+	if payout.PayoutBatchID == "" {
+		// sent without an answer: either PayPal never got it and takes it now,
+		// or it links the batch it made the first time
+		return s.sendPayout(ctx, payout)
+	}
+
+	batch, err := s.paypalClient.GetPayoutBatch(ctx, payout.PayoutBatchID)
+	if err != nil {
+		return err
+	}
+
+	for i := range batch.Items {
+		item := &batch.Items[i]
+		if item.PayoutItem.SenderItemID == payout.PayoutID {
+			return s.settlePayout(ctx, payout.PayoutID, item)
+		}
+	}
+
+	// items are listed once the batch is processed, touch it so it is polled again later
+	return s.settlePayout(ctx, payout.PayoutID, &model.PaypalPayoutItem{})
+}
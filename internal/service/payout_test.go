@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+)
+
+func newTestPayoutService(t *testing.T, paypalClient client.PaypalClient) (*payoutServiceImpl, repository.PayoutRepository) {
+	db := newTestDB(t)
+	payoutRepo := repository.NewPayoutRepository(db)
+	payouts := config.Payouts{ApprovalThreshold: 10000, EmailSubject: "You have a payout"}
+	return NewPayoutService(db, paypalClient, payoutRepo, payouts).(*payoutServiceImpl), payoutRepo
+}
+
+// _requestPayout credits 1000 cents to user-1 and asks for a 400 cents payout
+func _requestPayout(t *testing.T, s *payoutServiceImpl) *model.Payout {
+	t.Helper()
+	ctx := context.Background()
+
+	credit := &dto.EarningsCreditRequest{OperationID: "sale-1", Amount: 1000, Currency: "USD"}
+	if _, err := s.CreditEarnings(ctx, "user-1", credit); err != nil {
+		t.Fatalf("credit earnings: %v", err)
+	}
+
+	payout, err := s.RequestPayout(ctx, "user-1", &dto.PayoutRequest{
+		PayoutID: "payout-1",
+		Receiver: "seller@example.com",
+		Amount:   400,
+		Currency: "USD",
+	})
+	if err != nil {
+		t.Fatalf("request payout: %v", err)
+	}
+	return payout
+}
+
+func _earningsBalance(t *testing.T, payoutRepo repository.PayoutRepository) int64 {
+	t.Helper()
+	earnings, err := payoutRepo.GetEarnings(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("get earnings: %v", err)
+	}
+	for _, e := range earnings {
+		if e.Currency == "USD" {
+			return e.Balance
+		}
+	}
+	return 0
+}
+
+func _payoutReturns(t *testing.T, payoutRepo repository.PayoutRepository) int {
+	t.Helper()
+	entries, err := payoutRepo.GetEarningsLedger(context.Background(), "user-1", 100)
+	if err != nil {
+		t.Fatalf("get earnings ledger: %v", err)
+	}
+	var returns int
+	for _, e := range entries {
+		if e.Type == string(model.EARNINGS_PAYOUT_RETURN) {
+			returns++
+		}
+	}
+	return returns
+}
+
+func TestPayoutFailureReturnsEarningsOnce(t *testing.T) {
+	ctx := context.Background()
+	paypalClient := &fakePaypalClient{
+		createPayout: func(*client.PayoutRequest) (*model.PayoutBatchHeader, error) {
+			return &model.PayoutBatchHeader{PayoutBatchID: "batch-1", BatchStatus: "PENDING"}, nil
+		},
+	}
+	s, payoutRepo := newTestPayoutService(t, paypalClient)
+
+	payout := _requestPayout(t, s)
+	if payout.Status != string(model.PAYOUT_SUBMITTED) || payout.PayoutBatchID != "batch-1" {
+		t.Fatalf("payout = %s, batch %q; want SUBMITTED, batch-1", payout.Status, payout.PayoutBatchID)
+	}
+	if got := _earningsBalance(t, payoutRepo); got != 600 {
+		t.Fatalf("balance after payout = %d, want 600", got)
+	}
+
+	// PayPal retries webhooks and the sync polls, the return must not add up
+	for _, status := range []model.PayoutStatus{model.PAYOUT_FAILED, model.PAYOUT_FAILED, model.PAYOUT_RETURNED} {
+		if err := s.settlePayout(ctx, "payout-1", &model.PaypalPayoutItem{TransactionStatus: string(status)}); err != nil {
+			t.Fatalf("settle payout %s: %v", status, err)
+		}
+	}
+
+	if got := _earningsBalance(t, payoutRepo); got != 1000 {
+		t.Fatalf("balance after failed payout = %d, want 1000", got)
+	}
+	if got := _payoutReturns(t, payoutRepo); got != 1 {
+		t.Fatalf("payout returns = %d, want 1", got)
+	}
+
+	settled, err := payoutRepo.Get(ctx, "payout-1")
+	if err != nil {
+		t.Fatalf("get payout: %v", err)
+	}
+	if settled.Status != string(model.PAYOUT_FAILED) || settled.ReturnedAt == nil {
+		t.Fatalf("settled payout = %s, returned %v; want FAILED, returned", settled.Status, settled.ReturnedAt)
+	}
+}
+
+func TestPayoutSendFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  model.PayoutStatus
+		wantBalance int64
+		wantReturns int
+	}{
+		{
+			name:        "rejected batch is given back",
+			err:         &client.APIError{StatusCode: 422, Name: "UNPROCESSABLE_ENTITY", Issue: "RECEIVER_UNREGISTERED"},
+			wantStatus:  model.PAYOUT_FAILED,
+			wantBalance: 1000,
+			wantReturns: 1,
+		},
+		{
+			name:        "lost answer stays submitted",
+			err:         errors.New("connection reset by peer"),
+			wantStatus:  model.PAYOUT_SUBMITTED,
+			wantBalance: 600,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paypalClient := &fakePaypalClient{
+				createPayout: func(*client.PayoutRequest) (*model.PayoutBatchHeader, error) {
+					return nil, tt.err
+				},
+			}
+			s, payoutRepo := newTestPayoutService(t, paypalClient)
+
+			payout := _requestPayout(t, s)
+			if payout.Status != string(tt.wantStatus) {
+				t.Fatalf("status = %s, want %s", payout.Status, tt.wantStatus)
+			}
+			if payout.LastError == "" {
+				t.Fatal("last error is empty")
+			}
+			if got := _earningsBalance(t, payoutRepo); got != tt.wantBalance {
+				t.Fatalf("balance = %d, want %d", got, tt.wantBalance)
+			}
+			if got := _payoutReturns(t, payoutRepo); got != tt.wantReturns {
+				t.Fatalf("payout returns = %d, want %d", got, tt.wantReturns)
+			}
+		})
+	}
+}
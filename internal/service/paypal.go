@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"path"
 	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/config"
 	"paypal-integration-demo/internal/dto"
 	"paypal-integration-demo/internal/model"
 	"paypal-integration-demo/internal/repository"
@@ -19,37 +23,63 @@ type PaypalService interface {
 	Connect(merchantID string) string
 	ExchangeAuthCode(ctx context.Context, code string) (*model.PayPalToken, error)
 	GetPaypalMerchantID(ctx context.Context, merchantToken string) (string, error)
-
-	Pay(ctx context.Context, merchantID string, userID string, items []*dto.Item) (*dto.PayResponse, error)
-	PayAgain(ctx context.Context, merchantID string, userID string, items []*dto.Item) (*dto.PayResponse, error)
-	CaptureOrder(ctx context.Context, orderID string) error
+	StartOnboarding(ctx context.Context, merchantID string) (actionURL string, err error)
+	CompleteOnboarding(ctx context.Context, merchantID string, paypalMerchantID string) (*model.Merchant, error)
+
+	Pay(ctx context.Context, merchantID string, userID string, clientProfile string, fundingSource model.FundingSource, couponCode string, items []*dto.Item) (*dto.PayResponse, error)
+	OrderResult(ctx context.Context, orderID string, status string) (*dto.OrderResult, error)
+	// CancelOrder records that the buyer cancelled the approval on PayPal
+	CancelOrder(ctx context.Context, userID string, orderID string) error
+	EligibleFundingSources(ctx context.Context, items []*dto.Item) ([]model.FundingSource, error)
+	// PayAgain charges a saved payment method once the risk rules allow it.
+	// clientMetadataID is the device fingerprint forwarded to PayPal.
+	PayAgain(ctx context.Context, merchantID string, userID string, paymentMethodID string, clientMetadataID string, couponCode string, items []*dto.Item) (*dto.PayResponse, error)
+	PayWithCard(ctx context.Context, merchantID string, userID string, singleUseToken string, saveCard bool, couponCode string, billingAddress *dto.BillingAddress, items []*dto.Item) (*dto.PayResponse, error)
+	CompleteCardOrder(ctx context.Context, orderID string) (*dto.PayResponse, error)
+	CaptureOrder(ctx context.Context, orderID string) (*dto.CaptureResponse, error)
+	SubscribeOrderEvents(ctx context.Context, userID string, orderID string) (current *dto.OrderEvent, events <-chan *dto.OrderEvent, unsubscribe func(), err error)
 	HandleWebhook(ctx context.Context, headers http.Header, body []byte) error
 	CheckUserHaveSavedPayment(ctx context.Context, userID string) (bool, error)
+	ListPaymentMethods(ctx context.Context, userID string) ([]*model.UserVault, error)
+	SetDefaultPaymentMethod(ctx context.Context, userID string, vaultID string) error
+	DeletePaymentMethod(ctx context.Context, userID string, vaultID string) error
+	StartVaultSetup(ctx context.Context, merchantID string, userID string) (approveURL string, err error)
+	CompleteVaultSetup(ctx context.Context, setupTokenID string) (*model.UserVault, error)
 
 	SetExistingProductsSubPlan(ctx context.Context, merchantID string, merchantAccessToken string) error
-	SubscribeSubscription(ctx context.Context, userID string, productID string, merchantID string) (approveURL string, err error)
-	HandleSubscriptionSuccess(ctx context.Context, subscriptionID string) error
+	SubscribeSubscription(ctx context.Context, userID string, clientProfile string, productID string, merchantID string) (approveURL string, err error)
+	HandleSubscriptionSuccess(ctx context.Context, subscriptionID string, cancelled bool) (*dto.SubscriptionResult, error)
 	CancelSubscription(ctx context.Context, userID string, merchantID string) error
 	HasActiveSubscription(ctx context.Context, userID string, merchantID string) (bool, error)
 }
 
 type paypalServiceImpl struct {
-	db               *gorm.DB
-	paypalClient     client.PaypalClient
-	serviceBaseUrl   string
-	merchantRepo     repository.MerchantRepository
-	productRepo      repository.ProductRepository
-	orderRepo        repository.OrderRepository
-	webhookEventRepo repository.WebhookEventRepository
-	inventoryRepo    repository.InventoryRepository
-	vaultRepo        repository.VaultRepository
-	subscriptionRepo repository.SubscriptionRepository
+	merchantAuth
+	itemGranter
+	db                *gorm.DB
+	serviceBaseUrl    string
+	clientProfiles    config.ClientProfiles
+	orderEvents       *OrderEventBus
+	productRepo       repository.ProductRepository
+	webhookEventRepo  repository.WebhookEventRepository
+	vaultRepo         repository.VaultRepository
+	subscriptionRepo  repository.SubscriptionRepository
+	promotionRepo     repository.PromotionRepository
+	taxCalculator     TaxCalculator
+	purchaseLimitRepo repository.PurchaseLimitRepository
+	purchaseLimits    config.PurchaseLimits
+	riskEngine        RiskEngine
+	disputeService    DisputeService
+	payoutService     PayoutService
+	invoiceService    InvoiceService
 }
 
 func NewPaypalService(
 	db *gorm.DB,
 	paypalClient client.PaypalClient,
 	serviceBaseUrl string,
+	clientProfiles config.ClientProfiles,
+	orderEvents *OrderEventBus,
 	merchantRepo repository.MerchantRepository,
 	productRepo repository.ProductRepository,
 	orderRepo repository.OrderRepository,
@@ -57,18 +87,44 @@ func NewPaypalService(
 	inventoryRepo repository.InventoryRepository,
 	vaultRepo repository.VaultRepository,
 	subscriptionRepo repository.SubscriptionRepository,
+	walletRepo repository.WalletRepository,
+	entitlementRepo repository.EntitlementRepository,
+	promotionRepo repository.PromotionRepository,
+	taxCalculator TaxCalculator,
+	purchaseLimitRepo repository.PurchaseLimitRepository,
+	purchaseLimits config.PurchaseLimits,
+	riskEngine RiskEngine,
+	disputeService DisputeService,
+	payoutService PayoutService,
+	invoiceService InvoiceService,
 ) PaypalService {
 	return &paypalServiceImpl{
-		db:               db,
-		paypalClient:     paypalClient,
-		serviceBaseUrl:   serviceBaseUrl,
-		merchantRepo:     merchantRepo,
-		productRepo:      productRepo,
-		orderRepo:        orderRepo,
-		webhookEventRepo: webhookEventRepo,
-		inventoryRepo:    inventoryRepo,
-		vaultRepo:        vaultRepo,
-		subscriptionRepo: subscriptionRepo,
+		merchantAuth: merchantAuth{
+			merchantRepo: merchantRepo,
+			paypalClient: paypalClient,
+		},
+		itemGranter: itemGranter{
+			orderRepo:       orderRepo,
+			entitlementRepo: entitlementRepo,
+			walletRepo:      walletRepo,
+			inventoryRepo:   inventoryRepo,
+		},
+		db:                db,
+		serviceBaseUrl:    serviceBaseUrl,
+		clientProfiles:    clientProfiles,
+		orderEvents:       orderEvents,
+		productRepo:       productRepo,
+		webhookEventRepo:  webhookEventRepo,
+		vaultRepo:         vaultRepo,
+		subscriptionRepo:  subscriptionRepo,
+		promotionRepo:     promotionRepo,
+		taxCalculator:     taxCalculator,
+		purchaseLimitRepo: purchaseLimitRepo,
+		purchaseLimits:    purchaseLimits,
+		riskEngine:        riskEngine,
+		disputeService:    disputeService,
+		payoutService:     payoutService,
+		invoiceService:    invoiceService,
 	}
 }
 
@@ -84,184 +140,622 @@ func (s *paypalServiceImpl) GetPaypalMerchantID(ctx context.Context, merchantTok
 	return s.paypalClient.GetMerchantUserInfo(ctx, merchantToken)
 }
 
-func (s *paypalServiceImpl) Pay(ctx context.Context, merchantID string, userID string, items []*dto.Item) (*dto.PayResponse, error) {
-	productIDs := make([]string, len(items))
-	itemQuantityMap := make(map[string]int32)
-	for i, item := range items {
-		if item.Quantity <= 0 {
-			return nil, fmt.Errorf("item quantity must be positive")
+func (s *paypalServiceImpl) StartOnboarding(ctx context.Context, merchantID string) (string, error) {
+	merchant, err := s.merchantRepo.Get(ctx, merchantID)
+	if err != nil {
+		return "", fmt.Errorf("merchant not found")
+	}
+
+	returnURL := fmt.Sprintf("%s/api/merchants/%s/paypal/onboard/return", s.serviceBaseUrl, merchantID)
+	actionURL, err := s.paypalClient.CreatePartnerReferral(ctx, merchantID, returnURL)
+	if err != nil {
+		return "", fmt.Errorf("paypal api create partner referral: %w", err)
+	}
+
+	if merchant.OnboardingStatus != string(model.ONBOARDING_COMPLETED) {
+		merchant.OnboardingStatus = string(model.ONBOARDING_PENDING)
+		if err := s.merchantRepo.UpdateOnboarding(ctx, merchant); err != nil {
+			return "", fmt.Errorf("store onboarding status: %w", err)
 		}
-		productIDs[i] = item.Sku
+	}
+
+	return actionURL, nil
+}
 
-		itemQuantityMap[item.Sku] = item.Quantity
+func (s *paypalServiceImpl) CompleteOnboarding(ctx context.Context, merchantID string, paypalMerchantID string) (*model.Merchant, error) {
+	if paypalMerchantID == "" {
+		return nil, fmt.Errorf("missing paypal merchant id")
 	}
-	products, err := s.productRepo.FindMany(ctx, productIDs)
+
+	merchant, err := s.merchantRepo.Get(ctx, merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("merchant not found")
+	}
+
+	integration, err := s.paypalClient.GetMerchantIntegration(ctx, paypalMerchantID)
 	if err != nil {
-		return nil, fmt.Errorf("get many products by item ids")
+		return nil, fmt.Errorf("paypal api get merchant integration: %w", err)
+	}
+
+	// the tracking id is our merchant id, reject returns that belong to another
+	// merchant or to an integration not started through our referral link
+	if integration.TrackingID != merchantID {
+		return nil, fmt.Errorf("onboarding tracking id mismatch")
+	}
+
+	merchant.PayPalMerchantID = paypalMerchantID
+	merchant.PaymentsReceivable = integration.PaymentsReceivable
+	merchant.PrimaryEmailConfirmed = integration.PrimaryEmailConfirmed
+	merchant.PermissionsGranted = _hasThirdPartyPermissions(integration)
+
+	merchant.OnboardingStatus = string(model.ONBOARDING_ACTION_REQUIRED)
+	if merchant.PaymentsReceivable && merchant.PrimaryEmailConfirmed && merchant.PermissionsGranted {
+		merchant.OnboardingStatus = string(model.ONBOARDING_COMPLETED)
 	}
 
-	if len(products) != len(items) {
-		return nil, fmt.Errorf("some products not found")
+	if err := s.merchantRepo.UpdateOnboarding(ctx, merchant); err != nil {
+		return nil, fmt.Errorf("store onboarding status: %w", err)
 	}
 
-	totalAmount := int32(0)
-	orderItems := make([]*model.OrderItem, len(products))
-	for i, product := range products {
-		quantity := itemQuantityMap[product.ID]
-		totalAmount += product.Price * quantity
+	return merchant, nil
+}
 
-		orderItems[i] = &model.OrderItem{
-			ProductID: product.ID,
-			Quantity:  quantity,
-			UnitPrice: product.Price,
-			Currency:  product.Currency,
+func _hasThirdPartyPermissions(integration *model.MerchantIntegration) bool {
+	for _, oauth := range integration.OAuthIntegrations {
+		if oauth.IntegrationType != "OAUTH_THIRD_PARTY" {
+			continue
+		}
+		for _, thirdParty := range oauth.OAuthThirdParty {
+			if len(thirdParty.Scopes) > 0 {
+				return true
+			}
 		}
 	}
+	return false
+}
 
-	merchantAccessToken, err := s.getValidMerchantAccessToken(ctx, merchantID)
+func (s *paypalServiceImpl) Pay(ctx context.Context, merchantID string, userID string, clientProfile string, fundingSource model.FundingSource, couponCode string, items []*dto.Item) (*dto.PayResponse, error) {
+	if fundingSource == "" {
+		fundingSource = model.FUNDING_PAYPAL
+	}
+
+	profile, ok := s.clientProfiles.Get(clientProfile)
+	if !ok {
+		return nil, ErrUnknownClientProfile
+	}
+
+	// the payer's address is only known once they approved, see taxApprovedOrder
+	co, err := s.prepareCheckout(ctx, merchantID, userID, couponCode, nil, items)
 	if err != nil {
 		return nil, err
 	}
+	if err := _checkFundingSource(fundingSource, co.total); err != nil {
+		return nil, err
+	}
+	co.fundingSource = fundingSource
+	co.clientProfile = profile.Name
 
-	resp, err := s.paypalClient.CreateOrderForApproval(ctx, s.serviceBaseUrl, userID, "USD", totalAmount, merchantAccessToken)
+	if err := s.holdSpend(ctx, userID, co); err != nil {
+		return nil, err
+	}
+	if err := s.reservePromotions(ctx, userID, co); err != nil {
+		s.releaseSpend(ctx, co)
+		return nil, err
+	}
+
+	experience := s.experience(profile, "/api/paypal/success", "/api/paypal/cancel")
+	resp, err := s.paypalClient.CreateOrderForApproval(ctx, experience, fundingSource, co.units, co.merchantAuth)
 	if err != nil {
+		s.releasePromotions(ctx, co)
+		s.releaseSpend(ctx, co)
 		return nil, fmt.Errorf("paypal api create order: %w", err)
 	}
 
-	for _, orderItem := range orderItems {
-		orderItem.OrderID = resp.OrderID
+	if err := s.storeOrder(ctx, resp.OrderID, userID, "CREATED", co); err != nil {
+		// the buyer cannot approve an order this service does not know
+		s.releasePromotions(ctx, co)
+		s.releaseSpend(ctx, co)
+		return nil, err
 	}
 
-	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		err = s.orderRepo.Create(ctx, tx, &model.Order{
-			OrderID:    resp.OrderID,
-			UserID:     userID,
-			Status:     "CREATED",
-			Amount:     totalAmount,
-			Currency:   "USD",
-			MerchantID: merchantID,
-		})
+	return &dto.PayResponse{
+		OrderID:          resp.OrderID,
+		OrderApprovalURL: resp.ApproveURL,
+		Discount:         _formatDiscount(co.discount),
+	}, nil
+}
+
+// experience sends the buyer back through this service, which then redirects
+// to the client profile once the result is known
+func (s *paypalServiceImpl) experience(profile *config.ClientProfile, returnPath string, cancelPath string) *client.Experience {
+	// the result pages are rendered in the profile's locale when it has one
+	if profile.Locale != "" {
+		query := "?" + url.Values{"locale": {profile.Locale}}.Encode()
+		returnPath += query
+		cancelPath += query
+	}
+
+	return &client.Experience{
+		ReturnURL:          s.serviceBaseUrl + returnPath,
+		CancelURL:          s.serviceBaseUrl + cancelPath,
+		BrandName:          profile.BrandName,
+		Locale:             profile.Locale,
+		ShippingPreference: profile.ShippingPreference,
+	}
+}
+
+// CancelOrder moves the user's order still waiting for approval to CANCELLED
+// and gives its coupon back. Leaving PayPal does not void the order, the
+// approval link keeps working until it expires, so this only happens once
+// PayPal reports the order closed; until then RunRedemptionSweeper picks it up.
+// Orders already paid or failed are left as they are.
+func (s *paypalServiceImpl) CancelOrder(ctx context.Context, userID string, orderID string) error {
+	order, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("get order: %w", err)
+	}
+	// someone else's order is reported as not found
+	if order.UserID != userID {
+		return fmt.Errorf("get order: %w", gorm.ErrRecordNotFound)
+	}
+	if order.Status != "CREATED" && order.Status != "APPROVED" {
+		return nil
+	}
+
+	closed, err := s.paypalOrderClosed(ctx, order)
+	if err != nil {
+		return fmt.Errorf("get paypal order: %w", err)
+	}
+	if !closed {
+		return nil
+	}
+
+	return s.orderTransaction(ctx, func(tx *gorm.DB, events *orderEvents) error {
+		cancelled, err := s.orderRepo.MarkCancelled(ctx, tx, orderID)
 		if err != nil {
-			return fmt.Errorf("store order in db: %w", err)
+			return fmt.Errorf("cancel order: %w", err)
+		}
+		if !cancelled {
+			return nil
 		}
 
-		err = s.orderRepo.CreateOrderItems(ctx, tx, orderItems)
-		if err != nil {
-			return fmt.Errorf("store order items in db: %w", err)
+		if err := s.releaseOrderPromotions(ctx, tx, orderID); err != nil {
+			return err
 		}
+		events.add(orderID, OrderEventFailed, "CANCELLED")
 		return nil
 	})
-
-	return &dto.PayResponse{
-		OrderID:          resp.OrderID,
-		OrderApprovalURL: resp.ApproveURL,
-	}, nil
 }
 
-func (s *paypalServiceImpl) PayAgain(ctx context.Context, merchantID string, userID string, items []*dto.Item) (*dto.PayResponse, error) {
-	productIDs := make([]string, len(items))
-	itemQuantityMap := make(map[string]int32)
+// OrderResult describes the order once PayPal returns for it. An empty status
+// reports the stored order status, CANCELLED is the buyer leaving PayPal.
+// Client profiles other than the web page are redirected to with the result.
+func (s *paypalServiceImpl) OrderResult(ctx context.Context, orderID string, status string) (*dto.OrderResult, error) {
+	orderDetail, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order detail: %w", err)
+	}
 
-	for i, item := range items {
-		if item.Quantity <= 0 {
-			return nil, fmt.Errorf("item quantity must be positive")
-		}
-		productIDs[i] = item.Sku
-		itemQuantityMap[item.Sku] = item.Quantity
+	// leaving PayPal does not undo a payment that made it through meanwhile
+	pending := orderDetail.Status == "CREATED" || orderDetail.Status == "APPROVED"
+	if status == "" || (status == "CANCELLED" && !pending) {
+		status = orderDetail.Status
 	}
 
+	orderItems, err := s.orderRepo.GetOrderItems(ctx, s.db, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order items: %w", err)
+	}
+
+	productIDs := make([]string, len(orderItems))
+	for i, item := range orderItems {
+		productIDs[i] = item.ProductID
+	}
 	products, err := s.productRepo.FindMany(ctx, productIDs)
 	if err != nil {
-		return nil, fmt.Errorf("get products: %w", err)
+		return nil, fmt.Errorf("get many products by item ids: %w", err)
+	}
+	productNames := make(map[string]string, len(products))
+	for _, product := range products {
+		productNames[product.ID] = product.Name
 	}
-	if len(products) != len(items) {
-		return nil, fmt.Errorf("some products not found")
+
+	result := &dto.OrderResult{
+		OrderID:    orderID,
+		MerchantID: orderDetail.MerchantID,
+		Status:     status,
+		Outcome:    _orderOutcome(status),
+		Amount:     _formatMinor(_orderPaid(orderDetail)),
+		Discount:   _formatDiscount(orderDetail.Discount),
+		Tax:        _formatDiscount(orderDetail.Tax),
+		Currency:   orderDetail.Currency,
+		Items:      make([]*dto.OrderResultItem, len(orderItems)),
+	}
+	for i, item := range orderItems {
+		name := productNames[item.ProductID]
+		if name == "" {
+			name = item.ProductID
+		}
+		result.Items[i] = &dto.OrderResultItem{
+			ProductID: item.ProductID,
+			Name:      name,
+			Quantity:  item.Quantity,
+		}
 	}
 
-	vaultID, err := s.vaultRepo.GetVaultID(ctx, userID)
+	profile := s.returnProfile(orderDetail.ClientProfile)
+	result.HomeURL = profile.ReturnURL
+	if profile.Name != config.DefaultClientProfile {
+		result.RedirectURL = profile.ResultURL(status == "CANCELLED", url.Values{
+			"order_id": {orderID},
+			"status":   {status},
+		})
+	}
+
+	return result, nil
+}
+
+// returnProfile falls back to the web page for profiles removed since
+func (s *paypalServiceImpl) returnProfile(name string) *config.ClientProfile {
+	profile, ok := s.clientProfiles.Get(name)
+	if !ok {
+		profile, _ = s.clientProfiles.Get("")
+	}
+	return profile
+}
+
+func _orderOutcome(status string) string {
+	switch status {
+	case "COMPLETED", "PAID":
+		return "captured"
+	case "PARTIALLY_COMPLETED", "PARTIALLY_PAID":
+		return "partial"
+	case "FAILED", "DECLINED":
+		return "failed"
+	case "CANCELLED":
+		return "cancelled"
+	}
+	return "pending"
+}
+
+func (s *paypalServiceImpl) PayAgain(ctx context.Context, merchantID string, userID string, paymentMethodID string, clientMetadataID string, couponCode string, items []*dto.Item) (*dto.PayResponse, error) {
+	if len(clientMetadataID) > maxClientMetadataID {
+		return nil, fmt.Errorf("%w: at most %d characters", ErrInvalidClientMetadataID, maxClientMetadataID)
+	}
+
+	var vault *model.UserVault
+	var err error
+	if paymentMethodID != "" {
+		vault, err = s.vaultRepo.Get(ctx, userID, paymentMethodID)
+	} else {
+		vault, err = s.vaultRepo.GetDefault(ctx, userID)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("no vaulted payment method")
 	}
 
-	totalAmount := int32(0)
-	orderItems := make([]*model.OrderItem, len(products))
-	for i, product := range products {
-		qty := itemQuantityMap[product.ID]
-		totalAmount += product.Price * qty
+	// vault charges are captured right away, so they are taxed with the saved address
+	address := &TaxAddress{Country: vault.Country, Region: vault.Region}
+	co, err := s.prepareCheckout(ctx, merchantID, userID, couponCode, address, items)
+	if err != nil {
+		return nil, err
+	}
+	if co.merchantID == "" {
+		return nil, fmt.Errorf("saved payments only support carts from a single merchant")
+	}
 
-		orderItems[i] = &model.OrderItem{
-			ProductID: product.ID,
-			Quantity:  qty,
-			UnitPrice: product.Price,
-			Currency:  product.Currency,
-		}
+	// a payment token can only be charged by the account it was vaulted under
+	if vault.MerchantID != "" && vault.MerchantID != co.merchantID {
+		return nil, fmt.Errorf("payment method was saved with another merchant")
 	}
 
-	merchantAccessToken, err := s.getValidMerchantAccessToken(ctx, merchantID)
+	provider := model.VaultProvider(vault.Provider)
+	if provider == "" {
+		provider = model.VAULT_PAYPAL
+	}
+
+	co.fundingSource = model.FundingSource(provider)
+
+	if err := s.holdSpend(ctx, userID, co); err != nil {
+		return nil, err
+	}
+
+	risk, err := s.riskEngine.Assess(ctx, &RiskCharge{
+		UserID:           userID,
+		MerchantID:       co.merchantID,
+		Vault:            vault,
+		ClientMetadataID: clientMetadataID,
+		Amount:           _checkoutPaid(co),
+	})
 	if err != nil {
+		s.releaseSpend(ctx, co)
 		return nil, err
 	}
+	if risk.Decision != RiskAllow {
+		s.releaseSpend(ctx, co)
+		return nil, &RiskError{DecisionID: risk.ID, Decision: risk.Decision}
+	}
 
-	orderID, err := s.paypalClient.CreateOrderWithVault(ctx, userID, vaultID, "USD", totalAmount, merchantAccessToken)
+	if err := s.reservePromotions(ctx, userID, co); err != nil {
+		s.releaseSpend(ctx, co)
+		return nil, err
+	}
+
+	resp, err := s.paypalClient.CreateOrderWithVault(ctx, provider, vault.VaultID, clientMetadataID, co.units, co.merchantAuth)
 	if err != nil {
+		s.releasePromotions(ctx, co)
+		s.releaseSpend(ctx, co)
+
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+			s.riskEngine.RecordOutcome(ctx, risk, "", "DECLINED")
+			return nil, &VaultChargeError{Status: "DECLINED", Reason: apiErr.Issue}
+		}
 		return nil, fmt.Errorf("paypal create order with vault: %w", err)
 	}
 
-	for _, orderItem := range orderItems {
-		orderItem.OrderID = orderID
+	status := _capturedOrderStatus(resp)
+	s.riskEngine.RecordOutcome(ctx, risk, resp.OrderID, status)
+	if err := s.storeChargedOrder(ctx, resp, userID, status, co); err != nil {
+		return nil, err
 	}
 
-	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := s.orderRepo.Create(ctx, tx, &model.Order{
-			OrderID:  orderID,
-			UserID:   userID,
-			Status:   "COMPLETED", // paypal auto capture order when create order with vault so order status should be compeleted
-			Amount:   totalAmount,
-			Currency: "USD",
-		}); err != nil {
-			return err
+	if status == "COMPLETED" {
+		s.orderEvents.publish(&dto.OrderEvent{OrderID: resp.OrderID, Type: OrderEventCaptured, Status: status, At: time.Now()})
+	}
+	if status != "COMPLETED" && status != "PENDING" {
+		return nil, &VaultChargeError{OrderID: resp.OrderID, Status: status}
+	}
+
+	// items are granted once PAYMENT.CAPTURE.COMPLETED arrives for each unit
+	return &dto.PayResponse{
+		OrderID:  resp.OrderID,
+		Status:   status,
+		Discount: _formatDiscount(co.discount),
+		Tax:      _formatDiscount(co.tax),
+	}, nil
+}
+
+// storeChargedOrder stores a vault charge PayPal has already captured. Storing
+// is retried since the buyer paid; when it keeps failing the captures are
+// refunded rather than keeping money for an order this service does not know.
+func (s *paypalServiceImpl) storeChargedOrder(ctx context.Context, resp *client.HandleOrderResponse, userID string, status string, co *checkout) error {
+	var err error
+	for attempt := 1; attempt <= storeOrderAttempts; attempt++ {
+		if err = s.storeOrder(ctx, resp.OrderID, userID, status, co, resp.Captures...); err == nil {
+			return nil
+		}
+		log.Printf("store vault order %s, attempt %d: %v", resp.OrderID, attempt, err)
+		if attempt < storeOrderAttempts {
+			time.Sleep(time.Duration(attempt) * storeOrderBackoff)
 		}
+	}
 
-		if err := s.orderRepo.CreateOrderItems(ctx, tx, orderItems); err != nil {
-			return err
+	// the request may be gone by now, the refund must still go out
+	refundCtx := context.WithoutCancel(ctx)
+	for _, capture := range resp.Captures {
+		if capture.CaptureID == "" || _unitStatus(capture.Status) == "FAILED" {
+			continue
+		}
+		if refundErr := s.paypalClient.RefundCapture(refundCtx, capture.CaptureID, co.merchantAuth); refundErr != nil {
+			log.Printf("refund capture %s of unstored order %s: %v", capture.CaptureID, resp.OrderID, refundErr)
 		}
+	}
+	s.releasePromotions(refundCtx, co)
+	s.releaseSpend(refundCtx, co)
 
-		return nil
+	return fmt.Errorf("store vault order %s: %w", resp.OrderID, err)
+}
+
+// _capturedOrderStatus derives the stored status of a captured order from what
+// PayPal actually captured instead of assuming success
+func _capturedOrderStatus(resp *client.HandleOrderResponse) string {
+	if resp.Status != "COMPLETED" || len(resp.Captures) == 0 {
+		// e.g. PAYER_ACTION_REQUIRED, the vaulted account needs the buyer
+		return resp.Status
+	}
+
+	status := "COMPLETED"
+	for _, capture := range resp.Captures {
+		switch _unitStatus(capture.Status) {
+		case "FAILED":
+			return "DECLINED"
+		case "PENDING":
+			status = "PENDING"
+		}
+	}
+	return status
+}
+
+func (s *paypalServiceImpl) CaptureOrder(ctx context.Context, orderID string) (*dto.CaptureResponse, error) {
+	orderDetail, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order detail: %w", err)
+	}
+
+	merchantAuth, err := s.orderMerchantAuth(ctx, orderDetail)
+	if err != nil {
+		return nil, err
+	}
+
+	// the buyer approved the order on PayPal, we capture it right away
+	s.orderEvents.publish(&dto.OrderEvent{OrderID: orderID, Type: OrderEventApproved, Status: orderDetail.Status, At: time.Now()})
+
+	if err := s.taxApprovedOrder(ctx, orderDetail, merchantAuth); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.paypalClient.CaptureOrder(ctx, orderID, merchantAuth)
+	if err != nil {
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+			switch apiErr.Issue {
+			case "INSTRUMENT_DECLINED":
+				// the order stays CREATED so it can be approved again
+				return nil, &InstrumentDeclinedError{OrderID: orderID}
+			case "ORDER_ALREADY_CAPTURED":
+				return s.captureResult(ctx, orderID)
+			}
+		}
+		return nil, fmt.Errorf("paypal api capture order: %w", err)
+	}
+
+	err = s.orderTransaction(ctx, func(tx *gorm.DB, events *orderEvents) error {
+		return s.recordCaptures(ctx, tx, events, orderID, resp.Captures)
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return &dto.PayResponse{
-		OrderID: orderID,
-	}, nil
+	return s.captureResult(ctx, orderID)
 }
 
-func (s *paypalServiceImpl) CaptureOrder(ctx context.Context, orderID string) error {
+// captureResult reports the stored outcome of a captured order
+func (s *paypalServiceImpl) captureResult(ctx context.Context, orderID string) (*dto.CaptureResponse, error) {
 	orderDetail, err := s.orderRepo.FindByOrderID(ctx, orderID)
 	if err != nil {
-		return fmt.Errorf("get order detail: %w", err)
+		return nil, fmt.Errorf("get order detail: %w", err)
+	}
+
+	units, err := s.orderRepo.GetOrderUnits(ctx, s.db, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("get order units: %w", err)
+	}
+
+	result := &dto.CaptureResponse{
+		OrderID:  orderID,
+		Status:   orderDetail.Status,
+		Captures: make([]*dto.UnitCapture, len(units)),
+	}
+	for i, unit := range units {
+		result.Captures[i] = &dto.UnitCapture{
+			ReferenceID: unit.ReferenceID,
+			MerchantID:  unit.MerchantID,
+			CaptureID:   unit.CaptureID,
+			Status:      unit.Status,
+		}
 	}
 
-	merchantAccessToken, err := s.getValidMerchantAccessToken(ctx, orderDetail.MerchantID)
+	return result, nil
+}
+
+func (s *paypalServiceImpl) CheckUserHaveSavedPayment(ctx context.Context, userID string) (bool, error) {
+	vault, err := s.vaultRepo.GetDefault(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get default vault: %w", err)
+	}
+
+	return vault.VaultID != "", nil
+}
+
+func (s *paypalServiceImpl) ListPaymentMethods(ctx context.Context, userID string) ([]*model.UserVault, error) {
+	return s.vaultRepo.List(ctx, userID)
+}
+
+func (s *paypalServiceImpl) SetDefaultPaymentMethod(ctx context.Context, userID string, vaultID string) error {
+	return s.vaultRepo.SetDefault(ctx, userID, vaultID)
+}
+
+func (s *paypalServiceImpl) DeletePaymentMethod(ctx context.Context, userID string, vaultID string) error {
+	vault, err := s.vaultRepo.Get(ctx, userID, vaultID)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.paypalClient.CaptureOrder(ctx, orderID, merchantAccessToken)
+	merchantAuth := &client.MerchantAuth{}
+	if vault.MerchantID != "" {
+		merchantAuth, _, err = s.resolveMerchantAuth(ctx, vault.MerchantID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.paypalClient.DeletePaymentToken(ctx, vault.VaultID, merchantAuth); err != nil {
+		return fmt.Errorf("paypal api delete payment token: %w", err)
+	}
+
+	return s.vaultRepo.Delete(ctx, userID, vaultID)
+}
+
+// StartVaultSetup saves a PayPal account for later without placing an order.
+// Without a merchant the token is vaulted under the partner account.
+func (s *paypalServiceImpl) StartVaultSetup(ctx context.Context, merchantID string, userID string) (string, error) {
+	merchantAuth := &client.MerchantAuth{}
+	if merchantID != "" {
+		var err error
+		merchantAuth, _, err = s.resolveMerchantAuth(ctx, merchantID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	setupTokenID, approveURL, err := s.paypalClient.CreateSetupToken(
+		ctx,
+		fmt.Sprintf("%s/api/paypal/payment-methods/setup/return", s.serviceBaseUrl),
+		s.serviceBaseUrl,
+		merchantAuth,
+	)
 	if err != nil {
-		return fmt.Errorf("paypal api capture order: %w", err)
+		return "", fmt.Errorf("paypal api create setup token: %w", err)
 	}
 
-	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		return s.orderRepo.MarkCompleted(ctx, tx, orderID)
+	err = s.vaultRepo.CreateSetup(ctx, &model.VaultSetup{
+		SetupTokenID: setupTokenID,
+		UserID:       userID,
+		MerchantID:   merchantID,
+		Status:       "CREATED",
 	})
+	if err != nil {
+		return "", fmt.Errorf("store vault setup: %w", err)
+	}
+
+	return approveURL, nil
 }
 
-func (s *paypalServiceImpl) CheckUserHaveSavedPayment(ctx context.Context, userID string) (bool, error) {
-	vaultID, err := s.vaultRepo.GetVaultID(ctx, userID)
+func (s *paypalServiceImpl) CompleteVaultSetup(ctx context.Context, setupTokenID string) (*model.UserVault, error) {
+	setup, err := s.vaultRepo.GetSetup(ctx, setupTokenID)
+	if err != nil {
+		return nil, fmt.Errorf("get vault setup: %w", err)
+	}
+
+	if setup.Status == "VAULTED" {
+		return s.vaultRepo.Get(ctx, setup.UserID, setup.VaultID)
+	}
+
+	merchantAuth := &client.MerchantAuth{}
+	if setup.MerchantID != "" {
+		merchantAuth, _, err = s.resolveMerchantAuth(ctx, setup.MerchantID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := s.paypalClient.CreatePaymentToken(ctx, setupTokenID, merchantAuth)
 	if err != nil {
-		return false, fmt.Errorf("no vaulted payment method")
+		return nil, fmt.Errorf("paypal api create payment token: %w", err)
 	}
 
-	return vaultID != "", nil
+	vault := &model.UserVault{
+		UserID:     setup.UserID,
+		VaultID:    token.ID,
+		Provider:   string(model.VAULT_PAYPAL),
+		MerchantID: setup.MerchantID,
+		PayerEmail: token.PaymentSource.PayPal.Email,
+		Country:    token.PaymentSource.PayPal.Address.CountryCode,
+		Region:     token.PaymentSource.PayPal.Address.AdminArea1,
+	}
+	if err := s.vaultRepo.Create(ctx, vault); err != nil {
+		return nil, fmt.Errorf("save user paypal vault: %w", err)
+	}
+
+	if err := s.vaultRepo.CompleteSetup(ctx, setupTokenID, token.ID); err != nil {
+		return nil, fmt.Errorf("complete vault setup: %w", err)
+	}
+
+	return vault, nil
 }
 
 func (s *paypalServiceImpl) HandleWebhook(ctx context.Context, headers http.Header, body []byte) error {
@@ -275,66 +769,264 @@ func (s *paypalServiceImpl) HandleWebhook(ctx context.Context, headers http.Head
 		return fmt.Errorf("decode webhook payload: %w", err)
 	}
 
+	// PayPal redelivers events until it gets a 2xx, even ones already handled
+	processed, err := s.webhookEventRepo.Exists(ctx, eventPayload.ID)
+	if err != nil {
+		return fmt.Errorf("check webhook event processed: %w", err)
+	}
+	if processed {
+		return nil
+	}
+
+	handleErr := s.dispatchWebhook(ctx, &eventPayload)
+
+	// every delivery is kept so support can look up what PayPal sent for an order
+	record := &model.WebhookEvent{
+		EventID:    eventPayload.ID,
+		EventType:  eventPayload.EventType,
+		ResourceID: _webhookResourceID(&eventPayload),
+		OrderID:    _webhookOrderID(&eventPayload),
+		Status:     "PROCESSED",
+	}
+	if handleErr != nil {
+		record.Status = "FAILED"
+		record.LastError = handleErr.Error()
+	} else {
+		now := time.Now()
+		record.ProcessedAt = &now
+	}
+	if err := s.webhookEventRepo.Record(ctx, record); err != nil {
+		if handleErr != nil {
+			return handleErr
+		}
+		return fmt.Errorf("record webhook event: %w", err)
+	}
+
+	return handleErr
+}
+
+func (s *paypalServiceImpl) dispatchWebhook(ctx context.Context, eventPayload *model.PayPalWebhookEvent) error {
 	switch eventPayload.EventType {
 	case "PAYMENT.CAPTURE.COMPLETED":
 		// mark order as paid, grant items to user
-		return s.handleOrderPaid(ctx, &eventPayload)
+		return s.handleOrderPaid(ctx, eventPayload)
+	case "PAYMENT.CAPTURE.DENIED", "PAYMENT.CAPTURE.DECLINED":
+		// only the failed purchase unit is affected, the others keep their items
+		return s.handleCaptureFailed(ctx, eventPayload)
+	case "PAYMENT.CAPTURE.REFUNDED":
+		// recorded for the order history, refunded items are only revoked when a dispute is won by the buyer
+		return s.handleCaptureRefunded(ctx, eventPayload)
 	case "VAULT.PAYMENT-TOKEN.CREATED":
-		return s.handlePaymentTokenCreated(ctx, &eventPayload)
+		return s.handlePaymentTokenCreated(ctx, eventPayload)
+	case "CUSTOMER.DISPUTE.CREATED", "CUSTOMER.DISPUTE.UPDATED", "CUSTOMER.DISPUTE.RESOLVED":
+		// items of the disputed unit stay frozen until the dispute is resolved
+		return s.disputeService.HandleDisputeEvent(ctx, eventPayload)
+	case "PAYMENT.PAYOUTS-ITEM.SUCCEEDED", "PAYMENT.PAYOUTS-ITEM.UNCLAIMED", "PAYMENT.PAYOUTS-ITEM.HELD",
+		"PAYMENT.PAYOUTS-ITEM.RETURNED", "PAYMENT.PAYOUTS-ITEM.FAILED", "PAYMENT.PAYOUTS-ITEM.BLOCKED",
+		"PAYMENT.PAYOUTS-ITEM.REFUNDED", "PAYMENT.PAYOUTS-ITEM.CANCELED", "PAYMENT.PAYOUTS-ITEM.DENIED":
+		// returned and failed items give the amount back to the creator's earnings
+		return s.payoutService.HandlePayoutItemEvent(ctx, eventPayload)
+	case "INVOICING.INVOICE.PAID", "INVOICING.INVOICE.CANCELLED":
+		// paid invoices grant their items like a captured order
+		return s.invoiceService.HandleInvoiceEvent(ctx, eventPayload)
 	case "BILLING.SUBSCRIPTION.ACTIVATED":
 		// activate subscription
 		fmt.Println("subscription activated")
-		return s.handleSubscriptionActivated(ctx, &eventPayload)
+		return s.handleSubscriptionActivated(ctx, eventPayload)
 	case "BILLING.SUBSCRIPTION.CANCELLED":
 		fmt.Println("subscription canceled")
-		return s.handleSubscriptionCancelled(ctx, &eventPayload)
+		return s.handleSubscriptionCancelled(ctx, eventPayload)
 	}
 
 	return nil
 }
 
+func _webhookResourceID(event *model.PayPalWebhookEvent) string {
+	if event.Resource.DisputeID != "" {
+		return event.Resource.DisputeID
+	}
+	if event.Resource.PayoutItemID != "" {
+		return event.Resource.PayoutItemID
+	}
+	if event.Resource.Invoice.ID != "" {
+		return event.Resource.Invoice.ID
+	}
+	return event.Resource.ID
+}
+
+func _webhookOrderID(event *model.PayPalWebhookEvent) string {
+	if orderID := event.Resource.SupplementaryData.RelatedIDs.OrderID; orderID != "" {
+		return orderID
+	}
+	// invoices are stored as orders under the invoice id
+	if invoiceID := event.Resource.Invoice.ID; invoiceID != "" {
+		return invoiceID
+	}
+	// payment token events carry the order they were vaulted with in metadata
+	return event.Resource.Metadata.OrderID
+}
+
 func (s *paypalServiceImpl) handleOrderPaid(ctx context.Context, eventPayload *model.PayPalWebhookEvent) error {
-	orderID := eventPayload.Resource.SupplementaryData.RelatedIDs.OrderID
+	resource := eventPayload.Resource
+	orderID := resource.SupplementaryData.RelatedIDs.OrderID
 	if orderID == "" {
 		return fmt.Errorf("could not find order_id in webhook payload")
 	}
 
-	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		orderInfo, err := s.orderRepo.MarkPaid(ctx, tx, orderID)
+	orderInfo, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("get order detail: %w", err)
+	}
+
+	return s.orderTransaction(ctx, func(tx *gorm.DB, events *orderEvents) error {
+		// the capture's invoice_id is the reference id of the purchase unit it paid
+		unit, err := s.orderRepo.FindUnitByReferenceID(ctx, tx, resource.InvoiceID)
+		if errors.Is(err, gorm.ErrRecordNotFound) || resource.InvoiceID == "" {
+			granted, err := s.grantWholeOrder(ctx, tx, orderID)
+			if err != nil || !granted {
+				return err
+			}
+			events.add(orderID, OrderEventGranted, "COMPLETED")
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("mark order paid: %w", err)
+			return fmt.Errorf("get order unit: %w", err)
 		}
 
-		orderItems, err := s.orderRepo.GetOrderItems(ctx, tx, orderID)
+		granted, err := s.orderRepo.MarkUnitPaid(ctx, tx, unit.ReferenceID, resource.ID)
 		if err != nil {
-			return fmt.Errorf("get order items: %w", err)
-		}
-
-		// grant items to user inventory
-		for _, item := range orderItems {
-			err = s.inventoryRepo.Upsert(ctx, tx, &model.UserInventory{
-				UserID:    orderInfo.UserID,
-				ProductID: item.ProductID,
-				Quantity:  item.Quantity,
-			})
-			if err != nil {
-				return fmt.Errorf("update user inventory: %w", err)
-			}
+			return fmt.Errorf("mark order unit paid: %w", err)
+		}
+		if !granted {
+			return nil
+		}
+
+		unitItems, err := s.orderRepo.GetUnitItems(ctx, tx, unit.ReferenceID)
+		if err != nil {
+			return fmt.Errorf("get order unit items: %w", err)
+		}
+
+		if err := s.grantItems(ctx, tx, orderInfo.UserID, unitItems); err != nil {
+			return err
+		}
+
+		status, err := s.refreshOrderStatus(ctx, tx, events, orderID)
+		if err != nil {
+			return err
+		}
+		if status == "" {
+			// other merchants' units are still being captured
+			status = orderInfo.Status
 		}
 
+		events.add(orderID, OrderEventGranted, status)
 		return nil
 	})
 }
 
+// grantWholeOrder handles orders stored before purchase units were tracked.
+// It reports false when the order was already paid and nothing was granted.
+func (s *itemGranter) grantWholeOrder(ctx context.Context, tx *gorm.DB, orderID string) (bool, error) {
+	orderInfo, paid, err := s.orderRepo.MarkPaid(ctx, tx, orderID)
+	if err != nil {
+		return false, fmt.Errorf("mark order paid: %w", err)
+	}
+	if !paid {
+		return false, nil
+	}
+
+	orderItems, err := s.orderRepo.GetOrderItems(ctx, tx, orderID)
+	if err != nil {
+		return false, fmt.Errorf("get order items: %w", err)
+	}
+
+	return true, s.grantItems(ctx, tx, orderInfo.UserID, orderItems)
+}
+
+func (s *paypalServiceImpl) handleCaptureFailed(ctx context.Context, eventPayload *model.PayPalWebhookEvent) error {
+	resource := eventPayload.Resource
+	if resource.InvoiceID == "" {
+		return nil
+	}
+
+	return s.orderTransaction(ctx, func(tx *gorm.DB, events *orderEvents) error {
+		unit, err := s.orderRepo.FindUnitByReferenceID(ctx, tx, resource.InvoiceID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return fmt.Errorf("get order unit: %w", err)
+		}
+
+		if err := s.orderRepo.UpdateUnitCapture(ctx, tx, unit.ReferenceID, resource.ID, "FAILED"); err != nil {
+			return fmt.Errorf("mark order unit failed: %w", err)
+		}
+
+		_, err = s.refreshOrderStatus(ctx, tx, events, unit.OrderID)
+		return err
+	})
+}
+
+func (s *paypalServiceImpl) handleCaptureRefunded(ctx context.Context, eventPayload *model.PayPalWebhookEvent) error {
+	resource := eventPayload.Resource
+	if resource.ID == "" {
+		return fmt.Errorf("missing refund id in webhook payload")
+	}
+
+	refund := &model.OrderRefund{
+		RefundID:    resource.ID,
+		OrderID:     resource.SupplementaryData.RelatedIDs.OrderID,
+		ReferenceID: resource.InvoiceID,
+		CaptureID:   _refundedCaptureID(resource.Links),
+		Amount:      resource.Amount.Value,
+		Currency:    resource.Amount.Currency,
+		Status:      resource.Status,
+	}
+
+	// refunds carry the invoice_id of the capture, i.e. the purchase unit
+	if resource.InvoiceID != "" {
+		unit, err := s.orderRepo.FindUnitByReferenceID(ctx, s.db, resource.InvoiceID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("get order unit: %w", err)
+		}
+		if err == nil {
+			refund.OrderID = unit.OrderID
+			if refund.CaptureID == "" {
+				refund.CaptureID = unit.CaptureID
+			}
+		}
+	}
+	if refund.OrderID == "" {
+		// not one of our orders
+		return nil
+	}
+
+	if err := s.orderRepo.UpsertRefund(ctx, refund); err != nil {
+		return fmt.Errorf("store order refund: %w", err)
+	}
+	return nil
+}
+
+// _refundedCaptureID reads the capture id from the refund's "up" link
+func _refundedCaptureID(links []model.PaypalLink) string {
+	for _, link := range links {
+		if link.Rel == "up" {
+			return path.Base(link.Href)
+		}
+	}
+	return ""
+}
+
 func (s *paypalServiceImpl) handlePaymentTokenCreated(ctx context.Context, event *model.PayPalWebhookEvent) error {
 	resource := event.Resource
 	if resource.ID == "" {
 		return fmt.Errorf("missing vault_id in PAYMENT.TOKEN.CREATED event payload")
 	}
 
+	// tokens without an order come from setup tokens and are stored when the user returns
 	orderID := resource.Metadata.OrderID
 	if orderID == "" {
-		return fmt.Errorf("missing user id in PAYMENT.TOKEN.CREATED event payload")
+		return nil
 	}
 
 	orderInfo, err := s.orderRepo.FindByOrderID(ctx, orderID)
@@ -342,12 +1034,25 @@ func (s *paypalServiceImpl) handlePaymentTokenCreated(ctx context.Context, event
 		return fmt.Errorf("mark order paid: %w", err)
 	}
 
+	vault := &model.UserVault{
+		UserID:     orderInfo.UserID,
+		VaultID:    resource.ID,
+		Provider:   string(model.VAULT_PAYPAL),
+		MerchantID: orderInfo.MerchantID,
+		PayerEmail: resource.PaymentResource.PayPal.Email,
+		Country:    resource.PaymentResource.PayPal.Address.CountryCode,
+		Region:     resource.PaymentResource.PayPal.Address.AdminArea1,
+	}
+	if card := resource.PaymentResource.Card; card.LastDigits != "" {
+		vault.Provider = string(model.VAULT_CARD)
+		vault.CardBrand = card.Brand
+		vault.CardLastDigits = card.LastDigits
+		vault.Country = card.BillingAddress.CountryCode
+		vault.Region = card.BillingAddress.AdminArea1
+	}
+
 	// Upsert user vault info
-	err = s.vaultRepo.Create(ctx, &model.UserVault{
-		UserID:   orderInfo.UserID,
-		VaultID:  resource.ID,
-		Provider: "paypal",
-	})
+	err = s.vaultRepo.Create(ctx, vault)
 	if err != nil {
 		return fmt.Errorf("save user paypal vault: %w", err)
 	}
@@ -377,7 +1082,12 @@ func (s *paypalServiceImpl) handleSubscriptionCancelled(ctx context.Context, eve
 	return s.subscriptionRepo.CancelSubscription(ctx, subID)
 }
 
-func (s *paypalServiceImpl) SubscribeSubscription(ctx context.Context, userID string, productID string, merchantID string) (approveURL string, err error) {
+func (s *paypalServiceImpl) SubscribeSubscription(ctx context.Context, userID string, clientProfile string, productID string, merchantID string) (approveURL string, err error) {
+	profile, ok := s.clientProfiles.Get(clientProfile)
+	if !ok {
+		return "", ErrUnknownClientProfile
+	}
+
 	product, err := s.productRepo.FindByID(ctx, productID)
 	if err != nil {
 		return "", err
@@ -400,7 +1110,7 @@ func (s *paypalServiceImpl) SubscribeSubscription(ctx context.Context, userID st
 
 	subID, approveURL, err := s.paypalClient.CreateUserSubscription(
 		ctx,
-		s.serviceBaseUrl,
+		s.experience(profile, "/api/paypal/subscription/success", "/api/paypal/subscription/cancelled"),
 		plan.PayPalPlanID,
 		userID,
 		merchantAccessToken,
@@ -417,6 +1127,7 @@ func (s *paypalServiceImpl) SubscribeSubscription(ctx context.Context, userID st
 		MerchantID:           merchantID,
 		PayPalSubscriptionID: subID,
 		Status:               "PENDING",
+		ClientProfile:        profile.Name,
 	})
 	if err != nil {
 		return "", err
@@ -425,8 +1136,60 @@ func (s *paypalServiceImpl) SubscribeSubscription(ctx context.Context, userID st
 	return approveURL, nil
 }
 
-func (s *paypalServiceImpl) HandleSubscriptionSuccess(ctx context.Context, subscriptionID string) error {
-	return nil
+// HandleSubscriptionSuccess describes the subscription once PayPal returns for
+// it, the subscription itself is activated by the BILLING.SUBSCRIPTION.ACTIVATED webhook
+func (s *paypalServiceImpl) HandleSubscriptionSuccess(ctx context.Context, subscriptionID string, cancelled bool) (*dto.SubscriptionResult, error) {
+	sub, err := s.subscriptionRepo.GetBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("get subscription: %w", err)
+	}
+
+	productName := sub.ProductID
+	if product, err := s.productRepo.FindByID(ctx, sub.ProductID); err == nil {
+		productName = product.Name
+	}
+
+	status := sub.Status
+	if cancelled && sub.Status == "PENDING" {
+		// the subscriber never approved it, no webhook will follow
+		if err := s.subscriptionRepo.CancelSubscription(ctx, subscriptionID); err != nil {
+			return nil, fmt.Errorf("cancel subscription: %w", err)
+		}
+	}
+	if cancelled {
+		status = "CANCELLED"
+	}
+
+	result := &dto.SubscriptionResult{
+		SubscriptionID: subscriptionID,
+		MerchantID:     sub.MerchantID,
+		ProductName:    productName,
+		Status:         status,
+		Outcome:        _subscriptionOutcome(status),
+	}
+
+	profile := s.returnProfile(sub.ClientProfile)
+	result.HomeURL = profile.ReturnURL
+	if profile.Name != config.DefaultClientProfile {
+		result.RedirectURL = profile.ResultURL(cancelled, url.Values{
+			"subscription_id": {subscriptionID},
+			"status":          {status},
+		})
+	}
+
+	return result, nil
+}
+
+func _subscriptionOutcome(status string) string {
+	switch status {
+	case "ACTIVE":
+		return "active"
+	case "CANCELLED":
+		return "cancelled"
+	case "SUSPENDED", "EXPIRED":
+		return "failed"
+	}
+	return "pending"
 }
 
 func (s *paypalServiceImpl) HasActiveSubscription(ctx context.Context, userID string, merchantID string) (bool, error) {
@@ -460,7 +1223,43 @@ func (s *paypalServiceImpl) CancelSubscription(ctx context.Context, userID strin
 	return s.subscriptionRepo.CancelSubscription(ctx, sub.PayPalSubscriptionID)
 }
 
-func (s *paypalServiceImpl) getValidMerchantAccessToken(ctx context.Context, merchantID string) (string, error) {
+// merchantAuth gets the credentials for calls on a merchant's PayPal account,
+// for every service that acts as the merchant
+type merchantAuth struct {
+	merchantRepo repository.MerchantRepository
+	paypalClient client.PaypalClient
+}
+
+// orderMerchantAuth returns the credentials the order was created with
+func (s *merchantAuth) orderMerchantAuth(ctx context.Context, order *model.Order) (*client.MerchantAuth, error) {
+	if order.MerchantID == "" {
+		return &client.MerchantAuth{}, nil
+	}
+
+	merchantAuth, _, err := s.resolveMerchantAuth(ctx, order.MerchantID)
+	return merchantAuth, err
+}
+
+// paypalOrderClosed reports whether PayPal can no longer capture the order:
+// it was voided, or it expired and PayPal does not know it anymore.
+func (s *merchantAuth) paypalOrderClosed(ctx context.Context, order *model.Order) (bool, error) {
+	auth, err := s.orderMerchantAuth(ctx, order)
+	if err != nil {
+		return false, err
+	}
+
+	remote, err := s.paypalClient.GetOrder(ctx, order.OrderID, auth)
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return remote.Status == "VOIDED", nil
+}
+
+func (s *merchantAuth) getValidMerchantAccessToken(ctx context.Context, merchantID string) (string, error) {
 	merchant, err := s.merchantRepo.Get(ctx, merchantID)
 	if err != nil {
 		return "", fmt.Errorf("merchant not found")
@@ -497,6 +1296,39 @@ func (s *paypalServiceImpl) getValidMerchantAccessToken(ctx context.Context, mer
 	return token.AccessToken, nil
 }
 
+// resolveMerchantAuth picks the credentials for order calls on a merchant's
+// account. Merchants onboarded through Partner Referrals are charged with the
+// partner token and an auth assertion, others with their own OAuth token.
+func (s *merchantAuth) resolveMerchantAuth(ctx context.Context, merchantID string) (*client.MerchantAuth, *model.Merchant, error) {
+	merchant, err := s.merchantRepo.Get(ctx, merchantID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("merchant not found")
+	}
+
+	if merchant.OnboardingStatus == string(model.ONBOARDING_COMPLETED) && merchant.PayPalMerchantID != "" {
+		return &client.MerchantAuth{PayerID: merchant.PayPalMerchantID}, merchant, nil
+	}
+
+	merchantAccessToken, err := s.getValidMerchantAccessToken(ctx, merchantID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &client.MerchantAuth{AccessToken: merchantAccessToken}, merchant, nil
+}
+
+// _platformFee returns the fee in minor units, never more than the order amount
+func _platformFee(rule *model.MerchantFeeRule, currency string, amountMinor int64) int64 {
+	fee := amountMinor * int64(rule.PercentBps) / 10000
+	if rule.Currency == currency {
+		fee += rule.FixedFee
+	}
+	if fee > amountMinor {
+		fee = amountMinor
+	}
+	return fee
+}
+
 func (s *paypalServiceImpl) SetExistingProductsSubPlan(ctx context.Context, merchantID string, merchantAccessToken string) error {
 	subscriptionProducts, err := s.productRepo.GetByType(ctx, model.SUBSCRIPTION)
 	if err != nil {
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type PromotionService interface {
+	CreatePromotion(ctx context.Context, req *dto.CreatePromotionRequest) (*model.Promotion, error)
+	ListPromotions(ctx context.Context) ([]*model.Promotion, error)
+	SetPromotionActive(ctx context.Context, promotionID string, active bool) error
+	// RunRedemptionSweeper gives back the redemptions of abandoned approval
+	// orders PayPal has closed every interval until ctx is done
+	RunRedemptionSweeper(ctx context.Context, interval time.Duration)
+}
+
+type promotionServiceImpl struct {
+	merchantAuth
+	promotionRepo repository.PromotionRepository
+	orderRepo     repository.OrderRepository
+}
+
+func NewPromotionService(
+	paypalClient client.PaypalClient,
+	merchantRepo repository.MerchantRepository,
+	orderRepo repository.OrderRepository,
+	promotionRepo repository.PromotionRepository,
+) PromotionService {
+	return &promotionServiceImpl{
+		merchantAuth: merchantAuth{
+			merchantRepo: merchantRepo,
+			paypalClient: paypalClient,
+		},
+		promotionRepo: promotionRepo,
+		orderRepo:     orderRepo,
+	}
+}
+
+func (s *promotionServiceImpl) CreatePromotion(ctx context.Context, req *dto.CreatePromotionRequest) (*model.Promotion, error) {
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidPromotion)
+	}
+	switch model.DiscountType(req.DiscountType) {
+	case model.DISCOUNT_PERCENT:
+		if req.Value <= 0 || req.Value > 10000 {
+			return nil, fmt.Errorf("%w: percent value must be 1-10000 basis points", ErrInvalidPromotion)
+		}
+	case model.DISCOUNT_FIXED:
+		if req.Value <= 0 {
+			return nil, fmt.Errorf("%w: fixed value must be positive", ErrInvalidPromotion)
+		}
+	case model.DISCOUNT_SALE_PRICE:
+		// a sale price only makes sense for one product
+		if req.Value <= 0 || req.ProductID == "" {
+			return nil, fmt.Errorf("%w: sale price needs a positive value and a product", ErrInvalidPromotion)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown discount type %q", ErrInvalidPromotion, req.DiscountType)
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return nil, fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidPromotion)
+	}
+	if req.MaxRedemptions < 0 || req.MaxPerUser < 0 {
+		return nil, fmt.Errorf("%w: limits cannot be negative", ErrInvalidPromotion)
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(req.Code))
+	if code != "" {
+		_, err := s.promotionRepo.FindByCode(ctx, code)
+		if err == nil {
+			return nil, fmt.Errorf("%w: code %s is already active", ErrInvalidPromotion, code)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("find promotion by code: %w", err)
+		}
+	}
+
+	promotion := &model.Promotion{
+		ID:                uuid.NewString(),
+		Code:              code,
+		Name:              req.Name,
+		DiscountType:      req.DiscountType,
+		Value:             req.Value,
+		ProductID:         req.ProductID,
+		MerchantID:        req.MerchantID,
+		FirstPurchaseOnly: req.FirstPurchaseOnly,
+		StartsAt:          req.StartsAt,
+		EndsAt:            req.EndsAt,
+		MaxRedemptions:    req.MaxRedemptions,
+		MaxPerUser:        req.MaxPerUser,
+		Active:            true,
+	}
+	if err := s.promotionRepo.Create(ctx, promotion); err != nil {
+		return nil, fmt.Errorf("create promotion: %w", err)
+	}
+
+	return promotion, nil
+}
+
+func (s *promotionServiceImpl) ListPromotions(ctx context.Context) ([]*model.Promotion, error) {
+	return s.promotionRepo.List(ctx)
+}
+
+func (s *promotionServiceImpl) SetPromotionActive(ctx context.Context, promotionID string, active bool) error {
+	return s.promotionRepo.SetActive(ctx, promotionID, active)
+}
+
+func (s *promotionServiceImpl) RunRedemptionSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// the same window after which abandoned orders stop counting as spend
+			createdBefore := now.Add(-pendingOrderWindow)
+			orderIDs, err := s.promotionRepo.FindAbandonedOrders(ctx, createdBefore, 100)
+			if err != nil {
+				log.Printf("find abandoned promotion orders: %v", err)
+				continue
+			}
+			for _, orderID := range orderIDs {
+				if err := s.releaseAbandonedOrder(ctx, orderID, createdBefore); err != nil {
+					log.Printf("release promotions of abandoned order %s: %v", orderID, err)
+				}
+			}
+		}
+	}
+}
+
+// releaseAbandonedOrder gives the redemptions back once PayPal reports the
+// order closed. An order left alone may still be approved and captured until
+// it expires, so old age alone does not release its coupon.
+func (s *promotionServiceImpl) releaseAbandonedOrder(ctx context.Context, orderID string, createdBefore time.Time) error {
+	order, err := s.orderRepo.FindByOrderID(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("get order: %w", err)
+	}
+
+	closed, err := s.paypalOrderClosed(ctx, order)
+	if err != nil {
+		return fmt.Errorf("get paypal order: %w", err)
+	}
+	if !closed {
+		return nil
+	}
+
+	return s.promotionRepo.ReleaseAbandonedOrder(ctx, orderID, createdBefore)
+}
+
+// checkoutLine is one product of a cart as promotions see it
+type checkoutLine struct {
+	productID  string
+	name       string
+	merchantID string
+	quantity   int32
+	unitPrice  int64 // minor units
+	discount   int64 // minor units for the whole line, filled by applyPromotions
+	unitTax    int64 // minor units per unit, filled by quoteTax
+}
+
+func (l *checkoutLine) remaining() int64 {
+	return l.unitPrice*int64(l.quantity) - l.discount
+}
+
+type appliedPromotion struct {
+	promotion *model.Promotion
+	discount  int64 // minor units
+}
+
+// applyPromotions prices the cart with every eligible automatic promotion and
+// the coupon, in that order. Each one discounts what the earlier ones left, so
+// a line never goes below zero.
+func (s *paypalServiceImpl) applyPromotions(ctx context.Context, userID string, couponCode string, lines []*checkoutLine) ([]*appliedPromotion, error) {
+	now := time.Now()
+	promotions, err := s.promotionRepo.FindAutomatic(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("find automatic promotions: %w", err)
+	}
+
+	var coupon *model.Promotion
+	code := strings.ToUpper(strings.TrimSpace(couponCode))
+	if code != "" {
+		coupon, err = s.promotionRepo.FindByCode(ctx, code)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &CouponError{Code: code, Reason: "unknown coupon"}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("find promotion by code: %w", err)
+		}
+		promotions = append(promotions, coupon)
+	}
+
+	var applied []*appliedPromotion
+	for _, promotion := range promotions {
+		reason, err := s.promotionUnavailable(ctx, userID, promotion, now)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			if discount := _applyPromotion(promotion, lines); discount > 0 {
+				applied = append(applied, &appliedPromotion{promotion: promotion, discount: discount})
+				continue
+			}
+			reason = "no eligible items in the cart"
+		}
+		// automatic promotions that do not apply are silently skipped
+		if promotion == coupon {
+			return nil, &CouponError{Code: code, Reason: reason}
+		}
+	}
+
+	return applied, nil
+}
+
+// promotionUnavailable returns why the user cannot use promotion right now,
+// empty when they can. Limits are checked again under lock by Reserve.
+func (s *paypalServiceImpl) promotionUnavailable(ctx context.Context, userID string, promotion *model.Promotion, now time.Time) (string, error) {
+	if promotion.StartsAt != nil && now.Before(*promotion.StartsAt) {
+		return "not started yet", nil
+	}
+	if promotion.EndsAt != nil && !now.Before(*promotion.EndsAt) {
+		return "expired", nil
+	}
+	if promotion.MaxRedemptions > 0 && promotion.Redemptions >= promotion.MaxRedemptions {
+		return "redemption limit reached", nil
+	}
+	if promotion.MaxPerUser > 0 {
+		count, err := s.promotionRepo.CountUserRedemptions(ctx, promotion.ID, userID)
+		if err != nil {
+			return "", fmt.Errorf("count user redemptions: %w", err)
+		}
+		if count >= int64(promotion.MaxPerUser) {
+			return "already used", nil
+		}
+	}
+	if promotion.FirstPurchaseOnly {
+		count, err := s.orderRepo.CountPaidOrders(ctx, userID)
+		if err != nil {
+			return "", fmt.Errorf("count paid orders: %w", err)
+		}
+		if count > 0 {
+			return "first purchase only", nil
+		}
+	}
+
+	return "", nil
+}
+
+// _applyPromotion adds the promotion's discount to the lines in scope and
+// returns the total it took off
+func _applyPromotion(promotion *model.Promotion, lines []*checkoutLine) int64 {
+	fixedLeft := promotion.Value
+	total := int64(0)
+	for _, line := range lines {
+		if promotion.ProductID != "" && promotion.ProductID != line.productID {
+			continue
+		}
+		if promotion.MerchantID != "" && promotion.MerchantID != line.merchantID {
+			continue
+		}
+
+		discount := int64(0)
+		switch model.DiscountType(promotion.DiscountType) {
+		case model.DISCOUNT_PERCENT:
+			discount = line.remaining() * promotion.Value / 10000
+		case model.DISCOUNT_FIXED:
+			discount = fixedLeft
+			fixedLeft -= min(fixedLeft, line.remaining())
+		case model.DISCOUNT_SALE_PRICE:
+			discount = max(0, line.unitPrice-promotion.Value) * int64(line.quantity)
+		}
+		discount = min(discount, line.remaining())
+
+		line.discount += discount
+		total += discount
+	}
+
+	return total
+}
+
+// reservePromotions counts the applied promotions against their limits before
+// the PayPal order exists, storeOrder then attaches them to the order
+func (s *paypalServiceImpl) reservePromotions(ctx context.Context, userID string, co *checkout) error {
+	if len(co.promotions) == 0 {
+		return nil
+	}
+
+	reservationID := uuid.NewString()
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, applied := range co.promotions {
+			err := s.promotionRepo.Reserve(ctx, tx, applied.promotion, &model.PromotionRedemption{
+				PromotionID:   applied.promotion.ID,
+				UserID:        userID,
+				ReservationID: reservationID,
+				Discount:      applied.discount,
+			})
+			if errors.Is(err, repository.ErrPromotionExhausted) {
+				return &CouponError{Code: applied.promotion.Code, Reason: "redemption limit reached"}
+			}
+			if err != nil {
+				return fmt.Errorf("reserve promotion %s: %w", applied.promotion.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	co.reservationID = reservationID
+	return nil
+}
+
+// releaseOrderPromotions gives the order's redemptions back once it will not be paid
+func (s *paypalServiceImpl) releaseOrderPromotions(ctx context.Context, tx *gorm.DB, orderID string) error {
+	if err := s.promotionRepo.ReleaseOrderRedemptions(ctx, tx, orderID); err != nil {
+		return fmt.Errorf("release promotion redemptions: %w", err)
+	}
+	return nil
+}
+
+// releasePromotions gives the reserved redemptions back when no order was stored
+func (s *paypalServiceImpl) releasePromotions(ctx context.Context, co *checkout) {
+	if co.reservationID == "" {
+		return
+	}
+	if err := s.promotionRepo.ReleaseReservation(ctx, co.reservationID); err != nil {
+		log.Printf("release promotion reservation %s: %v", co.reservationID, err)
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+func newTestPromotion(t *testing.T, db *gorm.DB, maxRedemptions int32, maxPerUser int32) *model.Promotion {
+	t.Helper()
+
+	promotion := &model.Promotion{
+		ID:             "promo-1",
+		Code:           "SAVE10",
+		Name:           "Save 10",
+		DiscountType:   string(model.DISCOUNT_FIXED),
+		Value:          1000,
+		MaxRedemptions: maxRedemptions,
+		MaxPerUser:     maxPerUser,
+		Active:         true,
+	}
+	if err := db.Create(promotion).Error; err != nil {
+		t.Fatalf("create promotion: %v", err)
+	}
+	return promotion
+}
+
+func _couponCheckout(promotion *model.Promotion) *checkout {
+	return &checkout{promotions: []*appliedPromotion{{promotion: promotion, discount: 1000}}}
+}
+
+func _promotionRedemptions(t *testing.T, db *gorm.DB, promotionID string) int32 {
+	t.Helper()
+
+	var promotion model.Promotion
+	if err := db.First(&promotion, "id = ?", promotionID).Error; err != nil {
+		t.Fatalf("get promotion: %v", err)
+	}
+	return promotion.Redemptions
+}
+
+func TestReservePromotionsPerUserCap(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := &paypalServiceImpl{db: db, promotionRepo: repository.NewPromotionRepository(db)}
+	promotion := newTestPromotion(t, db, 0, 1)
+
+	if err := s.reservePromotions(ctx, "user-1", _couponCheckout(promotion)); err != nil {
+		t.Fatalf("first reservation: %v", err)
+	}
+
+	var couponErr *CouponError
+	if err := s.reservePromotions(ctx, "user-1", _couponCheckout(promotion)); !errors.As(err, &couponErr) {
+		t.Fatalf("second reservation of the same user: got %v, want CouponError", err)
+	}
+
+	if err := s.reservePromotions(ctx, "user-2", _couponCheckout(promotion)); err != nil {
+		t.Fatalf("reservation of another user: %v", err)
+	}
+
+	// the rejected reservation did not count
+	if got := _promotionRedemptions(t, db, promotion.ID); got != 2 {
+		t.Fatalf("redemptions = %d, want 2", got)
+	}
+}
+
+func TestReleasePromotionsGivesRedemptionBack(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	s := &paypalServiceImpl{db: db, promotionRepo: repository.NewPromotionRepository(db)}
+	promotion := newTestPromotion(t, db, 1, 0)
+
+	reserved := _couponCheckout(promotion)
+	if err := s.reservePromotions(ctx, "user-1", reserved); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+
+	var couponErr *CouponError
+	if err := s.reservePromotions(ctx, "user-2", _couponCheckout(promotion)); !errors.As(err, &couponErr) {
+		t.Fatalf("reservation over the global limit: got %v, want CouponError", err)
+	}
+
+	s.releasePromotions(ctx, reserved)
+	// releasing twice must not give the redemption back twice
+	s.releasePromotions(ctx, reserved)
+	if got := _promotionRedemptions(t, db, promotion.ID); got != 0 {
+		t.Fatalf("redemptions after release = %d, want 0", got)
+	}
+
+	if err := s.reservePromotions(ctx, "user-2", _couponCheckout(promotion)); err != nil {
+		t.Fatalf("reservation after the release: %v", err)
+	}
+}
+
+func TestReleaseOrderPromotions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	promotionRepo := repository.NewPromotionRepository(db)
+	s := &paypalServiceImpl{db: db, promotionRepo: promotionRepo}
+	promotion := newTestPromotion(t, db, 1, 0)
+
+	co := _couponCheckout(promotion)
+	if err := s.reservePromotions(ctx, "user-1", co); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+	if err := promotionRepo.AttachReservation(ctx, db, co.reservationID, "order-1"); err != nil {
+		t.Fatalf("attach reservation: %v", err)
+	}
+
+	// a stored order keeps its redemption, only the order's release gives it back
+	s.releasePromotions(ctx, co)
+	if got := _promotionRedemptions(t, db, promotion.ID); got != 1 {
+		t.Fatalf("redemptions after releasing the attached reservation = %d, want 1", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return s.releaseOrderPromotions(ctx, tx, "order-1")
+		})
+		if err != nil {
+			t.Fatalf("release order promotions: %v", err)
+		}
+	}
+	if got := _promotionRedemptions(t, db, promotion.ID); got != 0 {
+		t.Fatalf("redemptions after releasing the order = %d, want 0", got)
+	}
+
+	var redemption model.PromotionRedemption
+	if err := db.First(&redemption, "order_id = ?", "order-1").Error; err != nil {
+		t.Fatalf("get redemption: %v", err)
+	}
+	if redemption.Status != "RELEASED" {
+		t.Fatalf("redemption status = %s, want RELEASED", redemption.Status)
+	}
+}
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// approval orders not captured after this long are abandoned and stop counting
+	pendingOrderWindow = 3 * time.Hour
+	// a hold outlives the PayPal order creation it covers, even when the process dies
+	spendHoldTTL = 10 * time.Minute
+)
+
+type PurchaseLimitService interface {
+	GetLimits(ctx context.Context, userID string) (*dto.PurchaseLimitsResponse, error)
+	SetLimits(ctx context.Context, userID string, req *dto.SetPurchaseLimitsRequest) (*dto.PurchaseLimitsResponse, error)
+	GetAudit(ctx context.Context, userID string) ([]*model.PurchaseLimitAudit, error)
+}
+
+type purchaseLimitServiceImpl struct {
+	purchaseLimitRepo repository.PurchaseLimitRepository
+	defaults          config.PurchaseLimits
+}
+
+func NewPurchaseLimitService(purchaseLimitRepo repository.PurchaseLimitRepository, defaults config.PurchaseLimits) PurchaseLimitService {
+	return &purchaseLimitServiceImpl{
+		purchaseLimitRepo: purchaseLimitRepo,
+		defaults:          defaults,
+	}
+}
+
+func (s *purchaseLimitServiceImpl) GetLimits(ctx context.Context, userID string) (*dto.PurchaseLimitsResponse, error) {
+	override, err := s.purchaseLimitRepo.GetOverride(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		override = &model.UserPurchaseLimit{UserID: userID}
+	} else if err != nil {
+		return nil, fmt.Errorf("get purchase limit override: %w", err)
+	}
+
+	return s.limitsResponse(override), nil
+}
+
+func (s *purchaseLimitServiceImpl) SetLimits(ctx context.Context, userID string, req *dto.SetPurchaseLimitsRequest) (*dto.PurchaseLimitsResponse, error) {
+	if req.Actor == "" || req.Reason == "" {
+		return nil, fmt.Errorf("%w: actor and reason are required", ErrInvalidPurchaseLimit)
+	}
+	o := req.Override
+	for _, v := range []*int64{o.MaxOrderAmount, o.DailySpend, o.WeeklySpend, o.MonthlySpend, o.MerchantDailySpend, o.MerchantWeeklySpend, o.MerchantMonthlySpend} {
+		if v != nil && *v < 0 {
+			return nil, fmt.Errorf("%w: limits cannot be negative", ErrInvalidPurchaseLimit)
+		}
+	}
+	for _, v := range []*int32{o.MaxSKUQuantity, o.MaxPayAttempts} {
+		if v != nil && *v < 0 {
+			return nil, fmt.Errorf("%w: limits cannot be negative", ErrInvalidPurchaseLimit)
+		}
+	}
+
+	before := ""
+	current, err := s.purchaseLimitRepo.GetOverride(ctx, userID)
+	if err == nil {
+		before = _overrideJSON(_overrideDTO(current))
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("get purchase limit override: %w", err)
+	} else {
+		current = &model.UserPurchaseLimit{UserID: userID}
+	}
+
+	current.MaxOrderAmount = o.MaxOrderAmount
+	current.DailySpend = o.DailySpend
+	current.WeeklySpend = o.WeeklySpend
+	current.MonthlySpend = o.MonthlySpend
+	current.MerchantDailySpend = o.MerchantDailySpend
+	current.MerchantWeeklySpend = o.MerchantWeeklySpend
+	current.MerchantMonthlySpend = o.MerchantMonthlySpend
+	current.MaxSKUQuantity = o.MaxSKUQuantity
+	current.MaxPayAttempts = o.MaxPayAttempts
+	current.UpdatedBy = req.Actor
+	current.Reason = req.Reason
+
+	err = s.purchaseLimitRepo.SaveOverride(ctx, current, &model.PurchaseLimitAudit{
+		UserID: userID,
+		Actor:  req.Actor,
+		Reason: req.Reason,
+		Before: before,
+		After:  _overrideJSON(o),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("save purchase limit override: %w", err)
+	}
+
+	return s.limitsResponse(current), nil
+}
+
+func (s *purchaseLimitServiceImpl) GetAudit(ctx context.Context, userID string) ([]*model.PurchaseLimitAudit, error) {
+	return s.purchaseLimitRepo.GetAudit(ctx, userID)
+}
+
+func (s *purchaseLimitServiceImpl) limitsResponse(override *model.UserPurchaseLimit) *dto.PurchaseLimitsResponse {
+	return &dto.PurchaseLimitsResponse{
+		UserID:    override.UserID,
+		Defaults:  _limitsDTO(s.defaults),
+		Override:  _overrideDTO(override),
+		Effective: _limitsDTO(_effectiveLimits(s.defaults, override)),
+		UpdatedBy: override.UpdatedBy,
+		Reason:    override.Reason,
+	}
+}
+
+// _effectiveLimits applies the user's override on top of the defaults
+func _effectiveLimits(defaults config.PurchaseLimits, override *model.UserPurchaseLimit) config.PurchaseLimits {
+	limits := defaults
+	if override == nil {
+		return limits
+	}
+
+	for _, f := range []struct {
+		value *int64
+		dst   *int64
+	}{
+		{override.MaxOrderAmount, &limits.MaxOrderAmount},
+		{override.DailySpend, &limits.DailySpend},
+		{override.WeeklySpend, &limits.WeeklySpend},
+		{override.MonthlySpend, &limits.MonthlySpend},
+		{override.MerchantDailySpend, &limits.MerchantDailySpend},
+		{override.MerchantWeeklySpend, &limits.MerchantWeeklySpend},
+		{override.MerchantMonthlySpend, &limits.MerchantMonthlySpend},
+	} {
+		if f.value != nil {
+			*f.dst = *f.value
+		}
+	}
+	if override.MaxSKUQuantity != nil {
+		limits.MaxSKUQuantity = *override.MaxSKUQuantity
+	}
+	if override.MaxPayAttempts != nil {
+		limits.MaxPayAttempts = *override.MaxPayAttempts
+	}
+
+	return limits
+}
+
+func _limitsDTO(limits config.PurchaseLimits) dto.PurchaseLimits {
+	return dto.PurchaseLimits{
+		MaxOrderAmount:       limits.MaxOrderAmount,
+		DailySpend:           limits.DailySpend,
+		WeeklySpend:          limits.WeeklySpend,
+		MonthlySpend:         limits.MonthlySpend,
+		MerchantDailySpend:   limits.MerchantDailySpend,
+		MerchantWeeklySpend:  limits.MerchantWeeklySpend,
+		MerchantMonthlySpend: limits.MerchantMonthlySpend,
+		MaxSKUQuantity:       limits.MaxSKUQuantity,
+		MaxPayAttempts:       limits.MaxPayAttempts,
+	}
+}
+
+func _overrideDTO(override *model.UserPurchaseLimit) dto.PurchaseLimitOverride {
+	return dto.PurchaseLimitOverride{
+		MaxOrderAmount:       override.MaxOrderAmount,
+		DailySpend:           override.DailySpend,
+		WeeklySpend:          override.WeeklySpend,
+		MonthlySpend:         override.MonthlySpend,
+		MerchantDailySpend:   override.MerchantDailySpend,
+		MerchantWeeklySpend:  override.MerchantWeeklySpend,
+		MerchantMonthlySpend: override.MerchantMonthlySpend,
+		MaxSKUQuantity:       override.MaxSKUQuantity,
+		MaxPayAttempts:       override.MaxPayAttempts,
+	}
+}
+
+func _overrideJSON(override dto.PurchaseLimitOverride) string {
+	b, _ := json.Marshal(override)
+	return string(b)
+}
+
+// holdSpend checks the checkout against the user's purchase limits and holds
+// its amount until storeOrder records the order. Everything runs under the
+// user's row lock, so concurrent checkouts cannot both slip under a cap.
+func (s *paypalServiceImpl) holdSpend(ctx context.Context, userID string, co *checkout) error {
+	now := time.Now()
+	holdID := uuid.NewString()
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		override, err := s.purchaseLimitRepo.LockUser(ctx, tx, userID)
+		if err != nil {
+			return fmt.Errorf("lock user purchase limits: %w", err)
+		}
+		limits := _effectiveLimits(s.purchaseLimits, override)
+
+		if limits.MaxPayAttempts > 0 {
+			attempts, err := s.purchaseLimitRepo.CountPayAttempts(ctx, tx, userID, now.Add(-limits.PayAttemptWindow))
+			if err != nil {
+				return fmt.Errorf("count pay attempts: %w", err)
+			}
+			if attempts >= int64(limits.MaxPayAttempts) {
+				return &PurchaseLimitError{
+					Limit:      "pay_attempts",
+					Max:        int64(limits.MaxPayAttempts),
+					Current:    attempts,
+					Requested:  1,
+					RetryAfter: limits.PayAttemptWindow,
+				}
+			}
+			if err := s.purchaseLimitRepo.AddPayAttempt(ctx, tx, &model.PayAttempt{UserID: userID}); err != nil {
+				return fmt.Errorf("add pay attempt: %w", err)
+			}
+		}
+
+		if limits.MaxSKUQuantity > 0 {
+			for _, item := range co.orderItems {
+				if item.Quantity > limits.MaxSKUQuantity {
+					return &PurchaseLimitError{
+						Limit:     "sku_quantity",
+						ProductID: item.ProductID,
+						Max:       int64(limits.MaxSKUQuantity),
+						Requested: int64(item.Quantity),
+					}
+				}
+			}
+		}
+
+		inclusive := co.taxQuote != nil && co.taxQuote.Inclusive
+		total := int64(0)
+		var holds []*model.SpendHold
+		for _, unit := range co.orderUnits {
+			amount := int64(unit.Amount)*100 - unit.Discount
+			if !inclusive {
+				amount += unit.Tax
+			}
+			total += amount
+			holds = append(holds, &model.SpendHold{
+				HoldID:     holdID,
+				UserID:     userID,
+				MerchantID: unit.MerchantID,
+				Amount:     amount,
+				ExpiresAt:  now.Add(spendHoldTTL),
+			})
+		}
+
+		if limits.MaxOrderAmount > 0 && total > limits.MaxOrderAmount {
+			return &PurchaseLimitError{Limit: "max_order_amount", Max: limits.MaxOrderAmount, Requested: total}
+		}
+
+		windows := []struct {
+			name     string
+			max      int64
+			merchant int64
+			period   time.Duration
+		}{
+			{"daily_spend", limits.DailySpend, limits.MerchantDailySpend, 24 * time.Hour},
+			{"weekly_spend", limits.WeeklySpend, limits.MerchantWeeklySpend, 7 * 24 * time.Hour},
+			{"monthly_spend", limits.MonthlySpend, limits.MerchantMonthlySpend, 30 * 24 * time.Hour},
+		}
+		for _, window := range windows {
+			query := &repository.SpendQuery{
+				UserID:       userID,
+				Since:        now.Add(-window.period),
+				PendingSince: now.Add(-pendingOrderWindow),
+			}
+			if window.max > 0 {
+				if err := s.checkSpend(ctx, tx, query, window.name, window.max, total); err != nil {
+					return err
+				}
+			}
+			if window.merchant > 0 {
+				for _, hold := range holds {
+					query.MerchantID = hold.MerchantID
+					if err := s.checkSpend(ctx, tx, query, "merchant_"+window.name, window.merchant, hold.Amount); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if err := s.purchaseLimitRepo.CreateHolds(ctx, tx, holds); err != nil {
+			return fmt.Errorf("create spend holds: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	co.holdID = holdID
+	return nil
+}
+
+func (s *paypalServiceImpl) checkSpend(ctx context.Context, tx *gorm.DB, query *repository.SpendQuery, limit string, max int64, requested int64) error {
+	spent, err := s.purchaseLimitRepo.SumSpend(ctx, tx, query)
+	if err != nil {
+		return fmt.Errorf("sum user spend: %w", err)
+	}
+	if spent+requested > max {
+		return &PurchaseLimitError{
+			Limit:      limit,
+			MerchantID: query.MerchantID,
+			Max:        max,
+			Current:    spent,
+			Requested:  requested,
+		}
+	}
+	return nil
+}
+
+// releaseSpend drops the holds of a checkout whose order was not stored
+func (s *paypalServiceImpl) releaseSpend(ctx context.Context, co *checkout) {
+	if co.holdID == "" {
+		return
+	}
+	if err := s.purchaseLimitRepo.DeleteHolds(ctx, s.db, co.holdID); err != nil {
+		log.Printf("release spend hold %s: %v", co.holdID, err)
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+)
+
+func newTestLimitService(t *testing.T, limits config.PurchaseLimits) *paypalServiceImpl {
+	db := newTestDB(t)
+	return &paypalServiceImpl{
+		db:                db,
+		purchaseLimitRepo: repository.NewPurchaseLimitRepository(db),
+		purchaseLimits:    limits,
+	}
+}
+
+// _unitCheckout is a cart of one unit of amount whole units sold by merchantID
+func _unitCheckout(merchantID string, amount int32) *checkout {
+	return &checkout{
+		orderUnits: []*model.OrderUnit{{MerchantID: merchantID, Amount: amount}},
+		orderItems: []*model.OrderItem{{ProductID: "gem_pack", Quantity: 1}},
+	}
+}
+
+func _limitHit(t *testing.T, err error, limit string) *PurchaseLimitError {
+	t.Helper()
+
+	var limitErr *PurchaseLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("got %v, want PurchaseLimitError %s", err, limit)
+	}
+	if limitErr.Limit != limit {
+		t.Fatalf("limit = %s, want %s", limitErr.Limit, limit)
+	}
+	return limitErr
+}
+
+func TestHoldSpendDailyCap(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLimitService(t, config.PurchaseLimits{DailySpend: 5000})
+
+	first := _unitCheckout("merchant-1", 30)
+	if err := s.holdSpend(ctx, "user-1", first); err != nil {
+		t.Fatalf("first hold: %v", err)
+	}
+
+	limitErr := _limitHit(t, s.holdSpend(ctx, "user-1", _unitCheckout("merchant-1", 30)), "daily_spend")
+	if limitErr.Current != 3000 || limitErr.Requested != 3000 {
+		t.Fatalf("current %d, requested %d; want 3000, 3000", limitErr.Current, limitErr.Requested)
+	}
+
+	// another buyer has a cap of their own
+	if err := s.holdSpend(ctx, "user-2", _unitCheckout("merchant-1", 30)); err != nil {
+		t.Fatalf("hold of another user: %v", err)
+	}
+
+	s.releaseSpend(ctx, first)
+	if err := s.holdSpend(ctx, "user-1", _unitCheckout("merchant-1", 30)); err != nil {
+		t.Fatalf("hold after the release: %v", err)
+	}
+}
+
+func TestHoldSpendMerchantCap(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLimitService(t, config.PurchaseLimits{MerchantDailySpend: 4000})
+
+	if err := s.holdSpend(ctx, "user-1", _unitCheckout("merchant-1", 30)); err != nil {
+		t.Fatalf("first hold: %v", err)
+	}
+
+	limitErr := _limitHit(t, s.holdSpend(ctx, "user-1", _unitCheckout("merchant-1", 20)), "merchant_daily_spend")
+	if limitErr.MerchantID != "merchant-1" {
+		t.Fatalf("merchant = %s, want merchant-1", limitErr.MerchantID)
+	}
+
+	if err := s.holdSpend(ctx, "user-1", _unitCheckout("merchant-2", 20)); err != nil {
+		t.Fatalf("hold with another merchant: %v", err)
+	}
+}
+
+func TestHoldSpendIgnoresExpiredHolds(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLimitService(t, config.PurchaseLimits{DailySpend: 5000})
+
+	// left behind by a checkout that died before storing its order
+	expired := &model.SpendHold{
+		HoldID:     "expired-hold",
+		UserID:     "user-1",
+		MerchantID: "merchant-1",
+		Amount:     4000,
+		ExpiresAt:  time.Now().Add(-time.Minute),
+	}
+	if err := s.db.Create(expired).Error; err != nil {
+		t.Fatalf("create expired hold: %v", err)
+	}
+
+	if err := s.holdSpend(ctx, "user-1", _unitCheckout("merchant-1", 30)); err != nil {
+		t.Fatalf("hold next to an expired one: %v", err)
+	}
+}
+
+func TestHoldSpendCountsStoredOrders(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLimitService(t, config.PurchaseLimits{DailySpend: 5000})
+
+	for _, order := range []*model.Order{
+		{OrderID: "paid", Status: "PAID", UserID: "user-1", Amount: 30, Currency: "USD", MerchantID: "merchant-1"},
+		{OrderID: "cancelled", Status: "CANCELLED", UserID: "user-1", Amount: 30, Currency: "USD", MerchantID: "merchant-1"},
+	} {
+		if err := s.db.Create(order).Error; err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+		unit := &model.OrderUnit{
+			OrderID:     order.OrderID,
+			ReferenceID: order.OrderID + "-unit",
+			MerchantID:  order.MerchantID,
+			Amount:      order.Amount,
+			Currency:    order.Currency,
+			Status:      order.Status,
+		}
+		if err := s.db.Create(unit).Error; err != nil {
+			t.Fatalf("create order unit: %v", err)
+		}
+	}
+
+	// the paid order counts, the cancelled one does not
+	if err := s.holdSpend(ctx, "user-1", _unitCheckout("merchant-1", 20)); err != nil {
+		t.Fatalf("hold up to the cap: %v", err)
+	}
+	limitErr := _limitHit(t, s.holdSpend(ctx, "user-1", _unitCheckout("merchant-1", 1)), "daily_spend")
+	if limitErr.Current != 5000 {
+		t.Fatalf("current = %d, want 5000", limitErr.Current)
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+	"time"
+)
+
+const (
+	RiskAllow  = "allow"
+	RiskReview = "review"
+	RiskDeny   = "deny"
+)
+
+// the PayPal-Client-Metadata-Id header is limited to 36 characters
+const maxClientMetadataID = 36
+
+// RiskCharge is a saved payment method charge about to be sent to PayPal
+type RiskCharge struct {
+	UserID           string
+	MerchantID       string
+	Vault            *model.UserVault
+	ClientMetadataID string
+	Amount           int64 // minor units the buyer pays
+}
+
+// RiskEngine decides whether a saved payment method may be charged without
+// the buyer. Every decision is stored with the rules that hit.
+type RiskEngine interface {
+	Assess(ctx context.Context, charge *RiskCharge) (*model.RiskDecision, error)
+	// RecordOutcome keeps the PayPal result of an allowed charge, declines feed the retry rule
+	RecordOutcome(ctx context.Context, decision *model.RiskDecision, orderID string, outcome string)
+}
+
+type ruleRiskEngine struct {
+	riskRepo repository.RiskRepository
+	rules    config.RiskRules
+}
+
+func NewRuleRiskEngine(riskRepo repository.RiskRepository, rules config.RiskRules) RiskEngine {
+	return &ruleRiskEngine{
+		riskRepo: riskRepo,
+		rules:    rules,
+	}
+}
+
+func (e *ruleRiskEngine) Assess(ctx context.Context, charge *RiskCharge) (*model.RiskDecision, error) {
+	now := time.Now()
+	decision := &model.RiskDecision{
+		UserID:           charge.UserID,
+		MerchantID:       charge.MerchantID,
+		VaultID:          charge.Vault.VaultID,
+		ClientMetadataID: charge.ClientMetadataID,
+		Amount:           charge.Amount,
+		Decision:         RiskAllow,
+	}
+	hit := func(rule string, action string, detail string) {
+		decision.Hits = append(decision.Hits, &model.RiskRuleHit{Rule: rule, Action: action, Detail: detail})
+		if action == RiskDeny || decision.Decision == RiskAllow {
+			decision.Decision = action
+		}
+	}
+
+	if e.rules.NewVaultAmount > 0 && now.Sub(charge.Vault.CreatedAt) < e.rules.NewVaultAge && charge.Amount > e.rules.NewVaultAmount {
+		hit("new_vault_amount", e.rules.NewVaultAction,
+			fmt.Sprintf("%s charged on a method saved %s ago, at most %s allowed", _formatMinor(charge.Amount), now.Sub(charge.Vault.CreatedAt).Round(time.Minute), _formatMinor(e.rules.NewVaultAmount)))
+	}
+
+	if e.rules.MaxDistinctPayers > 0 {
+		payers, err := e.riskRepo.CountDistinctPayers(ctx, charge.UserID, now.Add(-e.rules.DistinctPayerWindow))
+		if err != nil {
+			return nil, fmt.Errorf("count distinct payers: %w", err)
+		}
+		if payers > e.rules.MaxDistinctPayers {
+			hit("distinct_payers", e.rules.DistinctPayerAction,
+				fmt.Sprintf("%d payers saved in %s, at most %d allowed", payers, e.rules.DistinctPayerWindow, e.rules.MaxDistinctPayers))
+		}
+	}
+
+	if e.rules.MaxDeclines > 0 {
+		declines, err := e.riskRepo.CountDeclines(ctx, charge.UserID, now.Add(-e.rules.DeclineWindow))
+		if err != nil {
+			return nil, fmt.Errorf("count declined charges: %w", err)
+		}
+		if declines >= e.rules.MaxDeclines {
+			hit("declines", e.rules.DeclineAction,
+				fmt.Sprintf("%d declined charges in %s, at most %d allowed", declines, e.rules.DeclineWindow, e.rules.MaxDeclines))
+		}
+	}
+
+	if err := e.riskRepo.CreateDecision(ctx, decision); err != nil {
+		return nil, fmt.Errorf("store risk decision: %w", err)
+	}
+
+	return decision, nil
+}
+
+func (e *ruleRiskEngine) RecordOutcome(ctx context.Context, decision *model.RiskDecision, orderID string, outcome string) {
+	if err := e.riskRepo.SetOutcome(ctx, decision.ID, orderID, outcome); err != nil {
+		log.Printf("record outcome of risk decision %d: %v", decision.ID, err)
+	}
+}
+
+// _checkoutPaid is what the buyer pays for the checkout in minor units
+func _checkoutPaid(co *checkout) int64 {
+	paid := int64(co.total)*100 - co.discount
+	if co.taxQuote == nil || !co.taxQuote.Inclusive {
+		paid += co.tax
+	}
+	return paid
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"paypal-integration-demo/internal/client"
+	"paypal-integration-demo/internal/config"
+	"paypal-integration-demo/internal/model"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// TaxAddress is where the buyer is taxed, taken from the PayPal payer or the
+// card billing address
+type TaxAddress struct {
+	Country string // ISO 3166-1 alpha-2
+	Region  string // state or province, e.g. CA
+}
+
+// TaxLine is one product of a cart, Amount is the whole line after
+// discounts in minor units
+type TaxLine struct {
+	ProductID string
+	Quantity  int32
+	Amount    int64
+}
+
+// TaxQuote is the tax of a cart for one address
+type TaxQuote struct {
+	Country      string
+	Jurisdiction string // e.g. DE or US-CA
+	Name         string
+	RateBps      int64
+	// Inclusive means the tax is contained in the line amounts, otherwise it is added on top
+	Inclusive bool
+	// UnitTax is the tax per unit of each line in minor units, in the order of the lines
+	UnitTax []int64
+}
+
+// TaxCalculator prices the tax of digital goods. Implementations may call out
+// to a tax provider, the default one reads a local table.
+type TaxCalculator interface {
+	// Calculate returns nil when the address is not taxed
+	Calculate(ctx context.Context, address *TaxAddress, lines []*TaxLine) (*TaxQuote, error)
+}
+
+type tableTaxCalculator struct {
+	rates config.TaxRates
+}
+
+// NewTableTaxCalculator taxes buyers with the rate of their region, or of
+// their country when the region has none
+func NewTableTaxCalculator(rates config.TaxRates) TaxCalculator {
+	return &tableTaxCalculator{
+		rates: rates,
+	}
+}
+
+func (c *tableTaxCalculator) Calculate(ctx context.Context, address *TaxAddress, lines []*TaxLine) (*TaxQuote, error) {
+	if address == nil || address.Country == "" {
+		return nil, nil
+	}
+	jurisdiction, rate, ok := c.rates.Lookup(address.Country, address.Region)
+	if !ok || rate.RateBps == 0 {
+		return nil, nil
+	}
+
+	quote := &TaxQuote{
+		Country:      strings.ToUpper(address.Country),
+		Jurisdiction: jurisdiction,
+		Name:         rate.Name,
+		RateBps:      rate.RateBps,
+		Inclusive:    rate.Inclusive,
+		UnitTax:      make([]int64, len(lines)),
+	}
+	for i, line := range lines {
+		if line.Quantity <= 0 || line.Amount <= 0 {
+			continue
+		}
+
+		var lineTax int64
+		if rate.Inclusive {
+			// the tax already contained in the price: amount * rate / (1 + rate)
+			lineTax = _divRound(line.Amount*rate.RateBps, 10000+rate.RateBps)
+		} else {
+			lineTax = _divRound(line.Amount*rate.RateBps, 10000)
+		}
+		// PayPal wants the tax per unit, so the line tax is rounded to a multiple of the quantity
+		quote.UnitTax[i] = _divRound(lineTax, int64(line.Quantity))
+	}
+
+	return quote, nil
+}
+
+// _divRound divides non-negative numbers rounding half up
+func _divRound(a int64, b int64) int64 {
+	return (a*2 + b) / (b * 2)
+}
+
+// quoteTax prices the tax of the lines and stores it on each of them, it
+// returns nil when the buyer is not taxed
+func (s *paypalServiceImpl) quoteTax(ctx context.Context, address *TaxAddress, lines []*checkoutLine) (*TaxQuote, error) {
+	taxLines := make([]*TaxLine, len(lines))
+	for i, line := range lines {
+		taxLines[i] = &TaxLine{
+			ProductID: line.productID,
+			Quantity:  line.quantity,
+			Amount:    line.remaining(),
+		}
+	}
+
+	quote, err := s.taxCalculator.Calculate(ctx, address, taxLines)
+	if err != nil {
+		return nil, fmt.Errorf("calculate tax: %w", err)
+	}
+	if quote == nil {
+		return nil, nil
+	}
+	if len(quote.UnitTax) != len(lines) {
+		return nil, fmt.Errorf("calculate tax: got %d lines, want %d", len(quote.UnitTax), len(lines))
+	}
+
+	for i, line := range lines {
+		line.unitTax = quote.UnitTax[i]
+	}
+	return quote, nil
+}
+
+// _taxedItems turns the taxed lines of a purchase unit into PayPal items and
+// returns them with the tax of the unit
+func _taxedItems(lines []*checkoutLine, inclusive bool) ([]*client.OrderItem, int64) {
+	items := make([]*client.OrderItem, len(lines))
+	tax := int64(0)
+	for i, line := range lines {
+		unitAmount := line.unitPrice
+		if inclusive {
+			unitAmount -= line.unitTax
+		}
+
+		items[i] = &client.OrderItem{
+			Name:       line.name,
+			SKU:        line.productID,
+			Quantity:   line.quantity,
+			UnitAmount: unitAmount,
+			Tax:        line.unitTax,
+		}
+		tax += line.unitTax * int64(line.quantity)
+	}
+
+	return items, tax
+}
+
+// taxApprovedOrder taxes an order the buyer approved on PayPal. The payer's
+// country is only known after approval, so the amounts are updated right
+// before capture.
+func (s *paypalServiceImpl) taxApprovedOrder(ctx context.Context, order *model.Order, merchantAuth *client.MerchantAuth) error {
+	if order.TaxJurisdiction != "" {
+		return nil
+	}
+
+	resp, err := s.paypalClient.GetOrder(ctx, order.OrderID, merchantAuth)
+	if err != nil {
+		return fmt.Errorf("paypal api get order: %w", err)
+	}
+	address := &TaxAddress{
+		Country: resp.PayerAddress.CountryCode,
+		Region:  resp.PayerAddress.AdminArea1,
+	}
+
+	units, err := s.orderRepo.GetOrderUnits(ctx, s.db, order.OrderID)
+	if err != nil {
+		return fmt.Errorf("get order units: %w", err)
+	}
+	items, err := s.orderRepo.GetOrderItems(ctx, s.db, order.OrderID)
+	if err != nil {
+		return fmt.Errorf("get order items: %w", err)
+	}
+
+	productIDs := make([]string, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	products, err := s.productRepo.FindMany(ctx, productIDs)
+	if err != nil {
+		return fmt.Errorf("get many products by item ids: %w", err)
+	}
+	productNames := make(map[string]string, len(products))
+	for _, product := range products {
+		productNames[product.ID] = product.Name
+	}
+
+	lines := make([]*checkoutLine, len(items))
+	linesByUnit := make(map[string][]*checkoutLine)
+	for i, item := range items {
+		lines[i] = &checkoutLine{
+			productID: item.ProductID,
+			name:      productNames[item.ProductID],
+			quantity:  item.Quantity,
+			unitPrice: int64(item.UnitPrice) * 100,
+			discount:  item.Discount,
+		}
+		linesByUnit[item.ReferenceID] = append(linesByUnit[item.ReferenceID], lines[i])
+	}
+
+	quote, err := s.quoteTax(ctx, address, lines)
+	if err != nil {
+		return err
+	}
+	if quote == nil {
+		return nil
+	}
+
+	clientUnits := make([]*client.OrderUnit, len(units))
+	for i, unit := range units {
+		clientUnits[i] = &client.OrderUnit{
+			ReferenceID: unit.ReferenceID,
+			Currency:    unit.Currency,
+			Amount:      unit.Amount,
+			Discount:    unit.Discount,
+		}
+		clientUnits[i].Items, clientUnits[i].Tax = _taxedItems(linesByUnit[unit.ReferenceID], quote.Inclusive)
+
+		unit.Tax = clientUnits[i].Tax
+		order.Tax += unit.Tax
+	}
+	for i, item := range items {
+		item.Tax = lines[i].unitTax * int64(item.Quantity)
+	}
+
+	if err := s.paypalClient.UpdateOrderAmounts(ctx, order.OrderID, clientUnits, merchantAuth); err != nil {
+		return fmt.Errorf("paypal api update order amounts: %w", err)
+	}
+
+	order.TaxInclusive = quote.Inclusive
+	order.TaxCountry = quote.Country
+	order.TaxJurisdiction = quote.Jurisdiction
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.orderRepo.UpdateTax(ctx, tx, order, units, items); err != nil {
+			return fmt.Errorf("store order tax: %w", err)
+		}
+		return nil
+	})
+}
+
+// _orderPaid is what the buyer pays for the order, in minor units
+func _orderPaid(order *model.Order) int64 {
+	paid := int64(order.Amount)*100 - order.Discount
+	if !order.TaxInclusive {
+		paid += order.Tax
+	}
+	return paid
+}
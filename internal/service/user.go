@@ -2,22 +2,35 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"paypal-integration-demo/internal/dto"
 	"paypal-integration-demo/internal/model"
 	"paypal-integration-demo/internal/repository"
+
+	"gorm.io/gorm"
 )
 
 type UserService interface {
 	GetInventory(ctx context.Context, userID string) ([]*model.UserInventory, error)
+	GetInventoryLedger(ctx context.Context, userID string, productID string, limit int) ([]*model.InventoryLedgerEntry, error)
+	AdjustInventory(ctx context.Context, userID string, req *dto.InventoryAdjustRequest) (*dto.InventoryAdjustResponse, error)
+	// VerifyInventory reports balances that drifted from the ledger. With fix
+	// set the balances are rebuilt from the ledger.
+	VerifyInventory(ctx context.Context, fix bool) (*dto.InventoryVerifyReport, error)
 }
 
 type userServiceImpl struct {
+	db            *gorm.DB
 	inventoryRepo repository.InventoryRepository
 }
 
 func NewUserService(
+	db *gorm.DB,
 	inventoryRepo repository.InventoryRepository,
 ) UserService {
 	return &userServiceImpl{
+		db:            db,
 		inventoryRepo: inventoryRepo,
 	}
 }
@@ -25,3 +38,95 @@ func NewUserService(
 func (s *userServiceImpl) GetInventory(ctx context.Context, userID string) ([]*model.UserInventory, error) {
 	return s.inventoryRepo.Get(ctx, userID)
 }
+
+func (s *userServiceImpl) GetInventoryLedger(ctx context.Context, userID string, productID string, limit int) ([]*model.InventoryLedgerEntry, error) {
+	if limit <= 0 {
+		limit = defaultLedgerPageSize
+	}
+	if limit > maxLedgerPageSize {
+		limit = maxLedgerPageSize
+	}
+
+	return s.inventoryRepo.GetLedger(ctx, userID, productID, limit)
+}
+
+func (s *userServiceImpl) AdjustInventory(ctx context.Context, userID string, req *dto.InventoryAdjustRequest) (*dto.InventoryAdjustResponse, error) {
+	if req.IdempotencyKey == "" || req.ProductID == "" || req.Delta == 0 {
+		return nil, fmt.Errorf("%w: idempotency_key, product_id and a non-zero delta are required", ErrInvalidInventoryAdjustment)
+	}
+
+	var applied bool
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		applied, err = s.inventoryRepo.Apply(ctx, tx, &model.InventoryLedgerEntry{
+			UserID:     userID,
+			ProductID:  req.ProductID,
+			Delta:      req.Delta,
+			Reason:     req.Reason,
+			SourceType: string(model.INVENTORY_SOURCE_ADMIN),
+			SourceID:   req.IdempotencyKey,
+			// namespaced so an admin key can never collide with a purchase grant
+			IdempotencyKey: "admin:" + req.IdempotencyKey,
+		})
+		return err
+	})
+	if errors.Is(err, repository.ErrInsufficientQuantity) {
+		return nil, fmt.Errorf("%w: user holds fewer than %d %s", ErrInvalidInventoryAdjustment, -req.Delta, req.ProductID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("adjust inventory: %w", err)
+	}
+
+	inventory, err := s.inventoryRepo.Get(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get inventory: %w", err)
+	}
+
+	return &dto.InventoryAdjustResponse{
+		Applied:   applied,
+		Inventory: inventory,
+	}, nil
+}
+
+func (s *userServiceImpl) VerifyInventory(ctx context.Context, fix bool) (*dto.InventoryVerifyReport, error) {
+	drifts, err := s.inventoryRepo.FindDrift(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find inventory drift: %w", err)
+	}
+
+	report := &dto.InventoryVerifyReport{Drifts: drifts}
+	if !fix {
+		return report, nil
+	}
+
+	for _, drift := range drifts {
+		err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if drift.LedgerEntries > 0 {
+				// the ledger is the source of truth
+				return s.inventoryRepo.SetBalance(ctx, tx, drift.UserID, drift.ProductID, drift.LedgerTotal)
+			}
+
+			// granted before the ledger existed: keep what the user holds and
+			// open the ledger with it. Apply also adds to the balance, so it
+			// is set back afterwards.
+			_, err := s.inventoryRepo.Apply(ctx, tx, &model.InventoryLedgerEntry{
+				UserID:         drift.UserID,
+				ProductID:      drift.ProductID,
+				Delta:          drift.Balance,
+				Reason:         "opening balance",
+				SourceType:     string(model.INVENTORY_SOURCE_OPENING),
+				IdempotencyKey: fmt.Sprintf("opening:%s:%s", drift.UserID, drift.ProductID),
+			})
+			if err != nil {
+				return err
+			}
+			return s.inventoryRepo.SetBalance(ctx, tx, drift.UserID, drift.ProductID, drift.Balance)
+		})
+		if err != nil {
+			return report, fmt.Errorf("fix inventory of %s/%s: %w", drift.UserID, drift.ProductID, err)
+		}
+		report.Fixed++
+	}
+
+	return report, nil
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/model"
+	"paypal-integration-demo/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLedgerPageSize = 50
+	maxLedgerPageSize     = 500
+)
+
+type WalletService interface {
+	GetWallet(ctx context.Context, userID string, limit int) (*dto.WalletResponse, error)
+	Spend(ctx context.Context, userID string, req *dto.WalletSpendRequest) (*dto.WalletOperationResponse, error)
+	Transfer(ctx context.Context, userID string, req *dto.WalletTransferRequest) (*dto.WalletOperationResponse, error)
+	Grant(ctx context.Context, userID string, req *dto.WalletAdjustRequest) (*dto.WalletOperationResponse, error)
+	Revoke(ctx context.Context, userID string, req *dto.WalletAdjustRequest) (*dto.WalletOperationResponse, error)
+	Reconcile(ctx context.Context, userID string) (*dto.WalletReconciliation, error)
+}
+
+type walletServiceImpl struct {
+	db         *gorm.DB
+	walletRepo repository.WalletRepository
+}
+
+func NewWalletService(db *gorm.DB, walletRepo repository.WalletRepository) WalletService {
+	return &walletServiceImpl{
+		db:         db,
+		walletRepo: walletRepo,
+	}
+}
+
+// walletChange moves Amount (signed) in or out of one user's wallet
+type walletChange struct {
+	userID       string
+	amount       int64
+	counterparty string
+}
+
+// applyWalletOperation records op and applies its changes inside tx. It
+// reports replayed=true without touching any balance when the operation id
+// was already applied with the same parameters.
+func applyWalletOperation(ctx context.Context, tx *gorm.DB, walletRepo repository.WalletRepository, op *model.WalletOperation, changes []walletChange) (bool, error) {
+	created, err := walletRepo.CreateOperation(ctx, tx, op)
+	if err != nil {
+		return false, fmt.Errorf("create wallet operation: %w", err)
+	}
+	if !created {
+		existing, err := walletRepo.FindOperation(ctx, tx, op.OperationID)
+		if err != nil {
+			return false, fmt.Errorf("get wallet operation: %w", err)
+		}
+		if !_sameWalletOperation(existing, op) {
+			return false, ErrWalletOperationConflict
+		}
+		return true, nil
+	}
+
+	for _, change := range changes {
+		var balance, frozen int64
+		if change.amount < 0 {
+			// currency under dispute cannot leave the wallet, support may still revoke it
+			if op.Type != string(model.WALLET_ADMIN_REVOKE) {
+				if frozen, err = walletRepo.FrozenBalance(ctx, tx, change.userID); err != nil {
+					return false, fmt.Errorf("get frozen wallet balance: %w", err)
+				}
+			}
+			balance, err = walletRepo.Debit(ctx, tx, change.userID, -change.amount, frozen)
+		} else {
+			balance, err = walletRepo.Credit(ctx, tx, change.userID, change.amount)
+		}
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			if frozen > 0 {
+				return false, ErrBalanceFrozen
+			}
+			return false, ErrInsufficientBalance
+		}
+		if err != nil {
+			return false, fmt.Errorf("update wallet balance: %w", err)
+		}
+
+		err = walletRepo.AddLedgerEntry(ctx, tx, &model.WalletLedgerEntry{
+			OperationID:        op.OperationID,
+			UserID:             change.userID,
+			Type:               op.Type,
+			Amount:             change.amount,
+			BalanceAfter:       balance,
+			CounterpartyUserID: change.counterparty,
+			Reason:             op.Reason,
+		})
+		if err != nil {
+			return false, fmt.Errorf("add wallet ledger entry: %w", err)
+		}
+	}
+
+	return false, nil
+}
+
+func _sameWalletOperation(a, b *model.WalletOperation) bool {
+	return a.Type == b.Type &&
+		a.UserID == b.UserID &&
+		a.CounterpartyUserID == b.CounterpartyUserID &&
+		a.Amount == b.Amount
+}
+
+func (s *walletServiceImpl) GetWallet(ctx context.Context, userID string, limit int) (*dto.WalletResponse, error) {
+	if limit <= 0 {
+		limit = defaultLedgerPageSize
+	}
+	if limit > maxLedgerPageSize {
+		limit = maxLedgerPageSize
+	}
+
+	balance, err := s.walletRepo.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get wallet balance: %w", err)
+	}
+
+	entries, err := s.walletRepo.GetLedger(ctx, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get wallet ledger: %w", err)
+	}
+
+	frozen, err := s.walletRepo.FrozenBalance(ctx, s.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get frozen wallet balance: %w", err)
+	}
+
+	return &dto.WalletResponse{
+		UserID:  userID,
+		Balance: balance,
+		Frozen:  frozen,
+		Ledger:  _ledgerEntries(entries),
+	}, nil
+}
+
+func (s *walletServiceImpl) Spend(ctx context.Context, userID string, req *dto.WalletSpendRequest) (*dto.WalletOperationResponse, error) {
+	op := &model.WalletOperation{
+		OperationID: req.OperationID,
+		Type:        string(model.WALLET_SPEND),
+		UserID:      userID,
+		Amount:      req.Amount,
+		Reason:      req.Reason,
+	}
+	return s.run(ctx, op, []walletChange{
+		{userID: userID, amount: -req.Amount},
+	})
+}
+
+func (s *walletServiceImpl) Transfer(ctx context.Context, userID string, req *dto.WalletTransferRequest) (*dto.WalletOperationResponse, error) {
+	if req.ToUserID == "" || req.ToUserID == userID {
+		return nil, fmt.Errorf("%w: transfer needs another user to receive it", ErrInvalidWalletOperation)
+	}
+
+	op := &model.WalletOperation{
+		OperationID:        req.OperationID,
+		Type:               string(model.WALLET_TRANSFER),
+		UserID:             userID,
+		CounterpartyUserID: req.ToUserID,
+		Amount:             req.Amount,
+		Reason:             req.Reason,
+	}
+	return s.run(ctx, op, []walletChange{
+		{userID: userID, amount: -req.Amount, counterparty: req.ToUserID},
+		{userID: req.ToUserID, amount: req.Amount, counterparty: userID},
+	})
+}
+
+func (s *walletServiceImpl) Grant(ctx context.Context, userID string, req *dto.WalletAdjustRequest) (*dto.WalletOperationResponse, error) {
+	op := &model.WalletOperation{
+		OperationID: req.OperationID,
+		Type:        string(model.WALLET_ADMIN_GRANT),
+		UserID:      userID,
+		Amount:      req.Amount,
+		Reason:      req.Reason,
+	}
+	return s.run(ctx, op, []walletChange{
+		{userID: userID, amount: req.Amount},
+	})
+}
+
+func (s *walletServiceImpl) Revoke(ctx context.Context, userID string, req *dto.WalletAdjustRequest) (*dto.WalletOperationResponse, error) {
+	op := &model.WalletOperation{
+		OperationID: req.OperationID,
+		Type:        string(model.WALLET_ADMIN_REVOKE),
+		UserID:      userID,
+		Amount:      req.Amount,
+		Reason:      req.Reason,
+	}
+	return s.run(ctx, op, []walletChange{
+		{userID: userID, amount: -req.Amount},
+	})
+}
+
+func (s *walletServiceImpl) run(ctx context.Context, op *model.WalletOperation, changes []walletChange) (*dto.WalletOperationResponse, error) {
+	if op.OperationID == "" {
+		return nil, fmt.Errorf("%w: operation_id is required", ErrInvalidWalletOperation)
+	}
+	if op.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidWalletOperation)
+	}
+
+	var replayed bool
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		replayed, err = applyWalletOperation(ctx, tx, s.walletRepo, op, changes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a replay answers with what the first request did
+	entries, err := s.walletRepo.GetOperationEntries(ctx, op.OperationID)
+	if err != nil {
+		return nil, fmt.Errorf("get wallet operation entries: %w", err)
+	}
+
+	resp := &dto.WalletOperationResponse{
+		OperationID: op.OperationID,
+		Type:        op.Type,
+		Replayed:    replayed,
+		Entries:     _ledgerEntries(entries),
+	}
+	for _, entry := range entries {
+		if entry.UserID == op.UserID {
+			resp.Balance = entry.BalanceAfter
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *walletServiceImpl) Reconcile(ctx context.Context, userID string) (*dto.WalletReconciliation, error) {
+	balance, err := s.walletRepo.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get wallet balance: %w", err)
+	}
+
+	total, count, err := s.walletRepo.SumLedger(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("sum wallet ledger: %w", err)
+	}
+
+	return &dto.WalletReconciliation{
+		UserID:        userID,
+		Balance:       balance,
+		LedgerTotal:   total,
+		LedgerEntries: count,
+		Balanced:      balance == total,
+	}, nil
+}
+
+func _ledgerEntries(entries []*model.WalletLedgerEntry) []*dto.WalletLedgerEntry {
+	result := make([]*dto.WalletLedgerEntry, len(entries))
+	for i, entry := range entries {
+		result[i] = &dto.WalletLedgerEntry{
+			OperationID:        entry.OperationID,
+			UserID:             entry.UserID,
+			Type:               entry.Type,
+			Amount:             entry.Amount,
+			BalanceAfter:       entry.BalanceAfter,
+			CounterpartyUserID: entry.CounterpartyUserID,
+			Reason:             entry.Reason,
+			CreatedAt:          entry.CreatedAt,
+		}
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"paypal-integration-demo/internal/dto"
+	"paypal-integration-demo/internal/repository"
+)
+
+func newTestWalletService(t *testing.T) (WalletService, repository.WalletRepository) {
+	db := newTestDB(t)
+	walletRepo := repository.NewWalletRepository(db)
+	return NewWalletService(db, walletRepo), walletRepo
+}
+
+func TestWalletSpendReplay(t *testing.T) {
+	ctx := context.Background()
+	walletService, walletRepo := newTestWalletService(t)
+
+	if _, err := walletService.Grant(ctx, "user-1", &dto.WalletAdjustRequest{OperationID: "grant-1", Amount: 100}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+
+	spend := &dto.WalletSpendRequest{OperationID: "spend-1", Amount: 30}
+	first, err := walletService.Spend(ctx, "user-1", spend)
+	if err != nil {
+		t.Fatalf("spend: %v", err)
+	}
+	if first.Replayed || first.Balance != 70 {
+		t.Fatalf("first spend = replayed %v, balance %d; want false, 70", first.Replayed, first.Balance)
+	}
+
+	again, err := walletService.Spend(ctx, "user-1", spend)
+	if err != nil {
+		t.Fatalf("replay spend: %v", err)
+	}
+	if !again.Replayed || again.Balance != 70 {
+		t.Fatalf("replayed spend = replayed %v, balance %d; want true, 70", again.Replayed, again.Balance)
+	}
+
+	_, err = walletService.Spend(ctx, "user-1", &dto.WalletSpendRequest{OperationID: "spend-1", Amount: 50})
+	if !errors.Is(err, ErrWalletOperationConflict) {
+		t.Fatalf("spend reusing the id with another amount: got %v, want ErrWalletOperationConflict", err)
+	}
+
+	balance, err := walletRepo.GetBalance(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if balance != 70 {
+		t.Fatalf("balance = %d, want 70", balance)
+	}
+}
+
+func TestWalletSpendInsufficientBalance(t *testing.T) {
+	ctx := context.Background()
+	walletService, walletRepo := newTestWalletService(t)
+
+	if _, err := walletService.Grant(ctx, "user-1", &dto.WalletAdjustRequest{OperationID: "grant-1", Amount: 10}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+
+	spend := &dto.WalletSpendRequest{OperationID: "spend-1", Amount: 20}
+	if _, err := walletService.Spend(ctx, "user-1", spend); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("spend over the balance: got %v, want ErrInsufficientBalance", err)
+	}
+
+	balance, err := walletRepo.GetBalance(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if balance != 10 {
+		t.Fatalf("balance after the failed spend = %d, want 10", balance)
+	}
+
+	// the failed spend left no operation behind, the same id goes through once funded
+	if _, err := walletService.Grant(ctx, "user-1", &dto.WalletAdjustRequest{OperationID: "grant-2", Amount: 10}); err != nil {
+		t.Fatalf("grant: %v", err)
+	}
+	resp, err := walletService.Spend(ctx, "user-1", spend)
+	if err != nil {
+		t.Fatalf("retry spend: %v", err)
+	}
+	if resp.Replayed || resp.Balance != 0 {
+		t.Fatalf("retried spend = replayed %v, balance %d; want false, 0", resp.Replayed, resp.Balance)
+	}
+}
@@ -0,0 +1,165 @@
+package view
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLocale = "en"
+
+var messages = map[string]map[string]string{
+	"en": {
+		"order_title":          "Payment",
+		"order_captured":       "Payment completed",
+		"order_captured_text":  "Thank you! Your items are being added to your inventory.",
+		"order_granted_text":   "Thank you! Your items have been added to your inventory.",
+		"order_partial":        "Payment partially completed",
+		"order_partial_text":   "Some merchants could not be paid. You only receive the items that were paid for.",
+		"order_pending":        "Payment pending",
+		"order_pending_text":   "PayPal is still processing your payment. Your items are granted as soon as it completes.",
+		"order_failed":         "Payment failed",
+		"order_failed_text":    "Your payment was declined. You have not been charged, please try another funding source.",
+		"order_cancelled":      "Payment cancelled",
+		"order_cancelled_text": "You cancelled the payment on PayPal. You have not been charged.",
+		"order_id":             "Order",
+		"discount":             "Discount",
+		"tax":                  "Tax",
+		"total":                "Total",
+		"item":                 "Item",
+		"quantity":             "Quantity",
+		"sub_title":            "Subscription",
+		"sub_active":           "Subscription active",
+		"sub_active_text":      "Your subscription is active.",
+		"sub_pending":          "Subscription approved",
+		"sub_pending_text":     "We are activating your subscription, this takes a few seconds.",
+		"sub_failed":           "Subscription failed",
+		"sub_failed_text":      "Your subscription could not be started.",
+		"sub_cancelled":        "Subscription cancelled",
+		"sub_cancelled_text":   "You did not subscribe. You have not been charged.",
+		"subscription_id":      "Subscription",
+		"plan":                 "Plan",
+		"back_to_shop":         "Back to shop",
+	},
+	"es": {
+		"order_title":          "Pago",
+		"order_captured":       "Pago completado",
+		"order_captured_text":  "¡Gracias! Estamos añadiendo los artículos a tu inventario.",
+		"order_granted_text":   "¡Gracias! Los artículos ya están en tu inventario.",
+		"order_partial":        "Pago completado parcialmente",
+		"order_partial_text":   "No se pudo pagar a algunos vendedores. Solo recibirás los artículos pagados.",
+		"order_pending":        "Pago pendiente",
+		"order_pending_text":   "PayPal sigue procesando tu pago. Recibirás los artículos en cuanto se complete.",
+		"order_failed":         "Pago fallido",
+		"order_failed_text":    "Tu pago fue rechazado. No se te ha cobrado, prueba con otro método de pago.",
+		"order_cancelled":      "Pago cancelado",
+		"order_cancelled_text": "Cancelaste el pago en PayPal. No se te ha cobrado.",
+		"order_id":             "Pedido",
+		"discount":             "Descuento",
+		"tax":                  "Impuestos",
+		"total":                "Total",
+		"item":                 "Artículo",
+		"quantity":             "Cantidad",
+		"sub_title":            "Suscripción",
+		"sub_active":           "Suscripción activa",
+		"sub_active_text":      "Tu suscripción está activa.",
+		"sub_pending":          "Suscripción aprobada",
+		"sub_pending_text":     "Estamos activando tu suscripción, tardará unos segundos.",
+		"sub_failed":           "Suscripción fallida",
+		"sub_failed_text":      "No se pudo iniciar tu suscripción.",
+		"sub_cancelled":        "Suscripción cancelada",
+		"sub_cancelled_text":   "No te has suscrito. No se te ha cobrado.",
+		"subscription_id":      "Suscripción",
+		"plan":                 "Plan",
+		"back_to_shop":         "Volver a la tienda",
+	},
+	"vi": {
+		"order_title":          "Thanh toán",
+		"order_captured":       "Thanh toán thành công",
+		"order_captured_text":  "Cảm ơn bạn! Vật phẩm đang được thêm vào kho đồ của bạn.",
+		"order_granted_text":   "Cảm ơn bạn! Vật phẩm đã được thêm vào kho đồ của bạn.",
+		"order_partial":        "Thanh toán thành công một phần",
+		"order_partial_text":   "Một số người bán không nhận được thanh toán. Bạn chỉ nhận được các vật phẩm đã thanh toán.",
+		"order_pending":        "Đang chờ thanh toán",
+		"order_pending_text":   "PayPal đang xử lý thanh toán. Vật phẩm sẽ được cấp ngay khi hoàn tất.",
+		"order_failed":         "Thanh toán thất bại",
+		"order_failed_text":    "Thanh toán bị từ chối. Bạn chưa bị trừ tiền, vui lòng thử phương thức khác.",
+		"order_cancelled":      "Đã hủy thanh toán",
+		"order_cancelled_text": "Bạn đã hủy thanh toán trên PayPal. Bạn chưa bị trừ tiền.",
+		"order_id":             "Đơn hàng",
+		"discount":             "Giảm giá",
+		"tax":                  "Thuế",
+		"total":                "Tổng cộng",
+		"item":                 "Vật phẩm",
+		"quantity":             "Số lượng",
+		"sub_title":            "Gói đăng ký",
+		"sub_active":           "Gói đăng ký đang hoạt động",
+		"sub_active_text":      "Gói đăng ký của bạn đã được kích hoạt.",
+		"sub_pending":          "Đã duyệt gói đăng ký",
+		"sub_pending_text":     "Chúng tôi đang kích hoạt gói đăng ký, vui lòng chờ vài giây.",
+		"sub_failed":           "Đăng ký thất bại",
+		"sub_failed_text":      "Không thể bắt đầu gói đăng ký của bạn.",
+		"sub_cancelled":        "Đã hủy đăng ký",
+		"sub_cancelled_text":   "Bạn chưa đăng ký. Bạn chưa bị trừ tiền.",
+		"subscription_id":      "Gói đăng ký",
+		"plan":                 "Gói",
+		"back_to_shop":         "Quay lại cửa hàng",
+	},
+}
+
+// Translate falls back to English for unknown locales and missing keys
+func Translate(locale string, key string) string {
+	if text, ok := messages[locale][key]; ok {
+		return text
+	}
+	if text, ok := messages[DefaultLocale][key]; ok {
+		return text
+	}
+	return key
+}
+
+// ResolveLocale picks a supported locale from an explicit locale parameter or
+// the Accept-Language header, e.g. "es-MX,es;q=0.9,en;q=0.8" gives "es".
+func ResolveLocale(param string, acceptLanguage string) string {
+	if locale := _supported(param); locale != "" {
+		return locale
+	}
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		tags = append(tags, weighted{tag: tag, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, tag := range tags {
+		if locale := _supported(tag.tag); locale != "" {
+			return locale
+		}
+	}
+
+	return DefaultLocale
+}
+
+func _supported(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if _, ok := messages[tag]; ok {
+		return tag
+	}
+	// only the language is translated, es-MX uses es
+	language, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if _, ok := messages[language]; ok {
+		return language
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package view
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+// Renderer renders the pages buyers land on after PayPal. A merchant can
+// override a page by placing <overrideDir>/<merchantID>/<page>.html, which
+// uses the same data and the "t" translation func as the embedded page.
+type Renderer struct {
+	base        *template.Template
+	overrideDir string
+
+	mu        sync.Mutex
+	overrides map[string]*template.Template
+}
+
+func NewRenderer(overrideDir string) (*Renderer, error) {
+	base, err := template.New("").Funcs(_funcs(DefaultLocale)).ParseFS(templatesFS, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse embedded templates: %w", err)
+	}
+
+	return &Renderer{
+		base:        base,
+		overrideDir: overrideDir,
+		overrides:   map[string]*template.Template{},
+	}, nil
+}
+
+// Render writes page (e.g. "order_result") in locale for merchantID
+func (r *Renderer) Render(w io.Writer, merchantID string, page string, locale string, data interface{}) error {
+	tmpl, err := r.lookup(merchantID, page)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err = tmpl.Clone()
+	if err != nil {
+		return fmt.Errorf("clone template: %w", err)
+	}
+
+	return tmpl.Funcs(_funcs(locale)).ExecuteTemplate(w, page+".html", data)
+}
+
+func (r *Renderer) lookup(merchantID string, page string) (*template.Template, error) {
+	if r.overrideDir == "" || merchantID == "" {
+		return r.base, nil
+	}
+
+	// merchant ids come from our db, but keep them from escaping the override dir
+	path := filepath.Join(r.overrideDir, filepath.Base(merchantID), page+".html")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if tmpl, ok := r.overrides[path]; ok {
+		return tmpl, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.overrides[path] = r.base
+		return r.base, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read template override: %w", err)
+	}
+
+	base, err := r.base.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("clone template: %w", err)
+	}
+	tmpl, err := base.New(page + ".html").Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("parse template override %s: %w", path, err)
+	}
+
+	r.overrides[path] = tmpl
+	return tmpl, nil
+}
+
+func _funcs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string) string {
+			return Translate(locale, key)
+		},
+		"locale": func() string {
+			return locale
+		},
+	}
+}